@@ -22,6 +22,7 @@ package commitlog
 
 import (
 	"bufio"
+	"io"
 	"os"
 
 	"github.com/m3db/m3/src/dbnode/digest"
@@ -50,9 +51,11 @@ func newChunkReader(bufferLen int) *chunkReader {
 	}
 }
 
-func (r *chunkReader) reset(fd *os.File) {
+// reset resets the chunk reader to read the contents of the file, which are
+// read from the file itself unless it was wrapped by a decompressor.
+func (r *chunkReader) reset(fd *os.File, contents io.Reader) {
 	r.fd = fd
-	r.buffer.Reset(fd)
+	r.buffer.Reset(contents)
 	r.remaining = 0
 }
 
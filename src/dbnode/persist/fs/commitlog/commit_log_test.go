@@ -21,10 +21,13 @@
 package commitlog
 
 import (
+	"bytes"
+	"compress/gzip"
 	"fmt"
 	"io/ioutil"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -34,6 +37,8 @@ import (
 	"github.com/m3db/bitset"
 	"github.com/m3db/m3/src/dbnode/clock"
 	"github.com/m3db/m3/src/dbnode/persist/fs"
+	"github.com/m3db/m3/src/dbnode/persist/fs/msgpack"
+	"github.com/m3db/m3/src/dbnode/persist/schema"
 	"github.com/m3db/m3/src/dbnode/ts"
 	"github.com/m3db/m3x/context"
 	"github.com/m3db/m3x/ident"
@@ -532,6 +537,132 @@ func TestCommitLogIteratorUsesPredicateFilter(t *testing.T) {
 	require.True(t, len(iterStruct.files) == 2)
 }
 
+func TestCommitLogIteratorSkipsFilesThatFailToOpen(t *testing.T) {
+	opts, scope := newTestOptions(t, overrides{
+		strategy: StrategyWriteWait,
+	})
+	defer cleanup(t, opts)
+
+	commitLog := newTestCommitLog(t, opts)
+
+	writes := []testWrite{
+		{testSeries(0, "foo.bar", testTags1, 127), time.Now(), 123.456, xtime.Second, nil, nil},
+		{testSeries(1, "foo.baz", testTags2, 150), time.Now(), 456.789, xtime.Second, nil, nil},
+	}
+	writeCommitLogs(t, scope, commitLog, writes).Wait()
+	require.NoError(t, commitLog.Close())
+
+	// Write a file that is not a valid commit log
+	dir := fs.CommitLogsDirPath(opts.FilesystemOptions().FilePathPrefix())
+	corruptPath := filepath.Join(dir, "corrupt.db")
+	require.NoError(t, ioutil.WriteFile(corruptPath, []byte("not a commit log"), 0666))
+
+	iter, err := NewIterator(IteratorOpts{
+		CommitLogOptions:      opts,
+		FileFilterPredicate:   ReadAllPredicate(),
+		SeriesFilterPredicate: ReadAllSeriesPredicate(),
+	})
+	require.NoError(t, err)
+	defer iter.Close()
+
+	// Place the corrupt file ahead of the valid ones
+	iterStruct := iter.(*iterator)
+	corrupt := File{FilePath: corruptPath, Duration: opts.BlockSize()}
+	iterStruct.files = append([]File{corrupt}, iterStruct.files...)
+
+	read := 0
+	for iter.Next() {
+		read++
+	}
+	require.Equal(t, len(writes), read)
+
+	require.Error(t, iter.Err())
+	fileErrs := iter.FileErrors()
+	require.Equal(t, 1, len(fileErrs))
+	require.Equal(t, corruptPath, fileErrs[0].File.FilePath)
+	require.Error(t, fileErrs[0].Err)
+}
+
+func TestCommitLogReaderRejectsUnsupportedVersion(t *testing.T) {
+	opts, _ := newTestOptions(t, overrides{
+		strategy: StrategyWriteWait,
+	})
+	defer cleanup(t, opts)
+
+	// Write a commit log file whose info claims a newer version than is supported.
+	encoder := msgpack.NewEncoder()
+	require.NoError(t, encoder.EncodeLogInfo(schema.LogInfo{
+		Start:    time.Now().UnixNano(),
+		Duration: int64(opts.BlockSize()),
+	}))
+	data := append([]byte(nil), encoder.Bytes()...)
+	// The version is the first field and is encoded as a single byte.
+	require.Equal(t, byte(1), data[0])
+	data[0] = 2
+
+	dir := fs.CommitLogsDirPath(opts.FilesystemOptions().FilePathPrefix())
+	require.NoError(t, os.MkdirAll(dir, opts.FilesystemOptions().NewDirectoryMode()))
+	filePath := filepath.Join(dir, "newer-version.db")
+	fd, err := os.Create(filePath)
+	require.NoError(t, err)
+	chunkWriter := newChunkWriter(func(error) {}, false)
+	chunkWriter.fd = fd
+	_, err = chunkWriter.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, fd.Close())
+
+	reader := newCommitLogReader(opts, ReadAllSeriesPredicate())
+	_, _, _, err = reader.Open(filePath)
+	require.Error(t, err)
+	require.Equal(t, fmt.Sprintf(
+		"unsupported commit log version 2 in file: %s, supported versions are up to 1", filePath),
+		err.Error())
+}
+
+func TestCommitLogIteratorReadsGzipCompressedFiles(t *testing.T) {
+	opts, scope := newTestOptions(t, overrides{
+		strategy: StrategyWriteWait,
+	})
+	defer cleanup(t, opts)
+
+	commitLog := newTestCommitLog(t, opts)
+
+	writes := []testWrite{
+		{testSeries(0, "foo.bar", testTags1, 127), time.Now(), 123.456, xtime.Second, []byte{1, 2, 3}, nil},
+		{testSeries(1, "foo.baz", testTags2, 150), time.Now(), 456.789, xtime.Second, nil, nil},
+	}
+	writeCommitLogs(t, scope, commitLog, writes).Wait()
+	require.NoError(t, commitLog.Close())
+
+	// Compress the commit log files in place as if they were archived.
+	dir := fs.CommitLogsDirPath(opts.FilesystemOptions().FilePathPrefix())
+	files, err := fs.SortedCommitLogFiles(dir)
+	require.NoError(t, err)
+	require.True(t, len(files) > 0)
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		require.NoError(t, err)
+
+		var compressed bytes.Buffer
+		w := gzip.NewWriter(&compressed)
+		_, err = w.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		require.NoError(t, ioutil.WriteFile(file, compressed.Bytes(), 0666))
+	}
+
+	// The compressed files can't be read as is.
+	_, err = NewIterator(IteratorOpts{
+		CommitLogOptions:      opts,
+		FileFilterPredicate:   ReadAllPredicate(),
+		SeriesFilterPredicate: ReadAllSeriesPredicate(),
+	})
+	require.Error(t, err)
+
+	commitLog.opts = commitLog.opts.SetReadDecompressor(GzipDecompressor)
+	assertCommitLogWritesByIterating(t, commitLog, writes)
+}
+
 func TestCommitLogWriteBehind(t *testing.T) {
 	opts, scope := newTestOptions(t, overrides{
 		strategy: StrategyWriteBehind,
@@ -22,6 +22,7 @@ package commitlog
 
 import (
 	"encoding/binary"
+	"io"
 	"os"
 	"sort"
 	"time"
@@ -38,6 +39,27 @@ type File struct {
 	Index    int64
 }
 
+// openFile opens a commit log file and returns it along with the reader for its
+// contents, which are decompressed if a decompressor is set.
+func openFile(filePath string, opts Options) (*os.File, io.Reader, error) {
+	fd, err := os.Open(filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	decompressor := opts.ReadDecompressor()
+	if decompressor == nil {
+		return fd, fd, nil
+	}
+
+	contents, err := decompressor(fd)
+	if err != nil {
+		fd.Close()
+		return nil, nil, err
+	}
+	return fd, contents, nil
+}
+
 // ReadLogInfo reads the commit log info out of a commitlog file
 func ReadLogInfo(filePath string, opts Options) (time.Time, time.Duration, int64, error) {
 	var fd *os.File
@@ -48,13 +70,13 @@ func ReadLogInfo(filePath string, opts Options) (time.Time, time.Duration, int64
 		}
 	}()
 
-	fd, err = os.Open(filePath)
+	fd, contents, err := openFile(filePath, opts)
 	if err != nil {
 		return time.Time{}, 0, 0, err
 	}
 
 	chunkReader := newChunkReader(opts.FlushSize())
-	chunkReader.reset(fd)
+	chunkReader.reset(fd, contents)
 	size, err := binary.ReadUvarint(chunkReader)
 	if err != nil {
 		return time.Time{}, 0, 0, err
@@ -39,6 +39,7 @@ var (
 
 type iteratorMetrics struct {
 	readsErrors tally.Counter
+	openErrors  tally.Counter
 }
 
 type iterator struct {
@@ -47,9 +48,11 @@ type iterator struct {
 	metrics    iteratorMetrics
 	log        xlog.Logger
 	files      []File
+	file       File
 	reader     commitLogReader
 	read       iteratorRead
 	err        error
+	fileErrs   []FileError
 	seriesPred SeriesFilterPredicate
 	setRead    bool
 	closed     bool
@@ -86,6 +89,7 @@ func NewIterator(iterOpts IteratorOpts) (Iterator, error) {
 		scope: scope,
 		metrics: iteratorMetrics{
 			readsErrors: scope.Counter("reads.errors"),
+			openErrors:  scope.Counter("opens.errors"),
 		},
 		log:        iops.Logger(),
 		files:      filteredFiles,
@@ -94,42 +98,40 @@ func NewIterator(iterOpts IteratorOpts) (Iterator, error) {
 }
 
 func (i *iterator) Next() bool {
-	if i.hasError() || i.closed {
-		return false
-	}
-	if i.reader == nil {
-		if !i.nextReader() {
-			return false
+	for !i.closed {
+		if i.reader == nil {
+			if !i.nextReader() {
+				return false
+			}
 		}
-	}
-	var err error
-	i.read.series, i.read.datapoint, i.read.unit, i.read.annotation, err = i.reader.Read()
-	if err == io.EOF {
-		closeErr := i.closeAndResetReader()
-		if closeErr != nil {
-			i.err = closeErr
+
+		var err error
+		i.read.series, i.read.datapoint, i.read.unit, i.read.annotation, err = i.reader.Read()
+		if err == nil {
+			i.setRead = true
+			return true
 		}
-		// Try the next reader
-		return i.Next()
-	}
-	if err != nil {
-		// Try the next reader, this enables restoring with best effort from commit logs
-		i.metrics.readsErrors.Inc(1)
-		i.log.Errorf("commit log reader returned error, iterator moving to next file: %v", err)
-		i.err = err
-		closeErr := i.closeAndResetReader()
-		if closeErr != nil {
-			i.err = closeErr
+
+		if err != io.EOF {
+			// Try the next reader, this enables restoring with best effort from commit logs
+			i.metrics.readsErrors.Inc(1)
+			i.log.Errorf(
+				"commit log reader returned error for file: %s, iterator moving to next file: %v",
+				i.file.FilePath, err)
+			i.addFileError(err)
+		}
+		if closeErr := i.closeAndResetReader(); closeErr != nil {
+			i.addFileError(closeErr)
 		}
-		return i.Next()
+		// Try the next reader
+		i.setRead = false
 	}
-	i.setRead = true
-	return true
+	return false
 }
 
 func (i *iterator) Current() (Series, ts.Datapoint, xtime.Unit, ts.Annotation) {
 	read := i.read
-	if i.hasError() || i.closed || !i.setRead {
+	if i.closed || !i.setRead {
 		read = iteratorRead{}
 	}
 	return read.series, read.datapoint, read.unit, read.annotation
@@ -139,6 +141,17 @@ func (i *iterator) Err() error {
 	return i.err
 }
 
+func (i *iterator) FileErrors() []FileError {
+	return i.fileErrs
+}
+
+func (i *iterator) addFileError(err error) {
+	if i.err == nil {
+		i.err = err
+	}
+	i.fileErrs = append(i.fileErrs, FileError{File: i.file, Err: err})
+}
+
 // TODO: Refactor codebase so that it can handle Close() returning an error
 func (i *iterator) Close() {
 	if i.closed {
@@ -148,46 +161,55 @@ func (i *iterator) Close() {
 	i.closeAndResetReader()
 }
 
-func (i *iterator) hasError() bool {
-	return i.err != nil
-}
-
+// nextReader opens the next commit log file that can be opened, skipping and
+// recording an error for any that can't be.
 func (i *iterator) nextReader() bool {
-	if len(i.files) == 0 {
-		return false
-	}
+	for len(i.files) > 0 {
+		if err := i.closeAndResetReader(); err != nil {
+			i.addFileError(err)
+		}
 
-	err := i.closeAndResetReader()
-	if err != nil {
-		i.err = err
-		return false
-	}
+		i.file = i.files[0]
+		i.files = i.files[1:]
+
+		reader, err := i.openReader(i.file)
+		if err != nil {
+			i.metrics.openErrors.Inc(1)
+			i.log.Errorf(
+				"unable to open commit log file: %s, iterator moving to next file: %v",
+				i.file.FilePath, err)
+			i.addFileError(err)
+			continue
+		}
 
-	file := i.files[0]
-	i.files = i.files[1:]
+		i.reader = reader
+		return true
+	}
+	return false
+}
 
+func (i *iterator) openReader(file File) (commitLogReader, error) {
 	t, idx := file.Start, file.Index
 	reader := newCommitLogReader(i.opts, i.seriesPred)
 	start, duration, index, err := reader.Open(file.FilePath)
 	if err != nil {
-		i.err = err
-		return false
-	}
-	if !t.Equal(start) {
-		i.err = errStartDoesNotMatch
-		return false
+		return nil, err
 	}
-	if duration != i.opts.BlockSize() {
-		i.err = errDurationDoesNotMatch
-		return false
+
+	var validateErr error
+	switch {
+	case !t.Equal(start):
+		validateErr = errStartDoesNotMatch
+	case duration != i.opts.BlockSize():
+		validateErr = errDurationDoesNotMatch
+	case index != idx:
+		validateErr = errIndexDoesNotMatch
 	}
-	if index != idx {
-		i.err = errIndexDoesNotMatch
-		return false
+	if validateErr != nil {
+		reader.Close()
+		return nil, validateErr
 	}
-
-	i.reader = reader
-	return true
+	return reader, nil
 }
 
 func filterFiles(opts Options, files []File, predicate FileFilterPredicate) []File {
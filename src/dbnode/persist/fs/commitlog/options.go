@@ -78,6 +78,7 @@ type options struct {
 	bytesPool        pool.CheckedBytesPool
 	identPool        ident.Pool
 	readConcurrency  int
+	readDecompressor Decompressor
 }
 
 // NewOptions creates new commit log options
@@ -240,3 +241,13 @@ func (o *options) SetIdentifierPool(value ident.Pool) Options {
 func (o *options) IdentifierPool() ident.Pool {
 	return o.identPool
 }
+
+func (o *options) SetReadDecompressor(value Decompressor) Options {
+	opts := *o
+	opts.readDecompressor = value
+	return &opts
+}
+
+func (o *options) ReadDecompressor() Decompressor {
+	return o.readDecompressor
+}
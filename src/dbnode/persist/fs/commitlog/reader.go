@@ -24,8 +24,8 @@ import (
 	"context"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
-	"os"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -163,15 +163,22 @@ func (r *reader) Open(filePath string) (time.Time, time.Duration, int64, error)
 	}
 	r.hasBeenOpened = true
 
-	fd, err := os.Open(filePath)
+	fd, contents, err := openFile(filePath, r.opts)
 	if err != nil {
 		return timeZero, 0, 0, err
 	}
 
-	r.chunkReader.reset(fd)
+	r.chunkReader.reset(fd, contents)
 	info, err := r.readInfo()
 	if err != nil {
 		r.Close()
+		if versionErr, ok := err.(msgpack.UnsupportedVersionError); ok {
+			// Written by a newer release, report it plainly rather than as a
+			// decoding failure.
+			err = fmt.Errorf(
+				"unsupported commit log version %d in file: %s, supported versions are up to %d",
+				versionErr.Actual, filePath, versionErr.Expected)
+		}
 		return timeZero, 0, 0, err
 	}
 	start := time.Unix(0, info.Start)
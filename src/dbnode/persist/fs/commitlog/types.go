@@ -21,6 +21,7 @@
 package commitlog
 
 import (
+	"io"
 	"time"
 
 	"github.com/m3db/m3/src/dbnode/clock"
@@ -74,13 +75,23 @@ type Iterator interface {
 	// Current returns the current commit log entry
 	Current() (Series, ts.Datapoint, xtime.Unit, ts.Annotation)
 
-	// Err returns an error if an error occurred
+	// Err returns the first error that occurred
 	Err() error
 
+	// FileErrors returns the errors that occurred opening or reading individual
+	// commit log files, the iterator moves on to the next file after each one
+	FileErrors() []FileError
+
 	// Close the iterator
 	Close()
 }
 
+// FileError is an error that occurred opening or reading a commit log file
+type FileError struct {
+	File File
+	Err  error
+}
+
 // IteratorOpts is a struct that contains coptions for the Iterator
 type IteratorOpts struct {
 	CommitLogOptions      Options
@@ -182,8 +193,20 @@ type Options interface {
 
 	// IdentifierPool returns the IdentifierPool to use for pooling identifiers.
 	IdentifierPool() ident.Pool
+
+	// SetReadDecompressor sets the decompressor applied to commit log files
+	// when they are read, nil reads them as is
+	SetReadDecompressor(value Decompressor) Options
+
+	// ReadDecompressor returns the decompressor applied to commit log files
+	// when they are read, nil reads them as is
+	ReadDecompressor() Decompressor
 }
 
+// Decompressor wraps the contents of a commit log file as it is read so that
+// commit log files that were compressed at rest can be read.
+type Decompressor func(r io.Reader) (io.Reader, error)
+
 // FileFilterPredicate is a predicate that allows the caller to determine
 // which commitlogs the iterator should read from
 type FileFilterPredicate func(f File) bool
@@ -72,12 +72,19 @@ type FileSetFile struct {
 // SnapshotTime returns the SnapshotTime for the given FileSetFile. Value is meaningless
 // if the the FileSetFile is a flush instead of a snapshot.
 func (f *FileSetFile) SnapshotTime() (time.Time, error) {
+	return f.SnapshotTimeWithReaderBufferSize(defaultBufioReaderSize)
+}
+
+// SnapshotTimeWithReaderBufferSize returns the SnapshotTime for the given FileSetFile
+// reading the info file with a buffer of the given size.
+func (f *FileSetFile) SnapshotTimeWithReaderBufferSize(readerBufferSize int) (time.Time, error) {
 	if !f.CachedSnapshotTime.IsZero() {
 		// Return immediately if we've already cached it.
 		return f.CachedSnapshotTime, nil
 	}
 
-	snapshotTime, err := SnapshotTime(f.filePathPrefix, f.ID)
+	decoder := msgpack.NewDecoder(nil)
+	snapshotTime, err := snapshotTime(f.filePathPrefix, f.ID, readerBufferSize, decoder)
 	if err != nil {
 		return time.Time{}, err
 	}
@@ -336,12 +343,16 @@ func timeAndIndexFromFileName(fname string, componentPosition int) (time.Time, i
 func SnapshotTime(
 	filePathPrefix string, id FileSetFileIdentifier) (time.Time, error) {
 	decoder := msgpack.NewDecoder(nil)
-	return snapshotTime(filePathPrefix, id, decoder)
+	return snapshotTime(filePathPrefix, id, defaultBufioReaderSize, decoder)
 }
 
 func snapshotTime(
-	filePathPrefix string, id FileSetFileIdentifier, decoder *msgpack.Decoder) (time.Time, error) {
-	infoBytes, err := readSnapshotInfoFile(filePathPrefix, id, defaultBufioReaderSize)
+	filePathPrefix string,
+	id FileSetFileIdentifier,
+	readerBufferSize int,
+	decoder *msgpack.Decoder,
+) (time.Time, error) {
+	infoBytes, err := readSnapshotInfoFile(filePathPrefix, id, readerBufferSize)
 	if err != nil {
 		return time.Time{}, err
 	}
@@ -0,0 +1,133 @@
+// Copyright (c) 2018 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package fs
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/m3db/m3/src/dbnode/digest"
+	"github.com/m3db/m3/src/dbnode/persist"
+	"github.com/m3db/m3x/ident"
+
+	"github.com/stretchr/testify/require"
+)
+
+func newTestInMemoryFileSetReader() DataFileSetReader {
+	return NewInMemoryFileSetReader([]InMemoryFileSetEntry{
+		{
+			ID:   ident.StringID("foo"),
+			Tags: ident.NewTags(ident.StringTag("city", "nyc")),
+			Data: []byte{1, 2, 3},
+		},
+		{
+			ID:   ident.StringID("bar"),
+			Data: []byte{4, 5},
+		},
+	})
+}
+
+func TestInMemoryFileSetReaderOpen(t *testing.T) {
+	var (
+		r          = newTestInMemoryFileSetReader()
+		blockStart = time.Now().Truncate(time.Hour)
+	)
+
+	_, _, _, _, err := r.Read()
+	require.Equal(t, errInMemoryReaderNotOpen, err)
+
+	require.NoError(t, r.Open(DataReaderOpenOptions{
+		Identifier: FileSetFileIdentifier{
+			Namespace:  ident.StringID("testns"),
+			Shard:      3,
+			BlockStart: blockStart,
+		},
+		FileSetType: persist.FileSetSnapshotType,
+	}))
+
+	status := r.Status()
+	require.True(t, status.Open)
+	require.Equal(t, "testns", status.Namespace.String())
+	require.Equal(t, uint32(3), status.Shard)
+	require.True(t, blockStart.Equal(status.BlockStart))
+	require.Equal(t, 2, r.Entries())
+
+	require.NoError(t, r.Close())
+	require.False(t, r.Status().Open)
+}
+
+func TestInMemoryFileSetReaderReadsSequentially(t *testing.T) {
+	r := newTestInMemoryFileSetReader()
+	require.NoError(t, r.Open(DataReaderOpenOptions{}))
+
+	id, tags, data, checksum, err := r.Read()
+	require.NoError(t, err)
+	require.Equal(t, "foo", id.String())
+	require.True(t, tags.Next())
+	require.Equal(t, "city", tags.Current().Name.String())
+	require.Equal(t, "nyc", tags.Current().Value.String())
+	require.False(t, tags.Next())
+	data.IncRef()
+	require.Equal(t, []byte{1, 2, 3}, data.Bytes())
+	data.DecRef()
+	require.Equal(t, digest.Checksum([]byte{1, 2, 3}), checksum)
+	require.Equal(t, 1, r.EntriesRead())
+
+	id, tags, data, checksum, err = r.Read()
+	require.NoError(t, err)
+	require.Equal(t, "bar", id.String())
+	require.Equal(t, 0, tags.Remaining())
+	data.IncRef()
+	require.Equal(t, []byte{4, 5}, data.Bytes())
+	data.DecRef()
+	require.Equal(t, digest.Checksum([]byte{4, 5}), checksum)
+	require.Equal(t, 2, r.EntriesRead())
+
+	// Reading metadata can't be mixed with reading data.
+	_, _, _, _, err = r.ReadMetadata()
+	require.Equal(t, errInMemoryReaderMixedReadMethods, err)
+}
+
+func TestInMemoryFileSetReaderReturnsEOF(t *testing.T) {
+	r := newTestInMemoryFileSetReader()
+	require.NoError(t, r.Open(DataReaderOpenOptions{}))
+
+	for i := 0; i < r.Entries(); i++ {
+		_, _, length, _, err := r.ReadMetadata()
+		require.NoError(t, err)
+		require.True(t, length > 0)
+	}
+	_, _, _, _, err := r.ReadMetadata()
+	require.Equal(t, io.EOF, err)
+	require.Equal(t, 2, r.MetadataRead())
+
+	// Reopening starts from the first entry again.
+	require.NoError(t, r.Open(DataReaderOpenOptions{}))
+	for i := 0; i < r.Entries(); i++ {
+		_, _, _, _, err = r.Read()
+		require.NoError(t, err)
+	}
+	_, _, _, _, err = r.Read()
+	require.Equal(t, io.EOF, err)
+	_, _, _, _, err = r.Read()
+	require.Equal(t, io.EOF, err)
+}
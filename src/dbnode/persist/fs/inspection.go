@@ -20,6 +20,20 @@
 
 package fs
 
+import (
+	"sort"
+)
+
+// CommitLogFilesInspection provides the set of commitlog files that existed
+// before the node began accepting writes.
+type CommitLogFilesInspection interface {
+	// CommitLogFilesSet returns the set of commitlog files that existed
+	// before the node began accepting writes.
+	CommitLogFilesSet() map[string]struct{}
+}
+
+var _ CommitLogFilesInspection = Inspection{}
+
 // Inspection contains the outcome of a filesystem inspection.
 type Inspection struct {
 	// SortedCommitLogFiles contains all commitlog filenames that existed
@@ -37,6 +51,18 @@ func (f Inspection) CommitLogFilesSet() map[string]struct{} {
 	return set
 }
 
+// NewInspection returns an Inspection for an explicit set of commitlog files
+// rather than scanning the filesystem, this is useful for tests and for warm
+// starts where the files that existed before the node began accepting writes
+// are already known.
+func NewInspection(commitLogFiles []string) Inspection {
+	sorted := append([]string(nil), commitLogFiles...)
+	sort.Sort(commitlogsByTimeAndIndexAscending(sorted))
+	return Inspection{
+		SortedCommitLogFiles: sorted,
+	}
+}
+
 // InspectFilesystem scans the filesystem and generates a Inspection
 // which the commitlog bootstrapper needs to avoid reading commitlog files that
 // were written *after* the process has already started. I.E in order to distinguish
@@ -21,6 +21,7 @@
 package fs
 
 import (
+	"os"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -43,3 +44,21 @@ func TestInspectFilesystem(t *testing.T) {
 		require.True(t, ok)
 	}
 }
+
+func TestNewInspection(t *testing.T) {
+	dir := createCommitLogFiles(t, 5, 1)
+	defer os.RemoveAll(dir)
+
+	sorted, err := SortedCommitLogFiles(CommitLogsDirPath(dir))
+	require.NoError(t, err)
+
+	// Pass the files in reverse order.
+	files := make([]string, 0, len(sorted))
+	for i := len(sorted) - 1; i >= 0; i-- {
+		files = append(files, sorted[i])
+	}
+
+	inspection := NewInspection(files)
+	require.Equal(t, sorted, inspection.SortedCommitLogFiles)
+	require.Equal(t, len(sorted), len(inspection.CommitLogFilesSet()))
+}
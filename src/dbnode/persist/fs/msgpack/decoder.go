@@ -45,6 +45,17 @@ var (
 
 var errorUnableToDetermineNumFieldsToSkip = errors.New("unable to determine num fields to skip")
 
+// UnsupportedVersionError is returned when decoding an object that was encoded
+// with a newer version than the decoder supports.
+type UnsupportedVersionError struct {
+	Expected int
+	Actual   int
+}
+
+func (e UnsupportedVersionError) Error() string {
+	return fmt.Sprintf("version mismatch: expected %v actual %v", e.Expected, e.Actual)
+}
+
 // Decoder decodes persisted msgpack-encoded data
 type Decoder struct {
 	reader            DecoderStream
@@ -423,7 +434,7 @@ func (dec *Decoder) checkVersion(expected int) int {
 		return 0
 	}
 	if version > expected {
-		dec.err = fmt.Errorf("version mismatch: expected %v actual %v", expected, version)
+		dec.err = UnsupportedVersionError{Expected: expected, Actual: version}
 		return 0
 	}
 
@@ -89,6 +89,7 @@ type reader struct {
 	expectedDataDigest        uint32
 	expectedDigestOfDigest    uint32
 	expectedBloomFilterDigest uint32
+	missingCheckpoint         bool
 	shard                     uint32
 	open                      bool
 }
@@ -163,9 +164,14 @@ func (r *reader) Open(opts DataReaderOpenOptions) error {
 		return fmt.Errorf("unable to open reader with fileset type: %s", opts.FileSetType)
 	}
 
-	// If there is no checkpoint file, don't read the data files.
+	// If there is no checkpoint file, don't read the data files unless
+	// explicitly allowed to.
+	r.missingCheckpoint = false
 	if err := r.readCheckpointFile(checkpointFilepath); err != nil {
-		return err
+		if err != ErrCheckpointFileNotFound || !opts.AllowMissingCheckpoint {
+			return err
+		}
+		r.missingCheckpoint = true
 	}
 
 	var infoFd, digestFd *os.File
@@ -274,9 +280,12 @@ func (r *reader) readDigest() error {
 		return err
 	}
 
-	err = r.digestFdWithDigestContents.Validate(r.expectedDigestOfDigest)
-	if err != nil {
-		return err
+	// Without a checkpoint file there's no digest of digests to validate against.
+	if !r.missingCheckpoint {
+		err = r.digestFdWithDigestContents.Validate(r.expectedDigestOfDigest)
+		if err != nil {
+			return err
+		}
 	}
 
 	// Note that we skip over the summaries file digest here which is available,
@@ -31,6 +31,7 @@ import (
 	"time"
 
 	"github.com/m3db/m3/src/dbnode/digest"
+	"github.com/m3db/m3/src/dbnode/persist"
 	"github.com/m3db/m3/src/dbnode/persist/fs/msgpack"
 	"github.com/m3db/m3/src/dbnode/persist/schema"
 	"github.com/m3db/m3/src/dbnode/serialize"
@@ -286,6 +287,52 @@ func TestReadNoCheckpointFile(t *testing.T) {
 	require.Equal(t, ErrCheckpointFileNotFound, err)
 }
 
+func TestReadNoCheckpointFileAllowMissingCheckpoint(t *testing.T) {
+	filePathPrefix := createTempDir(t)
+	defer os.RemoveAll(filePathPrefix)
+
+	var (
+		shard   = uint32(0)
+		entries = []testEntry{
+			{"foo", nil, []byte{1, 2, 3}},
+			{"bar", nil, []byte{4, 5, 6}},
+		}
+	)
+	w := newTestWriter(t, filePathPrefix)
+	writeTestData(t, w, shard, testWriterStart, entries, persist.FileSetSnapshotType)
+
+	shardDir := ShardSnapshotsDirPath(filePathPrefix, testNs1ID, shard)
+	checkpointFile := filesetPathFromTimeAndIndex(shardDir, testWriterStart, 0, checkpointFileSuffix)
+	require.True(t, mustFileExists(t, checkpointFile))
+	require.NoError(t, os.Remove(checkpointFile))
+
+	r := newTestReader(t, filePathPrefix)
+	rOpenOpts := DataReaderOpenOptions{
+		Identifier: FileSetFileIdentifier{
+			Namespace:  testNs1ID,
+			Shard:      shard,
+			BlockStart: testWriterStart,
+		},
+		FileSetType: persist.FileSetSnapshotType,
+	}
+	require.Equal(t, ErrCheckpointFileNotFound, r.Open(rOpenOpts))
+
+	rOpenOpts.AllowMissingCheckpoint = true
+	require.NoError(t, r.Open(rOpenOpts))
+	defer r.Close()
+
+	var read []string
+	for {
+		id, _, _, _, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		read = append(read, id.String())
+	}
+	require.Equal(t, len(entries), len(read))
+}
+
 func testReadOpen(t *testing.T, fileData map[string][]byte) {
 	filePathPrefix := createTempDir(t)
 	defer os.RemoveAll(filePathPrefix)
@@ -28,8 +28,9 @@ import (
 
 // ReaderOpenOptionsMatcher is a matcher for the DataReaderOpenOptions struct
 type ReaderOpenOptionsMatcher struct {
-	ID          FileSetFileIdentifier
-	FileSetType persist.FileSetType
+	ID                     FileSetFileIdentifier
+	FileSetType            persist.FileSetType
+	AllowMissingCheckpoint bool
 }
 
 // Matches determine whether m matches a DataWriterOpenOptions
@@ -54,13 +55,17 @@ func (m ReaderOpenOptionsMatcher) Matches(x interface{}) bool {
 	if m.FileSetType != readerOpenOptions.FileSetType {
 		return false
 	}
+	if m.AllowMissingCheckpoint != readerOpenOptions.AllowMissingCheckpoint {
+		return false
+	}
 
 	return true
 }
 
 func (m ReaderOpenOptionsMatcher) String() string {
 	return fmt.Sprintf(
-		"namespace: %s, shard: %d, blockstart: %d, volumeIndex: %d, filesetType: %s",
+		"namespace: %s, shard: %d, blockstart: %d, volumeIndex: %d, filesetType: %s, allowMissingCheckpoint: %t",
 		m.ID.Namespace.String(), m.ID.Shard, m.ID.BlockStart.Unix(), m.ID.VolumeIndex, m.FileSetType,
+		m.AllowMissingCheckpoint,
 	)
 }
@@ -101,6 +101,10 @@ type DataFileSetReaderStatus struct {
 type DataReaderOpenOptions struct {
 	Identifier  FileSetFileIdentifier
 	FileSetType persist.FileSetType
+	// AllowMissingCheckpoint allows opening a file set that has no checkpoint
+	// file, in which case the digest of digests can't be validated. This is
+	// unsafe as the file set may not have been completely written.
+	AllowMissingCheckpoint bool
 }
 
 // DataFileSetReader provides an unsynchronized reader for a TSDB file set
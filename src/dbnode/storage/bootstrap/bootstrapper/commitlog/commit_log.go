@@ -33,7 +33,7 @@ const (
 
 type commitLogBootstrapperProvider struct {
 	opts       Options
-	inspection fs.Inspection
+	inspection fs.CommitLogFilesInspection
 	next       bootstrap.BootstrapperProvider
 }
 
@@ -41,12 +41,15 @@ type commitLogBootstrapperProvider struct {
 // to bootstrap from commit log files.
 func NewCommitLogBootstrapperProvider(
 	opts Options,
-	inspection fs.Inspection,
+	inspection fs.CommitLogFilesInspection,
 	next bootstrap.BootstrapperProvider,
 ) (bootstrap.BootstrapperProvider, error) {
 	if err := opts.Validate(); err != nil {
 		return nil, err
 	}
+	if inspection == nil {
+		return nil, errInspectionNotSet
+	}
 	return commitLogBootstrapperProvider{
 		opts:       opts,
 		inspection: inspection,
@@ -0,0 +1,311 @@
+// Copyright (c) 2018 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package commitlog
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/m3db/m3/src/dbnode/persist/fs"
+	"github.com/m3db/m3/src/dbnode/persist/fs/commitlog"
+	"github.com/m3db/m3x/pool"
+)
+
+const decodedDirPrefix = "m3-bootstrap-decoded-"
+
+// snapshotTimeFileSuffixes are the suffixes of the files of a snapshot volume
+// that are read to resolve its snapshot time.
+var snapshotTimeFileSuffixes = []string{
+	"-info.db",
+	"-digest.db",
+	"-checkpoint.db",
+}
+
+// decryptingDecompressor returns a decompressor that decrypts commit log files
+// before applying the decompressor, if any, since files are compressed before
+// they are encrypted.
+func decryptingDecompressor(
+	decryptor Decryptor,
+	decompressor commitlog.Decompressor,
+) commitlog.Decompressor {
+	return func(r io.Reader) (io.Reader, error) {
+		decrypted, err := decryptor(r)
+		if err != nil {
+			return nil, err
+		}
+		if decompressor == nil {
+			return decrypted, nil
+		}
+		return decompressor(decrypted)
+	}
+}
+
+// fileDecoder wraps the contents of a snapshot file as it is read, for instance
+// to decrypt or decompress it.
+type fileDecoder func(r io.Reader) (io.Reader, error)
+
+// snapshotFileDecoder returns the decoder applied to snapshot files, which
+// decrypts them when a decryptor is set and then decompresses them when a
+// commit log read decompressor is set, or nil if neither is.
+func snapshotFileDecoder(opts Options) fileDecoder {
+	var (
+		decryptor    = opts.Decryptor()
+		decompressor = opts.CommitLogOptions().ReadDecompressor()
+	)
+	switch {
+	case decryptor != nil:
+		return fileDecoder(decryptingDecompressor(decryptor, decompressor))
+	case decompressor != nil:
+		return fileDecoder(decompressor)
+	default:
+		return nil
+	}
+}
+
+// decodingFileSource is a FileSource for encrypted or compressed snapshot
+// files. Since the fileset reader reads files by path, each volume opened is
+// decoded into a temporary directory with the same layout which is removed
+// once closed or once the volume fails to open.
+type decodingFileSource struct {
+	FileSource
+
+	decoder fileDecoder
+	// dir is the directory the temporary directories are created in, empty
+	// uses the system temporary directory.
+	dir string
+}
+
+// newDecodingFileSource returns a decodingFileSource, when dir is set any
+// volumes left decoded in it by a bootstrap that crashed are removed.
+func newDecodingFileSource(
+	source FileSource,
+	decoder fileDecoder,
+	dir string,
+) *decodingFileSource {
+	if dir != "" {
+		leftovers, _ := filepath.Glob(filepath.Join(dir, decodedDirPrefix+"*"))
+		for _, leftover := range leftovers {
+			os.RemoveAll(leftover)
+		}
+	}
+	return &decodingFileSource{FileSource: source, decoder: decoder, dir: dir}
+}
+
+func (s *decodingFileSource) NewReader(
+	bytesPool pool.CheckedBytesPool,
+	opts fs.Options,
+) (fs.DataFileSetReader, error) {
+	return &decodingReader{
+		source:    s,
+		bytesPool: bytesPool,
+		opts:      opts,
+	}, nil
+}
+
+// SnapshotTime returns the snapshot time of an encoded snapshot volume,
+// decoding only the files needed to resolve it.
+func (s *decodingFileSource) SnapshotTime(
+	f fs.FileSetFile,
+	readerBufferSize int,
+) (time.Time, error) {
+	if !f.CachedSnapshotTime.IsZero() {
+		return f.CachedSnapshotTime, nil
+	}
+
+	dir, err := s.decode(f, snapshotTimeFileSuffixes)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer os.RemoveAll(dir)
+
+	decoded, err := s.decodedFile(dir, f.ID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return decoded.SnapshotTimeWithReaderBufferSize(readerBufferSize)
+}
+
+// decode decodes the files of the volume with one of the suffixes, or all of
+// them if none are given, into a new temporary directory that is returned.
+func (s *decodingFileSource) decode(f fs.FileSetFile, suffixes []string) (string, error) {
+	if s.dir != "" {
+		if err := os.MkdirAll(s.dir, 0700); err != nil {
+			return "", err
+		}
+	}
+	dir, err := ioutil.TempDir(s.dir, decodedDirPrefix)
+	if err != nil {
+		return "", err
+	}
+
+	shardDir := fs.ShardSnapshotsDirPath(dir, f.ID.Namespace, f.ID.Shard)
+	if err := os.MkdirAll(shardDir, 0755); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+
+	for _, path := range f.AbsoluteFilepaths {
+		if !hasAnySuffix(path, suffixes) {
+			continue
+		}
+		target := filepath.Join(shardDir, filepath.Base(path))
+		if err := s.decodeFile(path, target); err != nil {
+			os.RemoveAll(dir)
+			return "", fmt.Errorf("unable to decode snapshot file %s: %v", path, err)
+		}
+	}
+	return dir, nil
+}
+
+func (s *decodingFileSource) decodeFile(source, target string) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	decoded, err := s.decoder(in)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, decoded); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// decodedFile returns the volume decoded into the directory.
+func (s *decodingFileSource) decodedFile(
+	dir string,
+	id fs.FileSetFileIdentifier,
+) (fs.FileSetFile, error) {
+	files, err := fs.SnapshotFiles(dir, id.Namespace, id.Shard)
+	if err != nil {
+		return fs.FileSetFile{}, err
+	}
+	for _, f := range files {
+		if f.ID.BlockStart.Equal(id.BlockStart) && f.ID.VolumeIndex == id.VolumeIndex {
+			return f, nil
+		}
+	}
+	return fs.FileSetFile{}, fmt.Errorf(
+		"snapshot for shard %d, blockStart %s and volume %d not found once decoded",
+		id.Shard, id.BlockStart.String(), id.VolumeIndex)
+}
+
+// decodingReader decodes the volume it is opened with and reads it with a
+// reader of the wrapped FileSource, it must be opened before being read.
+type decodingReader struct {
+	fs.DataFileSetReader
+
+	source    *decodingFileSource
+	bytesPool pool.CheckedBytesPool
+	opts      fs.Options
+	dir       string
+}
+
+func (r *decodingReader) Open(opts fs.DataReaderOpenOptions) error {
+	if err := r.closeOpened(); err != nil {
+		return err
+	}
+
+	id := opts.Identifier
+	files, err := r.source.SnapshotFiles(r.opts.FilePathPrefix(), id.Namespace, id.Shard)
+	if err != nil {
+		return err
+	}
+	var (
+		volume fs.FileSetFile
+		found  bool
+	)
+	for _, f := range files {
+		if f.ID.BlockStart.Equal(id.BlockStart) && f.ID.VolumeIndex == id.VolumeIndex {
+			volume, found = f, true
+			break
+		}
+	}
+	if !found {
+		return fmt.Errorf(
+			"snapshot for shard %d, blockStart %s and volume %d not found",
+			id.Shard, id.BlockStart.String(), id.VolumeIndex)
+	}
+
+	dir, err := r.source.decode(volume, nil)
+	if err != nil {
+		return err
+	}
+
+	reader, err := r.source.FileSource.NewReader(r.bytesPool, r.opts.SetFilePathPrefix(dir))
+	if err == nil {
+		err = reader.Open(opts)
+	}
+	if err != nil {
+		// Readers that fail to open aren't closed so don't leave the decoded
+		// files behind.
+		os.RemoveAll(dir)
+		return err
+	}
+	r.dir = dir
+	r.DataFileSetReader = reader
+	return nil
+}
+
+func (r *decodingReader) Close() error {
+	return r.closeOpened()
+}
+
+func (r *decodingReader) closeOpened() error {
+	var err error
+	if r.DataFileSetReader != nil {
+		err = r.DataFileSetReader.Close()
+		r.DataFileSetReader = nil
+	}
+	if r.dir != "" {
+		if removeErr := os.RemoveAll(r.dir); err == nil {
+			err = removeErr
+		}
+		r.dir = ""
+	}
+	return err
+}
+
+func hasAnySuffix(path string, suffixes []string) bool {
+	if len(suffixes) == 0 {
+		return true
+	}
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(path, suffix) {
+			return true
+		}
+	}
+	return false
+}
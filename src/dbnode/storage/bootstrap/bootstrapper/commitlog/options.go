@@ -21,46 +21,188 @@
 package commitlog
 
 import (
+	"bytes"
 	"errors"
+	"time"
 
+	"github.com/m3db/m3/src/dbnode/persist/fs"
 	"github.com/m3db/m3/src/dbnode/persist/fs/commitlog"
 	"github.com/m3db/m3/src/dbnode/storage/bootstrap/result"
+	"github.com/m3db/m3x/ident"
+	"github.com/m3db/m3x/pool"
+	xsync "github.com/m3db/m3x/sync"
 )
 
 const (
-	defaultEncodingConcurrency   = 4
-	defaultMergeShardConcurrency = 4
+	defaultEncodingConcurrency           = 4
+	defaultMergeShardConcurrency         = 4
+	defaultMergeSeriesConcurrency        = 1
+	defaultSnapshotResolutionConcurrency = 4
+	defaultSnapshotReadConcurrency       = 4
+	defaultNamespaceReadConcurrency      = 1
+
+	// Negative means unlimited.
+	defaultMaxSnapshotTimeResolutionErrors = -1
+
+	// namespacePadding are the bytes ignored at the end of namespace IDs by
+	// TrimmedNamespaceMatcher.
+	namespacePadding = "\x00 "
 )
 
 var (
-	errEncodingConcurrencyPositive   = errors.New("encoding concurrency must be positive")
-	errMergeShardConcurrencyPositive = errors.New("merge shard concurrency must be positive")
+	errResultOptionsNotSet                   = errors.New("result options not set")
+	errCommitLogOptionsNotSet                = errors.New("commit log options not set")
+	errEncodingConcurrencyPositive           = errors.New("encoding concurrency must be positive")
+	errAdaptiveEncodingConcurrencyInvalid    = errors.New("adaptive encoding concurrency min must be positive and not exceed max")
+	errMergeShardConcurrencyPositive         = errors.New("merge shard concurrency must be positive")
+	errMergeSeriesConcurrencyPositive        = errors.New("merge series concurrency must be positive")
+	errSnapshotResolutionConcurrencyPositive = errors.New("snapshot resolution concurrency must be positive")
+	errSnapshotReadConcurrencyPositive       = errors.New("snapshot read concurrency must be positive")
+	errNamespaceReadConcurrencyPositive      = errors.New("namespace read concurrency must be positive")
+	errProgressReporterNotSet                = errors.New("progress reporter not set")
+	errMaxUnmergedMemoryBytesNegative        = errors.New("max unmerged memory bytes must not be negative")
+	errWorkDistributorNotSet                 = errors.New("work distributor not set")
+	errFileSourceNotSet                      = errors.New("file source not set")
+	errMaxAnnotationBytesNegative            = errors.New("max annotation bytes must not be negative")
+	errPerShardMergeTimeoutNegative          = errors.New("per shard merge timeout must not be negative")
+	errEncoderBlockedWarnThresholdNegative   = errors.New("encoder blocked warn threshold must not be negative")
+	errMaxCommitLogFilesToReadNegative       = errors.New("max commit log files to read must not be negative")
+	errMaxEncodersPerSeriesNegative          = errors.New("max encoders per series must not be negative")
+	errMaxUnmergedBytesPerSeriesNegative     = errors.New("max unmerged bytes per series must not be negative")
+	errSnapshotInfoReaderBufferSizeNegative  = errors.New("snapshot info reader buffer size must not be negative")
 )
 
 type options struct {
-	resultOpts            result.Options
-	commitLogOpts         commitlog.Options
-	encodingConcurrency   int
-	mergeShardConcurrency int
+	resultOpts                    result.Options
+	commitLogOpts                 commitlog.Options
+	encodingConcurrency           int
+	minEncodingConcurrency        int
+	maxEncodingConcurrency        int
+	mergeShardConcurrency         int
+	randomizeShardMergeOrder      bool
+	mergeSeriesConcurrency        int
+	snapshotResolutionConcurrency int
+	snapshotReadConcurrency       int
+	namespaceReadConcurrency      int
+	sharedSnapshotReadPool        xsync.WorkerPool
+	maxSnapshotTimeResolutionErrs int
+	snapshotInfoReaderBufferSize  int
+	maxUnmergedMemoryBytes        int64
+	maxCommitLogFilesToRead       int
+	detectOverlappingFiles        bool
+	maxEncodersPerSeries          int
+	maxUnmergedBytesPerSeries     int64
+	maxAnnotationBytes            int
+	truncateOversizedAnnotations  bool
+	logOrphanDatapoints           bool
+	progressReporter              ProgressReporter
+	droppedReporter               DroppedDatapointsReporter
+	outOfOrderReporter            OutOfOrderDatapointsReporter
+	unmergedDebugSink             UnmergedDebugSink
+	workDistributor               WorkDistributor
+	fileSource                    FileSource
+	decryptor                     Decryptor
+	decryptionDir                 string
+	snapshotReadBytesPool         pool.CheckedBytesPool
+	seriesValidator               SeriesValidator
+	seriesFilter                  SeriesFilter
+	namespaceMatcher              NamespaceMatcher
+	seriesAllowlist               []ident.ID
+	blockFilter                   BlockFilter
+	allowIncompleteSnapshots      bool
+	validateSnapshotInfo          bool
+	deduplicateSnapshotBlocks     bool
+	emptyMergedBlocksAreErrors    bool
+	checkpointDir                 string
+	failOnCommitLogReadError      bool
+	omitStreamedShardResults      bool
+	snapshotsOnly                 bool
+	reportAccurateAvailability    bool
+	perShardMergeTimeout          time.Duration
+	encoderBlockedWarnThreshold   time.Duration
 }
 
 // NewOptions creates new bootstrap options
 func NewOptions() Options {
 	return &options{
-		resultOpts:            result.NewOptions(),
-		commitLogOpts:         commitlog.NewOptions(),
-		encodingConcurrency:   defaultEncodingConcurrency,
-		mergeShardConcurrency: defaultMergeShardConcurrency,
+		resultOpts:                    result.NewOptions(),
+		commitLogOpts:                 commitlog.NewOptions(),
+		encodingConcurrency:           defaultEncodingConcurrency,
+		mergeShardConcurrency:         defaultMergeShardConcurrency,
+		mergeSeriesConcurrency:        defaultMergeSeriesConcurrency,
+		snapshotResolutionConcurrency: defaultSnapshotResolutionConcurrency,
+		snapshotReadConcurrency:       defaultSnapshotReadConcurrency,
+		namespaceReadConcurrency:      defaultNamespaceReadConcurrency,
+		maxSnapshotTimeResolutionErrs: defaultMaxSnapshotTimeResolutionErrors,
+		progressReporter:              noopProgressReporter{},
+		workDistributor:               shardModuloWorkDistributor{},
+		fileSource:                    localFileSource{},
 	}
 }
 
 func (o *options) Validate() error {
+	if o.resultOpts == nil {
+		return errResultOptionsNotSet
+	}
+	if o.commitLogOpts == nil {
+		return errCommitLogOptionsNotSet
+	}
 	if o.encodingConcurrency <= 0 {
 		return errEncodingConcurrencyPositive
 	}
+	if (o.minEncodingConcurrency != 0 || o.maxEncodingConcurrency != 0) &&
+		(o.minEncodingConcurrency <= 0 ||
+			o.minEncodingConcurrency > o.maxEncodingConcurrency) {
+		return errAdaptiveEncodingConcurrencyInvalid
+	}
 	if o.mergeShardConcurrency <= 0 {
 		return errMergeShardConcurrencyPositive
 	}
+	if o.mergeSeriesConcurrency <= 0 {
+		return errMergeSeriesConcurrencyPositive
+	}
+	if o.snapshotResolutionConcurrency <= 0 {
+		return errSnapshotResolutionConcurrencyPositive
+	}
+	if o.snapshotReadConcurrency <= 0 {
+		return errSnapshotReadConcurrencyPositive
+	}
+	if o.namespaceReadConcurrency <= 0 {
+		return errNamespaceReadConcurrencyPositive
+	}
+	if o.maxUnmergedMemoryBytes < 0 {
+		return errMaxUnmergedMemoryBytesNegative
+	}
+	if o.maxAnnotationBytes < 0 {
+		return errMaxAnnotationBytesNegative
+	}
+	if o.maxCommitLogFilesToRead < 0 {
+		return errMaxCommitLogFilesToReadNegative
+	}
+	if o.maxEncodersPerSeries < 0 {
+		return errMaxEncodersPerSeriesNegative
+	}
+	if o.maxUnmergedBytesPerSeries < 0 {
+		return errMaxUnmergedBytesPerSeriesNegative
+	}
+	if o.snapshotInfoReaderBufferSize < 0 {
+		return errSnapshotInfoReaderBufferSizeNegative
+	}
+	if o.perShardMergeTimeout < 0 {
+		return errPerShardMergeTimeoutNegative
+	}
+	if o.encoderBlockedWarnThreshold < 0 {
+		return errEncoderBlockedWarnThresholdNegative
+	}
+	if o.progressReporter == nil {
+		return errProgressReporterNotSet
+	}
+	if o.workDistributor == nil {
+		return errWorkDistributorNotSet
+	}
+	if o.fileSource == nil {
+		return errFileSourceNotSet
+	}
 	return o.commitLogOpts.Validate()
 }
 
@@ -94,6 +236,17 @@ func (o *options) EncodingConcurrency() int {
 	return o.encodingConcurrency
 }
 
+func (o *options) SetAdaptiveEncodingConcurrency(min, max int) Options {
+	opts := *o
+	opts.minEncodingConcurrency = min
+	opts.maxEncodingConcurrency = max
+	return &opts
+}
+
+func (o *options) AdaptiveEncodingConcurrency() (int, int) {
+	return o.minEncodingConcurrency, o.maxEncodingConcurrency
+}
+
 func (o *options) SetMergeShardsConcurrency(value int) Options {
 	opts := *o
 	opts.mergeShardConcurrency = value
@@ -103,3 +256,450 @@ func (o *options) SetMergeShardsConcurrency(value int) Options {
 func (o *options) MergeShardsConcurrency() int {
 	return o.mergeShardConcurrency
 }
+
+func (o *options) SetRandomizeShardMergeOrder(value bool) Options {
+	opts := *o
+	opts.randomizeShardMergeOrder = value
+	return &opts
+}
+
+func (o *options) RandomizeShardMergeOrder() bool {
+	return o.randomizeShardMergeOrder
+}
+
+func (o *options) SetMergeSeriesConcurrency(value int) Options {
+	opts := *o
+	opts.mergeSeriesConcurrency = value
+	return &opts
+}
+
+func (o *options) MergeSeriesConcurrency() int {
+	return o.mergeSeriesConcurrency
+}
+
+func (o *options) SetSnapshotResolutionConcurrency(value int) Options {
+	opts := *o
+	opts.snapshotResolutionConcurrency = value
+	return &opts
+}
+
+func (o *options) SnapshotResolutionConcurrency() int {
+	return o.snapshotResolutionConcurrency
+}
+
+func (o *options) SetMaxSnapshotTimeResolutionErrors(value int) Options {
+	opts := *o
+	opts.maxSnapshotTimeResolutionErrs = value
+	return &opts
+}
+
+func (o *options) MaxSnapshotTimeResolutionErrors() int {
+	return o.maxSnapshotTimeResolutionErrs
+}
+
+func (o *options) SetSnapshotReadConcurrency(value int) Options {
+	opts := *o
+	opts.snapshotReadConcurrency = value
+	return &opts
+}
+
+func (o *options) SnapshotReadConcurrency() int {
+	return o.snapshotReadConcurrency
+}
+
+func (o *options) SetNamespaceReadConcurrency(value int) Options {
+	opts := *o
+	opts.namespaceReadConcurrency = value
+	return &opts
+}
+
+func (o *options) NamespaceReadConcurrency() int {
+	return o.namespaceReadConcurrency
+}
+
+func (o *options) SetSharedSnapshotReadPool(value xsync.WorkerPool) Options {
+	opts := *o
+	opts.sharedSnapshotReadPool = value
+	return &opts
+}
+
+func (o *options) SharedSnapshotReadPool() xsync.WorkerPool {
+	return o.sharedSnapshotReadPool
+}
+
+func (o *options) SetMaxUnmergedMemoryBytes(value int64) Options {
+	opts := *o
+	opts.maxUnmergedMemoryBytes = value
+	return &opts
+}
+
+func (o *options) MaxUnmergedMemoryBytes() int64 {
+	return o.maxUnmergedMemoryBytes
+}
+
+func (o *options) SetMaxCommitLogFilesToRead(value int) Options {
+	opts := *o
+	opts.maxCommitLogFilesToRead = value
+	return &opts
+}
+
+func (o *options) MaxCommitLogFilesToRead() int {
+	return o.maxCommitLogFilesToRead
+}
+
+func (o *options) SetDetectOverlappingCommitLogFiles(value bool) Options {
+	opts := *o
+	opts.detectOverlappingFiles = value
+	return &opts
+}
+
+func (o *options) DetectOverlappingCommitLogFiles() bool {
+	return o.detectOverlappingFiles
+}
+
+func (o *options) SetMaxEncodersPerSeries(value int) Options {
+	opts := *o
+	opts.maxEncodersPerSeries = value
+	return &opts
+}
+
+func (o *options) MaxEncodersPerSeries() int {
+	return o.maxEncodersPerSeries
+}
+
+func (o *options) SetMaxUnmergedBytesPerSeries(value int64) Options {
+	opts := *o
+	opts.maxUnmergedBytesPerSeries = value
+	return &opts
+}
+
+func (o *options) MaxUnmergedBytesPerSeries() int64 {
+	return o.maxUnmergedBytesPerSeries
+}
+
+func (o *options) SetMaxAnnotationBytes(value int) Options {
+	opts := *o
+	opts.maxAnnotationBytes = value
+	return &opts
+}
+
+func (o *options) MaxAnnotationBytes() int {
+	return o.maxAnnotationBytes
+}
+
+func (o *options) SetTruncateOversizedAnnotations(value bool) Options {
+	opts := *o
+	opts.truncateOversizedAnnotations = value
+	return &opts
+}
+
+func (o *options) TruncateOversizedAnnotations() bool {
+	return o.truncateOversizedAnnotations
+}
+
+func (o *options) SetLogOrphanDatapoints(value bool) Options {
+	opts := *o
+	opts.logOrphanDatapoints = value
+	return &opts
+}
+
+func (o *options) LogOrphanDatapoints() bool {
+	return o.logOrphanDatapoints
+}
+
+func (o *options) SetProgressReporter(value ProgressReporter) Options {
+	opts := *o
+	opts.progressReporter = value
+	return &opts
+}
+
+func (o *options) ProgressReporter() ProgressReporter {
+	return o.progressReporter
+}
+
+func (o *options) SetUnmergedDebugSink(value UnmergedDebugSink) Options {
+	opts := *o
+	opts.unmergedDebugSink = value
+	return &opts
+}
+
+func (o *options) UnmergedDebugSink() UnmergedDebugSink {
+	return o.unmergedDebugSink
+}
+
+func (o *options) SetDroppedDatapointsReporter(value DroppedDatapointsReporter) Options {
+	opts := *o
+	opts.droppedReporter = value
+	return &opts
+}
+
+func (o *options) DroppedDatapointsReporter() DroppedDatapointsReporter {
+	return o.droppedReporter
+}
+
+func (o *options) SetOutOfOrderDatapointsReporter(value OutOfOrderDatapointsReporter) Options {
+	opts := *o
+	opts.outOfOrderReporter = value
+	return &opts
+}
+
+func (o *options) OutOfOrderDatapointsReporter() OutOfOrderDatapointsReporter {
+	return o.outOfOrderReporter
+}
+
+func (o *options) SetWorkDistributor(value WorkDistributor) Options {
+	opts := *o
+	opts.workDistributor = value
+	return &opts
+}
+
+func (o *options) WorkDistributor() WorkDistributor {
+	return o.workDistributor
+}
+
+func (o *options) SetFileSource(value FileSource) Options {
+	opts := *o
+	opts.fileSource = value
+	return &opts
+}
+
+func (o *options) FileSource() FileSource {
+	return o.fileSource
+}
+
+func (o *options) SetDecryptor(value Decryptor) Options {
+	opts := *o
+	opts.decryptor = value
+	return &opts
+}
+
+func (o *options) Decryptor() Decryptor {
+	return o.decryptor
+}
+
+func (o *options) SetDecryptionDir(value string) Options {
+	opts := *o
+	opts.decryptionDir = value
+	return &opts
+}
+
+func (o *options) DecryptionDir() string {
+	return o.decryptionDir
+}
+
+func (o *options) SetSeriesValidator(value SeriesValidator) Options {
+	opts := *o
+	opts.seriesValidator = value
+	return &opts
+}
+
+func (o *options) SeriesValidator() SeriesValidator {
+	return o.seriesValidator
+}
+
+func (o *options) SetSeriesFilter(value SeriesFilter) Options {
+	opts := *o
+	opts.seriesFilter = value
+	return &opts
+}
+
+func (o *options) SeriesFilter() SeriesFilter {
+	return o.seriesFilter
+}
+
+func (o *options) SetNamespaceMatcher(value NamespaceMatcher) Options {
+	opts := *o
+	opts.namespaceMatcher = value
+	return &opts
+}
+
+func (o *options) NamespaceMatcher() NamespaceMatcher {
+	return o.namespaceMatcher
+}
+
+func (o *options) SetSeriesAllowlist(value []ident.ID) Options {
+	opts := *o
+	opts.seriesAllowlist = value
+	return &opts
+}
+
+func (o *options) SeriesAllowlist() []ident.ID {
+	return o.seriesAllowlist
+}
+
+func (o *options) SetBlockFilter(value BlockFilter) Options {
+	opts := *o
+	opts.blockFilter = value
+	return &opts
+}
+
+func (o *options) BlockFilter() BlockFilter {
+	return o.blockFilter
+}
+
+func (o *options) SetAllowIncompleteSnapshots(value bool) Options {
+	opts := *o
+	opts.allowIncompleteSnapshots = value
+	return &opts
+}
+
+func (o *options) AllowIncompleteSnapshots() bool {
+	return o.allowIncompleteSnapshots
+}
+
+func (o *options) SetValidateSnapshotInfo(value bool) Options {
+	opts := *o
+	opts.validateSnapshotInfo = value
+	return &opts
+}
+
+func (o *options) ValidateSnapshotInfo() bool {
+	return o.validateSnapshotInfo
+}
+
+func (o *options) SetSnapshotInfoReaderBufferSize(value int) Options {
+	opts := *o
+	opts.snapshotInfoReaderBufferSize = value
+	return &opts
+}
+
+func (o *options) SnapshotInfoReaderBufferSize() int {
+	return o.snapshotInfoReaderBufferSize
+}
+
+func (o *options) SetSnapshotReadBytesPool(value pool.CheckedBytesPool) Options {
+	opts := *o
+	opts.snapshotReadBytesPool = value
+	return &opts
+}
+
+func (o *options) SnapshotReadBytesPool() pool.CheckedBytesPool {
+	return o.snapshotReadBytesPool
+}
+
+func (o *options) SetDeduplicateSnapshotBlocks(value bool) Options {
+	opts := *o
+	opts.deduplicateSnapshotBlocks = value
+	return &opts
+}
+
+func (o *options) DeduplicateSnapshotBlocks() bool {
+	return o.deduplicateSnapshotBlocks
+}
+
+func (o *options) SetEmptyMergedBlocksAreErrors(value bool) Options {
+	opts := *o
+	opts.emptyMergedBlocksAreErrors = value
+	return &opts
+}
+
+func (o *options) EmptyMergedBlocksAreErrors() bool {
+	return o.emptyMergedBlocksAreErrors
+}
+
+func (o *options) SetCheckpointDir(value string) Options {
+	opts := *o
+	opts.checkpointDir = value
+	return &opts
+}
+
+func (o *options) CheckpointDir() string {
+	return o.checkpointDir
+}
+
+func (o *options) SetOmitStreamedShardResults(value bool) Options {
+	opts := *o
+	opts.omitStreamedShardResults = value
+	return &opts
+}
+
+func (o *options) OmitStreamedShardResults() bool {
+	return o.omitStreamedShardResults
+}
+
+func (o *options) SetFailOnCommitLogReadError(value bool) Options {
+	opts := *o
+	opts.failOnCommitLogReadError = value
+	return &opts
+}
+
+func (o *options) FailOnCommitLogReadError() bool {
+	return o.failOnCommitLogReadError
+}
+
+func (o *options) SetSnapshotsOnly(value bool) Options {
+	opts := *o
+	opts.snapshotsOnly = value
+	return &opts
+}
+
+func (o *options) SnapshotsOnly() bool {
+	return o.snapshotsOnly
+}
+
+func (o *options) SetReportAccurateAvailability(value bool) Options {
+	opts := *o
+	opts.reportAccurateAvailability = value
+	return &opts
+}
+
+func (o *options) ReportAccurateAvailability() bool {
+	return o.reportAccurateAvailability
+}
+
+func (o *options) SetPerShardMergeTimeout(value time.Duration) Options {
+	opts := *o
+	opts.perShardMergeTimeout = value
+	return &opts
+}
+
+func (o *options) PerShardMergeTimeout() time.Duration {
+	return o.perShardMergeTimeout
+}
+
+func (o *options) SetEncoderBlockedWarnThreshold(value time.Duration) Options {
+	opts := *o
+	opts.encoderBlockedWarnThreshold = value
+	return &opts
+}
+
+func (o *options) EncoderBlockedWarnThreshold() time.Duration {
+	return o.encoderBlockedWarnThreshold
+}
+
+type noopProgressReporter struct{}
+
+func (noopProgressReporter) OnCommitLogFileSelected(file string) {}
+func (noopProgressReporter) OnDatapointsRead(n int64)            {}
+func (noopProgressReporter) OnShardMergeComplete(shard uint32)   {}
+
+type shardModuloWorkDistributor struct{}
+
+func (shardModuloWorkDistributor) WorkerIndex(series commitlog.Series, numWorkers int) int {
+	return int(series.Shard % uint32(numWorkers))
+}
+
+type localFileSource struct{}
+
+func (localFileSource) SnapshotFiles(
+	filePathPrefix string,
+	namespace ident.ID,
+	shard uint32,
+) (fs.FileSetFilesSlice, error) {
+	return fs.SnapshotFiles(filePathPrefix, namespace, shard)
+}
+
+func (localFileSource) NewReader(
+	bytesPool pool.CheckedBytesPool,
+	opts fs.Options,
+) (fs.DataFileSetReader, error) {
+	return fs.NewReader(bytesPool, opts)
+}
+
+// TrimmedNamespaceMatcher matches namespace IDs that are equal once trailing
+// NUL and space padding is removed from both.
+func TrimmedNamespaceMatcher(bootstrapping ident.ID, written ident.ID) bool {
+	return bytes.Equal(
+		bytes.TrimRight(bootstrapping.Bytes(), namespacePadding),
+		bytes.TrimRight(written.Bytes(), namespacePadding))
+}
@@ -21,16 +21,27 @@
 package commitlog
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"hash/fnv"
 	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/m3db/m3/src/dbnode/encoding"
 	"github.com/m3db/m3/src/dbnode/persist"
 	"github.com/m3db/m3/src/dbnode/persist/fs"
 	"github.com/m3db/m3/src/dbnode/persist/fs/commitlog"
+	"github.com/m3db/m3/src/dbnode/retention"
 	"github.com/m3db/m3/src/dbnode/storage/block"
 	"github.com/m3db/m3/src/dbnode/storage/bootstrap"
 	"github.com/m3db/m3/src/dbnode/storage/bootstrap/result"
@@ -45,28 +56,174 @@ import (
 	"github.com/m3db/m3x/pool"
 	xsync "github.com/m3db/m3x/sync"
 	xtime "github.com/m3db/m3x/time"
+
+	"github.com/uber-go/tally"
 )
 
 var (
 	errIndexingNotEnableForNamespace = errors.New("indexing not enabled for namespace")
+	errInspectionNotSet              = errors.New("filesystem inspection not set")
+	errAnnotationTooLarge            = errors.New("annotation exceeds max annotation bytes")
+	errSnapshotTimeZero              = errors.New("snapshot time is the zero value")
+	errSnapshotNotListed             = errors.New("snapshot is no longer listed in the snapshot files")
+	errSnapshotTruncated             = errors.New("snapshot file is truncated")
+	errUnmergedSnapshotsOnly         = errors.New("commit log can't be read unmerged when only reading snapshots")
+	errCheckpointMismatch            = errors.New("checkpoint is of other shards or time ranges")
 )
 
-const encoderChanBufSize = 1000
+// IteratorCreationError is returned when the commit log iterator could not be
+// created, the cause is available via Unwrap or xerrors.InnerError.
+type IteratorCreationError struct {
+	Err error
+}
+
+func (e IteratorCreationError) Error() string {
+	return fmt.Sprintf("unable to create commit log iterator: %v", e.Err)
+}
+
+// Unwrap returns the error that caused the iterator creation to fail.
+func (e IteratorCreationError) Unwrap() error {
+	return e.Err
+}
+
+// InnerError returns the error that caused the iterator creation to fail.
+func (e IteratorCreationError) InnerError() error {
+	return e.Err
+}
+
+const (
+	encoderChanBufSize = 1000
+	// progressReportInterval is the number of datapoints read between each
+	// notification of the progress reporter.
+	progressReportInterval = 100000
+	// adaptiveConcurrencyWindow is the number of datapoints handed to the
+	// encoding workers between each adjustment of the adaptive concurrency.
+	adaptiveConcurrencyWindow = 1000
+	// adaptiveConcurrencyGrowDivisor grows the adaptive concurrency when more
+	// than one in this many datapoints of a window blocked being handed off.
+	adaptiveConcurrencyGrowDivisor = 10
+	// maxMergeErrorSamples is the number of series that failed to merge which
+	// are kept per shard and logged per read.
+	maxMergeErrorSamples = 10
+	// maxEncodeErrorSamples is the number of datapoints that failed to encode
+	// which are kept per encoding worker and logged per read.
+	maxEncodeErrorSamples = 10
+	// maxOutOfOrderDatapointSamples is the number of datapoints that arrived out
+	// of order which are kept per encoding worker and reported per read.
+	maxOutOfOrderDatapointSamples = 10
+	// maxOrphanDatapointSamples is the number of datapoints whose shard is not
+	// being bootstrapped which are logged per read when enabled.
+	maxOrphanDatapointSamples = 10
+)
 
 type newIteratorFn func(opts commitlog.IteratorOpts) (commitlog.Iterator, error)
 type snapshotFilesFn func(filePathPrefix string, namespace ident.ID, shard uint32) (fs.FileSetFilesSlice, error)
 type newReaderFn func(bytesPool pool.CheckedBytesPool, opts fs.Options) (fs.DataFileSetReader, error)
+type snapshotTimeFn func(f fs.FileSetFile, readerBufferSize int) (time.Time, error)
+type commitLogFilesFn func(opts commitlog.Options) ([]commitlog.File, error)
+
+// shardReadFn is called with the result of each shard as soon as it is read.
+type shardReadFn func(r ShardReadResult)
+
+// unmergedFn takes over the commit log data read instead of it being merged.
+type unmergedFn func(unmerged map[uint32]*shardData)
 
 type commitLogSource struct {
 	opts Options
 	log  xlog.Logger
 
 	// Filesystem inspection capture before node was started.
-	inspection fs.Inspection
+	inspection fs.CommitLogFilesInspection
+
+	newIteratorFn    newIteratorFn
+	snapshotFilesFn  snapshotFilesFn
+	newReaderFn      newReaderFn
+	snapshotTimeFn   snapshotTimeFn
+	commitLogFilesFn commitLogFilesFn
+	nowFn            func() time.Time
+	shardOrderSeedFn func() int64
+
+	metrics commitLogSourceMetrics
+
+	summariesLock sync.RWMutex
+	summaries     map[string]BootstrapSummary
+}
+
+// skippedCommitLogFiles collects the commit log files skipped by a single read,
+// a nil collector discards them.
+type skippedCommitLogFiles struct {
+	sync.Mutex
+	paths []string
+}
+
+func (f *skippedCommitLogFiles) add(path string) {
+	if f == nil {
+		return
+	}
+	f.Lock()
+	f.paths = append(f.paths, path)
+	f.Unlock()
+}
+
+func (f *skippedCommitLogFiles) list() []string {
+	f.Lock()
+	defer f.Unlock()
+	return append([]string(nil), f.paths...)
+}
 
-	newIteratorFn   newIteratorFn
-	snapshotFilesFn snapshotFilesFn
-	newReaderFn     newReaderFn
+type commitLogSourceMetrics struct {
+	datapointsRead        tally.Counter
+	datapointsSkipped     tally.Counter
+	datapointsMerged      tally.Counter
+	seriesEncoded         tally.Counter
+	encodeErrors          tally.Counter
+	seriesQuarantined     tally.Counter
+	mergeErrors           tally.Counter
+	emptyMergedBlocks     tally.Counter
+	shardsNoSnapshots     tally.Counter
+	commitLogFilesRead    tally.Counter
+	commitLogFilesSkipped tally.Counter
+	commitLogFileErrors   tally.Counter
+	overlappingFiles      tally.Counter
+	oversizedAnnotations  tally.Counter
+	orphanDatapoints      tally.Counter
+	mergeTimeouts         tally.Counter
+	blocksDeduplicated    tally.Counter
+	encodersCompacted     tally.Counter
+	unmergedBlocksDropped tally.Counter
+	encoderBlocked        tally.Timer
+	encoderBlockedSlow    tally.Counter
+	readDuration          tally.Timer
+	mergeDuration         tally.Timer
+}
+
+func newCommitLogSourceMetrics(scope tally.Scope) commitLogSourceMetrics {
+	scope = scope.SubScope("bootstrap").SubScope("commitlog")
+	return commitLogSourceMetrics{
+		datapointsRead:        scope.Counter("datapoints-read"),
+		datapointsSkipped:     scope.Counter("datapoints-skipped"),
+		datapointsMerged:      scope.Counter("datapoints-merged"),
+		seriesEncoded:         scope.Counter("series-encoded"),
+		encodeErrors:          scope.Counter("encode-errors"),
+		seriesQuarantined:     scope.Counter("series-quarantined"),
+		mergeErrors:           scope.Counter("merge-errors"),
+		emptyMergedBlocks:     scope.Counter("empty-merged-blocks"),
+		shardsNoSnapshots:     scope.Counter("shards-no-snapshots"),
+		commitLogFilesRead:    scope.Counter("commitlog-files-read"),
+		commitLogFilesSkipped: scope.Counter("commitlog-files-skipped"),
+		commitLogFileErrors:   scope.Counter("commitlog-file-errors"),
+		overlappingFiles:      scope.Counter("commitlog-files-overlapping"),
+		oversizedAnnotations:  scope.Counter("oversized-annotations"),
+		orphanDatapoints:      scope.Counter("orphan-datapoints"),
+		mergeTimeouts:         scope.Counter("merge-timeouts"),
+		blocksDeduplicated:    scope.Counter("snapshot-blocks-deduplicated"),
+		encodersCompacted:     scope.Counter("series-encoders-compacted"),
+		unmergedBlocksDropped: scope.Counter("unmerged-blocks-dropped"),
+		encoderBlocked:        scope.Timer("encoder-blocked-duration"),
+		encoderBlockedSlow:    scope.Counter("encoder-blocked-slow"),
+		readDuration:          scope.Timer("read-duration"),
+		mergeDuration:         scope.Timer("merge-duration"),
+	}
 }
 
 type encoder struct {
@@ -74,7 +231,27 @@ type encoder struct {
 	enc         encoding.Encoder
 }
 
-func newCommitLogSource(opts Options, inspection fs.Inspection) bootstrap.Source {
+// NewCommitLogSource creates a new commit log bootstrap source.
+func NewCommitLogSource(opts Options, inspection fs.CommitLogFilesInspection) (Source, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	if inspection == nil {
+		return nil, errInspectionNotSet
+	}
+	return newCommitLogSource(opts, inspection).(Source), nil
+}
+
+func newCommitLogSource(opts Options, inspection fs.CommitLogFilesInspection) bootstrap.Source {
+	var (
+		fileSource   = opts.FileSource()
+		snapshotTime = fileSetFileSnapshotTime
+	)
+	if decoder := snapshotFileDecoder(opts); decoder != nil {
+		decoding := newDecodingFileSource(fileSource, decoder, opts.DecryptionDir())
+		fileSource, snapshotTime = decoding, decoding.SnapshotTime
+	}
+
 	return &commitLogSource{
 		opts: opts,
 		log: opts.
@@ -85,12 +262,27 @@ func newCommitLogSource(opts Options, inspection fs.Inspection) bootstrap.Source
 
 		inspection: inspection,
 
-		newIteratorFn:   commitlog.NewIterator,
-		snapshotFilesFn: fs.SnapshotFiles,
-		newReaderFn:     fs.NewReader,
+		newIteratorFn:    commitlog.NewIterator,
+		snapshotFilesFn:  fileSource.SnapshotFiles,
+		newReaderFn:      fileSource.NewReader,
+		snapshotTimeFn:   snapshotTime,
+		commitLogFilesFn: commitlog.Files,
+		// Whenever now matters, for instance for retention, it comes from the
+		// clock of the commit log options so tests can fix it.
+		nowFn:            opts.CommitLogOptions().ClockOptions().NowFn(),
+		shardOrderSeedFn: func() int64 { return time.Now().UnixNano() },
+
+		metrics: newCommitLogSourceMetrics(
+			opts.ResultOptions().InstrumentOptions().MetricsScope()),
+
+		summaries: make(map[string]BootstrapSummary),
 	}
 }
 
+func fileSetFileSnapshotTime(f fs.FileSetFile, readerBufferSize int) (time.Time, error) {
+	return f.SnapshotTimeWithReaderBufferSize(readerBufferSize)
+}
+
 func (s *commitLogSource) Can(strategy bootstrap.Strategy) bool {
 	switch strategy {
 	case bootstrap.BootstrapSequential:
@@ -103,12 +295,104 @@ func (s *commitLogSource) AvailableData(
 	ns namespace.Metadata,
 	shardsTimeRanges result.ShardTimeRanges,
 ) result.ShardTimeRanges {
+	if s.opts.ReportAccurateAvailability() {
+		available, err := s.availableFromFiles(ns, shardsTimeRanges)
+		if err != nil {
+			s.log.
+				WithFields(xlog.NewErrField(err)).
+				Error("unable to determine available commit log data, reporting none available")
+			return result.ShardTimeRanges{}
+		}
+		return available
+	}
 	// Commit log bootstrapper is a last ditch effort, so fulfill all
 	// time ranges requested even if not enough data, just to succeed
 	// the bootstrap
 	return shardsTimeRanges
 }
 
+// availableFromFiles returns the requested ranges except for the blocks where
+// the commit log files present when the node started don't cover the entire
+// range of the commit log that would need to be read given the snapshots. When
+// only snapshots are read just the ranges covered by them are available.
+func (s *commitLogSource) availableFromFiles(
+	ns namespace.Metadata,
+	shardsTimeRanges result.ShardTimeRanges,
+) (result.ShardTimeRanges, error) {
+	if shardsTimeRanges.IsEmpty() {
+		return result.ShardTimeRanges{}, nil
+	}
+
+	var (
+		rOpts          = ns.Options().RetentionOptions()
+		blockSize      = rOpts.BlockSize()
+		commitLogOpts  = s.commitLogReadOptions()
+		fsOpts         = commitLogOpts.FilesystemOptions()
+		filePathPrefix = fsOpts.FilePathPrefix()
+		now            = s.nowFn()
+	)
+
+	snapshotFilesByShard, err := s.snapshotFilesByShard(
+		ns.ID(), filePathPrefix, shardsTimeRanges)
+	if err != nil {
+		return nil, err
+	}
+
+	mostRecentCompleteSnapshotByBlockShard, err := s.mostRecentCompleteSnapshotByBlockShard(
+		shardsTimeRanges, blockSize, snapshotFilesByShard, fsOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.opts.SnapshotsOnly() {
+		// The commit log isn't read so the writes received after each snapshot
+		// was taken are left for a subsequent bootstrapper, same as when reading.
+		available := shardsTimeRanges.Copy()
+		available.Subtract(snapshotTailsUnfulfilled(shardsTimeRanges, blockSize,
+			rOpts.BufferPast(), mostRecentCompleteSnapshotByBlockShard))
+		return available, nil
+	}
+
+	files, err := s.commitLogFilesFn(commitLogOpts)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list commit log files: %v", err)
+	}
+
+	var (
+		commitlogFilesPresentBeforeStart = s.inspection.CommitLogFilesSet()
+		covered                          = xtime.Ranges{}
+		unavailable                      = result.ShardTimeRanges{}
+	)
+	for _, f := range files {
+		if _, ok := commitlogFilesPresentBeforeStart[f.FilePath]; ok {
+			covered = covered.AddRange(xtime.Range{Start: f.Start, End: f.Start.Add(f.Duration)})
+		}
+	}
+
+	for blockStart, mostRecentByShard := range mostRecentCompleteSnapshotByBlockShard {
+		blockRange := xtime.Range{Start: blockStart.ToTime(), End: blockStart.ToTime().Add(blockSize)}
+		for shard, mostRecent := range mostRecentByShard {
+			required := commitLogRangeToCheck(rOpts, blockRange.Start, mostRecent.CachedSnapshotTime)
+			if required.End.After(now) {
+				// Nothing can have been written to the commit log after now.
+				required.End = now
+			}
+			if !required.Start.Before(required.End) {
+				continue
+			}
+			missing := xtime.Ranges{}.AddRange(required).RemoveRanges(covered)
+			if missing.IsEmpty() {
+				continue
+			}
+			unavailable[shard] = unavailable[shard].AddRange(blockRange)
+		}
+	}
+
+	available := shardsTimeRanges.Copy()
+	available.Subtract(unavailable)
+	return available, nil
+}
+
 // ReadData will read a combination of the available snapshot files and commit log files to
 // restore as much unflushed data from disk as possible. The logic for performing this
 // correctly is as follows:
@@ -119,13 +403,13 @@ func (s *commitLogSource) AvailableData(
 //        This value corresponds to the (local) moment in time right before the snapshotting process
 //        began.
 //    3.  Find the minimum SnapshotTime for all of the shards and block starts (call it t0), and
-//        replay (M3TSZ encode) all commit log entries whose system timestamps overlap the range
+//        replay (encode) all commit log entries whose system timestamps overlap the range
 //        [minimumSnapshotTimeAcrossShards, blockStart.Add(blockSize).Add(bufferPast)]. This logic
 //        has one exception which is in the case where there is no minimimum snapshot time across
 //        shards (the code treats this case as minimum snapshot time across shards == blockStart).
 //        In that case, we replay all commit log entries whose system timestamps overlap the range
 //        [blockStart.Add(-bufferFuture), blockStart.Add(blockSize).Add(bufferPast)].
-//    4.  For each shard/blockStart combination, merge all of the M3TSZ encoders that we created from
+//    4.  For each shard/blockStart combination, merge all of the encoders that we created from
 //        reading the commit log along with the data available in the corresponding snapshot file.
 //
 // Example #1:
@@ -178,11 +462,184 @@ func (s *commitLogSource) ReadData(
 	ns namespace.Metadata,
 	shardsTimeRanges result.ShardTimeRanges,
 	runOpts bootstrap.RunOptions,
+) (result.DataBootstrapResult, error) {
+	var (
+		numDatapointsMerged int64
+		skipped             = &skippedCommitLogFiles{}
+	)
+	onShardRead := func(r ShardReadResult) {
+		atomic.AddInt64(&numDatapointsMerged, r.NumDatapointsMerged)
+	}
+	bootstrapResult, err := s.readData(ns, shardsTimeRanges, runOpts, nil, skipped, onShardRead, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	s.recordBootstrapSummary(ns.ID(), shardsTimeRanges, bootstrapResult,
+		atomic.LoadInt64(&numDatapointsMerged), skipped.list())
+	return bootstrapResult, nil
+}
+
+// ReadStreaming bootstraps the shards and time ranges the same way as ReadData
+// but also sends the result of each shard on the channel as soon as the shard
+// has been merged, the channel is closed before returning. Unless streamed
+// shard results are omitted from the returned result they are part of it so
+// must not be closed by the receiver, either way more ranges may be marked as
+// unfulfilled in the returned result.
+func (s *commitLogSource) ReadStreaming(
+	ns namespace.Metadata,
+	shardsTimeRanges result.ShardTimeRanges,
+	runOpts bootstrap.RunOptions,
+	shardResults chan<- ShardReadResult,
+) (result.DataBootstrapResult, error) {
+	var (
+		numDatapointsMerged int64
+		streamedLock        sync.Mutex
+		streamed            = make(map[uint32]struct{}, len(shardsTimeRanges))
+		checkpoint          *shardCheckpoint
+		skipped             = &skippedCommitLogFiles{}
+	)
+	if s.opts.CheckpointDir() != "" {
+		checkpoint = s.newShardCheckpoint(ns.ID(), shardsTimeRanges)
+	}
+	onShardRead := func(r ShardReadResult) {
+		atomic.AddInt64(&numDatapointsMerged, r.NumDatapointsMerged)
+		streamedLock.Lock()
+		streamed[r.Shard] = struct{}{}
+		streamedLock.Unlock()
+		if checkpoint != nil && r.Unfulfilled.IsEmpty() {
+			// Only the receiver knows when the result is safe to skip.
+			shard := r.Shard
+			r.persisted = func() error { return checkpoint.record(shard) }
+		}
+		shardResults <- r
+	}
+	bootstrapResult, err := s.readData(ns, shardsTimeRanges, runOpts, checkpoint, skipped, onShardRead, nil)
+	close(shardResults)
+	if err != nil {
+		return nil, err
+	}
+
+	if checkpoint != nil {
+		s.removeCheckpoint(ns.ID(), checkpoint)
+	}
+	s.recordBootstrapSummary(ns.ID(), shardsTimeRanges, bootstrapResult,
+		atomic.LoadInt64(&numDatapointsMerged), skipped.list())
+	if !s.opts.OmitStreamedShardResults() {
+		return bootstrapResult, nil
+	}
+
+	// The receiver owns the shard results it was sent, only return the rest.
+	remaining := result.NewDataBootstrapResult()
+	for shard, shardResult := range bootstrapResult.ShardResults() {
+		if _, ok := streamed[shard]; !ok {
+			remaining.Add(shard, shardResult, nil)
+		}
+	}
+	remaining.SetUnfulfilled(bootstrapResult.Unfulfilled())
+	return remaining, nil
+}
+
+// ReadUnmerged reads the commit log datapoints the same way as ReadData but
+// hands over their encoders instead of merging them with the snapshots.
+func (s *commitLogSource) ReadUnmerged(
+	ns namespace.Metadata,
+	shardsTimeRanges result.ShardTimeRanges,
+	runOpts bootstrap.RunOptions,
+) (UnmergedResult, error) {
+	if s.opts.SnapshotsOnly() {
+		return nil, errUnmergedSnapshotsOnly
+	}
+
+	var unmerged map[uint32]*shardData
+	onUnmerged := func(u map[uint32]*shardData) {
+		unmerged = u
+	}
+	bootstrapResult, err := s.readData(ns, shardsTimeRanges, runOpts, nil, nil, nil, onUnmerged)
+	if err != nil {
+		return nil, err
+	}
+	return newUnmergedResult(unmerged, bootstrapResult.Unfulfilled()), nil
+}
+
+// ReadNamespaces bootstraps each of the namespaces the same way as ReadData,
+// up to the namespace read concurrency of them at a time. Each namespace reads
+// the commit log on its own, the series of other namespaces being filtered out
+// by the iterator, so the encoding and merging of every namespace remains
+// independent of the others.
+func (s *commitLogSource) ReadNamespaces(
+	requests []NamespaceReadRequest,
+	runOpts bootstrap.RunOptions,
+) []NamespaceReadResult {
+	var (
+		results    = make([]NamespaceReadResult, len(requests))
+		workerPool = xsync.NewWorkerPool(s.opts.NamespaceReadConcurrency())
+		wg         sync.WaitGroup
+	)
+	workerPool.Init()
+	for i, req := range requests {
+		i, req := i, req
+		results[i].Namespace = req.Namespace.ID()
+		wg.Add(1)
+		workerPool.Go(func() {
+			defer wg.Done()
+			results[i].Result, results[i].Err = s.ReadData(
+				req.Namespace, req.ShardsTimeRanges, runOpts)
+		})
+	}
+	wg.Wait()
+	return results
+}
+
+func (s *commitLogSource) readData(
+	ns namespace.Metadata,
+	shardsTimeRanges result.ShardTimeRanges,
+	runOpts bootstrap.RunOptions,
+	checkpoint *shardCheckpoint,
+	skipped *skippedCommitLogFiles,
+	onShardRead shardReadFn,
+	onUnmerged unmergedFn,
 ) (result.DataBootstrapResult, error) {
 	if shardsTimeRanges.IsEmpty() {
 		return result.NewDataBootstrapResult(), nil
 	}
 
+	// Series are distributed across this many encoding workers so guard against
+	// options that were never validated rather than dividing by zero.
+	if s.opts.EncodingConcurrency() <= 0 {
+		return nil, errEncodingConcurrencyPositive
+	}
+	s.checkCommitLogBlockSize(ns)
+
+	// Shards that are deliberately skipped aren't reported as orphans when their
+	// datapoints are read from the commit log.
+	var (
+		requestedShardsTimeRanges = shardsTimeRanges
+		excludedShardsTimeRanges  = result.ShardTimeRanges{}
+	)
+	if checkpoint != nil {
+		// Shards persisted by the receiver before an earlier bootstrap of the same
+		// shards and time ranges was interrupted are skipped. Their results aren't
+		// reloaded so they are returned as unfulfilled.
+		var checkpointed result.ShardTimeRanges
+		shardsTimeRanges, checkpointed = s.withoutCheckpointedShards(
+			ns.ID(), checkpoint, shardsTimeRanges)
+		excludedShardsTimeRanges.AddRanges(checkpointed)
+		if shardsTimeRanges.IsEmpty() {
+			return excludedShardsTimeRanges.ToUnfulfilledResult(), nil
+		}
+	}
+
+	if blockFilter := s.opts.BlockFilter(); blockFilter != nil {
+		var filtered result.ShardTimeRanges
+		shardsTimeRanges, filtered = filterShardTimeRangesByBlock(
+			shardsTimeRanges, ns.Options().RetentionOptions().BlockSize(), blockFilter)
+		excludedShardsTimeRanges.AddRanges(filtered)
+		if shardsTimeRanges.IsEmpty() {
+			return excludedShardsTimeRanges.ToUnfulfilledResult(), nil
+		}
+	}
+
 	var (
 		fsOpts         = s.opts.CommitLogOptions().FilesystemOptions()
 		filePathPrefix = fsOpts.FilePathPrefix()
@@ -194,34 +651,35 @@ func (s *commitLogSource) ReadData(
 	if err != nil {
 		return nil, err
 	}
-
-	var (
-		bOpts     = s.opts.ResultOptions()
-		blOpts    = bOpts.DatabaseBlockOptions()
-		blockSize = ns.Options().RetentionOptions().BlockSize()
-	)
+	s.recordShardsWithoutSnapshots(snapshotFilesByShard)
 
 	// Determine the minimum number of commit logs files that we
 	// must read based on the available snapshot files.
 	readCommitLogPred, mostRecentCompleteSnapshotByBlockShard, err := s.newReadCommitLogPredBasedOnAvailableSnapshotFiles(
-		ns, shardsTimeRanges, snapshotFilesByShard)
+		ns, shardsTimeRanges, snapshotFilesByShard, skipped)
 	if err != nil {
 		return nil, err
 	}
 
+	if s.opts.SnapshotsOnly() {
+		return s.readSnapshotsOnly(ns, shardsTimeRanges, excludedShardsTimeRanges,
+			snapshotFilesByShard, mostRecentCompleteSnapshotByBlockShard, onShardRead)
+	}
+
 	// Setup the commit log iterator.
 	var (
-		nsID              = ns.ID()
-		seriesSkipped     int
-		datapointsSkipped int
-		datapointsRead    int
+		nsID          = ns.ID()
+		seriesSkipped int
 
 		// TODO(rartoul): When we implement caching data across namespaces, this will need
 		// to be commitlog.ReadAllSeriesPredicate() if CacheSeriesMetadata() is enabled
 		// because we'll need to read data for all namespaces, not just the one we're currently
 		// bootstrapping.
+		seriesFilter        = s.seriesFilter()
+		namespaceMatcher    = s.namespaceMatcher()
 		readSeriesPredicate = func(id ident.ID, namespace ident.ID) bool {
-			shouldReadSeries := nsID.Equal(namespace)
+			shouldReadSeries := namespaceMatcher(nsID, namespace) &&
+				(seriesFilter == nil || seriesFilter(id))
 			if !shouldReadSeries {
 				seriesSkipped++
 			}
@@ -229,7 +687,7 @@ func (s *commitLogSource) ReadData(
 		}
 
 		iterOpts = commitlog.IteratorOpts{
-			CommitLogOptions:      s.opts.CommitLogOptions(),
+			CommitLogOptions:      s.commitLogReadOptions(),
 			FileFilterPredicate:   readCommitLogPred,
 			SeriesFilterPredicate: readSeriesPredicate,
 		}
@@ -237,102 +695,942 @@ func (s *commitLogSource) ReadData(
 
 	defer func() {
 		s.log.Infof("seriesSkipped: %d", seriesSkipped)
-		s.log.Infof("datapointsSkipped: %d", datapointsSkipped)
-		s.log.Infof("datapointsRead: %d", datapointsRead)
 	}()
 
 	iter, err := s.newIteratorFn(iterOpts)
 	if err != nil {
-		return nil, fmt.Errorf("unable to create commit log iterator: %v", err)
+		return nil, IteratorCreationError{Err: err}
 	}
 
 	defer iter.Close()
 
-	// Setup the M3TSZ encoding pipeline
+	bootstrapResult, err := s.readFromIterator(ns, shardsTimeRanges, runOpts, iter, ReadPlan{
+		MostRecentSnapshotByBlockShard: mostRecentCompleteSnapshotByBlockShard,
+		SnapshotFilesByShard:           snapshotFilesByShard,
+	}, skippedShards(requestedShardsTimeRanges, shardsTimeRanges), onShardRead, onUnmerged)
+	if err != nil {
+		return nil, err
+	}
+
+	// Shards skipped by the checkpoint and blocks excluded by the block filter
+	// were not bootstrapped.
+	for shard, ranges := range excludedShardsTimeRanges {
+		bootstrapResult.Add(shard, nil, ranges)
+	}
+
+	return bootstrapResult, nil
+}
+
+// checkCommitLogBlockSize warns when commit log files span more than a block
+// of the namespace. The files to read are chosen by their own start and
+// duration so none are missed, but a file spanning several blocks is read in
+// full whenever any of them needs commit log data which defeats the snapshots
+// of the others.
+func (s *commitLogSource) checkCommitLogBlockSize(ns namespace.Metadata) {
 	var (
-		// +1 so we can use the shard number as an index throughout without constantly
-		// remembering to subtract 1 to convert to zero-based indexing
-		numShards        = s.findHighestShard(shardsTimeRanges) + 1
-		numConc          = s.opts.EncodingConcurrency()
+		commitLogBlockSize = s.opts.CommitLogOptions().BlockSize()
+		nsBlockSize        = ns.Options().RetentionOptions().BlockSize()
+	)
+	if commitLogBlockSize <= nsBlockSize {
+		return
+	}
+	s.log.
+		WithFields(
+			xlog.NewField("namespace", ns.ID().String()),
+			xlog.NewField("commitLogBlockSize", commitLogBlockSize.String()),
+			xlog.NewField("namespaceBlockSize", nsBlockSize.String()),
+		).
+		Warn("commit log block size exceeds namespace block size")
+}
+
+// shardCheckpoint records the shards of a streaming bootstrap whose results the
+// receiver has persisted so that the same bootstrap, resumed after a crash, can
+// skip them. The file starts with a fingerprint of the requested shards and time
+// ranges so that it is ignored by a bootstrap of any others.
+type shardCheckpoint struct {
+	sync.Mutex
+
+	path        string
+	fingerprint string
+	// started is set once the file holds the fingerprint of this bootstrap.
+	started bool
+	// removed is set once the bootstrap has completed, shards persisted after
+	// that are not recorded.
+	removed bool
+}
+
+func (s *commitLogSource) newShardCheckpoint(
+	namespace ident.ID,
+	shardsTimeRanges result.ShardTimeRanges,
+) *shardCheckpoint {
+	fileName := fmt.Sprintf("commitlog-bootstrap-%s.checkpoint", namespace.String())
+	return &shardCheckpoint{
+		path:        filepath.Join(s.opts.CheckpointDir(), fileName),
+		fingerprint: shardTimeRangesFingerprint(shardsTimeRanges),
+	}
+}
+
+// shardTimeRangesFingerprint returns a fingerprint of the shards and time ranges
+// that doesn't depend on map iteration order.
+func shardTimeRangesFingerprint(shardsTimeRanges result.ShardTimeRanges) string {
+	shards := make([]uint32, 0, len(shardsTimeRanges))
+	for shard := range shardsTimeRanges {
+		shards = append(shards, shard)
+	}
+	sort.Slice(shards, func(i, j int) bool { return shards[i] < shards[j] })
+
+	h := fnv.New64a()
+	for _, shard := range shards {
+		fmt.Fprintf(h, "%d:", shard)
+		iter := shardsTimeRanges[shard].Iter()
+		for iter.Next() {
+			currRange := iter.Value()
+			fmt.Fprintf(h, "%d-%d,", currRange.Start.UnixNano(), currRange.End.UnixNano())
+		}
+		fmt.Fprint(h, ";")
+	}
+	return strconv.FormatUint(h.Sum64(), 16)
+}
+
+// read returns the shards recorded as persisted, the file holds the fingerprint
+// followed by one shard per line. A checkpoint written by a bootstrap of other
+// shards or time ranges returns errCheckpointMismatch and is overwritten by the
+// first shard recorded.
+func (c *shardCheckpoint) read() (map[uint32]struct{}, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	data, err := ioutil.ReadFile(c.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	lines := strings.Fields(string(data))
+	if len(lines) == 0 || lines[0] != c.fingerprint {
+		return nil, errCheckpointMismatch
+	}
+
+	completed := make(map[uint32]struct{}, len(lines)-1)
+	for _, line := range lines[1:] {
+		shard, err := strconv.ParseUint(line, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid shard in checkpoint: %s", line)
+		}
+		completed[uint32(shard)] = struct{}{}
+	}
+	c.started = true
+	return completed, nil
+}
+
+// record records that the result of the shard has been persisted.
+func (c *shardCheckpoint) record(shard uint32) error {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.removed {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0755); err != nil {
+		return err
+	}
+
+	var (
+		flags  = os.O_APPEND | os.O_CREATE | os.O_WRONLY
+		header string
+	)
+	if !c.started {
+		// Replace any checkpoint that wasn't written by this bootstrap.
+		flags = os.O_TRUNC | os.O_CREATE | os.O_WRONLY
+		header = c.fingerprint + "\n"
+	}
+	f, err := os.OpenFile(c.path, flags, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(f, "%s%d\n", header, shard); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	c.started = true
+	return f.Close()
+}
+
+// remove removes the checkpoint once the bootstrap has completed so that the
+// next bootstrap reads every shard again.
+func (c *shardCheckpoint) remove() error {
+	c.Lock()
+	defer c.Unlock()
+
+	c.removed = true
+	if err := os.Remove(c.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// withoutCheckpointedShards splits the shards and time ranges into those still
+// to be read and those of the shards recorded as persisted in the checkpoint.
+func (s *commitLogSource) withoutCheckpointedShards(
+	namespace ident.ID,
+	checkpoint *shardCheckpoint,
+	shardsTimeRanges result.ShardTimeRanges,
+) (result.ShardTimeRanges, result.ShardTimeRanges) {
+	completed, err := checkpoint.read()
+	if err != nil {
+		// Reading every shard again is always safe.
+		s.log.
+			WithFields(
+				xlog.NewField("namespace", namespace.String()),
+				xlog.NewErrField(err),
+			).
+			Warn("ignoring bootstrap checkpoint, bootstrapping every shard")
+		return shardsTimeRanges, nil
+	}
+	if len(completed) == 0 {
+		return shardsTimeRanges, nil
+	}
+
+	var (
+		remaining = make(result.ShardTimeRanges, len(shardsTimeRanges))
+		skipped   = make(result.ShardTimeRanges, len(completed))
+	)
+	for shard, ranges := range shardsTimeRanges {
+		if _, ok := completed[shard]; ok {
+			skipped[shard] = ranges
+			continue
+		}
+		remaining[shard] = ranges
+	}
+	s.log.
+		WithFields(
+			xlog.NewField("namespace", namespace.String()),
+			xlog.NewField("completedShards", len(skipped)),
+			xlog.NewField("remainingShards", len(remaining)),
+		).
+		Info("resuming bootstrap from checkpoint")
+	return remaining, skipped
+}
+
+// removeCheckpoint removes the checkpoint once the bootstrap has completed.
+func (s *commitLogSource) removeCheckpoint(namespace ident.ID, checkpoint *shardCheckpoint) {
+	if err := checkpoint.remove(); err != nil {
+		s.log.
+			WithFields(
+				xlog.NewField("namespace", namespace.String()),
+				xlog.NewErrField(err),
+			).
+			Warn("unable to remove bootstrap checkpoint")
+	}
+}
+
+// Reset prepares the source for another bootstrap with the commit log files
+// present when the node started, it must not be called while reading.
+func (s *commitLogSource) Reset(inspection fs.CommitLogFilesInspection) {
+	s.inspection = inspection
+
+	s.summariesLock.Lock()
+	s.summaries = make(map[string]BootstrapSummary)
+	s.summariesLock.Unlock()
+}
+
+// LastBootstrapSummary returns the summary of the most recent data bootstrap
+// of the namespace, if any.
+func (s *commitLogSource) LastBootstrapSummary(namespace ident.ID) (BootstrapSummary, bool) {
+	s.summariesLock.RLock()
+	summary, ok := s.summaries[namespace.String()]
+	s.summariesLock.RUnlock()
+	return summary, ok
+}
+
+func (s *commitLogSource) recordBootstrapSummary(
+	namespace ident.ID,
+	shardsTimeRanges result.ShardTimeRanges,
+	bootstrapResult result.DataBootstrapResult,
+	numDatapointsMerged int64,
+	skippedCommitLogFiles []string,
+) {
+	summary := newBootstrapSummary(shardsTimeRanges, bootstrapResult)
+	summary.NumDatapointsMerged = numDatapointsMerged
+	summary.SkippedCommitLogFiles = skippedCommitLogFiles
+	if n := len(summary.SkippedCommitLogFiles); n > 0 {
+		s.log.
+			WithFields(
+				xlog.NewField("namespace", namespace.String()),
+				xlog.NewField("skipped", n),
+			).
+			Infof("skipped %d commit log files outside bootstrap range", n)
+	}
+	s.log.
+		WithFields(
+			xlog.NewField("namespace", namespace.String()),
+			xlog.NewField("shards", summary.NumShards),
+			xlog.NewField("series", summary.NumSeries),
+			xlog.NewField("blocks", summary.NumBlocks),
+			xlog.NewField("bytes", summary.NumBytes),
+			xlog.NewField("datapointsMerged", summary.NumDatapointsMerged),
+			xlog.NewField("fulfilled", summary.Fulfilled.SummaryString()),
+			xlog.NewField("unfulfilled", summary.Unfulfilled.SummaryString()),
+		).
+		Info("commit log bootstrap summary")
+
+	s.summariesLock.Lock()
+	s.summaries[namespace.String()] = summary
+	s.summariesLock.Unlock()
+}
+
+func newBootstrapSummary(
+	shardsTimeRanges result.ShardTimeRanges,
+	bootstrapResult result.DataBootstrapResult,
+) BootstrapSummary {
+	summary := BootstrapSummary{
+		NumShards:   len(shardsTimeRanges),
+		Fulfilled:   shardsTimeRanges.Copy(),
+		Unfulfilled: bootstrapResult.Unfulfilled().Copy(),
+	}
+	summary.Fulfilled.Subtract(summary.Unfulfilled)
+
+	for _, shardResult := range bootstrapResult.ShardResults() {
+		if shardResult == nil {
+			continue
+		}
+		for _, entry := range shardResult.AllSeries().Iter() {
+			summary.NumSeries++
+			for _, dbBlock := range entry.Value().Blocks.AllBlocks() {
+				summary.NumBlocks++
+				summary.NumBytes += int64(dbBlock.Len())
+			}
+		}
+	}
+	return summary
+}
+
+// ReadFromIterator bootstraps the shards and time ranges from the datapoints
+// of the provided iterator merged with the snapshots in the plan.
+func (s *commitLogSource) ReadFromIterator(
+	ns namespace.Metadata,
+	shardsTimeRanges result.ShardTimeRanges,
+	runOpts bootstrap.RunOptions,
+	iter commitlog.Iterator,
+	plan ReadPlan,
+) (result.DataBootstrapResult, error) {
+	return s.readFromIterator(ns, shardsTimeRanges, runOpts, iter, plan, nil, nil, nil)
+}
+
+func (s *commitLogSource) readFromIterator(
+	ns namespace.Metadata,
+	shardsTimeRanges result.ShardTimeRanges,
+	runOpts bootstrap.RunOptions,
+	iter commitlog.Iterator,
+	plan ReadPlan,
+	skipped map[uint32]struct{},
+	onShardRead shardReadFn,
+	onUnmerged unmergedFn,
+) (result.DataBootstrapResult, error) {
+	if shardsTimeRanges.IsEmpty() {
+		return result.NewDataBootstrapResult(), nil
+	}
+
+	if s.opts.EncodingConcurrency() <= 0 {
+		return nil, errEncodingConcurrencyPositive
+	}
+
+	var (
+		bOpts             = s.opts.ResultOptions()
+		blOpts            = bOpts.DatabaseBlockOptions()
+		blockSize         = ns.Options().RetentionOptions().BlockSize()
+		datapointsSkipped int
+		datapointsRead    int
+		orphanDatapoints  int
+		logOrphans        = s.opts.LogOrphanDatapoints()
+		progressReporter  = s.opts.ProgressReporter()
+	)
+
+	defer func() {
+		s.log.Infof("datapointsSkipped: %d", datapointsSkipped)
+		s.log.Infof("datapointsRead: %d", datapointsRead)
+		s.metrics.datapointsSkipped.Inc(int64(datapointsSkipped))
+		s.metrics.datapointsRead.Inc(int64(datapointsRead))
+		s.metrics.orphanDatapoints.Inc(int64(orphanDatapoints))
+	}()
+
+	readStart := time.Now()
+	now := s.nowFn()
+
+	// Setup the encoding pipeline, the encoders come from the pool of the block
+	// options so the encoding used is whatever the pool was configured with.
+	var (
+		numConc          = s.numEncodingWorkers(shardsTimeRanges)
 		encoderPool      = blOpts.EncoderPool()
 		workerErrs       = make([]int, numConc)
-		shardDataByShard = s.newShardDataByShard(shardsTimeRanges, numShards)
+		workerErrSamples = make([][]encodeError, numConc)
+		workerDropped    = make([][]DroppedDatapoint, numConc)
+		workerOutOfOrder = make([]outOfOrderDatapoints, numConc)
+		droppedReporter  = s.opts.DroppedDatapointsReporter()
+		// Each worker is responsible for a distinct set of shards so the memory
+		// budget is split evenly between them.
+		workerMaxUnmergedBytes = s.opts.MaxUnmergedMemoryBytes() / int64(numConc)
+		shardDataByShard       = s.newShardDataByShard(
+			shardsTimeRanges, plan.MostRecentSnapshotByBlockShard)
+		ropts      = ns.Options().RetentionOptions()
+		bufferPast = ropts.BufferPast()
+		// Blocks that started before the cutoff have aged out of retention and
+		// would be discarded by the series as soon as they were loaded.
+		retentionCutoff = now.Add(-ropts.RetentionPeriod()).Truncate(blockSize)
+
+		workDistributor      = s.opts.WorkDistributor()
+		shardWorkers         = make(map[uint32]int, len(shardsTimeRanges))
+		distributeErr        error
+		blockedWarnThreshold = s.opts.EncoderBlockedWarnThreshold()
+		adaptive             *adaptiveConcurrency
 	)
+	if minConc, maxConc := s.opts.AdaptiveEncodingConcurrency(); maxConc > 0 {
+		adaptive = newAdaptiveConcurrency(minConc, numConc)
+	}
+
+	if s.opts.MaxUnmergedMemoryBytes() > 0 && workerMaxUnmergedBytes == 0 {
+		workerMaxUnmergedBytes = 1
+	}
 
 	encoderChans := make([]chan encoderArg, numConc)
 	for i := 0; i < numConc; i++ {
 		encoderChans[i] = make(chan encoderArg, encoderChanBufSize)
 	}
 
-	// Spin up numConc background go-routines to handle M3TSZ encoding. This must
+	// Spin up numConc background go-routines to handle encoding. This must
 	// happen before we start reading to prevent infinitely blocking writes to
 	// the encoderChans.
 	wg := &sync.WaitGroup{}
 	for workerNum, encoderChan := range encoderChans {
 		wg.Add(1)
-		go s.startM3TSZEncodingWorker(
-			ns, runOpts, workerNum, encoderChan, shardDataByShard, encoderPool, workerErrs, blOpts, wg)
+		go s.startEncodingWorker(
+			ns, runOpts, workerNum, encoderChan, shardDataByShard, encoderPool, workerErrs,
+			workerErrSamples, workerDropped, droppedReporter != nil, workerOutOfOrder,
+			workerMaxUnmergedBytes, blOpts, wg)
 	}
 
-	// Read / M3TSZ encode all the datapoints in the commit log that we need to read.
+	// Read / encode all the datapoints in the commit log that we need to read.
 	for iter.Next() {
 		series, dp, unit, annotation := iter.Current()
-		if !s.shouldEncodeForData(shardDataByShard, blockSize, series, dp.Timestamp) {
+		if !s.shouldEncodeForData(
+			shardDataByShard, blockSize, bufferPast, retentionCutoff, series, dp.Timestamp) {
 			datapointsSkipped++
+			_, bootstrapping := shardsTimeRanges[series.Shard]
+			_, isSkipped := skipped[series.Shard]
+			if !bootstrapping && !isSkipped {
+				// The shard isn't being bootstrapped at all which may mean the
+				// datapoint was routed to the wrong shard.
+				orphanDatapoints++
+				if logOrphans && orphanDatapoints <= maxOrphanDatapointSamples {
+					s.log.
+						WithFields(
+							xlog.NewField("namespace", series.Namespace.String()),
+							xlog.NewField("shard", series.Shard),
+							xlog.NewField("id", series.ID.String()),
+							xlog.NewField("timestamp", dp.Timestamp),
+						).
+						Warn("read datapoint from commit log for shard that is not being bootstrapped")
+				}
+			}
+			continue
+		}
+
+		datapointsRead++
+		if datapointsRead%progressReportInterval == 0 {
+			progressReporter.OnDatapointsRead(progressReportInterval)
+		}
+
+		// By default work is distributed such that each encoder goroutine is responsible
+		// for approximately numShards / numConc shards. This also means that all
+		// datapoints for a given shard/series will be processed in a serialized
+		// manner.
+		// We choose to distribute work by shard instead of series.UniqueIndex
+		// because it means that all accesses to the shardDataByShard entries don't need
+		// to be synchronized because each entry belongs to a single shard so it
+		// will only be accessed serially from a single worker routine. Any custom
+		// distributor must uphold this so verify it before handing the work off.
+		// With adaptive concurrency the number of workers changes as we go so
+		// shards keep the worker they were first assigned to instead.
+		workerNum, owned := shardWorkers[series.Shard]
+		if !owned || adaptive == nil {
+			numWorkers := numConc
+			if adaptive != nil {
+				numWorkers = adaptive.active
+			}
+			idx := workDistributor.WorkerIndex(series, numWorkers)
+			if idx < 0 || idx >= numWorkers {
+				distributeErr = fmt.Errorf(
+					"work distributor returned worker: %d for series: %s, expected [0, %d)",
+					idx, series.ID.String(), numWorkers)
+				break
+			}
+			if owned && idx != workerNum {
+				distributeErr = fmt.Errorf(
+					"work distributor returned worker: %d for series: %s in shard: %d already owned by worker: %d",
+					idx, series.ID.String(), series.Shard, workerNum)
+				break
+			}
+			workerNum = idx
+			shardWorkers[series.Shard] = workerNum
+		}
+
+		arg := encoderArg{
+			series:     series,
+			dp:         dp,
+			unit:       unit,
+			annotation: annotation,
+			blockStart: dp.Timestamp.Truncate(blockSize),
+		}
+		if blockedWarnThreshold <= 0 && adaptive == nil {
+			encoderChans[workerNum] <- arg
+			continue
+		}
+		blocked := s.sendEncoderArgMeasured(encoderChans[workerNum], arg, workerNum, blockedWarnThreshold)
+		if adaptive != nil && adaptive.observe(blocked) {
+			s.log.Debugf("adjusted active encoding workers to: %d", adaptive.active)
+		}
+	}
+
+	// Commit log files that couldn't be read are skipped by the iterator so only
+	// fail the bootstrap if an error can't be attributed to a file, unless any
+	// read error is configured to be fatal.
+	var (
+		fileErrs = iter.FileErrors()
+		readErr  = distributeErr
+	)
+	if iterErr := iter.Err(); readErr == nil && iterErr != nil &&
+		(len(fileErrs) == 0 || s.opts.FailOnCommitLogReadError()) {
+		readErr = iterErr
+	}
+	if readErr != nil {
+		for _, encoderChan := range encoderChans {
+			close(encoderChan)
+		}
+		wg.Wait()
+		// Nothing will be merged so return the encoders to the pool.
+		closeUnmergedEncoders(shardDataByShard)
+		return nil, readErr
+	}
+	if remaining := datapointsRead % progressReportInterval; remaining > 0 {
+		progressReporter.OnDatapointsRead(int64(remaining))
+	}
+
+	for _, encoderChan := range encoderChans {
+		close(encoderChan)
+	}
+
+	// Block until all required data from the commit log has been read and
+	// encoded by the worker goroutines
+	wg.Wait()
+	s.metrics.readDuration.Record(time.Since(readStart))
+	s.logEncodingOutcome(workerErrs, workerErrSamples, iter)
+	if droppedReporter != nil {
+		s.reportDroppedDatapoints(ns, droppedReporter, workerDropped)
+	}
+	if outOfOrderReporter := s.opts.OutOfOrderDatapointsReporter(); outOfOrderReporter != nil {
+		s.reportOutOfOrderDatapoints(ns, outOfOrderReporter, workerOutOfOrder)
+	}
+	if debugSink := s.opts.UnmergedDebugSink(); debugSink != nil {
+		debugSink.OnUnmerged(ns.ID(), unmergedStats(shardDataByShard))
+	}
+	if onUnmerged != nil {
+		// The encoders are handed over rather than merged so they must not be
+		// closed here.
+		onUnmerged(shardDataByShard)
+		bootstrapResult := result.NewDataBootstrapResult()
+		for shard, ranges := range s.unreadableCommitLogFilesUnfulfilled(ns, shardsTimeRanges, fileErrs) {
+			bootstrapResult.Add(shard, nil, ranges)
+		}
+		for shard, ranges := range droppedBlocksUnfulfilled(shardsTimeRanges, shardDataByShard, blockSize) {
+			bootstrapResult.Add(shard, nil, ranges)
+		}
+		return bootstrapResult, nil
+	}
+
+	// Merge all the different encoders from the commit log that we created with
+	// the data that is available in the snapshot files.
+	mergeStart := time.Now()
+	s.log.Infof("starting merge...")
+	bootstrapResult, err := s.mergeAllShardsCommitLogEncodersAndSnapshots(
+		ns,
+		shardsTimeRanges,
+		plan.SnapshotFilesByShard,
+		plan.MostRecentSnapshotByBlockShard,
+		blockSize,
+		shardDataByShard,
+		onShardRead,
+	)
+	if err != nil {
+		return nil, err
+	}
+	mergeDuration := time.Since(mergeStart)
+	s.metrics.mergeDuration.Record(mergeDuration)
+	s.log.Infof("done merging..., took: %s", mergeDuration.String())
+
+	// Blocks that may have had writes in commit log files that couldn't be read
+	// are incomplete.
+	for shard, ranges := range s.unreadableCommitLogFilesUnfulfilled(ns, shardsTimeRanges, fileErrs) {
+		bootstrapResult.Add(shard, nil, ranges)
+	}
+	// As are the blocks whose commit log data was dropped while encoding.
+	for shard, ranges := range droppedBlocksUnfulfilled(shardsTimeRanges, shardDataByShard, blockSize) {
+		bootstrapResult.Add(shard, nil, ranges)
+	}
+
+	return bootstrapResult, nil
+}
+
+// readSnapshotsOnly bootstraps the data in the snapshot files without replaying
+// the commit log, the data that may have been written after each snapshot was
+// taken is marked as unfulfilled so that a subsequent bootstrapper can fill it.
+func (s *commitLogSource) readSnapshotsOnly(
+	ns namespace.Metadata,
+	shardsTimeRanges result.ShardTimeRanges,
+	excludedShardsTimeRanges result.ShardTimeRanges,
+	snapshotFilesByShard map[uint32]fs.FileSetFilesSlice,
+	mostRecentCompleteSnapshotByBlockShard map[xtime.UnixNano]map[uint32]fs.FileSetFile,
+	onShardRead shardReadFn,
+) (result.DataBootstrapResult, error) {
+	var (
+		blockSize = ns.Options().RetentionOptions().BlockSize()
+		// No commit log data to merge with the snapshots.
+		shardDataByShard = s.newShardDataByShard(
+			shardsTimeRanges, mostRecentCompleteSnapshotByBlockShard)
+	)
+
+	mergeStart := time.Now()
+	bootstrapResult, err := s.mergeAllShardsCommitLogEncodersAndSnapshots(
+		ns,
+		shardsTimeRanges,
+		snapshotFilesByShard,
+		mostRecentCompleteSnapshotByBlockShard,
+		blockSize,
+		shardDataByShard,
+		onShardRead,
+	)
+	if err != nil {
+		return nil, err
+	}
+	s.metrics.mergeDuration.Record(time.Since(mergeStart))
+
+	for shard, ranges := range excludedShardsTimeRanges {
+		bootstrapResult.Add(shard, nil, ranges)
+	}
+
+	tails := snapshotTailsUnfulfilled(shardsTimeRanges, blockSize,
+		ns.Options().RetentionOptions().BufferPast(), mostRecentCompleteSnapshotByBlockShard)
+	for shard, ranges := range tails {
+		bootstrapResult.Add(shard, nil, ranges)
+	}
+
+	return bootstrapResult, nil
+}
+
+// snapshotTailsUnfulfilled returns the ranges of each block that may contain
+// writes received after the most recent snapshot for the block was taken.
+func snapshotTailsUnfulfilled(
+	shardsTimeRanges result.ShardTimeRanges,
+	blockSize time.Duration,
+	bufferPast time.Duration,
+	mostRecentCompleteSnapshotByBlockShard map[xtime.UnixNano]map[uint32]fs.FileSetFile,
+) result.ShardTimeRanges {
+	unfulfilled := result.ShardTimeRanges{}
+	for blockStart, mostRecentByShard := range mostRecentCompleteSnapshotByBlockShard {
+		for shard, mostRecent := range mostRecentByShard {
+			ranges, ok := shardsTimeRanges[shard]
+			if !ok {
+				continue
+			}
+
+			// Writes received after the snapshot was taken can be for any time
+			// within buffer past of it, blocks without a snapshot have their
+			// snapshot time set to the block start so are entirely unfulfilled.
+			var (
+				start = blockStart.ToTime()
+				tail  = xtime.Range{
+					Start: mostRecent.CachedSnapshotTime.Add(-bufferPast),
+					End:   start.Add(blockSize),
+				}
+			)
+			if tail.Start.Before(start) {
+				tail.Start = start
+			}
+
+			notTail := ranges.RemoveRange(tail)
+			tailRanges := ranges.RemoveRanges(notTail)
+			if tailRanges.IsEmpty() {
+				continue
+			}
+			unfulfilled.AddRanges(result.ShardTimeRanges{shard: tailRanges})
+		}
+	}
+	return unfulfilled
+}
+
+// unreadableCommitLogFilesUnfulfilled logs the commit log files that could not
+// be read and returns the ranges of the blocks that could have had writes in them.
+func (s *commitLogSource) unreadableCommitLogFilesUnfulfilled(
+	ns namespace.Metadata,
+	shardsTimeRanges result.ShardTimeRanges,
+	fileErrs []commitlog.FileError,
+) result.ShardTimeRanges {
+	var (
+		rOpts       = ns.Options().RetentionOptions()
+		blockSize   = rOpts.BlockSize()
+		unfulfilled = result.ShardTimeRanges{}
+	)
+	for _, fileErr := range fileErrs {
+		s.log.
+			WithFields(
+				xlog.NewField("file", fileErr.File.FilePath),
+				xlog.NewField("start", fileErr.File.Start),
+				xlog.NewField("duration", fileErr.File.Duration),
+				xlog.NewErrField(fileErr.Err),
+			).
+			Error("unable to read commit log file, marking its blocks as unfulfilled")
+		s.metrics.commitLogFileErrors.Inc(1)
+
+		// The file contains writes received during its duration which can be for
+		// any block within the buffer past and future of that time.
+		var (
+			start    = fileErr.File.Start.Add(-rOpts.BufferPast()).Truncate(blockSize)
+			end      = fileErr.File.Start.Add(fileErr.File.Duration).Add(rOpts.BufferFuture())
+			affected = xtime.Range{Start: start, End: end.Truncate(blockSize).Add(blockSize)}
+		)
+		for shard, ranges := range shardsTimeRanges {
+			notAffected := ranges.RemoveRange(affected)
+			affectedRanges := ranges.RemoveRanges(notAffected)
+			if affectedRanges.IsEmpty() {
+				continue
+			}
+			unfulfilled.AddRanges(result.ShardTimeRanges{shard: affectedRanges})
+		}
+	}
+	return unfulfilled
+}
+
+// skippedShards returns the requested shards that are no longer read, such as
+// those completed before a checkpoint or with every block filtered out.
+func skippedShards(
+	requested result.ShardTimeRanges,
+	read result.ShardTimeRanges,
+) map[uint32]struct{} {
+	var skipped map[uint32]struct{}
+	for shard := range requested {
+		if _, ok := read[shard]; ok {
 			continue
 		}
+		if skipped == nil {
+			skipped = make(map[uint32]struct{})
+		}
+		skipped[shard] = struct{}{}
+	}
+	return skipped
+}
+
+// filterShardTimeRangesByBlock splits the shard time ranges into the ranges of
+// the blocks that pass the filter and the ranges of those that don't.
+func filterShardTimeRangesByBlock(
+	shardsTimeRanges result.ShardTimeRanges,
+	blockSize time.Duration,
+	blockFilter BlockFilter,
+) (result.ShardTimeRanges, result.ShardTimeRanges) {
+	var (
+		included = result.ShardTimeRanges{}
+		excluded = result.ShardTimeRanges{}
+	)
+	for shard, ranges := range shardsTimeRanges {
+		var shardIncluded, shardExcluded xtime.Ranges
+		iter := ranges.Iter()
+		for iter.Next() {
+			currRange := iter.Value()
+			for blockStart := currRange.Start.Truncate(blockSize); blockStart.Before(currRange.End); blockStart = blockStart.Add(blockSize) {
+				blockRange := xtime.Range{
+					Start: blockStart,
+					End:   blockStart.Add(blockSize),
+				}
+				// Only include the part of the block that was requested.
+				if blockRange.Start.Before(currRange.Start) {
+					blockRange.Start = currRange.Start
+				}
+				if blockRange.End.After(currRange.End) {
+					blockRange.End = currRange.End
+				}
+
+				if blockFilter(xtime.ToUnixNano(blockStart)) {
+					shardIncluded = shardIncluded.AddRange(blockRange)
+				} else {
+					shardExcluded = shardExcluded.AddRange(blockRange)
+				}
+			}
+		}
+
+		if !shardIncluded.IsEmpty() {
+			included[shard] = shardIncluded
+		}
+		if !shardExcluded.IsEmpty() {
+			excluded[shard] = shardExcluded
+		}
+	}
+	return included, excluded
+}
+
+// Plan determines which snapshot and commit log files ReadData would read for the
+// provided shards and time ranges without reading any of them.
+func (s *commitLogSource) Plan(
+	ns namespace.Metadata,
+	shardsTimeRanges result.ShardTimeRanges,
+) (ReadPlan, error) {
+	if shardsTimeRanges.IsEmpty() {
+		return ReadPlan{}, nil
+	}
+
+	filePathPrefix := s.opts.CommitLogOptions().FilesystemOptions().FilePathPrefix()
+	snapshotFilesByShard, err := s.snapshotFilesByShard(
+		ns.ID(), filePathPrefix, shardsTimeRanges)
+	if err != nil {
+		return ReadPlan{}, err
+	}
+
+	mostRecentCompleteSnapshotByBlockShard, rangesToCheck, err := s.planCommitLogReads(
+		ns, shardsTimeRanges, snapshotFilesByShard)
+	if err != nil {
+		return ReadPlan{}, err
+	}
+
+	commitLogFiles, err := s.commitLogFilesToRead(rangesToCheck)
+	if err != nil {
+		return ReadPlan{}, err
+	}
+
+	return ReadPlan{
+		MostRecentSnapshotByBlockShard: mostRecentCompleteSnapshotByBlockShard,
+		SnapshotFilesByShard:           snapshotFilesByShard,
+		RangesToCheck:                  rangesToCheck,
+		CommitLogFiles:                 commitLogFiles,
+	}, nil
+}
+
+// SnapshotTimes returns the snapshot time resolved for each block and shard of
+// the plan and whether it fell back to the block start.
+func (p ReadPlan) SnapshotTimes() map[xtime.UnixNano]map[uint32]BlockSnapshotTime {
+	snapshotTimes := make(map[xtime.UnixNano]map[uint32]BlockSnapshotTime,
+		len(p.MostRecentSnapshotByBlockShard))
+	for blockStart, byShard := range p.MostRecentSnapshotByBlockShard {
+		shardTimes := make(map[uint32]BlockSnapshotTime, len(byShard))
+		for shard, snapshot := range byShard {
+			// Fallbacks are recorded without any snapshot files.
+			shardTimes[shard] = BlockSnapshotTime{
+				SnapshotTime:         snapshot.CachedSnapshotTime,
+				FellBackToBlockStart: snapshot.IsZero(),
+			}
+		}
+		snapshotTimes[blockStart] = shardTimes
+	}
+	return snapshotTimes
+}
 
-		datapointsRead++
+// SnapshotIndexGaps returns the gaps in the volume indices of the snapshot
+// files of each block and shard of the plan.
+func (p ReadPlan) SnapshotIndexGaps() []SnapshotIndexGap {
+	return snapshotIndexGaps(p.SnapshotFilesByShard)
+}
 
-		// Distribute work such that each encoder goroutine is responsible for
-		// approximately numShards / numConc shards. This also means that all
-		// datapoints for a given shard/series will be processed in a serialized
-		// manner.
-		// We choose to distribute work by shard instead of series.UniqueIndex
-		// because it means that all accesses to the shardDataByShard slice don't need
-		// to be synchronized because each index belongs to a single shard so it
-		// will only be accessed serially from a single worker routine.
-		encoderChans[series.Shard%uint32(numConc)] <- encoderArg{
-			series:     series,
-			dp:         dp,
-			unit:       unit,
-			annotation: annotation,
-			blockStart: dp.Timestamp.Truncate(blockSize),
+// snapshotIndexGaps returns the ranges of volume indices missing between the
+// lowest and highest index of the snapshot files of each block and shard,
+// ordered by shard, block start and index.
+func snapshotIndexGaps(snapshotFilesByShard map[uint32]fs.FileSetFilesSlice) []SnapshotIndexGap {
+	var gaps []SnapshotIndexGap
+	for shard, files := range snapshotFilesByShard {
+		indicesByBlock := make(map[xtime.UnixNano][]int)
+		for _, f := range files {
+			blockStart := xtime.ToUnixNano(f.ID.BlockStart)
+			indicesByBlock[blockStart] = append(indicesByBlock[blockStart], f.ID.VolumeIndex)
+		}
+		for blockStart, indices := range indicesByBlock {
+			sort.Ints(indices)
+			for i := 1; i < len(indices); i++ {
+				if indices[i]-indices[i-1] <= 1 {
+					continue
+				}
+				gaps = append(gaps, SnapshotIndexGap{
+					Shard:        shard,
+					BlockStart:   blockStart.ToTime(),
+					FirstMissing: indices[i-1] + 1,
+					LastMissing:  indices[i] - 1,
+				})
+			}
 		}
 	}
+	sort.Slice(gaps, func(i, j int) bool {
+		if gaps[i].Shard != gaps[j].Shard {
+			return gaps[i].Shard < gaps[j].Shard
+		}
+		if !gaps[i].BlockStart.Equal(gaps[j].BlockStart) {
+			return gaps[i].BlockStart.Before(gaps[j].BlockStart)
+		}
+		return gaps[i].FirstMissing < gaps[j].FirstMissing
+	})
+	return gaps
+}
 
-	if iterErr := iter.Err(); iterErr != nil {
-		return nil, iterErr
+// EstimateCost estimates the cost of bootstrapping the provided shards and time
+// ranges from the sizes of the snapshot and commit log files that ReadData would
+// read, none of the files are read.
+func (s *commitLogSource) EstimateCost(
+	ns namespace.Metadata,
+	shardsTimeRanges result.ShardTimeRanges,
+) (BootstrapCostEstimate, error) {
+	plan, err := s.Plan(ns, shardsTimeRanges)
+	if err != nil {
+		return BootstrapCostEstimate{}, err
 	}
 
-	for _, encoderChan := range encoderChans {
-		close(encoderChan)
+	var estimate BootstrapCostEstimate
+	for _, mostRecentByShard := range plan.MostRecentSnapshotByBlockShard {
+		for _, snapshot := range mostRecentByShard {
+			if snapshot.IsZero() {
+				// No snapshot for this block and shard.
+				continue
+			}
+			size, err := filesSize(snapshot.AbsoluteFilepaths)
+			if err != nil {
+				return BootstrapCostEstimate{}, err
+			}
+			estimate.NumSnapshotFileSets++
+			estimate.SnapshotBytes += size
+		}
 	}
 
-	// Block until all required data from the commit log has been read and
-	// encoded by the worker goroutines
-	wg.Wait()
-	s.logEncodingOutcome(workerErrs, iter)
+	if s.opts.SnapshotsOnly() {
+		return estimate, nil
+	}
 
-	// Merge all the different encoders from the commit log that we created with
-	// the data that is available in the snapshot files.
-	mergeStart := time.Now()
-	s.log.Infof("starting merge...")
-	bootstrapResult, err := s.mergeAllShardsCommitLogEncodersAndSnapshots(
-		ns,
-		shardsTimeRanges,
-		snapshotFilesByShard,
-		mostRecentCompleteSnapshotByBlockShard,
-		int(numShards),
-		blockSize,
-		shardDataByShard,
-	)
+	size, err := filesSize(plan.CommitLogFiles)
 	if err != nil {
-		return nil, err
+		return BootstrapCostEstimate{}, err
 	}
-	s.log.Infof("done merging..., took: %s", time.Since(mergeStart).String())
+	estimate.NumCommitLogFiles = len(plan.CommitLogFiles)
+	estimate.CommitLogBytes = size
+	return estimate, nil
+}
 
-	return bootstrapResult, nil
+func filesSize(filePaths []string) (int64, error) {
+	var size int64
+	for _, filePath := range filePaths {
+		info, err := os.Stat(filePath)
+		if err != nil {
+			return 0, fmt.Errorf("unable to determine size of file %s: %v", filePath, err)
+		}
+		size += info.Size()
+	}
+	return size, nil
 }
 
 func (s *commitLogSource) snapshotFilesByShard(
@@ -352,15 +1650,49 @@ func (s *commitLogSource) snapshotFilesByShard(
 	return snapshotFilesByShard, nil
 }
 
+// recordShardsWithoutSnapshots counts the shards that have no snapshot files, this
+// is expected for new shards and the entire commit log is read for them instead.
+func (s *commitLogSource) recordShardsWithoutSnapshots(
+	snapshotFilesByShard map[uint32]fs.FileSetFilesSlice,
+) {
+	for shard, snapshotFiles := range snapshotFilesByShard {
+		if len(snapshotFiles) == 0 {
+			s.log.Debugf("no snapshot files for shard: %d", shard)
+			s.metrics.shardsNoSnapshots.Inc(1)
+		}
+	}
+}
+
+// newShardDataByShard returns the shard data for each of the shards being
+// bootstrapped. It is keyed by shard rather than indexed by it so that a
+// sparse set of high numbered shards doesn't allocate a slot for every lower
+// shard, the map is never modified after this so it's safe for concurrent reads.
 func (s *commitLogSource) newShardDataByShard(
 	shardsTimeRanges result.ShardTimeRanges,
-	numShards uint32,
-) []shardData {
-	shardDataByShard := make([]shardData, numShards)
-	for shard := range shardsTimeRanges {
-		shardDataByShard[shard] = shardData{
-			series: NewMap(MapOptions{}),
-			ranges: shardsTimeRanges[shard],
+	mostRecentCompleteSnapshotByBlockShard map[xtime.UnixNano]map[uint32]fs.FileSetFile,
+) map[uint32]*shardData {
+	shardDataByShard := make(map[uint32]*shardData, len(shardsTimeRanges))
+	for shard, ranges := range shardsTimeRanges {
+		if ranges.IsEmpty() {
+			// Nothing is bootstrapped for the shard so none of its datapoints are
+			// encoded and it has nothing to merge.
+			continue
+		}
+
+		snapshotTimes := make(map[xtime.UnixNano]time.Time, len(mostRecentCompleteSnapshotByBlockShard))
+		for blockStart, mostRecentByShard := range mostRecentCompleteSnapshotByBlockShard {
+			mostRecent, ok := mostRecentByShard[shard]
+			if !ok || mostRecent.CachedSnapshotTime.Equal(blockStart.ToTime()) {
+				// No snapshot for this block so every datapoint needs to be read.
+				continue
+			}
+			snapshotTimes[blockStart] = mostRecent.CachedSnapshotTime
+		}
+
+		shardDataByShard[shard] = &shardData{
+			series:        NewMap(MapOptions{}),
+			ranges:        ranges,
+			snapshotTimes: snapshotTimes,
 		}
 	}
 
@@ -371,82 +1703,215 @@ func (s *commitLogSource) newShardDataByShard(
 // map[xtime.UnixNano]map[uint32]fs.FileSetFile with the contract that
 // for each shard/block combination in shardsTimeRanges, an entry will
 // exist in the map such that FileSetFile.CachedSnapshotTime is the
-// actual cached snapshot time, or the blockStart.
+// actual cached snapshot time, or the blockStart. Blocks that don't overlap
+// the ranges of a shard use the blockStart without any snapshot being read,
+// the range may start in the middle of a block of another shard for instance.
+// An error is returned if the snapshot time of more snapshots than allowed
+// could not be resolved.
 func (s *commitLogSource) mostRecentCompleteSnapshotByBlockShard(
 	shardsTimeRanges result.ShardTimeRanges,
 	blockSize time.Duration,
 	snapshotFilesByShard map[uint32]fs.FileSetFilesSlice,
 	fsOpts fs.Options,
-) map[xtime.UnixNano]map[uint32]fs.FileSetFile {
+) (map[xtime.UnixNano]map[uint32]fs.FileSetFile, error) {
 	var (
 		minBlock, maxBlock              = shardsTimeRanges.MinMax()
 		mostRecentSnapshotsByBlockShard = map[xtime.UnixNano]map[uint32]fs.FileSetFile{}
+		// Resolving the snapshot time requires reading the snapshot info file
+		// from disk so we do it in parallel.
+		workerPool     = xsync.NewWorkerPool(s.opts.SnapshotResolutionConcurrency())
+		lock           sync.Mutex
+		wg             sync.WaitGroup
+		resolutionErrs int
+
+		infoReaderBufferSize = s.opts.SnapshotInfoReaderBufferSize()
 	)
+	if infoReaderBufferSize == 0 {
+		infoReaderBufferSize = fsOpts.InfoReaderBufferSize()
+	}
+	workerPool.Init()
 
-	for currBlockStart := minBlock.Truncate(blockSize); currBlockStart.Before(maxBlock); currBlockStart = currBlockStart.Add(blockSize) {
-		for shard := range shardsTimeRanges {
-			// Anonymous func for easier clean up using defer.
-			func() {
-				var (
-					currBlockUnixNanos = xtime.ToUnixNano(currBlockStart)
-					mostRecentSnapshot fs.FileSetFile
-				)
-
-				defer func() {
-					existing := mostRecentSnapshotsByBlockShard[currBlockUnixNanos]
-					if existing == nil {
-						existing = map[uint32]fs.FileSetFile{}
-					}
+	setMostRecentSnapshot := func(
+		blockStart time.Time,
+		shard uint32,
+		mostRecentSnapshot fs.FileSetFile,
+	) {
+		if mostRecentSnapshot.IsZero() || mostRecentSnapshot.CachedSnapshotTime.IsZero() {
+			// If we were unable to determine the most recent snapshot time for a given
+			// shard/blockStart combination, then just fall back to using the blockStart
+			// time as that will force us to read the entire commit log for that duration.
+			mostRecentSnapshot = fs.FileSetFile{CachedSnapshotTime: blockStart}
+		}
 
-					if mostRecentSnapshot.IsZero() {
-						// If we were unable to determine the most recent snapshot time for a given
-						// shard/blockStart combination, then just fall back to using the blockStart
-						// time as that will force us to read the entire commit log for that duration.
-						mostRecentSnapshot.CachedSnapshotTime = currBlockStart
-					}
-					existing[shard] = mostRecentSnapshot
-					mostRecentSnapshotsByBlockShard[currBlockUnixNanos] = existing
-				}()
+		lock.Lock()
+		blockStartNano := xtime.ToUnixNano(blockStart)
+		existing := mostRecentSnapshotsByBlockShard[blockStartNano]
+		if existing == nil {
+			existing = map[uint32]fs.FileSetFile{}
+			mostRecentSnapshotsByBlockShard[blockStartNano] = existing
+		}
+		existing[shard] = mostRecentSnapshot
+		lock.Unlock()
+	}
 
-				snapshotFiles, ok := snapshotFilesByShard[shard]
-				if !ok {
-					// If there are no snapshot files for this shard, then rely on
-					// the defer to fallback to using the block start time.
-					return
-				}
+	for currBlockStart := minBlock.Truncate(blockSize); currBlockStart.Before(maxBlock); currBlockStart = currBlockStart.Add(blockSize) {
+		blockRange := xtime.Range{Start: currBlockStart, End: currBlockStart.Add(blockSize)}
+		for shard, ranges := range shardsTimeRanges {
+			if !ranges.Overlaps(blockRange) {
+				// The block isn't bootstrapped for this shard so its snapshot time is
+				// ignored by minimumMostRecentSnapshotTimeByBlock, don't resolve it.
+				setMostRecentSnapshot(currBlockStart, shard, fs.FileSetFile{})
+				continue
+			}
 
-				mostRecentSnapshotVolume, ok := snapshotFiles.LatestVolumeForBlock(currBlockStart)
-				if !ok {
-					// If there are no complete snapshot files for this block, then rely on
-					// the defer to fallback to using the block start time.
-					return
-				}
+			// Finding the latest volume sorts the slice in place so it can't be
+			// performed concurrently, but it doesn't require any I/O either.
+			mostRecentSnapshotVolume, numDuplicates, ok := latestSnapshotVolumeForBlock(
+				snapshotFilesByShard[shard], currBlockStart)
+			if numDuplicates > 0 {
+				s.log.
+					WithFields(
+						xlog.NewField("namespace", mostRecentSnapshotVolume.ID.Namespace),
+						xlog.NewField("blockStart", currBlockStart),
+						xlog.NewField("shard", shard),
+						xlog.NewField("index", mostRecentSnapshotVolume.ID.VolumeIndex),
+						xlog.NewField("duplicates", numDuplicates),
+						xlog.NewField("filepaths", mostRecentSnapshotVolume.AbsoluteFilepaths),
+					).
+					Warn("found complete snapshot files with duplicate index, using the first by file path")
+			}
+			if !ok {
+				// If there are no complete snapshot files for this shard and block, then
+				// fallback to using the block start time.
+				setMostRecentSnapshot(currBlockStart, shard, fs.FileSetFile{})
+				continue
+			}
+
+			currBlockStart, shard := currBlockStart, shard
+			wg.Add(1)
+			workerPool.Go(func() {
+				defer wg.Done()
 
 				// Make sure we're able to read the snapshot time. This will also set the
 				// CachedSnapshotTime field so that we can rely upon it from here on out.
-				_, err := mostRecentSnapshotVolume.SnapshotTime()
+				snapshotTime, err := s.snapshotTimeFn(mostRecentSnapshotVolume, infoReaderBufferSize)
+				if err == nil && snapshotTime.IsZero() {
+					// A zero snapshot time would otherwise be treated as a snapshot taken
+					// at the epoch rather than one whose time is unknown.
+					err = errSnapshotTimeZero
+				}
 				if err != nil {
 					s.log.
 						WithFields(
-							xlog.NewField("namespace", mostRecentSnapshot.ID.Namespace),
-							xlog.NewField("blockStart", mostRecentSnapshot.ID.BlockStart),
-							xlog.NewField("shard", mostRecentSnapshot.ID.Shard),
-							xlog.NewField("index", mostRecentSnapshot.ID.VolumeIndex),
-							xlog.NewField("filepaths", mostRecentSnapshot.AbsoluteFilepaths),
+							xlog.NewField("namespace", mostRecentSnapshotVolume.ID.Namespace),
+							xlog.NewField("blockStart", mostRecentSnapshotVolume.ID.BlockStart),
+							xlog.NewField("shard", mostRecentSnapshotVolume.ID.Shard),
+							xlog.NewField("index", mostRecentSnapshotVolume.ID.VolumeIndex),
+							xlog.NewField("filepaths", mostRecentSnapshotVolume.AbsoluteFilepaths),
+							xlog.NewErrField(err),
 						).
 						Error("error resolving snapshot time for snapshot file")
+					lock.Lock()
+					resolutionErrs++
+					lock.Unlock()
 
-					// If we couldn't determine the snapshot time for the snapshot file, then rely
-					// on the defer to fallback to using the block start time.
+					// If we couldn't determine the snapshot time for the snapshot file, then
+					// fallback to using the block start time.
+					setMostRecentSnapshot(currBlockStart, shard, fs.FileSetFile{})
 					return
 				}
 
-				mostRecentSnapshot = mostRecentSnapshotVolume
-			}()
+				mostRecentSnapshotVolume.CachedSnapshotTime = snapshotTime
+				setMostRecentSnapshot(currBlockStart, shard, mostRecentSnapshotVolume)
+			})
+		}
+	}
+
+	wg.Wait()
+
+	// Many snapshots being unreadable indicates systemic corruption rather than
+	// the odd bad file so fail instead of silently reading the entire commit log.
+	maxErrs := s.opts.MaxSnapshotTimeResolutionErrors()
+	if maxErrs >= 0 && resolutionErrs > maxErrs {
+		return nil, fmt.Errorf(
+			"unable to resolve snapshot time for %d snapshot files which exceeds the max of %d",
+			resolutionErrs, maxErrs)
+	}
+
+	return mostRecentSnapshotsByBlockShard, nil
+}
+
+// validateSnapshotInfo returns an error if the snapshot opened by the reader
+// is not for the namespace, shard and block requested. The block start and
+// size come from the info file of the snapshot while the namespace and shard
+// are those the reader resolved the files of.
+func validateSnapshotInfo(
+	reader fs.DataFileSetReader,
+	nsID ident.ID,
+	shard uint32,
+	blockStart time.Time,
+	blockSize time.Duration,
+) error {
+	var (
+		status     = reader.Status()
+		blockRange = reader.Range()
+	)
+	if status.Namespace == nil || !status.Namespace.Equal(nsID) || status.Shard != shard {
+		return fmt.Errorf(
+			"snapshot is for namespace: %v and shard: %d but expected namespace: %s and shard: %d",
+			status.Namespace, status.Shard, nsID.String(), shard)
+	}
+	if !blockRange.Start.Equal(blockStart) || blockRange.End.Sub(blockRange.Start) != blockSize {
+		return fmt.Errorf(
+			"snapshot is for block: %s with size: %s but expected block: %s with size: %s",
+			blockRange.Start.String(), blockRange.End.Sub(blockRange.Start).String(),
+			blockStart.String(), blockSize.String())
+	}
+	return nil
+}
+
+// latestSnapshotVolumeForBlock returns the complete snapshot of the block with
+// the highest volume index, the same as LatestVolumeForBlock, along with the
+// number of other complete snapshots that have the same index. Those should
+// never exist, if they do the snapshot whose sorted file paths come first is
+// chosen so that bootstraps are reproducible. Like LatestVolumeForBlock this
+// sorts the files in place.
+func latestSnapshotVolumeForBlock(
+	files fs.FileSetFilesSlice,
+	blockStart time.Time,
+) (fs.FileSetFile, int, bool) {
+	latest, ok := files.LatestVolumeForBlock(blockStart)
+	if !ok {
+		return fs.FileSetFile{}, 0, false
+	}
+
+	var (
+		numDuplicates int
+		latestPaths   = sortedFilepaths(latest)
+	)
+	for _, f := range files {
+		if !f.ID.BlockStart.Equal(blockStart) || f.ID.VolumeIndex != latest.ID.VolumeIndex ||
+			!f.HasCheckpointFile() {
+			continue
+		}
+		paths := sortedFilepaths(f)
+		if paths == latestPaths {
+			continue
+		}
+		numDuplicates++
+		if paths < latestPaths {
+			latest, latestPaths = f, paths
 		}
 	}
+	return latest, numDuplicates, true
+}
 
-	return mostRecentSnapshotsByBlockShard
+// sortedFilepaths returns the file paths of the fileset sorted and joined so
+// that filesets can be compared regardless of the order of their files.
+func sortedFilepaths(f fs.FileSetFile) string {
+	paths := append([]string(nil), f.AbsoluteFilepaths...)
+	sort.Strings(paths)
+	return strings.Join(paths, ",")
 }
 
 func (s *commitLogSource) minimumMostRecentSnapshotTimeByBlock(
@@ -457,7 +1922,12 @@ func (s *commitLogSource) minimumMostRecentSnapshotTimeByBlock(
 	minimumMostRecentSnapshotTimeByBlock := map[xtime.UnixNano]time.Time{}
 	for blockStart, mostRecentSnapshotsByShard := range mostRecentSnapshotByBlockShard {
 
-		var minMostRecentSnapshot time.Time
+		// Shards without a snapshot have a snapshot time of blockStart which is never
+		// after any real snapshot time, so they always drive the minimum.
+		var (
+			minMostRecentSnapshot time.Time
+			found                 bool
+		)
 		for shard, mostRecentSnapshotForShard := range mostRecentSnapshotsByShard {
 			blockRange := xtime.Range{Start: blockStart.ToTime(), End: blockStart.ToTime().Add(blockSize)}
 			if !shardsTimeRanges[shard].Overlaps(blockRange) {
@@ -467,12 +1937,13 @@ func (s *commitLogSource) minimumMostRecentSnapshotTimeByBlock(
 				continue
 			}
 
-			if mostRecentSnapshotForShard.CachedSnapshotTime.Before(minMostRecentSnapshot) || minMostRecentSnapshot.IsZero() {
+			if !found || mostRecentSnapshotForShard.CachedSnapshotTime.Before(minMostRecentSnapshot) {
 				minMostRecentSnapshot = mostRecentSnapshotForShard.CachedSnapshotTime
+				found = true
 			}
 		}
 
-		if minMostRecentSnapshot.IsZero() {
+		if !found {
 			// If we didn't find a minimum most recent snapshot time for this blockStart, just use the
 			// blockStart as the minimum since we'll need to read the entire commit log in this case.
 			minMostRecentSnapshot = blockStart.ToTime()
@@ -484,6 +1955,9 @@ func (s *commitLogSource) minimumMostRecentSnapshotTimeByBlock(
 	return minimumMostRecentSnapshotTimeByBlock
 }
 
+// bootstrapShardSnapshots reads the snapshot files for a shard and returns
+// the time ranges of any blocks whose snapshot could not be read so that they
+// can be marked as unfulfilled.
 func (s *commitLogSource) bootstrapShardSnapshots(
 	nsID ident.ID,
 	shard uint32,
@@ -492,10 +1966,12 @@ func (s *commitLogSource) bootstrapShardSnapshots(
 	blockSize time.Duration,
 	snapshotFiles fs.FileSetFilesSlice,
 	mostRecentCompleteSnapshotByBlockShard map[xtime.UnixNano]map[uint32]fs.FileSetFile,
-) (result.ShardResult, error) {
+	dedupCache *blockDedupCache,
+) (result.ShardResult, xtime.Ranges, error) {
 	var (
 		shardResult    result.ShardResult
 		allSeriesSoFar *result.Map
+		unfulfilled    xtime.Ranges
 		rangeIter      = shardTimeRanges.Iter()
 		err            error
 	)
@@ -509,7 +1985,7 @@ func (s *commitLogSource) bootstrapShardSnapshots(
 		)
 
 		if !isMultipleOfBlockSize {
-			return nil, fmt.Errorf(
+			return nil, nil, fmt.Errorf(
 				"received bootstrap range that is not multiple of blockSize, blockSize: %d, start: %s, end: %s",
 				blockSize, currRange.End.String(), currRange.Start.String(),
 			)
@@ -524,25 +2000,116 @@ func (s *commitLogSource) bootstrapShardSnapshots(
 			snapshotsForBlock := mostRecentCompleteSnapshotByBlockShard[xtime.ToUnixNano(blockStart)]
 			mostRecentCompleteSnapshotForShardBlock := snapshotsForBlock[shard]
 
-			if mostRecentCompleteSnapshotForShardBlock.CachedSnapshotTime.Equal(blockStart) ||
-				// Should never happen
-				mostRecentCompleteSnapshotForShardBlock.IsZero() {
-				// There is no snapshot file for this time, and even if there was, there would
-				// be no point in reading it. In this specific case its not an error scenario
-				// because the fact that snapshotTime == blockStart means we already accounted
-				// for the fact that this snapshot did not exist when we were deciding which
-				// commit logs to read.
-				s.log.Debugf(
-					"no snapshots for shard: %d and blockStart: %s",
-					shard, blockStart.String())
-				continue
+			var candidates []fs.FileSetFile
+			if mostRecentCompleteSnapshotForShardBlock.IsZero() && s.opts.AllowIncompleteSnapshots() {
+				// The entire commit log is replayed for blocks without a complete snapshot
+				// so an incomplete snapshot can only add to the data that's bootstrapped.
+				if incomplete, ok := latestIncompleteSnapshotForBlock(snapshotFiles, blockStart); ok {
+					s.log.
+						WithFields(
+							xlog.NewField("shard", shard),
+							xlog.NewField("blockStart", blockStart),
+							xlog.NewField("index", incomplete.ID.VolumeIndex),
+							xlog.NewField("filepaths", incomplete.AbsoluteFilepaths),
+						).
+						Warn("UNSAFE: no complete snapshot for block, reading snapshot without a checkpoint file which may be partially written")
+					candidates = []fs.FileSetFile{incomplete}
+				}
+			}
+
+			if len(candidates) == 0 {
+				if mostRecentCompleteSnapshotForShardBlock.CachedSnapshotTime.Equal(blockStart) ||
+					// Should never happen
+					mostRecentCompleteSnapshotForShardBlock.IsZero() {
+					// There is no snapshot file for this time, and even if there was, there would
+					// be no point in reading it. In this specific case its not an error scenario
+					// because the fact that snapshotTime == blockStart means we already accounted
+					// for the fact that this snapshot did not exist when we were deciding which
+					// commit logs to read.
+					s.log.Debugf(
+						"no snapshots for shard: %d and blockStart: %s",
+						shard, blockStart.String())
+					continue
+				}
+
+				// Try the most recent complete snapshot first and fall back to earlier complete
+				// snapshots for the same block if it can't be read.
+				candidates = completeSnapshotsForBlockNewestFirst(
+					snapshotFiles, blockStart, mostRecentCompleteSnapshotForShardBlock)
 			}
 
-			shardResult, err = s.bootstrapShardBlockSnapshot(
-				nsID, shard, blockStart, metadataOnly, shardResult, allSeriesSoFar, blockSize,
-				snapshotFiles, mostRecentCompleteSnapshotForShardBlock)
+			var fellBack bool
+			for i, candidate := range candidates {
+				fellBack = i > 0
+				if !snapshotListed(snapshotFiles, candidate) {
+					// The snapshot chosen when planning may have been removed since, don't
+					// try to open a volume that is known not to exist anymore.
+					err = errSnapshotNotListed
+				} else {
+					shardResult, err = s.bootstrapShardBlockSnapshot(
+						nsID, shard, blockStart, metadataOnly, shardResult, allSeriesSoFar, blockSize,
+						snapshotFiles, candidate, dedupCache)
+					if err == nil {
+						break
+					}
+					if err == errSnapshotTruncated {
+						// Keep the entries read before the truncation, the rest of the
+						// block is left for a subsequent bootstrapper.
+						break
+					}
+
+					// Release any data read from the snapshot before it failed.
+					discardBlocksAt(shardResult, blockStart)
+				}
+
+				if i < len(candidates)-1 {
+					// Writes that occurred between the two snapshots aren't replayed from the
+					// commit log since it's only read from the most recent snapshot time, so
+					// the block is marked as unfulfilled if the earlier snapshot is read.
+					s.log.
+						WithFields(
+							xlog.NewField("shard", shard),
+							xlog.NewField("blockStart", blockStart),
+							xlog.NewField("index", candidate.ID.VolumeIndex),
+							xlog.NewField("fallbackIndex", candidates[i+1].ID.VolumeIndex),
+							xlog.NewErrField(err),
+						).
+						Warn("unable to read snapshot file, falling back to earlier snapshot")
+				}
+			}
+			if err == nil && fellBack {
+				// Keep the data of the earlier snapshot but leave the writes between the
+				// two snapshots, which are missing, to a subsequent bootstrapper.
+				s.log.
+					WithFields(
+						xlog.NewField("shard", shard),
+						xlog.NewField("blockStart", blockStart),
+						xlog.NewField("index", candidates[0].ID.VolumeIndex),
+					).
+					Warn("read earlier snapshot file, marking block as unfulfilled")
+				unfulfilled = unfulfilled.AddRange(xtime.Range{
+					Start: blockStart,
+					End:   blockStart.Add(blockSize),
+				})
+			}
 			if err != nil {
-				return shardResult, err
+				// The snapshot was expected to be readable when we decided which commit logs
+				// to read so the data it contains will be missing, mark the block as unfulfilled
+				// so that a subsequent bootstrapper has the chance to fulfill it.
+				if err != errSnapshotTruncated {
+					s.log.
+						WithFields(
+							xlog.NewField("shard", shard),
+							xlog.NewField("blockStart", blockStart),
+							xlog.NewField("index", candidates[0].ID.VolumeIndex),
+							xlog.NewErrField(err),
+						).
+						Error("unable to read snapshot file, marking block as unfulfilled")
+				}
+				unfulfilled = unfulfilled.AddRange(xtime.Range{
+					Start: blockStart,
+					End:   blockStart.Add(blockSize),
+				})
 			}
 		}
 	}
@@ -550,7 +2117,84 @@ func (s *commitLogSource) bootstrapShardSnapshots(
 	if shardResult == nil {
 		shardResult = result.NewShardResult(0, s.opts.ResultOptions())
 	}
-	return shardResult, nil
+	return shardResult, unfulfilled, nil
+}
+
+// snapshotListed returns whether the snapshot volume is one of the snapshot files.
+func snapshotListed(snapshotFiles fs.FileSetFilesSlice, snapshot fs.FileSetFile) bool {
+	for _, f := range snapshotFiles {
+		if f.ID.BlockStart.Equal(snapshot.ID.BlockStart) &&
+			f.ID.VolumeIndex == snapshot.ID.VolumeIndex {
+			return true
+		}
+	}
+	return false
+}
+
+// completeSnapshotsForBlockNewestFirst returns the most recent complete snapshot for
+// a block followed by any earlier complete snapshots for the same block, newest first.
+func completeSnapshotsForBlockNewestFirst(
+	snapshotFiles fs.FileSetFilesSlice,
+	blockStart time.Time,
+	mostRecent fs.FileSetFile,
+) []fs.FileSetFile {
+	candidates := []fs.FileSetFile{mostRecent}
+	for _, snapshot := range snapshotFiles {
+		if !snapshot.ID.BlockStart.Equal(blockStart) ||
+			snapshot.ID.VolumeIndex >= mostRecent.ID.VolumeIndex ||
+			!snapshot.HasCheckpointFile() {
+			continue
+		}
+		candidates = append(candidates, snapshot)
+	}
+
+	earlier := candidates[1:]
+	sort.Slice(earlier, func(i, j int) bool {
+		return earlier[i].ID.VolumeIndex > earlier[j].ID.VolumeIndex
+	})
+	return candidates
+}
+
+// discardBlocksAt closes and removes the blocks starting at blockStart from the
+// shard result so that the data of a snapshot that failed part way through being
+// read is returned to the pools rather than mixed with that of another snapshot.
+func discardBlocksAt(shardResult result.ShardResult, blockStart time.Time) {
+	if shardResult == nil {
+		return
+	}
+	for _, entry := range shardResult.AllSeries().Iter() {
+		blocks := entry.Value().Blocks
+		if b, ok := blocks.BlockAt(blockStart); ok {
+			blocks.RemoveBlockAt(blockStart)
+			b.Close()
+		}
+	}
+}
+
+// latestIncompleteSnapshotForBlock returns the latest snapshot for a block that
+// doesn't have a checkpoint file, provided that there's no complete snapshot for
+// the block at all.
+func latestIncompleteSnapshotForBlock(
+	snapshotFiles fs.FileSetFilesSlice,
+	blockStart time.Time,
+) (fs.FileSetFile, bool) {
+	var (
+		latest fs.FileSetFile
+		found  bool
+	)
+	for _, snapshot := range snapshotFiles {
+		if !snapshot.ID.BlockStart.Equal(blockStart) {
+			continue
+		}
+		if snapshot.HasCheckpointFile() {
+			return fs.FileSetFile{}, false
+		}
+		if !found || snapshot.ID.VolumeIndex > latest.ID.VolumeIndex {
+			latest = snapshot
+			found = true
+		}
+	}
+	return latest, found
 }
 
 func (s *commitLogSource) bootstrapShardBlockSnapshot(
@@ -562,19 +2206,24 @@ func (s *commitLogSource) bootstrapShardBlockSnapshot(
 	allSeriesSoFar *result.Map,
 	blockSize time.Duration,
 	snapshotFiles fs.FileSetFilesSlice,
-	mostRecentCompleteSnapshot fs.FileSetFile,
+	snapshot fs.FileSetFile,
+	dedupCache *blockDedupCache,
 ) (result.ShardResult, error) {
 	var (
 		bOpts      = s.opts.ResultOptions()
 		blOpts     = bOpts.DatabaseBlockOptions()
 		blocksPool = blOpts.DatabaseBlockPool()
 		bytesPool  = blOpts.BytesPool()
+		readPool   = s.opts.SnapshotReadBytesPool()
 		fsOpts     = s.opts.CommitLogOptions().FilesystemOptions()
 		idPool     = s.opts.CommitLogOptions().IdentifierPool()
 	)
+	if readPool == nil {
+		readPool = bytesPool
+	}
 
 	// Bootstrap the snapshot file
-	reader, err := s.newReaderFn(bytesPool, fsOpts)
+	reader, err := s.newReaderFn(readPool, fsOpts)
 	if err != nil {
 		return shardResult, err
 	}
@@ -584,17 +2233,25 @@ func (s *commitLogSource) bootstrapShardBlockSnapshot(
 			Namespace:   nsID,
 			BlockStart:  blockStart,
 			Shard:       shard,
-			VolumeIndex: mostRecentCompleteSnapshot.ID.VolumeIndex,
+			VolumeIndex: snapshot.ID.VolumeIndex,
 		},
-		FileSetType: persist.FileSetSnapshotType,
+		FileSetType:            persist.FileSetSnapshotType,
+		AllowMissingCheckpoint: s.opts.AllowIncompleteSnapshots() && !snapshot.HasCheckpointFile(),
 	})
 	if err != nil {
 		return shardResult, err
 	}
+	defer reader.Close()
+
+	if s.opts.ValidateSnapshotInfo() {
+		if err := validateSnapshotInfo(reader, nsID, shard, blockStart, blockSize); err != nil {
+			return shardResult, err
+		}
+	}
 
 	s.log.Infof(
 		"reading snapshot for shard: %d and blockStart: %s and volume: %d",
-		shard, blockStart.String(), mostRecentCompleteSnapshot.ID.VolumeIndex)
+		shard, blockStart.String(), snapshot.ID.VolumeIndex)
 	for {
 		var (
 			id               ident.ID
@@ -607,6 +2264,16 @@ func (s *commitLogSource) bootstrapShardBlockSnapshot(
 		} else {
 			id, tagsIter, data, expectedChecksum, err = reader.Read()
 		}
+		if err == io.ErrUnexpectedEOF {
+			s.log.
+				WithFields(
+					xlog.NewField("shard", shard),
+					xlog.NewField("blockStart", blockStart),
+					xlog.NewField("index", snapshot.ID.VolumeIndex),
+				).
+				Warn("snapshot file is truncated, keeping the entries read so far and marking block as unfulfilled")
+			return shardResult, errSnapshotTruncated
+		}
 		if err != nil && err != io.EOF {
 			return shardResult, err
 		}
@@ -615,22 +2282,61 @@ func (s *commitLogSource) bootstrapShardBlockSnapshot(
 			break
 		}
 
+		if data != nil && readPool != bytesPool {
+			data = copyBytes(bytesPool, data)
+		}
+
+		var (
+			dedup        = dedupCache != nil && !metadataOnly
+			segmentFlags = ts.FinalizeHead
+		)
+		if dedup {
+			// The data may end up shared with identical blocks so the block is only
+			// used to verify the checksum and doesn't finalize it.
+			segmentFlags = ts.FinalizeNone
+		}
 		dbBlock := blocksPool.Get()
-		dbBlock.Reset(blockStart, blockSize, ts.NewSegment(data, nil, ts.FinalizeHead))
+		dbBlock.Reset(blockStart, blockSize, ts.NewSegment(data, nil, segmentFlags))
+		closeBlock := func() {
+			dbBlock.Close()
+			if dedup {
+				data.Finalize()
+			}
+		}
 
 		if !metadataOnly {
 			// Resetting the block will trigger a checksum calculation, so use that instead
 			// of calculating it twice.
 			checksum, err := dbBlock.Checksum()
 			if err != nil {
+				closeBlock()
 				return shardResult, err
 			}
 
 			if checksum != expectedChecksum {
+				closeBlock()
 				return shardResult, fmt.Errorf("checksum for series: %s was %d but expected %d", id, checksum, expectedChecksum)
 			}
 		}
 
+		if dedup {
+			shared, duplicate := dedupCache.share(blockStart, expectedChecksum, data)
+			dbBlock.Close()
+			switch {
+			case shared == nil:
+				// The cache was closed by a bootstrap that is no longer waiting for
+				// this read so the block keeps the data to itself.
+				shared = data
+			case duplicate:
+				// The block shares the data of an identical one so its own copy can
+				// be returned to the pool.
+				data.Finalize()
+				s.metrics.blocksDeduplicated.Inc(1)
+			}
+			dbBlock = blocksPool.Get()
+			dbBlock.Reset(blockStart, blockSize, ts.NewSegment(shared, nil, ts.FinalizeHead))
+		}
+
 		var (
 			tags             ident.Tags
 			shouldDecodeTags = true
@@ -651,6 +2357,7 @@ func (s *commitLogSource) bootstrapShardBlockSnapshot(
 			if tagsIter.Remaining() > 0 {
 				tags, err = convert.TagsFromTagsIter(id, tagsIter, idPool)
 				if err != nil {
+					dbBlock.Close()
 					return shardResult, fmt.Errorf("unable to decode tags: %v", err)
 				}
 			}
@@ -659,14 +2366,6 @@ func (s *commitLogSource) bootstrapShardBlockSnapshot(
 		// Always close even if we didn't use it.
 		tagsIter.Close()
 
-		// Mark the ID and Tags as no finalize to enable no-copy optimization later
-		// in the bootstrap process (when they're being loaded into the shard). Also,
-		// technically we'll be calling NoFinalize() repeatedly on the same IDs for
-		// different blocks since we're reusing them, but thats ok as it an idempotent
-		// operation and there is no concurrency here.
-		id.NoFinalize()
-		tags.NoFinalize()
-
 		if shardResult == nil {
 			// Delay initialization so we can estimate size.
 			shardResult = result.NewShardResult(reader.Entries(), s.opts.ResultOptions())
@@ -681,10 +2380,146 @@ func (s *commitLogSource) newReadCommitLogPredBasedOnAvailableSnapshotFiles(
 	ns namespace.Metadata,
 	shardsTimeRanges result.ShardTimeRanges,
 	snapshotFilesByShard map[uint32]fs.FileSetFilesSlice,
+	skipped *skippedCommitLogFiles,
 ) (
 	func(f commitlog.File) bool,
 	map[xtime.UnixNano]map[uint32]fs.FileSetFile,
 	error,
+) {
+	mostRecentCompleteSnapshotByBlockShard, rangesToCheck, err := s.planCommitLogReads(
+		ns, shardsTimeRanges, snapshotFilesByShard)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var (
+		max    = s.opts.MaxCommitLogFilesToRead()
+		detect = s.opts.DetectOverlappingCommitLogFiles()
+	)
+	if (max > 0 || detect) && !s.opts.SnapshotsOnly() {
+		commitLogFiles, err := s.selectedCommitLogFiles(rangesToCheck)
+		if err != nil {
+			return nil, nil, err
+		}
+		// Fail before reading anything so that overly wide time ranges are caught
+		// early rather than surfacing as a bootstrap that never finishes.
+		if max > 0 && len(commitLogFiles) > max {
+			return nil, nil, fmt.Errorf(
+				"bootstrap would read %d commit log files which exceeds MaxCommitLogFilesToRead of %d, check the time ranges being bootstrapped",
+				len(commitLogFiles), max)
+		}
+		if detect {
+			s.reportOverlappingCommitLogFiles(ns.ID(), commitLogFiles)
+		}
+	}
+
+	return s.newReadCommitLogPred(rangesToCheck, skipped), mostRecentCompleteSnapshotByBlockShard, nil
+}
+
+// commitLogFilesToRead returns the paths of the commit log files that overlap
+// with the ranges to check and so would be selected by newReadCommitLogPred.
+func (s *commitLogSource) commitLogFilesToRead(rangesToCheck []xtime.Range) ([]string, error) {
+	files, err := s.selectedCommitLogFiles(rangesToCheck)
+	if err != nil {
+		return nil, err
+	}
+
+	var commitLogFiles []string
+	for _, f := range files {
+		commitLogFiles = append(commitLogFiles, f.FilePath)
+	}
+	return commitLogFiles, nil
+}
+
+// selectedCommitLogFiles returns the commit log files that overlap with the
+// ranges to check and so would be selected by newReadCommitLogPred.
+func (s *commitLogSource) selectedCommitLogFiles(rangesToCheck []xtime.Range) ([]commitlog.File, error) {
+	files, err := s.commitLogFilesFn(s.commitLogReadOptions())
+	if err != nil {
+		return nil, fmt.Errorf("unable to list commit log files: %v", err)
+	}
+
+	var (
+		commitlogFilesPresentBeforeStart = s.inspection.CommitLogFilesSet()
+		commitLogFiles                   []commitlog.File
+	)
+	for _, f := range files {
+		if ShouldReadCommitLogFile(f, rangesToCheck, commitlogFilesPresentBeforeStart) {
+			commitLogFiles = append(commitLogFiles, f)
+		}
+	}
+	return commitLogFiles, nil
+}
+
+// commitLogFileOverlap is a pair of commit log files whose time ranges overlap.
+type commitLogFileOverlap struct {
+	first  commitlog.File
+	second commitlog.File
+}
+
+// overlappingCommitLogFiles returns the pairs of commit log files whose time
+// ranges overlap, each file is paired with the file that reaches furthest of
+// those starting before it.
+func overlappingCommitLogFiles(files []commitlog.File) []commitLogFileOverlap {
+	sorted := append([]commitlog.File(nil), files...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if !sorted[i].Start.Equal(sorted[j].Start) {
+			return sorted[i].Start.Before(sorted[j].Start)
+		}
+		return sorted[i].Index < sorted[j].Index
+	})
+
+	var overlaps []commitLogFileOverlap
+	if len(sorted) == 0 {
+		return overlaps
+	}
+
+	furthest := sorted[0]
+	for _, f := range sorted[1:] {
+		furthestEnd := furthest.Start.Add(furthest.Duration)
+		if f.Start.Before(furthestEnd) {
+			overlaps = append(overlaps, commitLogFileOverlap{first: furthest, second: f})
+		}
+		if f.Start.Add(f.Duration).After(furthestEnd) {
+			furthest = f
+		}
+	}
+	return overlaps
+}
+
+// reportOverlappingCommitLogFiles logs and counts the commit log files selected
+// to be read whose time ranges overlap, such as after a crash and restart wrote
+// the same writes to multiple files, since their datapoints are read twice.
+func (s *commitLogSource) reportOverlappingCommitLogFiles(
+	namespace ident.ID,
+	files []commitlog.File,
+) {
+	overlaps := overlappingCommitLogFiles(files)
+	for _, overlap := range overlaps {
+		s.log.
+			WithFields(
+				xlog.NewField("namespace", namespace.String()),
+				xlog.NewField("file", overlap.second.FilePath),
+				xlog.NewField("start", overlap.second.Start),
+				xlog.NewField("overlappingFile", overlap.first.FilePath),
+				xlog.NewField("overlappingEnd", overlap.first.Start.Add(overlap.first.Duration)),
+			).
+			Warn("commit log file overlaps with another selected file, its writes may be replayed twice")
+	}
+	s.metrics.overlappingFiles.Inc(int64(len(overlaps)))
+}
+
+// planCommitLogReads determines the most recent complete snapshot for each block and
+// shard along with the system time ranges that commit log files need to overlap with
+// in order to be read.
+func (s *commitLogSource) planCommitLogReads(
+	ns namespace.Metadata,
+	shardsTimeRanges result.ShardTimeRanges,
+	snapshotFilesByShard map[uint32]fs.FileSetFilesSlice,
+) (
+	map[xtime.UnixNano]map[uint32]fs.FileSetFile,
+	[]xtime.Range,
+	error,
 ) {
 	blockSize := ns.Options().RetentionOptions().BlockSize()
 
@@ -696,8 +2531,23 @@ func (s *commitLogSource) newReadCommitLogPredBasedOnAvailableSnapshotFiles(
 	// snapshot that was taken for each shard. I.E we want to create a datastructure that looks
 	// like this:
 	// 		map[blockStart]map[shard]mostRecentSnapshotTime
-	mostRecentCompleteSnapshotByBlockShard := s.mostRecentCompleteSnapshotByBlockShard(
+	mostRecentCompleteSnapshotByBlockShard, err := s.mostRecentCompleteSnapshotByBlockShard(
 		shardsTimeRanges, blockSize, snapshotFilesByShard, s.opts.CommitLogOptions().FilesystemOptions())
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, gap := range snapshotIndexGaps(snapshotFilesByShard) {
+		// Only reported, the most recent complete snapshot is still read.
+		s.log.
+			WithFields(
+				xlog.NewField("namespace", ns.ID().String()),
+				xlog.NewField("shard", gap.Shard),
+				xlog.NewField("blockStart", gap.BlockStart),
+				xlog.NewField("firstMissingIndex", gap.FirstMissing),
+				xlog.NewField("lastMissingIndex", gap.LastMissing),
+			).
+			Warn("snapshot volume indices for block are not contiguous, a snapshot may have failed")
+	}
 	for block, mostRecentByShard := range mostRecentCompleteSnapshotByBlockShard {
 		for shard, mostRecent := range mostRecentByShard {
 
@@ -733,20 +2583,17 @@ func (s *commitLogSource) newReadCommitLogPredBasedOnAvailableSnapshotFiles(
 	// construct a new predicate based on the data structure we constructed earlier where the new
 	// predicate will check if there is any overlap between a commit log file and a temporary range
 	// we construct that begins with the minimum snapshot time and ends with the end of that block + bufferPast.
-	return s.newReadCommitLogPred(ns, minimumMostRecentSnapshotTimeByBlock), mostRecentCompleteSnapshotByBlockShard, nil
+	rangesToCheck := s.commitLogRangesToCheck(ns, minimumMostRecentSnapshotTimeByBlock)
+	return mostRecentCompleteSnapshotByBlockShard, rangesToCheck, nil
 }
 
-func (s *commitLogSource) newReadCommitLogPred(
+func (s *commitLogSource) commitLogRangesToCheck(
 	ns namespace.Metadata,
 	minimumMostRecentSnapshotTimeByBlock map[xtime.UnixNano]time.Time,
-) func(f commitlog.File) bool {
+) []xtime.Range {
 	var (
-		rOpts                            = ns.Options().RetentionOptions()
-		blockSize                        = rOpts.BlockSize()
-		bufferPast                       = rOpts.BufferPast()
-		bufferFuture                     = rOpts.BufferFuture()
-		rangesToCheck                    = []xtime.Range{}
-		commitlogFilesPresentBeforeStart = s.inspection.CommitLogFilesSet()
+		rOpts         = ns.Options().RetentionOptions()
+		rangesToCheck = []xtime.Range{}
 	)
 
 	for blockStart, minimumMostRecentSnapshotTime := range minimumMostRecentSnapshotTimeByBlock {
@@ -768,62 +2615,238 @@ func (s *commitLogSource) newReadCommitLogPred(
 		// have to take bufferFuture into account because commit logs with system timestamps in the previous
 		// block may contain writes for the block that we're trying to bootstrap, and we can't rely upon the
 		// fact that they are already included in our (non-existent) snapshot.
-		if minimumMostRecentSnapshotTime.Equal(blockStart.ToTime()) {
-			minimumMostRecentSnapshotTime = minimumMostRecentSnapshotTime.Add(-bufferFuture)
-		}
-		rangesToCheck = append(rangesToCheck, xtime.Range{
-			Start: minimumMostRecentSnapshotTime,
-			End:   blockStart.ToTime().Add(blockSize).Add(bufferPast),
-		})
+		rangesToCheck = append(rangesToCheck, commitLogRangeToCheck(
+			rOpts, blockStart.ToTime(), minimumMostRecentSnapshotTime))
+	}
+
+	return rangesToCheck
+}
+
+// commitLogRangeToCheck returns the system time range of the commit log that
+// must be read to bootstrap a block merged with a snapshot taken at the snapshot
+// time, see commitLogRangesToCheck.
+func commitLogRangeToCheck(
+	rOpts retention.Options,
+	blockStart time.Time,
+	snapshotTime time.Time,
+) xtime.Range {
+	if snapshotTime.Equal(blockStart) {
+		snapshotTime = snapshotTime.Add(-rOpts.BufferFuture())
 	}
+	return xtime.Range{
+		Start: snapshotTime,
+		End:   blockStart.Add(rOpts.BlockSize()).Add(rOpts.BufferPast()),
+	}
+}
 
-	// TODO: We have to rely on the global minimum across shards to determine which commit log files
-	// we need to read, but we can still skip datapoints from the commitlog itself that belong to a shard
-	// that has a snapshot more recent than the global minimum. If we use an array for fast-access this could
-	// be a small win in terms of memory utilization.
+func (s *commitLogSource) newReadCommitLogPred(
+	rangesToCheck []xtime.Range,
+	skipped *skippedCommitLogFiles,
+) func(f commitlog.File) bool {
+	var (
+		commitlogFilesPresentBeforeStart = s.inspection.CommitLogFilesSet()
+		progressReporter                 = s.opts.ProgressReporter()
+	)
+
+	// We have to rely on the global minimum across shards to determine which commit log files
+	// we need to read, but datapoints from the commitlog itself that belong to a shard that has a
+	// snapshot more recent than the global minimum are skipped in shouldEncodeForData.
 	return func(f commitlog.File) bool {
-		_, ok := commitlogFilesPresentBeforeStart[f.FilePath]
-		if !ok {
-			// If the file wasn't on disk before the node started then it only contains
-			// writes that are already in memory (and in-fact the file may be actively
-			// being written to.)
+		if _, ok := commitlogFilesPresentBeforeStart[f.FilePath]; !ok {
+			// Files created after the node started are not counted as skipped.
 			return false
 		}
 
-		for _, rangeToCheck := range rangesToCheck {
-			commitLogEntryRange := xtime.Range{
-				Start: f.Start,
-				End:   f.Start.Add(f.Duration),
-			}
-
-			if commitLogEntryRange.Overlaps(rangeToCheck) {
-				s.log.
-					Infof(
-						"opting to read commit log: %s with start: %s and duration: %s",
-						f.FilePath, f.Start.String(), f.Duration.String())
-				return true
-			}
+		if ShouldReadCommitLogFile(f, rangesToCheck, commitlogFilesPresentBeforeStart) {
+			s.log.
+				Infof(
+					"opting to read commit log: %s with start: %s and duration: %s",
+					f.FilePath, f.Start.String(), f.Duration.String())
+			progressReporter.OnCommitLogFileSelected(f.FilePath)
+			s.metrics.commitLogFilesRead.Inc(1)
+			return true
 		}
 
+		s.metrics.commitLogFilesSkipped.Inc(1)
 		s.log.
-			Infof(
+			Debugf(
 				"opting to skip commit log: %s with start: %s and duration: %s",
 				f.FilePath, f.Start.String(), f.Duration.String())
+		skipped.add(f.FilePath)
+		return false
+	}
+}
+
+// ShouldReadCommitLogFile returns whether the commit log file should be read
+// during bootstrap. Files that are not in knownFiles were created after the
+// node started and only contain writes that are already in memory (the file
+// may in fact be actively written to) so they are always skipped. Otherwise
+// the file is read if its time range overlaps any of the ranges to check,
+// which already include the buffer past and buffer future of the namespace
+// (see commitLogRangeToCheck). Ranges are half-open so a file that ends
+// exactly where a range starts, or starts exactly where it ends, is skipped.
+func ShouldReadCommitLogFile(
+	f commitlog.File,
+	rangesToCheck []xtime.Range,
+	knownFiles map[string]struct{},
+) bool {
+	if _, ok := knownFiles[f.FilePath]; !ok {
 		return false
 	}
+
+	commitLogEntryRange := xtime.Range{
+		Start: f.Start,
+		End:   f.Start.Add(f.Duration),
+	}
+	for _, rangeToCheck := range rangesToCheck {
+		if commitLogEntryRange.Overlaps(rangeToCheck) {
+			return true
+		}
+	}
+	return false
+}
+
+// numEncodingWorkers returns the number of encoding workers to start, since each
+// worker owns whole shards there is no point starting more of them than there
+// are shards to bootstrap. With adaptive concurrency the maximum number of
+// workers is started and only as many as are active are assigned new shards.
+func (s *commitLogSource) numEncodingWorkers(shardsTimeRanges result.ShardTimeRanges) int {
+	numShards := 0
+	for _, ranges := range shardsTimeRanges {
+		if !ranges.IsEmpty() {
+			numShards++
+		}
+	}
+	numConc := s.opts.EncodingConcurrency()
+	if _, maxConc := s.opts.AdaptiveEncodingConcurrency(); maxConc > 0 {
+		numConc = maxConc
+	}
+	if numConc < numShards {
+		return numConc
+	}
+	return numShards
+}
+
+// sendEncoderArgMeasured hands the datapoint to an encoding worker and records
+// how long the send was blocked if the worker's channel was full, slow sends
+// are logged so that stalled workers can be diagnosed. Returns whether the
+// send blocked.
+func (s *commitLogSource) sendEncoderArgMeasured(
+	encoderChan chan<- encoderArg,
+	arg encoderArg,
+	workerNum int,
+	warnThreshold time.Duration,
+) bool {
+	select {
+	case encoderChan <- arg:
+		return false
+	default:
+	}
+
+	blockedStart := time.Now()
+	encoderChan <- arg
+	blocked := time.Since(blockedStart)
+	s.metrics.encoderBlocked.Record(blocked)
+	if warnThreshold <= 0 || blocked < warnThreshold {
+		return true
+	}
+
+	s.metrics.encoderBlockedSlow.Inc(1)
+	s.log.
+		WithFields(
+			xlog.NewField("worker", workerNum),
+			xlog.NewField("shard", arg.series.Shard),
+			xlog.NewField("blocked", blocked.String()),
+			xlog.NewField("threshold", warnThreshold.String()),
+		).
+		Warn("blocked handing datapoint to encoding worker, worker is falling behind")
+	return true
+}
+
+// adaptiveConcurrency tracks the number of encoding workers that new shards are
+// assigned to, growing it while handing datapoints to the workers blocks and
+// shrinking it while it doesn't.
+type adaptiveConcurrency struct {
+	min     int
+	max     int
+	active  int
+	sends   int
+	blocked int
+}
+
+func newAdaptiveConcurrency(min, max int) *adaptiveConcurrency {
+	if min > max {
+		min = max
+	}
+	return &adaptiveConcurrency{min: min, max: max, active: min}
+}
+
+// observe records whether handing a datapoint to a worker blocked and returns
+// whether the number of active workers changed as a result.
+func (c *adaptiveConcurrency) observe(blocked bool) bool {
+	c.sends++
+	if blocked {
+		c.blocked++
+	}
+	if c.sends < adaptiveConcurrencyWindow {
+		return false
+	}
+
+	prev := c.active
+	switch {
+	case c.blocked*adaptiveConcurrencyGrowDivisor > c.sends && c.active < c.max:
+		c.active++
+	case c.blocked == 0 && c.active > c.min:
+		c.active--
+	}
+	c.sends, c.blocked = 0, 0
+	return c.active != prev
 }
 
-func (s *commitLogSource) startM3TSZEncodingWorker(
+// startEncodingWorker encodes the datapoints it receives with encoders from the
+// provided pool, the pool determines the encoding so any encoding.Encoder
+// implementation can be plugged in through the block options.
+func (s *commitLogSource) startEncodingWorker(
 	ns namespace.Metadata,
 	runOpts bootstrap.RunOptions,
 	workerNum int,
 	ec <-chan encoderArg,
-	unmerged []shardData,
+	unmerged map[uint32]*shardData,
 	encoderPool encoding.EncoderPool,
 	workerErrs []int,
+	workerErrSamples [][]encodeError,
+	workerDropped [][]DroppedDatapoint,
+	trackDropped bool,
+	workerOutOfOrder []outOfOrderDatapoints,
+	maxUnmergedBytes int64,
 	blopts block.Options,
 	wg *sync.WaitGroup,
 ) {
+	var (
+		unmergedBytes    int64
+		numCompacted     int
+		numBlocksDropped int
+		ownedShards      = make(map[uint32]struct{})
+		seriesValidator  = s.opts.SeriesValidator()
+		maxEncoders      = s.opts.MaxEncodersPerSeries()
+		maxSeriesBytes   = s.opts.MaxUnmergedBytesPerSeries()
+	)
+	// If compacting fails the encoders are gone, which is counted as an error and
+	// leaves the block incomplete.
+	compact := func(shard uint32, blockStart time.Time, encoders []encoder) ([]encoder, error) {
+		for _, enc := range encoders {
+			unmergedBytes -= int64(enc.enc.Len())
+		}
+		compacted, err := s.compactEncoders(blockStart, encoders, encoderPool, blopts)
+		if err != nil {
+			unmerged[shard].markIncomplete(xtime.ToUnixNano(blockStart))
+		}
+		for _, enc := range compacted {
+			unmergedBytes += int64(enc.enc.Len())
+		}
+		s.metrics.encodersCompacted.Inc(1)
+		return compacted, err
+	}
 	for arg := range ec {
 		var (
 			series     = arg.series
@@ -842,6 +2865,19 @@ func (s *commitLogSource) startM3TSZEncodingWorker(
 				id:       series.ID,
 				tags:     series.Tags,
 				encoders: make(map[xtime.UnixNano][]encoder)}
+			if seriesValidator != nil {
+				if err := seriesValidator(series.ID, series.Tags); err != nil {
+					s.log.
+						WithFields(
+							xlog.NewField("shard", series.Shard),
+							xlog.NewField("id", series.ID.String()),
+							xlog.NewErrField(err),
+						).
+						Warn("series failed validation, skipping its commit log datapoints")
+					unmergedSeries.quarantined = true
+					s.metrics.seriesQuarantined.Inc(1)
+				}
+			}
 			// Have to use unsafe because we don't want to copy the IDs we put
 			// into this map because its lifecycle is much shorter than that of
 			// the IDs we're putting into it so copying would waste too much
@@ -850,62 +2886,381 @@ func (s *commitLogSource) startM3TSZEncodingWorker(
 			unmergedShard.SetUnsafe(
 				series.ID, unmergedSeries,
 				SetUnsafeOptions{NoCopyKey: true, NoFinalizeKey: true})
+			if !unmergedSeries.quarantined {
+				s.metrics.seriesEncoded.Inc(1)
+			}
+		}
+		if unmergedSeries.quarantined {
+			continue
 		}
 
 		var (
-			err            error
 			blockStartNano = xtime.ToUnixNano(blockStart)
 			unmergedBlock  = unmergedSeries.encoders[blockStartNano]
 			wroteExisting  = false
+			// Out of order datapoints aren't after the last write of any existing
+			// encoder of the block so need a new encoder of their own.
+			outOfOrder = false
 		)
-		for i := range unmergedBlock {
-			if unmergedBlock[i].lastWriteAt.Before(dp.Timestamp) {
-				unmergedBlock[i].lastWriteAt = dp.Timestamp
-				err = unmergedBlock[i].enc.Encode(dp, unit, annotation)
-				wroteExisting = true
-				break
-			}
+		if _, ok := unmerged[series.Shard].droppedBlocks[blockStartNano]; ok {
+			// The commit log data of the block was dropped to stay within the memory
+			// limit so the block is left for a subsequent bootstrapper.
+			continue
 		}
-		if !wroteExisting {
-			enc := encoderPool.Get()
-			enc.Reset(blockStart, blopts.DatabaseBlockAllocSize())
-
-			err = enc.Encode(dp, unit, annotation)
-			if err == nil {
-				unmergedBlock = append(unmergedBlock, encoder{
-					lastWriteAt: dp.Timestamp,
-					enc:         enc,
-				})
+		annotation, err := s.checkAnnotation(series.ID, annotation)
+		if err == nil {
+			for i := range unmergedBlock {
+				if unmergedBlock[i].lastWriteAt.Before(dp.Timestamp) {
+					unmergedBlock[i].lastWriteAt = dp.Timestamp
+					lenBefore := unmergedBlock[i].enc.Len()
+					err = unmergedBlock[i].enc.Encode(dp, unit, annotation)
+					unmergedBytes += int64(unmergedBlock[i].enc.Len() - lenBefore)
+					wroteExisting = true
+					break
+				}
+			}
+			if !wroteExisting {
+				outOfOrder = len(unmergedBlock) > 0
+				enc := encoderPool.Get()
+				enc.Reset(blockStart, blopts.DatabaseBlockAllocSize())
+
+				err = enc.Encode(dp, unit, annotation)
+				if err == nil {
+					unmergedBytes += int64(enc.Len())
+					unmergedBlock = append(unmergedBlock, encoder{
+						lastWriteAt: dp.Timestamp,
+						enc:         enc,
+					})
+					if maxEncoders > 0 && len(unmergedBlock) > maxEncoders {
+						// Datapoints arriving in descending order need a new encoder each,
+						// compact them so merging the block doesn't become quadratic.
+						unmergedBlock, err = compact(series.Shard, blockStart, unmergedBlock)
+					}
+					unmergedSeries.encoders[blockStartNano] = unmergedBlock
+				} else {
+					// Return the encoder to the pool since nothing was written to it.
+					enc.Close()
+				}
+			}
+			if err == nil && maxSeriesBytes > 0 && extraEncodersLen(unmergedBlock) > maxSeriesBytes {
+				// The encoders beyond the first only hold out of order datapoints, compact
+				// them into the first so the series starts over with a single encoder.
+				unmergedBlock, err = compact(series.Shard, blockStart, unmergedBlock)
 				unmergedSeries.encoders[blockStartNano] = unmergedBlock
 			}
 		}
 		if err != nil {
 			workerErrs[workerNum]++
+			if len(workerErrSamples[workerNum]) < maxEncodeErrorSamples {
+				workerErrSamples[workerNum] = append(workerErrSamples[workerNum], encodeError{
+					shard:     series.Shard,
+					id:        series.ID,
+					timestamp: dp.Timestamp,
+					err:       err,
+				})
+			}
+			if trackDropped {
+				workerDropped[workerNum] = append(workerDropped[workerNum], DroppedDatapoint{
+					Shard:     series.Shard,
+					ID:        series.ID,
+					Timestamp: dp.Timestamp,
+					Err:       err,
+				})
+			}
+		} else if outOfOrder {
+			workerOutOfOrder[workerNum].add(OutOfOrderDatapoint{
+				Shard:     series.Shard,
+				ID:        series.ID,
+				Timestamp: dp.Timestamp,
+			})
+		}
+
+		ownedShards[series.Shard] = struct{}{}
+		if maxUnmergedBytes > 0 && unmergedBytes > maxUnmergedBytes {
+			var numErrs int
+			unmergedBytes, numErrs = s.compactUnmergedShards(ownedShards, unmerged, encoderPool, blopts)
+			workerErrs[workerNum] += numErrs
+			numCompacted++
+			// Compaction can't shrink the encoded data itself, so once it isn't enough
+			// drop the oldest blocks, leaving them to a subsequent bootstrapper, with
+			// some headroom to avoid compacting again on the next datapoint.
+			if target := maxUnmergedBytes - maxUnmergedBytes/4; unmergedBytes > target {
+				var blocksDropped int
+				unmergedBytes, blocksDropped = dropOldestUnmergedBlocks(
+					ownedShards, unmerged, unmergedBytes, target)
+				numBlocksDropped += blocksDropped
+			}
 		}
 	}
+	if numCompacted > 0 {
+		s.log.Infof(
+			"compacted unmerged encoders %d times to stay within the memory limit", numCompacted)
+	}
+	if numBlocksDropped > 0 {
+		s.log.
+			WithFields(
+				xlog.NewField("worker", workerNum),
+				xlog.NewField("blocksDropped", numBlocksDropped),
+				xlog.NewField("maxUnmergedBytes", maxUnmergedBytes),
+			).
+			Warn("dropped the commit log data of the oldest blocks to stay within the memory limit, marking them as unfulfilled")
+		s.metrics.unmergedBlocksDropped.Inc(int64(numBlocksDropped))
+	}
 	wg.Done()
 }
 
+// dropOldestUnmergedBlocks closes the encoders of the oldest blocks of the
+// provided shards until the bytes they hold are within the target, the blocks
+// are recorded as dropped so their later datapoints are skipped and they are
+// marked as unfulfilled. It returns the number of bytes still held by the
+// encoders along with the number of shard blocks dropped.
+func dropOldestUnmergedBlocks(
+	shards map[uint32]struct{},
+	unmerged map[uint32]*shardData,
+	unmergedBytes int64,
+	target int64,
+) (int64, int) {
+	var blockStarts []xtime.UnixNano
+	seen := make(map[xtime.UnixNano]struct{})
+	for shard := range shards {
+		for _, entry := range unmerged[shard].series.Iter() {
+			for blockStart := range entry.Value().encoders {
+				if _, ok := seen[blockStart]; !ok {
+					seen[blockStart] = struct{}{}
+					blockStarts = append(blockStarts, blockStart)
+				}
+			}
+		}
+	}
+	sort.Slice(blockStarts, func(i, j int) bool {
+		return blockStarts[i] < blockStarts[j]
+	})
+
+	numDropped := 0
+	for _, blockStart := range blockStarts {
+		if unmergedBytes <= target {
+			break
+		}
+		for shard := range shards {
+			data := unmerged[shard]
+			dropped := false
+			for _, entry := range data.series.Iter() {
+				encodersByBlock := entry.Value().encoders
+				encoders, ok := encodersByBlock[blockStart]
+				if !ok {
+					continue
+				}
+				for _, enc := range encoders {
+					unmergedBytes -= int64(enc.enc.Len())
+					enc.enc.Close()
+				}
+				delete(encodersByBlock, blockStart)
+				dropped = true
+			}
+			if dropped {
+				if data.droppedBlocks == nil {
+					data.droppedBlocks = make(map[xtime.UnixNano]struct{})
+				}
+				data.droppedBlocks[blockStart] = struct{}{}
+				numDropped++
+			}
+		}
+	}
+	return unmergedBytes, numDropped
+}
+
+// droppedBlocksUnfulfilled returns the requested ranges of the blocks whose
+// commit log data was dropped, entirely or for some of their series, while
+// encoding.
+func droppedBlocksUnfulfilled(
+	shardsTimeRanges result.ShardTimeRanges,
+	unmerged map[uint32]*shardData,
+	blockSize time.Duration,
+) result.ShardTimeRanges {
+	unfulfilled := result.ShardTimeRanges{}
+	for shard, data := range unmerged {
+		ranges := shardsTimeRanges[shard]
+		blockStarts := make([]xtime.UnixNano, 0, len(data.droppedBlocks)+len(data.incompleteBlocks))
+		for blockStart := range data.droppedBlocks {
+			blockStarts = append(blockStarts, blockStart)
+		}
+		for blockStart := range data.incompleteBlocks {
+			blockStarts = append(blockStarts, blockStart)
+		}
+		for _, blockStart := range blockStarts {
+			var (
+				block          = xtime.Range{Start: blockStart.ToTime(), End: blockStart.ToTime().Add(blockSize)}
+				notAffected    = ranges.RemoveRange(block)
+				affectedRanges = ranges.RemoveRanges(notAffected)
+			)
+			if affectedRanges.IsEmpty() {
+				continue
+			}
+			unfulfilled.AddRanges(result.ShardTimeRanges{shard: affectedRanges})
+		}
+	}
+	return unfulfilled
+}
+
+// checkAnnotation returns the annotation to encode with a datapoint read from the
+// commit log, annotations larger than the max are either truncated or rejected
+// with errAnnotationTooLarge. Snapshot data is bootstrapped as is.
+func (s *commitLogSource) checkAnnotation(
+	id ident.ID,
+	annotation ts.Annotation,
+) (ts.Annotation, error) {
+	maxBytes := s.opts.MaxAnnotationBytes()
+	if maxBytes == 0 || len(annotation) <= maxBytes {
+		return annotation, nil
+	}
+
+	truncate := s.opts.TruncateOversizedAnnotations()
+	s.metrics.oversizedAnnotations.Inc(1)
+	s.log.
+		WithFields(
+			xlog.NewField("id", id.String()),
+			xlog.NewField("annotationBytes", len(annotation)),
+			xlog.NewField("maxAnnotationBytes", maxBytes),
+			xlog.NewField("truncate", truncate),
+		).
+		Warn("datapoint annotation exceeds max annotation bytes")
+	if truncate {
+		return annotation[:maxBytes], nil
+	}
+	return nil, errAnnotationTooLarge
+}
+
+// extraEncodersLen returns the number of bytes held by the encoders of a
+// series block other than the first.
+func extraEncodersLen(encoders []encoder) int64 {
+	var n int64
+	for i := 1; i < len(encoders); i++ {
+		n += int64(encoders[i].enc.Len())
+	}
+	return n
+}
+
+// compactUnmergedShards merges all the encoders for each series block in the
+// provided shards into a single encoder and returns the number of bytes that
+// are still held by the encoders along with the number of encoding errors.
+func (s *commitLogSource) compactUnmergedShards(
+	shards map[uint32]struct{},
+	unmerged map[uint32]*shardData,
+	encoderPool encoding.EncoderPool,
+	blopts block.Options,
+) (int64, int) {
+	var (
+		unmergedBytes int64
+		numErrs       int
+	)
+	for shard := range shards {
+		for _, entry := range unmerged[shard].series.Iter() {
+			encodersByBlock := entry.Value().encoders
+			for blockStart, encoders := range encodersByBlock {
+				if len(encoders) > 1 {
+					compacted, err := s.compactEncoders(blockStart.ToTime(), encoders, encoderPool, blopts)
+					if err != nil {
+						numErrs++
+						unmerged[shard].markIncomplete(blockStart)
+					}
+					encoders = compacted
+					encodersByBlock[blockStart] = compacted
+				}
+				for _, enc := range encoders {
+					unmergedBytes += int64(enc.enc.Len())
+				}
+			}
+		}
+	}
+	return unmergedBytes, numErrs
+}
+
+// closeUnmergedEncoders closes the encoders of every series read from the
+// commit log, returning them to the pool, for reads abandoned before merging.
+// The encoders can't be used afterwards.
+func closeUnmergedEncoders(unmerged map[uint32]*shardData) {
+	for _, unmergedShard := range unmerged {
+		if unmergedShard.series == nil {
+			continue
+		}
+		for _, entry := range unmergedShard.series.Iter() {
+			closeSeriesEncoders(entry.Value())
+		}
+	}
+}
+
+// closeSeriesEncoders closes the encoders of a series read from the commit log
+// that won't be merged, returning them to the pool.
+func closeSeriesEncoders(val metadataAndEncodersByTime) {
+	for blockStart, encoders := range val.encoders {
+		for _, enc := range encoders {
+			enc.enc.Close()
+		}
+		delete(val.encoders, blockStart)
+	}
+}
+
+// compactEncoders merges encoders that belong to the same series block into a
+// single encoder, datapoints with the same timestamp are resolved in favour of
+// the last encoder as they are when merging. The provided encoders are no longer
+// usable afterwards.
+func (s *commitLogSource) compactEncoders(
+	blockStart time.Time,
+	encoders []encoder,
+	encoderPool encoding.EncoderPool,
+	blopts block.Options,
+) ([]encoder, error) {
+	// Closes the encoders by calling Discard() on each.
+	readers, err := newIOReadersFromEncodersAndBlock(
+		blopts.SegmentReaderPool(), encoders, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer readers.close()
+
+	var (
+		enc         = encoderPool.Get()
+		lastWriteAt time.Time
+	)
+	enc.Reset(blockStart, blopts.DatabaseBlockAllocSize())
+	err = mergeReaders(blopts.MultiReaderIteratorPool(), readers, func(
+		dp ts.Datapoint,
+		unit xtime.Unit,
+		annotation ts.Annotation,
+	) error {
+		lastWriteAt = dp.Timestamp
+		return enc.Encode(dp, unit, annotation)
+	})
+	if err != nil {
+		enc.Close()
+		return nil, err
+	}
+
+	return []encoder{{lastWriteAt: lastWriteAt, enc: enc}}, nil
+}
+
 func (s *commitLogSource) shouldEncodeForData(
-	unmerged []shardData,
+	unmerged map[uint32]*shardData,
 	dataBlockSize time.Duration,
+	bufferPast time.Duration,
+	retentionCutoff time.Time,
 	series commitlog.Series,
 	timestamp time.Time,
 ) bool {
-	// Check if the shard number is higher the amount of space we pre-allocated.
-	// If it is, then it's not one of the shards we're trying to bootstrap
-	if series.Shard > uint32(len(unmerged)-1) {
+	// Check if the shard is one of the shards we're trying to bootstrap
+	unmergedShard, ok := unmerged[series.Shard]
+	if !ok {
 		return false
 	}
-
-	// Check if the shard is one of the shards we're trying to bootstrap
-	ranges := unmerged[series.Shard].ranges
+	ranges := unmergedShard.ranges
 	if ranges.IsEmpty() {
 		// Did not allocate map for this shard so not expecting data for it
 		return false
 	}
 
-	// Check if the block corresponds to the time-range that we're trying to bootstrap
+	// Check if the block corresponds to the time-range that we're trying to bootstrap,
+	// Truncate operates on the absolute time so the block start is aligned in UTC
+	// whatever the location of the timestamp.
 	blockStart := timestamp.Truncate(dataBlockSize)
 	blockEnd := blockStart.Add(dataBlockSize)
 	blockRange := xtime.Range{
@@ -913,7 +3268,24 @@ func (s *commitLogSource) shouldEncodeForData(
 		End:   blockEnd,
 	}
 
-	return ranges.Overlaps(blockRange)
+	if !ranges.Overlaps(blockRange) {
+		return false
+	}
+
+	// Check if the block is still within the namespace retention period
+	if blockStart.Before(retentionCutoff) {
+		return false
+	}
+
+	// Check if the datapoint is guaranteed to have been captured by the snapshot. Writes
+	// are only accepted until bufferPast has elapsed after their timestamp so if that
+	// happened before the snapshot was taken then the snapshot already contains it.
+	snapshotTime, ok := unmergedShard.snapshotTimes[xtime.ToUnixNano(blockStart)]
+	if ok && timestamp.Add(bufferPast).Before(snapshotTime) {
+		return false
+	}
+
+	return true
 }
 
 func (s *commitLogSource) shouldIncludeInIndex(
@@ -952,78 +3324,254 @@ func (s *commitLogSource) mergeAllShardsCommitLogEncodersAndSnapshots(
 	shardsTimeRanges result.ShardTimeRanges,
 	snapshotFiles map[uint32]fs.FileSetFilesSlice,
 	mostRecentCompleteSnapshotByBlockShard map[xtime.UnixNano]map[uint32]fs.FileSetFile,
-	numShards int,
 	blockSize time.Duration,
-	unmerged []shardData,
+	unmerged map[uint32]*shardData,
+	onShardRead shardReadFn,
 ) (result.DataBootstrapResult, error) {
 	var (
-		shardErrs       = make([]int, numShards)
-		shardEmptyErrs  = make([]int, numShards)
-		bootstrapResult = result.NewDataBootstrapResult()
+		// Each shard being merged gets its own slot so they can be updated concurrently
+		// and the bootstrap result is only assembled once every shard has finished.
+		shardReadResults = make([]ShardReadResult, len(unmerged))
+		shardReadErrs    = make([]error, len(unmerged))
+		shardStats       = make([]mergeStats, len(unmerged))
+		shardMergeErrs   = make([][]mergeSeriesError, len(unmerged))
+		shardIdx         int
+		// Controls how many shards can have their snapshots read in parallel
+		readPool = s.opts.SharedSnapshotReadPool()
 		// Controls how many shards can be merged in parallel
-		workerPool          = xsync.NewWorkerPool(s.opts.MergeShardsConcurrency())
-		bootstrapResultLock sync.Mutex
-		wg                  sync.WaitGroup
+		workerPool       = xsync.NewWorkerPool(s.opts.MergeShardsConcurrency())
+		wg               sync.WaitGroup
+		progressReporter = s.opts.ProgressReporter()
+		dedupCache       *blockDedupCache
 	)
+	if s.opts.DeduplicateSnapshotBlocks() {
+		dedupCache = newBlockDedupCache()
+	}
+	if readPool == nil {
+		readPool = xsync.NewWorkerPool(s.opts.SnapshotReadConcurrency())
+		readPool.Init()
+	}
 	workerPool.Init()
 
-	for shard, unmergedShard := range unmerged {
-		if unmergedShard.series == nil {
-			// Not bootstrapping this shard
-			continue
+	for _, shard := range s.shardMergeOrder(unmerged) {
+		wg.Add(1)
+		var (
+			unmergedShard = *unmerged[shard]
+			shard         = int(shard)
+			idx           = shardIdx
+			// The outcome of each shard is recorded exactly once, either when its
+			// merge completes or when it runs out of time. The lock also protects
+			// the timer since it's started by the read but stopped by either.
+			finishLock   sync.Mutex
+			finished     bool
+			timer        *time.Timer
+			cancellation = &mergeCancellation{}
+		)
+		shardIdx++
+		finish := func(r ShardReadResult, fn func()) bool {
+			finishLock.Lock()
+			if finished {
+				finishLock.Unlock()
+				return false
+			}
+			finished = true
+			if timer != nil {
+				timer.Stop()
+			}
+			finishLock.Unlock()
+
+			shardReadResults[idx] = r
+			fn()
+			if onShardRead != nil {
+				onShardRead(r)
+			}
+			wg.Done()
+			return true
 		}
+		readPool.Go(func() {
+			if timeout := s.opts.PerShardMergeTimeout(); timeout > 0 {
+				finishLock.Lock()
+				timer = time.AfterFunc(timeout, func() {
+					r := ShardReadResult{
+						Shard:       uint32(shard),
+						Unfulfilled: shardsTimeRanges[uint32(shard)],
+					}
+					finish(r, func() {
+						// Stop merging the abandoned shard. A snapshot read or series merge
+						// in progress can't be interrupted so it keeps running, possibly
+						// after the bootstrap has returned, and then releases the snapshot
+						// data and encoders of the shard rather than handing them out.
+						cancellation.cancel()
+						s.log.
+							WithFields(
+								xlog.NewField("namespace", ns.ID().String()),
+								xlog.NewField("shard", shard),
+								xlog.NewField("timeout", timeout.String()),
+							).
+							Error("shard merge timed out, marking shard as unfulfilled")
+						s.metrics.mergeTimeouts.Inc(1)
+					})
+				})
+				finishLock.Unlock()
+			}
 
-		snapshotData, err := s.bootstrapShardSnapshots(
-			ns.ID(),
-			uint32(shard),
-			false,
-			shardsTimeRanges[uint32(shard)],
-			blockSize,
-			snapshotFiles[uint32(shard)],
-			mostRecentCompleteSnapshotByBlockShard,
-		)
-		if err != nil {
-			bootstrapResultLock.Lock()
-			// Mark the shard time ranges as unfulfilled so a subsequent bootstrapper
-			// has the chance to fulfill it.
-			bootstrapResult.Add(
+			snapshotData, snapshotUnfulfilled, err := s.bootstrapShardSnapshots(
+				ns.ID(),
 				uint32(shard),
-				result.NewShardResult(0, s.opts.ResultOptions()),
+				false,
 				shardsTimeRanges[uint32(shard)],
+				blockSize,
+				snapshotFiles[uint32(shard)],
+				mostRecentCompleteSnapshotByBlockShard,
+				dedupCache,
 			)
-			bootstrapResultLock.Unlock()
-			return nil, err
-		}
+			if err != nil {
+				// The commit log data of the shard is never merged, whether or not
+				// it already timed out, so return its encoders to the pool.
+				closeUnmergedEncoders(map[uint32]*shardData{uint32(shard): &unmergedShard})
+
+				// Mark the shard time ranges as unfulfilled so a subsequent bootstrapper
+				// has the chance to fulfill it.
+				r := ShardReadResult{
+					Shard:       uint32(shard),
+					Unfulfilled: shardsTimeRanges[uint32(shard)],
+				}
+				finish(r, func() {
+					shardReadErrs[idx] = err
+				})
+				return
+			}
+			if cancellation.cancelled() {
+				// The shard timed out while its snapshots were being read.
+				snapshotData.Close()
+				closeUnmergedEncoders(map[uint32]*shardData{uint32(shard): &unmergedShard})
+				return
+			}
 
-		// Merge snapshot and commit log data
-		wg.Add(1)
-		shard, unmergedShard := shard, unmergedShard
-		mergeShardFunc := func() {
-			var shardResult result.ShardResult
-			shardResult, shardEmptyErrs[shard], shardErrs[shard] = s.mergeShardCommitLogEncodersAndSnapshots(
-				shard, snapshotData, unmergedShard, blockSize)
-
-			if shardResult != nil && shardResult.NumSeries() > 0 {
-				// Prevent race conditions while updating bootstrapResult from multiple go-routines
-				bootstrapResultLock.Lock()
-				if shardEmptyErrs[shard] != 0 || shardErrs[shard] != 0 {
+			// Merge snapshot and commit log data, this is handed off to the merge
+			// workers so that the next snapshot read can overlap with it.
+			workerPool.Go(func() {
+				shardResult, stats, mergeErrs := s.mergeShardCommitLogEncodersAndSnapshots(
+					shard, snapshotData, unmergedShard, blockSize, cancellation)
+
+				unfulfilled := snapshotUnfulfilled
+				if stats.numErrs != 0 || (stats.numEmptyErrs != 0 && s.opts.EmptyMergedBlocksAreErrors()) {
 					// If there were any errors, keep the data but mark the shard time ranges as
 					// unfulfilled so a subsequent bootstrapper has the chance to fulfill it.
-					bootstrapResult.Add(uint32(shard), shardResult, shardsTimeRanges[uint32(shard)])
-				} else {
-					bootstrapResult.Add(uint32(shard), shardResult, xtime.Ranges{})
+					unfulfilled = shardsTimeRanges[uint32(shard)]
+				}
+
+				r := ShardReadResult{
+					Shard:               uint32(shard),
+					Result:              shardResult,
+					Unfulfilled:         unfulfilled,
+					NumDatapointsMerged: int64(stats.numDatapoints),
+				}
+				finished := finish(r, func() {
+					shardStats[idx] = stats
+					shardMergeErrs[idx] = mergeErrs
+					progressReporter.OnShardMergeComplete(uint32(shard))
+				})
+				if !finished {
+					// The shard already timed out and was marked as unfulfilled.
+					shardResult.Close()
+				}
+			})
+		})
+	}
+
+	// Wait for all read and merge goroutines to complete
+	wg.Wait()
+	if dedupCache != nil {
+		dedupCache.close()
+	}
+	for _, err := range shardReadErrs {
+		if err != nil {
+			// The shards that were merged are never returned so close their results,
+			// unless they were streamed to a receiver that now owns them.
+			if onShardRead == nil || !s.opts.OmitStreamedShardResults() {
+				for _, r := range shardReadResults {
+					if r.Result != nil {
+						r.Result.Close()
+					}
 				}
-				bootstrapResultLock.Unlock()
 			}
-			wg.Done()
+			return nil, err
 		}
-		workerPool.Go(mergeShardFunc)
 	}
 
-	// Wait for all merge goroutines to complete
-	wg.Wait()
-	s.logMergeShardsOutcome(shardErrs, shardEmptyErrs)
-	return bootstrapResult, nil
+	// Empty shard results and unfulfilled ranges are ignored by Add.
+	bootstrapResult := result.NewDataBootstrapResult()
+	for _, r := range shardReadResults {
+		bootstrapResult.Add(r.Shard, r.Result, r.Unfulfilled)
+	}
+	s.logMergeShardsOutcome(shardStats, shardMergeErrs)
+	return bootstrapResult, nil
+}
+
+// shardMergeOrder returns the order in which the shards are merged, which is
+// shuffled when configured so that shards that are expensive to merge and
+// numbered close to each other don't all hold up the worker pool at once.
+func (s *commitLogSource) shardMergeOrder(unmerged map[uint32]*shardData) []uint32 {
+	shards := make([]uint32, 0, len(unmerged))
+	for shard := range unmerged {
+		shards = append(shards, shard)
+	}
+	if !s.opts.RandomizeShardMergeOrder() {
+		return shards
+	}
+
+	// Sort first so the order only depends on the seed.
+	sort.Slice(shards, func(i, j int) bool {
+		return shards[i] < shards[j]
+	})
+	rng := rand.New(rand.NewSource(s.shardOrderSeedFn()))
+	rng.Shuffle(len(shards), func(i, j int) {
+		shards[i], shards[j] = shards[j], shards[i]
+	})
+	return shards
+}
+
+// mergeStats counts the outcome of merging the blocks of series.
+type mergeStats struct {
+	numEmptyErrs  int
+	numErrs       int
+	numDatapoints int
+}
+
+func (s *mergeStats) add(other mergeStats) {
+	s.numEmptyErrs += other.numEmptyErrs
+	s.numErrs += other.numErrs
+	s.numDatapoints += other.numDatapoints
+}
+
+// mergeSeriesError is a series of a shard that failed to merge.
+type encodeError struct {
+	shard     uint32
+	id        ident.ID
+	timestamp time.Time
+	err       error
+}
+
+// mergeCancellation is cancelled once a shard merge has been abandoned so that
+// the series that haven't been merged yet are skipped, a nil cancellation is
+// never cancelled.
+type mergeCancellation struct {
+	done int32
+}
+
+func (c *mergeCancellation) cancel() {
+	atomic.StoreInt32(&c.done, 1)
+}
+
+func (c *mergeCancellation) cancelled() bool {
+	return c != nil && atomic.LoadInt32(&c.done) == 1
+}
+
+type mergeSeriesError struct {
+	shard uint32
+	id    ident.ID
+	err   error
 }
 
 func (s *commitLogSource) mergeShardCommitLogEncodersAndSnapshots(
@@ -1031,152 +3579,194 @@ func (s *commitLogSource) mergeShardCommitLogEncodersAndSnapshots(
 	snapshotData result.ShardResult,
 	unmergedShard shardData,
 	blockSize time.Duration,
-) (result.ShardResult, int, int) {
-	var (
-		bOpts                   = s.opts.ResultOptions()
-		blOpts                  = bOpts.DatabaseBlockOptions()
-		blocksPool              = blOpts.DatabaseBlockPool()
-		multiReaderIteratorPool = blOpts.MultiReaderIteratorPool()
-		segmentReaderPool       = blOpts.SegmentReaderPool()
-		encoderPool             = blOpts.EncoderPool()
-	)
-
-	numSeries := 0
-	if unmergedShard.series != nil {
-		numSeries = unmergedShard.series.Len()
-	}
+	cancellation *mergeCancellation,
+) (result.ShardResult, mergeStats, []mergeSeriesError) {
+	blOpts := s.opts.ResultOptions().DatabaseBlockOptions()
 
 	var (
-		shardResult       = result.NewShardResult(numSeries, s.opts.ResultOptions())
-		numShardEmptyErrs int
-		numErrs           int
+		capacity    = mergedSeriesCapacity(snapshotData, unmergedShard)
+		shardResult = result.NewShardResult(capacity, s.opts.ResultOptions())
+		stats       mergeStats
+		mergeErrs   []mergeSeriesError
+		// Protects the shard result, the snapshot series, the stats and the merge
+		// errors when series are merged concurrently.
+		lock sync.Mutex
 	)
 
 	allSnapshotSeries := snapshotData.AllSeries()
 
-	if unmergedShard.series != nil {
-		for _, unmergedBlocks := range unmergedShard.series.Iter() {
-			val := unmergedBlocks.Value()
-			snapshotSeriesData, _ := allSnapshotSeries.Get(val.id)
-			seriesBlocks, numSeriesEmptyErrs, numSeriesErrs := s.mergeSeries(
-				snapshotSeriesData,
-				val,
-				blocksPool,
-				multiReaderIteratorPool,
-				segmentReaderPool,
-				encoderPool,
-				blockSize,
-				blOpts,
-			)
+	mergeOne := func(val metadataAndEncodersByTime) {
+		if cancellation.cancelled() {
+			// The merge was abandoned, the series' snapshot data is left in the
+			// result to be closed along with it.
+			closeSeriesEncoders(val)
+			return
+		}
+
+		lock.Lock()
+		if val.quarantined {
+			// The series was rejected by the series validator so its snapshot data
+			// is dropped along with its commit log datapoints.
+			if snapshotSeriesData, ok := allSnapshotSeries.Get(val.id); ok {
+				allSnapshotSeries.Delete(val.id)
+				snapshotSeriesData.Blocks.Close()
+				snapshotSeriesData.ID.Finalize()
+				snapshotSeriesData.Tags.Finalize()
+			}
+			lock.Unlock()
+			return
+		}
+		snapshotSeriesData, hasSnapshotSeries := allSnapshotSeries.Get(val.id)
+		lock.Unlock()
+
+		seriesBlocks, seriesStats, mergeErr := s.mergeSeries(
+			snapshotSeriesData,
+			val,
+			blockSize,
+			blOpts,
+		)
 
-			if seriesBlocks != nil && seriesBlocks.Len() > 0 {
-				shardResult.AddSeries(val.id, val.tags, seriesBlocks)
+		lock.Lock()
+		defer lock.Unlock()
+		if seriesBlocks != nil && seriesBlocks.Len() > 0 {
+			shardResult.AddSeries(val.id, val.tags, seriesBlocks)
+			if hasSnapshotSeries {
+				// The snapshot blocks now belong to the merged series so release the
+				// snapshot entry straight away rather than holding on to it until
+				// every series in the shard has been merged. We can't close the blocks
+				// since they may have been loaded into the shard result, but the ID and
+				// tags are replaced by the commit log ones so they go back to their pools.
+				allSnapshotSeries.Delete(val.id)
+				snapshotSeriesData.ID.Finalize()
+				snapshotSeriesData.Tags.Finalize()
 			}
+		}
+
+		stats.add(seriesStats)
+		if mergeErr != nil && len(mergeErrs) < maxMergeErrorSamples {
+			// Copy the ID since the unmerged series is released once merged.
+			mergeErrs = append(mergeErrs, mergeSeriesError{
+				shard: uint32(shard),
+				id:    ident.StringID(val.id.String()),
+				err:   mergeErr,
+			})
+		}
+	}
 
-			numShardEmptyErrs += numSeriesEmptyErrs
-			numErrs += numSeriesErrs
+	if unmergedShard.series != nil {
+		// Each series is merged independently of the others, with all of its
+		// blocks, so they can be spread across workers.
+		concurrency := s.opts.MergeSeriesConcurrency()
+		if concurrency <= 1 {
+			for _, unmergedBlocks := range unmergedShard.series.Iter() {
+				mergeOne(unmergedBlocks.Value())
+			}
+		} else {
+			var (
+				workerPool = xsync.NewWorkerPool(concurrency)
+				wg         sync.WaitGroup
+			)
+			workerPool.Init()
+			for _, unmergedBlocks := range unmergedShard.series.Iter() {
+				val := unmergedBlocks.Value()
+				wg.Add(1)
+				workerPool.Go(func() {
+					mergeOne(val)
+					wg.Done()
+				})
+			}
+			wg.Wait()
 		}
 	}
 
-	allShardResultSeries := shardResult.AllSeries()
+	// Only series that had no commit log data remain in the snapshot.
 	for _, val := range allSnapshotSeries.Iter() {
-		id := val.Key()
 		blocks := val.Value()
+		// Mark the ID and Tags as no finalize to enable no-copy optimization later
+		// in the bootstrap process (when they're being loaded into the shard).
+		blocks.ID.NoFinalize()
+		blocks.Tags.NoFinalize()
+		shardResult.AddSeries(val.Key(), blocks.Tags, blocks.Blocks)
+	}
+	return shardResult, stats, mergeErrs
+}
 
-		if allShardResultSeries.Contains(id) {
-			// Already merged so we know the ID and tags from the snapshot
-			// won't be used and can be closed. We can't close the blocks
-			// though because we may have loaded some of the blocks into
-			// the shard result and we don't want to close them.
-			id.Finalize()
-			blocks.Tags.Finalize()
+// mergedSeriesCapacity returns the number of distinct series in the snapshot
+// and commit log data for a shard so the merged shard result can be sized
+// once up front instead of growing as series are added.
+func mergedSeriesCapacity(snapshotData result.ShardResult, unmergedShard shardData) int {
+	allSnapshotSeries := snapshotData.AllSeries()
+	capacity := allSnapshotSeries.Len()
+	if unmergedShard.series == nil {
+		return capacity
+	}
+	for _, entry := range unmergedShard.series.Iter() {
+		val := entry.Value()
+		if val.quarantined {
+			if allSnapshotSeries.Contains(val.id) {
+				capacity--
+			}
 			continue
 		}
-
-		shardResult.AddSeries(id, blocks.Tags, blocks.Blocks)
+		if !allSnapshotSeries.Contains(val.id) {
+			capacity++
+		}
 	}
-	return shardResult, numShardEmptyErrs, numErrs
+	return capacity
 }
 
 func (s *commitLogSource) mergeSeries(
 	snapshotData result.DatabaseSeriesBlocks,
 	unmergedCommitlogBlocks metadataAndEncodersByTime,
-	blocksPool block.DatabaseBlockPool,
-	multiReaderIteratorPool encoding.MultiReaderIteratorPool,
-	segmentReaderPool xio.SegmentReaderPool,
-	encoderPool encoding.EncoderPool,
 	blockSize time.Duration,
 	blopts block.Options,
-) (block.DatabaseSeriesBlocks, int, int) {
+) (block.DatabaseSeriesBlocks, mergeStats, error) {
 	var seriesBlocks block.DatabaseSeriesBlocks
-	var numEmptyErrs int
-	var numErrs int
+	var stats mergeStats
+	// Only the first error is returned, the rest are counted.
+	var firstErr error
 
 	for startNano, encoders := range unmergedCommitlogBlocks.encoders {
 		var (
-			start            = startNano.ToTime()
-			snapshotBlock    block.DatabaseBlock
-			hasSnapshotBlock bool
+			start           = startNano.ToTime()
+			snapshotSegment ts.Segment
 		)
 
 		if snapshotData.Blocks != nil {
-			snapshotBlock, hasSnapshotBlock = snapshotData.Blocks.BlockAt(start)
+			if snapshotBlock, ok := snapshotData.Blocks.BlockAt(start); ok {
+				// Closes the snapshot block, it also needs to be removed from the
+				// Blocks to prevent a double free when we call Blocks.Close() later.
+				snapshotSegment = snapshotBlock.Discard()
+				snapshotData.Blocks.RemoveBlockAt(start)
+			}
 		}
 
-		if !hasSnapshotBlock {
-			// Make sure snapshotBlock is nil if it does not exist.
-			snapshotBlock = nil
+		blockEncoders := make([]encoding.Encoder, 0, len(encoders))
+		for _, encoder := range encoders {
+			blockEncoders = append(blockEncoders, encoder.enc)
 		}
 
-		// Closes encoders and snapshotBlock by calling Discard() on each.
-		readers, err := newIOReadersFromEncodersAndBlock(
-			segmentReaderPool, encoders, snapshotBlock)
+		mergedBlock, blockStats, err := MergeEncodersAndSnapshot(
+			start, blockSize, blockEncoders, snapshotSegment, blopts)
 		if err != nil {
-			numErrs++
-			continue
-		}
-
-		iter := multiReaderIteratorPool.Get()
-		iter.Reset(readers, time.Time{}, 0)
-
-		enc := encoderPool.Get()
-		enc.Reset(start, blopts.DatabaseBlockAllocSize())
-		for iter.Next() {
-			dp, unit, annotation := iter.Current()
-			encodeErr := enc.Encode(dp, unit, annotation)
-			if encodeErr != nil {
-				err = encodeErr
-				numErrs++
-				break
-			}
-		}
-
-		if iterErr := iter.Err(); iterErr != nil {
-			if err == nil {
-				err = iter.Err()
+			stats.numErrs++
+			if firstErr == nil {
+				firstErr = err
 			}
-			numErrs++
-		}
-
-		// Automatically returns iter to the pool
-		iter.Close()
-		readers.close()
-		if hasSnapshotBlock {
-			// Block is already closed, but we need to remove from the Blocks
-			// to prevent a double free when we call Blocks.Close() later.
-			snapshotData.Blocks.RemoveBlockAt(start)
+			continue
 		}
 
-		if err != nil {
+		if mergedBlock == nil {
+			// Nothing was left to encode, for instance every datapoint was dropped.
+			stats.numEmptyErrs++
 			continue
 		}
 
-		pooledBlock := blocksPool.Get()
-		pooledBlock.Reset(start, blockSize, enc.Discard())
+		stats.numDatapoints += blockStats.NumDatapoints
 		if seriesBlocks == nil {
 			seriesBlocks = block.NewDatabaseSeriesBlocks(len(unmergedCommitlogBlocks.encoders))
 		}
-		seriesBlocks.AddBlock(pooledBlock)
+		seriesBlocks.AddBlock(mergedBlock)
 	}
 
 	if snapshotData.Blocks != nil {
@@ -1199,7 +3789,70 @@ func (s *commitLogSource) mergeSeries(
 			seriesBlocks.AddBlock(snapshotBlock)
 		}
 	}
-	return seriesBlocks, numEmptyErrs, numErrs
+	return seriesBlocks, stats, firstErr
+}
+
+// MergeStats describes the outcome of merging a block.
+type MergeStats struct {
+	// NumDatapoints is the number of datapoints written to the merged block.
+	NumDatapoints int
+}
+
+// MergeEncodersAndSnapshot merges the datapoints of the encoders and of the
+// snapshot segment into a single block, either may be empty. Datapoints with
+// the same timestamp are resolved in favour of the last encoder and encoders
+// are favoured over the snapshot. The encoders are discarded and the snapshot
+// segment is finalized. A nil block is returned if there was nothing to merge.
+func MergeEncodersAndSnapshot(
+	start time.Time,
+	blockSize time.Duration,
+	encoders []encoding.Encoder,
+	snapshot ts.Segment,
+	opts block.Options,
+) (block.DatabaseBlock, MergeStats, error) {
+	var (
+		segmentReaderPool = opts.SegmentReaderPool()
+		readers           = make(ioReaders, 0, len(encoders)+1)
+		stats             MergeStats
+	)
+	// The snapshot goes first so that the encoders win ties.
+	if snapshot.Len() > 0 {
+		reader := segmentReaderPool.Get()
+		reader.Reset(snapshot)
+		readers = append(readers, reader)
+	} else {
+		snapshot.Finalize()
+	}
+	for _, enc := range encoders {
+		reader := segmentReaderPool.Get()
+		reader.Reset(enc.Discard())
+		readers = append(readers, reader)
+	}
+	defer readers.close()
+
+	enc := opts.EncoderPool().Get()
+	enc.Reset(start, opts.DatabaseBlockAllocSize())
+	err := mergeReaders(opts.MultiReaderIteratorPool(), readers, func(
+		dp ts.Datapoint,
+		unit xtime.Unit,
+		annotation ts.Annotation,
+	) error {
+		stats.NumDatapoints++
+		return enc.Encode(dp, unit, annotation)
+	})
+	if err != nil {
+		enc.Close()
+		return nil, MergeStats{}, err
+	}
+
+	if stats.NumDatapoints == 0 {
+		enc.Close()
+		return nil, stats, nil
+	}
+
+	pooledBlock := opts.DatabaseBlockPool().Get()
+	pooledBlock.Reset(start, blockSize, enc.Discard())
+	return pooledBlock, stats, nil
 }
 
 func (s *commitLogSource) findHighestShard(shardsTimeRanges result.ShardTimeRanges) uint32 {
@@ -1212,45 +3865,262 @@ func (s *commitLogSource) findHighestShard(shardsTimeRanges result.ShardTimeRang
 	return max
 }
 
-func (s *commitLogSource) logEncodingOutcome(workerErrs []int, iter commitlog.Iterator) {
+func (s *commitLogSource) logEncodingOutcome(
+	workerErrs []int,
+	workerErrSamples [][]encodeError,
+	iter commitlog.Iterator,
+) {
 	errSum := 0
 	for _, numErrs := range workerErrs {
 		errSum += numErrs
 	}
 	if errSum > 0 {
 		s.log.Errorf("error bootstrapping from commit log: %d block encode errors", errSum)
+		s.metrics.encodeErrors.Inc(int64(errSum))
+	}
+
+	// Log a sample of the datapoints that failed to encode to help track down
+	// what caused them.
+	numLogged := 0
+	for _, encodeErrs := range workerErrSamples {
+		for _, encodeErr := range encodeErrs {
+			if numLogged >= maxEncodeErrorSamples {
+				break
+			}
+			s.log.
+				WithFields(
+					xlog.NewField("shard", encodeErr.shard),
+					xlog.NewField("series", encodeErr.id.String()),
+					xlog.NewField("timestamp", encodeErr.timestamp),
+					xlog.NewErrField(encodeErr.err),
+				).
+				Error("error encoding datapoint from commit log")
+			numLogged++
+		}
 	}
 	if err := iter.Err(); err != nil {
 		s.log.Errorf("error reading commit log: %v", err)
 	}
 }
 
-func (s *commitLogSource) logMergeShardsOutcome(shardErrs []int, shardEmptyErrs []int) {
-	errSum := 0
-	for _, numErrs := range shardErrs {
-		errSum += numErrs
+func (s *commitLogSource) reportDroppedDatapoints(
+	ns namespace.Metadata,
+	reporter DroppedDatapointsReporter,
+	workerDropped [][]DroppedDatapoint,
+) {
+	numDropped := 0
+	for _, dropped := range workerDropped {
+		numDropped += len(dropped)
+	}
+	if numDropped == 0 {
+		return
+	}
+
+	allDropped := make([]DroppedDatapoint, 0, numDropped)
+	for _, dropped := range workerDropped {
+		allDropped = append(allDropped, dropped...)
+	}
+	reporter.ReportDroppedDatapoints(ns.ID(), allDropped)
+}
+
+// outOfOrderDatapoints counts the datapoints an encoding worker received out of
+// order and keeps a sample of them.
+type outOfOrderDatapoints struct {
+	num     int
+	samples []OutOfOrderDatapoint
+}
+
+func (d *outOfOrderDatapoints) add(dp OutOfOrderDatapoint) {
+	d.num++
+	if len(d.samples) < maxOutOfOrderDatapointSamples {
+		d.samples = append(d.samples, dp)
+	}
+}
+
+func (s *commitLogSource) reportOutOfOrderDatapoints(
+	ns namespace.Metadata,
+	reporter OutOfOrderDatapointsReporter,
+	workerOutOfOrder []outOfOrderDatapoints,
+) {
+	var (
+		numOutOfOrder int
+		samples       []OutOfOrderDatapoint
+	)
+	for _, outOfOrder := range workerOutOfOrder {
+		numOutOfOrder += outOfOrder.num
+		samples = append(samples, outOfOrder.samples...)
+	}
+	if numOutOfOrder == 0 {
+		return
+	}
+	reporter.ReportOutOfOrderDatapoints(ns.ID(), numOutOfOrder, samples)
+}
+
+// unmergedResult is the UnmergedResult of the commit log data read for
+// shards that was handed over instead of being merged.
+type unmergedResult struct {
+	unmerged    map[uint32]*shardData
+	unfulfilled result.ShardTimeRanges
+}
+
+func newUnmergedResult(
+	unmerged map[uint32]*shardData,
+	unfulfilled result.ShardTimeRanges,
+) *unmergedResult {
+	return &unmergedResult{unmerged: unmerged, unfulfilled: unfulfilled}
+}
+
+func (r *unmergedResult) Shards() []uint32 {
+	shards := make([]uint32, 0, len(r.unmerged))
+	for shard := range r.unmerged {
+		shards = append(shards, shard)
+	}
+	sort.Slice(shards, func(i, j int) bool {
+		return shards[i] < shards[j]
+	})
+	return shards
+}
+
+func (r *unmergedResult) Series(shard uint32) []UnmergedSeries {
+	unmergedShard, ok := r.unmerged[shard]
+	if !ok || unmergedShard.series == nil {
+		return nil
+	}
+
+	series := make([]UnmergedSeries, 0, unmergedShard.series.Len())
+	for _, entry := range unmergedShard.series.Iter() {
+		value := entry.Value()
+		if value.quarantined {
+			continue
+		}
+		encoders := make(map[xtime.UnixNano][]encoding.Encoder, len(value.encoders))
+		for blockStart, blockEncoders := range value.encoders {
+			for _, enc := range blockEncoders {
+				encoders[blockStart] = append(encoders[blockStart], enc.enc)
+			}
+		}
+		series = append(series, UnmergedSeries{
+			ID:       value.id,
+			Tags:     value.tags,
+			Encoders: encoders,
+		})
+	}
+	return series
+}
+
+func (r *unmergedResult) Unfulfilled() result.ShardTimeRanges {
+	return r.unfulfilled
+}
+
+func (r *unmergedResult) Close() {
+	closeUnmergedEncoders(r.unmerged)
+}
+
+// unmergedStats returns the number of series and encoders of every shard and
+// block of the commit log data read, ordered by shard and block start.
+func unmergedStats(unmerged map[uint32]*shardData) []UnmergedShardStats {
+	stats := make([]UnmergedShardStats, 0, len(unmerged))
+	for shard, unmergedShard := range unmerged {
+		shardStats := UnmergedShardStats{Shard: shard}
+		if unmergedShard.series != nil {
+			byBlock := make(map[xtime.UnixNano]UnmergedBlockStats)
+			for _, entry := range unmergedShard.series.Iter() {
+				shardStats.NumSeries++
+				for blockStart, encoders := range entry.Value().encoders {
+					blockStats := byBlock[blockStart]
+					blockStats.NumSeries++
+					blockStats.NumEncoders += len(encoders)
+					byBlock[blockStart] = blockStats
+				}
+			}
+			for blockStart, blockStats := range byBlock {
+				blockStats.BlockStart = blockStart.ToTime()
+				shardStats.Blocks = append(shardStats.Blocks, blockStats)
+			}
+			sort.Slice(shardStats.Blocks, func(i, j int) bool {
+				return shardStats.Blocks[i].BlockStart.Before(shardStats.Blocks[j].BlockStart)
+			})
+		}
+		stats = append(stats, shardStats)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Shard < stats[j].Shard
+	})
+	return stats
+}
+
+func (s *commitLogSource) logMergeShardsOutcome(
+	shardStats []mergeStats,
+	shardMergeErrs [][]mergeSeriesError,
+) {
+	var total mergeStats
+	for _, stats := range shardStats {
+		total.add(stats)
 	}
+	s.log.Infof("merged %d datapoints while bootstrapping from commit log", total.numDatapoints)
+	s.metrics.datapointsMerged.Inc(int64(total.numDatapoints))
+
+	errSum := total.numErrs
 	if errSum > 0 {
 		s.log.Errorf("error bootstrapping from commit log: %d merge out of order errors", errSum)
+		s.metrics.mergeErrors.Inc(int64(errSum))
 	}
 
-	emptyErrSum := 0
-	for _, numEmptyErr := range shardEmptyErrs {
-		emptyErrSum += numEmptyErr
+	// Log a sample of the series that failed to merge to help track down corrupt data.
+	numLogged := 0
+	for _, mergeErrs := range shardMergeErrs {
+		for _, mergeErr := range mergeErrs {
+			if numLogged >= maxMergeErrorSamples {
+				break
+			}
+			s.log.
+				WithFields(
+					xlog.NewField("shard", mergeErr.shard),
+					xlog.NewField("series", mergeErr.id.String()),
+					xlog.NewErrField(mergeErr.err),
+				).
+				Error("error merging series from commit log")
+			numLogged++
+		}
 	}
+
+	emptyErrSum := total.numEmptyErrs
 	if emptyErrSum > 0 {
-		s.log.Errorf("error bootstrapping from commit log: %d empty unmerged blocks errors", emptyErrSum)
+		if s.opts.EmptyMergedBlocksAreErrors() {
+			s.log.Errorf("error bootstrapping from commit log: %d empty unmerged blocks errors", emptyErrSum)
+			s.metrics.mergeErrors.Inc(int64(emptyErrSum))
+		} else {
+			// Empty blocks are usually benign, all of their datapoints having been dropped.
+			s.log.Infof("dropped %d empty blocks while bootstrapping from commit log", emptyErrSum)
+			s.metrics.emptyMergedBlocks.Inc(int64(emptyErrSum))
+		}
+	}
+}
+
+// withSharedSnapshotReadPool runs the snapshot read on the shared snapshot read
+// pool if there is one so that it counts towards the reads of every source the
+// pool is shared with, otherwise the read is run inline.
+func (s *commitLogSource) withSharedSnapshotReadPool(read func()) {
+	readPool := s.opts.SharedSnapshotReadPool()
+	if readPool == nil {
+		read()
+		return
 	}
+
+	done := make(chan struct{})
+	readPool.Go(func() {
+		defer close(done)
+		read()
+	})
+	<-done
 }
 
 func (s *commitLogSource) AvailableIndex(
 	ns namespace.Metadata,
 	shardsTimeRanges result.ShardTimeRanges,
 ) result.ShardTimeRanges {
-	// Commit log bootstrapper is a last ditch effort, so fulfill all
-	// time ranges requested even if not enough data, just to succeed
-	// the bootstrap
-	return shardsTimeRanges
+	// The index is built from the same data so it's available wherever the data is.
+	return s.AvailableData(ns, shardsTimeRanges)
 }
 
 func (s *commitLogSource) ReadIndex(
@@ -1303,28 +4173,40 @@ func (s *commitLogSource) ReadIndex(
 	// Determine which commit log files we need to read based on which snapshot
 	// snapshot files are available.
 	readCommitLogPredicate, mostRecentCompleteSnapshotByBlockShard, err := s.newReadCommitLogPredBasedOnAvailableSnapshotFiles(
-		ns, shardsTimeRanges, snapshotFilesByShard)
+		ns, shardsTimeRanges, snapshotFilesByShard, nil)
 	if err != nil {
 		return nil, err
 	}
 
 	var (
-		readSeriesPredicate = newReadSeriesPredicate(ns)
+		readSeriesPredicate = newReadSeriesPredicate(ns, s.seriesFilter(), s.namespaceMatcher())
 		iterOpts            = commitlog.IteratorOpts{
-			CommitLogOptions:      s.opts.CommitLogOptions(),
+			CommitLogOptions:      s.commitLogReadOptions(),
 			FileFilterPredicate:   readCommitLogPredicate,
 			SeriesFilterPredicate: readSeriesPredicate,
 		}
 	)
 
-	// Start by reading any available snapshot files.
+	// Start by reading any available snapshot files, the ranges of those that
+	// can't be read are left for a subsequent bootstrapper.
+	snapshotsUnfulfilled := result.ShardTimeRanges{}
 	for shard, tr := range shardsTimeRanges {
-		shardResult, err := s.bootstrapShardSnapshots(
-			ns.ID(), shard, true, tr, blockSize, snapshotFilesByShard[shard],
-			mostRecentCompleteSnapshotByBlockShard)
+		var (
+			shardResult         result.ShardResult
+			snapshotUnfulfilled xtime.Ranges
+			err                 error
+		)
+		s.withSharedSnapshotReadPool(func() {
+			shardResult, snapshotUnfulfilled, err = s.bootstrapShardSnapshots(
+				ns.ID(), shard, true, tr, blockSize, snapshotFilesByShard[shard],
+				mostRecentCompleteSnapshotByBlockShard, nil)
+		})
 		if err != nil {
 			return nil, err
 		}
+		if !snapshotUnfulfilled.IsEmpty() {
+			snapshotsUnfulfilled[shard] = snapshotUnfulfilled
+		}
 
 		// Bootstrap any series we got from the snapshot files into the index.
 		for _, val := range shardResult.AllSeries().Iter() {
@@ -1338,20 +4220,39 @@ func (s *commitLogSource) ReadIndex(
 		}
 	}
 
-	// Next, read all of the data from the commit log files that wasn't covered
-	// by the snapshot files.
-	iter, err := s.newIteratorFn(iterOpts)
-	if err != nil {
-		return nil, fmt.Errorf("unable to create commit log iterator: %v", err)
-	}
-	defer iter.Close()
+	fulfillableRanges := shardsTimeRanges.Copy()
+	fulfillableRanges.Subtract(snapshotsUnfulfilled)
+	if s.opts.SnapshotsOnly() {
+		// The commit log isn't read so the writes received after each snapshot was
+		// taken are left for a subsequent bootstrapper, same as when reading data.
+		fulfillableRanges.Subtract(snapshotTailsUnfulfilled(shardsTimeRanges, blockSize,
+			ns.Options().RetentionOptions().BufferPast(), mostRecentCompleteSnapshotByBlockShard))
+	} else {
+		// Next, read all of the data from the commit log files that wasn't covered
+		// by the snapshot files.
+		iter, err := s.newIteratorFn(iterOpts)
+		if err != nil {
+			return nil, IteratorCreationError{Err: err}
+		}
+		defer iter.Close()
 
-	for iter.Next() {
-		series, dp, _, _ := iter.Current()
+		for iter.Next() {
+			series, dp, _, _ := iter.Current()
 
-		s.maybeAddToIndex(
-			series.ID, series.Tags, series.Shard, highestShard, dp.Timestamp, bootstrapRangesByShard,
-			indexResults, indexOptions, indexBlockSize, resultOptions)
+			s.maybeAddToIndex(
+				series.ID, series.Tags, series.Shard, highestShard, dp.Timestamp, bootstrapRangesByShard,
+				indexResults, indexOptions, indexBlockSize, resultOptions)
+		}
+
+		// Same as when reading data, commit log files that couldn't be read are
+		// skipped and the blocks they may have had writes for left unfulfilled.
+		fileErrs := iter.FileErrors()
+		if err := iter.Err(); err != nil &&
+			(len(fileErrs) == 0 || s.opts.FailOnCommitLogReadError()) {
+			return nil, err
+		}
+		fulfillableRanges.Subtract(
+			s.unreadableCommitLogFilesUnfulfilled(ns, shardsTimeRanges, fileErrs))
 	}
 
 	// If all successful then we mark each index block as fulfilled
@@ -1361,7 +4262,7 @@ func (s *commitLogSource) ReadIndex(
 			End:   block.BlockStart().Add(indexOptions.BlockSize()),
 		}
 		fulfilled := result.ShardTimeRanges{}
-		for shard, timeRanges := range shardsTimeRanges {
+		for shard, timeRanges := range fulfillableRanges {
 			iter := timeRanges.Iter()
 			for iter.Next() {
 				curr := iter.Value()
@@ -1423,28 +4324,216 @@ func (s commitLogSource) maybeAddToIndex(
 	return err
 }
 
-func newReadSeriesPredicate(ns namespace.Metadata) commitlog.SeriesFilterPredicate {
+// seriesFilter returns the series filter of the options restricted to the
+// series of the allowlist, nil if every series is read.
+func (s *commitLogSource) seriesFilter() SeriesFilter {
+	var (
+		seriesFilter = s.opts.SeriesFilter()
+		allowlist    = s.opts.SeriesAllowlist()
+	)
+	if len(allowlist) == 0 {
+		return seriesFilter
+	}
+
+	allowed := make(map[string]struct{}, len(allowlist))
+	for _, id := range allowlist {
+		allowed[id.String()] = struct{}{}
+	}
+	return func(id ident.ID) bool {
+		if _, ok := allowed[string(id.Bytes())]; !ok {
+			return false
+		}
+		return seriesFilter == nil || seriesFilter(id)
+	}
+}
+
+// commitLogReadOptions returns the commit log options used to read commit log
+// files, which decrypt them when a decryptor is set.
+func (s *commitLogSource) commitLogReadOptions() commitlog.Options {
+	opts := s.opts.CommitLogOptions()
+	if decryptor := s.opts.Decryptor(); decryptor != nil {
+		opts = opts.SetReadDecompressor(
+			decryptingDecompressor(decryptor, opts.ReadDecompressor()))
+	}
+	return opts
+}
+
+// namespaceMatcher returns the configured namespace matcher or, if none is
+// set, one that requires the namespace IDs to be byte identical.
+func (s *commitLogSource) namespaceMatcher() NamespaceMatcher {
+	if matcher := s.opts.NamespaceMatcher(); matcher != nil {
+		return matcher
+	}
+	return func(bootstrapping ident.ID, written ident.ID) bool {
+		return bootstrapping.Equal(written)
+	}
+}
+
+func newReadSeriesPredicate(
+	ns namespace.Metadata,
+	seriesFilter SeriesFilter,
+	namespaceMatcher NamespaceMatcher,
+) commitlog.SeriesFilterPredicate {
 	nsID := ns.ID()
 	return func(id ident.ID, namespace ident.ID) bool {
-		return nsID.Equal(namespace)
+		return namespaceMatcher(nsID, namespace) && (seriesFilter == nil || seriesFilter(id))
+	}
+}
+
+// copyBytes copies the data into bytes from the pool and returns the data to
+// the pool it was read with.
+func copyBytes(bytesPool pool.CheckedBytesPool, data checked.Bytes) checked.Bytes {
+	data.IncRef()
+	copied := bytesPool.Get(data.Len())
+	copied.IncRef()
+	copied.AppendAll(data.Bytes())
+	copied.DecRef()
+	data.DecRef()
+	data.Finalize()
+	return copied
+}
+
+type blockDedupKey struct {
+	blockStart xtime.UnixNano
+	checksum   uint32
+}
+
+// blockDedupCache holds the data of the snapshot blocks read by a bootstrap so
+// that identical blocks, for instance of a series present in the snapshots of
+// multiple shards, can share it. Only blocks that are not merged with commit
+// log data end up sharing it since merging re-encodes the data.
+type blockDedupCache struct {
+	sync.Mutex
+	blocks map[blockDedupKey][]*sharedBlockData
+	closed bool
+}
+
+func newBlockDedupCache() *blockDedupCache {
+	return &blockDedupCache{blocks: make(map[blockDedupKey][]*sharedBlockData)}
+}
+
+// share returns bytes for a block that reference the data of an identical
+// block read earlier and true. If there isn't one the data is added for later
+// blocks to share and bytes referencing it are returned with false. Once the
+// cache is closed nil is returned and the data isn't shared.
+func (c *blockDedupCache) share(
+	blockStart time.Time,
+	checksum uint32,
+	data checked.Bytes,
+) (checked.Bytes, bool) {
+	key := blockDedupKey{blockStart: xtime.ToUnixNano(blockStart), checksum: checksum}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if c.closed {
+		return nil, false
+	}
+
+	data.IncRef()
+	defer data.DecRef()
+	// The checksum can collide so the data itself has to be compared.
+	for _, existing := range c.blocks[key] {
+		existing.data.IncRef()
+		equal := bytes.Equal(existing.data.Bytes(), data.Bytes())
+		existing.data.DecRef()
+		if equal {
+			return existing.newBytes(), true
+		}
+	}
+	shared := newSharedBlockData(data)
+	c.blocks[key] = append(c.blocks[key], shared)
+	return shared.newBytes(), false
+}
+
+// close releases the data held by the cache, the data is finalized once the
+// blocks sharing it have been closed too.
+func (c *blockDedupCache) close() {
+	c.Lock()
+	defer c.Unlock()
+
+	for _, blocks := range c.blocks {
+		for _, shared := range blocks {
+			shared.release()
+		}
+	}
+	c.blocks = nil
+	c.closed = true
+}
+
+// sharedBlockData is the data of a snapshot block shared by identical blocks.
+// Each block gets bytes of its own that reference the data, so the data is only
+// finalized once the bytes of every block and the cache have released it.
+type sharedBlockData struct {
+	data checked.Bytes
+	opts checked.BytesOptions
+	refs int64
+}
+
+func newSharedBlockData(data checked.Bytes) *sharedBlockData {
+	// The cache holds a reference until it's closed.
+	shared := &sharedBlockData{data: data, refs: 1}
+	shared.opts = checked.NewBytesOptions().SetFinalizer(shared)
+	return shared
+}
+
+func (d *sharedBlockData) newBytes() checked.Bytes {
+	atomic.AddInt64(&d.refs, 1)
+	d.data.IncRef()
+	defer d.data.DecRef()
+	return checked.NewBytes(d.data.Bytes(), d.opts)
+}
+
+// FinalizeBytes releases the reference of bytes returned by newBytes.
+func (d *sharedBlockData) FinalizeBytes(_ checked.Bytes) {
+	d.release()
+}
+
+func (d *sharedBlockData) release() {
+	if atomic.AddInt64(&d.refs, -1) == 0 {
+		d.data.Finalize()
 	}
 }
 
 type shardData struct {
 	series *Map
 	ranges xtime.Ranges
+	// snapshotTimes contains the snapshot time for every block that has a
+	// snapshot which will be merged with the commit log data.
+	snapshotTimes map[xtime.UnixNano]time.Time
+	// droppedBlocks contains the blocks whose commit log data was dropped while
+	// encoding, they are only accessed by the encoding worker owning the shard
+	// until it is done.
+	droppedBlocks map[xtime.UnixNano]struct{}
+	// incompleteBlocks contains the blocks that lost the commit log data of some
+	// series because compacting their encoders failed, they are accessed the same
+	// way as the dropped blocks.
+	incompleteBlocks map[xtime.UnixNano]struct{}
+}
+
+// markIncomplete records that some of the commit log data of the block was lost
+// so that it's marked as unfulfilled.
+func (d *shardData) markIncomplete(blockStart xtime.UnixNano) {
+	if d.incompleteBlocks == nil {
+		d.incompleteBlocks = make(map[xtime.UnixNano]struct{})
+	}
+	d.incompleteBlocks[blockStart] = struct{}{}
 }
 
 type metadataAndEncodersByTime struct {
 	id   ident.ID
 	tags ident.Tags
+	// quarantined is set when the series was rejected by the series validator
+	// in which case its datapoints are not encoded and its snapshot data is
+	// dropped when merging.
+	quarantined bool
 	// int64 instead of time.Time because there is an optimized map access pattern
 	// for i64's
 	encoders map[xtime.UnixNano][]encoder
 }
 
 // encoderArg contains all the information a worker go-routine needs to encode
-// a data point as M3TSZ
+// a data point
 type encoderArg struct {
 	series     commitlog.Series
 	dp         ts.Datapoint
@@ -1453,6 +4542,84 @@ type encoderArg struct {
 	blockStart time.Time
 }
 
+// mergeReaders calls fn with the datapoints of all the readers in time order.
+//
+// Datapoints with identical timestamps are deduplicated explicitly rather than
+// relying on the order that a multi-reader iterator happens to visit its
+// readers in, so that the result is deterministic across runs. The datapoint
+// from the reader with the highest index wins: readers are ordered with the
+// snapshot block first followed by the commit log encoders in the order they
+// were created, and the worker only ever writes a repeated timestamp into a
+// later encoder. This means that commit log datapoints win over snapshot
+// datapoints and that the last write in commit log order wins.
+func mergeReaders(
+	multiReaderIteratorPool encoding.MultiReaderIteratorPool,
+	readers ioReaders,
+	fn func(dp ts.Datapoint, unit xtime.Unit, annotation ts.Annotation) error,
+) error {
+	var (
+		iters  = make([]encoding.MultiReaderIterator, 0, len(readers))
+		active = make([]bool, 0, len(readers))
+	)
+	defer func() {
+		// Automatically returns iters to the pool.
+		for _, iter := range iters {
+			iter.Close()
+		}
+	}()
+
+	for _, reader := range readers {
+		iter := multiReaderIteratorPool.Get()
+		iter.Reset([]xio.SegmentReader{reader}, time.Time{}, 0)
+		iters = append(iters, iter)
+		active = append(active, iter.Next())
+	}
+
+	for {
+		var (
+			winner = -1
+			at     time.Time
+		)
+		for i, iter := range iters {
+			if !active[i] {
+				continue
+			}
+			dp, _, _ := iter.Current()
+			// Ties go to the later reader, see the rule above.
+			if winner == -1 || !dp.Timestamp.After(at) {
+				winner = i
+				at = dp.Timestamp
+			}
+		}
+		if winner == -1 {
+			break
+		}
+
+		dp, unit, annotation := iters[winner].Current()
+		if err := fn(dp, unit, annotation); err != nil {
+			return err
+		}
+
+		// Advance every reader positioned at the emitted timestamp so the
+		// losing duplicates are dropped.
+		for i, iter := range iters {
+			if !active[i] {
+				continue
+			}
+			if curr, _, _ := iter.Current(); curr.Timestamp.Equal(at) {
+				active[i] = iter.Next()
+			}
+		}
+	}
+
+	for _, iter := range iters {
+		if err := iter.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 type ioReaders []xio.SegmentReader
 
 func newIOReadersFromEncodersAndBlock(
@@ -21,10 +21,17 @@
 package commitlog
 
 import (
+	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"reflect"
 	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -34,18 +41,23 @@ import (
 	"github.com/m3db/m3/src/dbnode/persist"
 	"github.com/m3db/m3/src/dbnode/persist/fs"
 	"github.com/m3db/m3/src/dbnode/persist/fs/commitlog"
+	"github.com/m3db/m3/src/dbnode/retention"
 	"github.com/m3db/m3/src/dbnode/storage/block"
 	"github.com/m3db/m3/src/dbnode/storage/bootstrap"
 	"github.com/m3db/m3/src/dbnode/storage/bootstrap/result"
 	"github.com/m3db/m3/src/dbnode/storage/namespace"
 	"github.com/m3db/m3/src/dbnode/ts"
 	"github.com/m3db/m3x/checked"
+	xerrors "github.com/m3db/m3x/errors"
 	"github.com/m3db/m3x/ident"
+	xlog "github.com/m3db/m3x/log"
 	"github.com/m3db/m3x/pool"
+	xsync "github.com/m3db/m3x/sync"
 	xtime "github.com/m3db/m3x/time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/require"
+	"github.com/uber-go/tally"
 )
 
 var (
@@ -92,6 +104,103 @@ func TestAvailableEmptyRangeError(t *testing.T) {
 	require.True(t, result.ShardTimeRanges{}.Equal(res))
 }
 
+func TestAvailableReportsMissingCommitLogFiles(t *testing.T) {
+	var (
+		md         = testNsMetadata(t)
+		rOpts      = md.Options().RetentionOptions()
+		blockSize  = rOpts.BlockSize()
+		now        = time.Now()
+		first      = now.Truncate(blockSize).Add(-2 * blockSize)
+		second     = first.Add(blockSize)
+		end        = now.Truncate(blockSize)
+		logSize    = 10 * time.Minute
+		missingAt  = second.Add(blockSize / 2).Truncate(logSize)
+		snapshotAt = missingAt.Add(2 * logSize)
+		ranges     = xtime.Ranges{}.AddRange(xtime.Range{Start: first, End: end})
+		requested  = result.ShardTimeRanges{0: ranges, 1: ranges}
+		files      []commitlog.File
+		inspection = testCommitLogFilesInspection{}
+	)
+
+	// Contiguous commit log files from before the first block until after now
+	// except for one in the middle of the second block.
+	for at := first.Add(-blockSize); at.Before(end.Add(blockSize)); at = at.Add(logSize) {
+		if at.Equal(missingAt) {
+			continue
+		}
+		path := fmt.Sprintf("commitlog-%d", at.UnixNano())
+		files = append(files, commitlog.File{FilePath: path, Start: at, Duration: logSize})
+		inspection[path] = struct{}{}
+	}
+
+	tests := []struct {
+		name          string
+		accurate      bool
+		snapshotsOnly bool
+		expected      result.ShardTimeRanges
+	}{
+		{
+			name:     "last ditch effort",
+			expected: requested,
+		},
+		{
+			name:     "accurate",
+			accurate: true,
+			expected: result.ShardTimeRanges{
+				// Only shard 0 reads the missing file since shard 1 has a snapshot
+				// for the second block that was taken after it.
+				0: xtime.Ranges{}.AddRange(xtime.Range{Start: first, End: second}),
+				1: ranges,
+			},
+		},
+		{
+			name:          "accurate snapshots only",
+			accurate:      true,
+			snapshotsOnly: true,
+			expected: result.ShardTimeRanges{
+				// Only the part of the second block of shard 1 that can't have been
+				// written to after its snapshot was taken.
+				1: xtime.Ranges{}.AddRange(xtime.Range{
+					Start: second,
+					End:   snapshotAt.Add(-rOpts.BufferPast()),
+				}),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			opts := testOptions().
+				SetReportAccurateAvailability(test.accurate).
+				SetSnapshotsOnly(test.snapshotsOnly)
+			src := newCommitLogSource(opts, inspection).(*commitLogSource)
+			src.commitLogFilesFn = func(_ commitlog.Options) ([]commitlog.File, error) {
+				return files, nil
+			}
+			src.snapshotFilesFn = func(_ string, namespace ident.ID, shard uint32) (fs.FileSetFilesSlice, error) {
+				if shard != 1 {
+					return nil, nil
+				}
+				return fs.FileSetFilesSlice{
+					fs.FileSetFile{
+						ID: fs.FileSetFileIdentifier{
+							Namespace:  namespace,
+							BlockStart: second,
+							Shard:      shard,
+						},
+						AbsoluteFilepaths:  []string{"checkpoint"},
+						CachedSnapshotTime: snapshotAt,
+					},
+				}, nil
+			}
+
+			available := src.AvailableData(md, requested)
+			require.True(t, test.expected.Equal(available),
+				fmt.Sprintf("expected: %s, actual: %s", test.expected, available))
+		})
+	}
+}
+
 func TestReadEmpty(t *testing.T) {
 	opts := testOptions()
 
@@ -100,6 +209,7 @@ func TestReadEmpty(t *testing.T) {
 	res, err := src.ReadData(testNsMetadata(t), result.ShardTimeRanges{},
 		testDefaultRunOpts)
 	require.NoError(t, err)
+	require.NotNil(t, res)
 	require.Equal(t, 0, len(res.ShardResults()))
 	require.True(t, res.Unfulfilled().IsEmpty())
 }
@@ -108,8 +218,9 @@ func TestReadErrorOnNewIteratorError(t *testing.T) {
 	opts := testOptions()
 	src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
 
+	cause := fmt.Errorf("an error")
 	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
-		return nil, fmt.Errorf("an error")
+		return nil, cause
 	}
 
 	ranges := xtime.Ranges{}
@@ -121,6 +232,67 @@ func TestReadErrorOnNewIteratorError(t *testing.T) {
 		testDefaultRunOpts)
 	require.Error(t, err)
 	require.Nil(t, res)
+
+	iterErr, ok := err.(IteratorCreationError)
+	require.True(t, ok)
+	require.Equal(t, cause, iterErr.Unwrap())
+	require.Equal(t, cause, xerrors.InnerError(err))
+}
+
+func TestOptionsValidate(t *testing.T) {
+	opts := testOptions()
+	require.NoError(t, opts.Validate())
+
+	tests := []struct {
+		name     string
+		opts     Options
+		expected error
+	}{
+		{"nil result options", opts.SetResultOptions(nil), errResultOptionsNotSet},
+		{"nil commit log options", opts.SetCommitLogOptions(nil), errCommitLogOptionsNotSet},
+		{"zero encoding concurrency", opts.SetEncodingConcurrency(0), errEncodingConcurrencyPositive},
+		{"zero merge shards concurrency", opts.SetMergeShardsConcurrency(0), errMergeShardConcurrencyPositive},
+		{"zero merge series concurrency", opts.SetMergeSeriesConcurrency(0), errMergeSeriesConcurrencyPositive},
+		{"zero snapshot resolution concurrency", opts.SetSnapshotResolutionConcurrency(0), errSnapshotResolutionConcurrencyPositive},
+		{"zero snapshot read concurrency", opts.SetSnapshotReadConcurrency(0), errSnapshotReadConcurrencyPositive},
+		{"nil progress reporter", opts.SetProgressReporter(nil), errProgressReporterNotSet},
+		{"nil work distributor", opts.SetWorkDistributor(nil), errWorkDistributorNotSet},
+		{"nil file source", opts.SetFileSource(nil), errFileSourceNotSet},
+	}
+
+	for _, test := range tests {
+		require.Equal(t, test.expected, test.opts.Validate(), test.name)
+
+		_, err := NewCommitLogSource(test.opts, fs.Inspection{})
+		require.Equal(t, test.expected, err, test.name)
+
+		_, err = NewCommitLogBootstrapperProvider(test.opts, fs.Inspection{}, nil)
+		require.Equal(t, test.expected, err, test.name)
+	}
+}
+
+func TestReadErrorOnNonPositiveEncodingConcurrency(t *testing.T) {
+	opts := testOptions().SetEncodingConcurrency(0)
+	require.Equal(t, errEncodingConcurrencyPositive, opts.Validate())
+
+	_, err := NewCommitLogSource(opts, fs.Inspection{})
+	require.Equal(t, errEncodingConcurrencyPositive, err)
+
+	// Sources constructed without validating the options must error rather
+	// than panic when distributing series across workers.
+	src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		return newTestCommitLogIterator(nil, nil), nil
+	}
+
+	ranges := xtime.Ranges{}.AddRange(xtime.Range{
+		Start: time.Now(),
+		End:   time.Now().Add(time.Hour),
+	})
+	res, err := src.ReadData(testNsMetadata(t), result.ShardTimeRanges{0: ranges},
+		testDefaultRunOpts)
+	require.Equal(t, errEncodingConcurrencyPositive, err)
+	require.Nil(t, res)
 }
 
 func TestReadOrderedValues(t *testing.T) {
@@ -168,241 +340,5786 @@ func TestReadOrderedValues(t *testing.T) {
 		values[:4], blockSize, res.ShardResults(), opts))
 }
 
-func TestReadUnorderedValues(t *testing.T) {
+func TestReadUsesConfiguredEncoderPool(t *testing.T) {
+	var (
+		opts      = testOptions()
+		blOpts    = opts.ResultOptions().DatabaseBlockOptions()
+		encPool   = &testCountingEncoderPool{EncoderPool: blOpts.EncoderPool()}
+		md        = testNsMetadata(t)
+		blockSize = md.Options().RetentionOptions().BlockSize()
+		now       = time.Now()
+		start     = now.Truncate(blockSize).Add(-blockSize)
+		end       = now.Truncate(blockSize)
+		ranges    = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		foo       = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		bar       = commitlog.Series{Namespace: testNamespaceID, Shard: 1, ID: ident.StringID("bar")}
+		values    = []testValue{
+			{foo, start, 1.0, xtime.Second, nil},
+			{foo, start.Add(time.Minute), 2.0, xtime.Second, nil},
+			{bar, start.Add(2 * time.Minute), 3.0, xtime.Second, nil},
+		}
+	)
+
+	opts = opts.SetResultOptions(opts.ResultOptions().SetDatabaseBlockOptions(
+		blOpts.SetEncoderPool(encPool)))
+	src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		return newTestCommitLogIterator(values, nil), nil
+	}
+
+	res, err := src.ReadData(md, result.ShardTimeRanges{0: ranges, 1: ranges}, testDefaultRunOpts)
+	require.NoError(t, err)
+	require.NoError(t, verifyShardResultsAreCorrect(
+		values, blockSize, res.ShardResults(), opts))
+
+	// At least one encoder per series is taken from the configured pool.
+	require.True(t, atomic.LoadInt64(&encPool.gets) >= 2)
+}
+
+// testCountingEncoderPool counts the encoders taken from the pool it wraps.
+type testCountingEncoderPool struct {
+	encoding.EncoderPool
+
+	gets int64
+}
+
+func (p *testCountingEncoderPool) Get() encoding.Encoder {
+	atomic.AddInt64(&p.gets, 1)
+	return p.EncoderPool.Get()
+}
+
+func TestReadClosesEncodersWhenAbandoned(t *testing.T) {
+	var (
+		opts      = testOptions()
+		blOpts    = opts.ResultOptions().DatabaseBlockOptions()
+		encPool   = &testLeakCheckingEncoderPool{EncoderPool: blOpts.EncoderPool()}
+		md        = testNsMetadata(t)
+		blockSize = md.Options().RetentionOptions().BlockSize()
+		now       = time.Now()
+		start     = now.Truncate(blockSize).Add(-blockSize)
+		end       = now.Truncate(blockSize)
+		ranges    = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		foo       = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		bar       = commitlog.Series{Namespace: testNamespaceID, Shard: 1, ID: ident.StringID("bar")}
+		values    = []testValue{
+			{foo, start, 1.0, xtime.Second, nil},
+			{foo, start.Add(time.Minute), 2.0, xtime.Second, nil},
+			{bar, start.Add(2 * time.Minute), 3.0, xtime.Second, nil},
+		}
+	)
+
+	opts = opts.SetResultOptions(opts.ResultOptions().SetDatabaseBlockOptions(
+		blOpts.SetEncoderPool(encPool)))
+	src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		// The error can't be attributed to a file so the read is abandoned after
+		// all of the values have been encoded.
+		return newTestCommitLogIterator(values, fmt.Errorf("an error")), nil
+	}
+
+	_, err := src.ReadData(md, result.ShardTimeRanges{0: ranges, 1: ranges}, testDefaultRunOpts)
+	require.Error(t, err)
+
+	encPool.Lock()
+	defer encPool.Unlock()
+	require.True(t, encPool.gets >= 2)
+	require.Equal(t, 0, encPool.outstanding)
+}
+
+// testLeakCheckingEncoderPool tracks the encoders taken from the pool it wraps
+// that have not been closed or discarded yet.
+type testLeakCheckingEncoderPool struct {
+	sync.Mutex
+	encoding.EncoderPool
+
+	gets        int
+	outstanding int
+}
+
+func (p *testLeakCheckingEncoderPool) Get() encoding.Encoder {
+	p.Lock()
+	p.gets++
+	p.outstanding++
+	p.Unlock()
+	return &testLeakCheckingEncoder{Encoder: p.EncoderPool.Get(), pool: p}
+}
+
+type testLeakCheckingEncoder struct {
+	encoding.Encoder
+
+	pool     *testLeakCheckingEncoderPool
+	released bool
+}
+
+func (e *testLeakCheckingEncoder) release() {
+	if e.released {
+		return
+	}
+	e.released = true
+	e.pool.Lock()
+	e.pool.outstanding--
+	e.pool.Unlock()
+}
+
+func (e *testLeakCheckingEncoder) Close() {
+	e.release()
+	e.Encoder.Close()
+}
+
+func (e *testLeakCheckingEncoder) Discard() ts.Segment {
+	e.release()
+	return e.Encoder.Discard()
+}
+
+func TestReadClampsEncodingWorkersToNumShards(t *testing.T) {
+	var (
+		opts      = testOptions().SetEncodingConcurrency(16)
+		md        = testNsMetadata(t)
+		blockSize = md.Options().RetentionOptions().BlockSize()
+		now       = time.Now()
+		start     = now.Truncate(blockSize).Add(-blockSize)
+		end       = now.Truncate(blockSize)
+		ranges    = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		foo       = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		bar       = commitlog.Series{Namespace: testNamespaceID, Shard: 1, ID: ident.StringID("bar")}
+		values    = []testValue{
+			{foo, start, 1.0, xtime.Second, nil},
+			{bar, start.Add(time.Minute), 2.0, xtime.Second, nil},
+		}
+		distributor = &testRecordingWorkDistributor{}
+	)
+
+	opts = opts.SetWorkDistributor(distributor)
+	src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		return newTestCommitLogIterator(values, nil), nil
+	}
+
+	res, err := src.ReadData(md, result.ShardTimeRanges{0: ranges, 1: ranges}, testDefaultRunOpts)
+	require.NoError(t, err)
+	require.NoError(t, verifyShardResultsAreCorrect(
+		values, blockSize, res.ShardResults(), opts))
+	require.Equal(t, []int{2, 2}, distributor.numWorkers)
+}
+
+// testRecordingWorkDistributor records the number of workers it distributes
+// series between.
+type testRecordingWorkDistributor struct {
+	numWorkers []int
+}
+
+func (d *testRecordingWorkDistributor) WorkerIndex(series commitlog.Series, numWorkers int) int {
+	d.numWorkers = append(d.numWorkers, numWorkers)
+	return int(series.Shard % uint32(numWorkers))
+}
+
+func TestReadAdaptiveEncodingConcurrencyGrowsUnderBackpressure(t *testing.T) {
+	var (
+		opts        = testOptions().SetAdaptiveEncodingConcurrency(1, 4)
+		blOpts      = opts.ResultOptions().DatabaseBlockOptions()
+		md          = testNsMetadata(t)
+		blockSize   = md.Options().RetentionOptions().BlockSize()
+		now         = time.Now()
+		start       = now.Truncate(blockSize).Add(-blockSize)
+		end         = now.Truncate(blockSize)
+		ranges      = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		distributor = &testRecordingWorkDistributor{}
+		values      []testValue
+	)
+
+	// Enough datapoints of a single shard for the slow worker to fall behind
+	// over several windows before the remaining shards are first seen.
+	foo := commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+	for i := 0; i < 8*adaptiveConcurrencyWindow; i++ {
+		values = append(values, testValue{
+			foo, start.Add(time.Duration(i) * 100 * time.Millisecond), float64(i), xtime.Millisecond, nil})
+	}
+	shardsTimeRanges := result.ShardTimeRanges{0: ranges}
+	for shard := uint32(1); shard < 4; shard++ {
+		series := commitlog.Series{
+			Namespace: testNamespaceID, Shard: shard, ID: ident.StringID(fmt.Sprintf("series-%d", shard))}
+		values = append(values, testValue{series, start, 1.0, xtime.Second, nil})
+		shardsTimeRanges[shard] = ranges
+	}
+
+	opts = opts.
+		SetWorkDistributor(distributor).
+		SetResultOptions(opts.ResultOptions().SetDatabaseBlockOptions(
+			blOpts.SetEncoderPool(&testSlowEncoderPool{EncoderPool: blOpts.EncoderPool()})))
+	src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		return newTestCommitLogIterator(values, nil), nil
+	}
+
+	res, err := src.ReadData(md, shardsTimeRanges, testDefaultRunOpts)
+	require.NoError(t, err)
+	require.NoError(t, verifyShardResultsAreCorrect(
+		values, blockSize, res.ShardResults(), opts))
+
+	// The distributor is only asked once per shard, the first shard is assigned
+	// to the min number of workers and the last ones to the max.
+	require.Equal(t, []int{1, 4, 4, 4}, distributor.numWorkers)
+}
+
+// testSlowEncoderPool returns encoders that take a while to encode each
+// datapoint so that the encoding workers fall behind the reader.
+type testSlowEncoderPool struct {
+	encoding.EncoderPool
+}
+
+func (p *testSlowEncoderPool) Get() encoding.Encoder {
+	return &testSlowEncoder{Encoder: p.EncoderPool.Get()}
+}
+
+type testSlowEncoder struct {
+	encoding.Encoder
+}
+
+func (e *testSlowEncoder) Encode(
+	dp ts.Datapoint,
+	unit xtime.Unit,
+	annotation ts.Annotation,
+) error {
+	time.Sleep(100 * time.Microsecond)
+	return e.Encoder.Encode(dp, unit, annotation)
+}
+
+func TestReadFromIterator(t *testing.T) {
 	opts := testOptions()
 	md := testNsMetadata(t)
 	src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		require.FailNow(t, "should use the provided iterator")
+		return nil, nil
+	}
 
 	blockSize := md.Options().RetentionOptions().BlockSize()
 	now := time.Now()
 	start := now.Truncate(blockSize).Add(-blockSize)
 	end := now.Truncate(blockSize)
-
-	// Request a little after the start of data, because always reading full blocks
-	// it should return the entire block beginning from "start"
-	require.True(t, blockSize >= minCommitLogRetention)
-	ranges := xtime.Ranges{}
-	ranges = ranges.AddRange(xtime.Range{
-		Start: start,
-		End:   end,
-	})
+	ranges := xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
 
 	foo := commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+	bar := commitlog.Series{Namespace: testNamespaceID, Shard: 1, ID: ident.StringID("bar")}
 
 	values := []testValue{
-		{foo, start.Add(10 * time.Minute), 1.0, xtime.Second, nil},
-		{foo, start.Add(1 * time.Minute), 2.0, xtime.Second, nil},
+		{foo, start, 1.0, xtime.Second, nil},
+		{bar, start.Add(1 * time.Minute), 2.0, xtime.Second, nil},
 		{foo, start.Add(2 * time.Minute), 3.0, xtime.Second, nil},
-		{foo, start.Add(3 * time.Minute), 4.0, xtime.Second, nil},
-		{foo, start, 5.0, xtime.Second, nil},
 	}
-	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
-		return newTestCommitLogIterator(values, nil), nil
+	iter := newTestCommitLogIterator(values, nil)
+
+	targetRanges := result.ShardTimeRanges{0: ranges, 1: ranges}
+	res, err := src.ReadFromIterator(md, targetRanges, testDefaultRunOpts, iter, ReadPlan{})
+	require.NoError(t, err)
+	require.Equal(t, 2, len(res.ShardResults()))
+	require.Equal(t, 0, len(res.Unfulfilled()))
+	require.NoError(t, verifyShardResultsAreCorrect(
+		values, blockSize, res.ShardResults(), opts))
+
+	// The caller owns the iterator.
+	require.False(t, iter.closed)
+}
+
+func TestReadStreamingEmitsEachShardBeforeReturning(t *testing.T) {
+	var (
+		opts      = testOptions()
+		md        = testNsMetadata(t)
+		src       = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		blockSize = md.Options().RetentionOptions().BlockSize()
+		now       = time.Now()
+		start     = now.Truncate(blockSize).Add(-blockSize)
+		end       = now.Truncate(blockSize)
+		ranges    = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		targets   = result.ShardTimeRanges{}
+		values    []testValue
+	)
+
+	for shard := uint32(0); shard < 3; shard++ {
+		targets[shard] = ranges
+		series := commitlog.Series{
+			Namespace: testNamespaceID,
+			Shard:     shard,
+			ID:        ident.StringID(fmt.Sprintf("series-%d", shard)),
+		}
+		values = append(values, testValue{series, start.Add(time.Minute), float64(shard), xtime.Second, nil})
+	}
+
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		return newTestCommitLogIterator(values, nil), nil
+	}
+
+	var (
+		shardResults = make(chan ShardReadResult)
+		returned     int32
+		received     = map[uint32]int{}
+		done         = make(chan struct{})
+	)
+	go func() {
+		defer close(done)
+		for r := range shardResults {
+			// Unbuffered so every send happens before ReadStreaming returns.
+			require.Equal(t, int32(0), atomic.LoadInt32(&returned))
+			require.Equal(t, 1, r.Result.NumSeries())
+			require.True(t, r.Unfulfilled.IsEmpty())
+			received[r.Shard]++
+		}
+	}()
+
+	res, err := src.ReadStreaming(md, targets, testDefaultRunOpts, shardResults)
+	atomic.StoreInt32(&returned, 1)
+	<-done
+
+	require.NoError(t, err)
+	require.Equal(t, map[uint32]int{0: 1, 1: 1, 2: 1}, received)
+	require.NoError(t, verifyShardResultsAreCorrect(
+		values, blockSize, res.ShardResults(), opts))
+}
+
+func TestReadStreamingOmitsStreamedShardResults(t *testing.T) {
+	var (
+		opts      = testOptions().SetOmitStreamedShardResults(true)
+		md        = testNsMetadata(t)
+		src       = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		blockSize = md.Options().RetentionOptions().BlockSize()
+		now       = time.Now()
+		start     = now.Truncate(blockSize).Add(-blockSize)
+		end       = now.Truncate(blockSize)
+		ranges    = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		targets   = result.ShardTimeRanges{}
+		values    []testValue
+	)
+
+	for shard := uint32(0); shard < 3; shard++ {
+		targets[shard] = ranges
+		series := commitlog.Series{
+			Namespace: testNamespaceID,
+			Shard:     shard,
+			ID:        ident.StringID(fmt.Sprintf("series-%d", shard)),
+		}
+		values = append(values, testValue{series, start.Add(time.Minute), float64(shard), xtime.Second, nil})
+	}
+
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		return newTestCommitLogIterator(values, nil), nil
+	}
+
+	var (
+		shardResults = make(chan ShardReadResult)
+		received     = map[uint32]int{}
+		streamed     = result.ShardResults{}
+		done         = make(chan struct{})
+	)
+	go func() {
+		defer close(done)
+		for r := range shardResults {
+			received[r.Shard]++
+			streamed[r.Shard] = r.Result
+		}
+	}()
+
+	res, err := src.ReadStreaming(md, targets, testDefaultRunOpts, shardResults)
+	<-done
+
+	require.NoError(t, err)
+	require.Equal(t, map[uint32]int{0: 1, 1: 1, 2: 1}, received)
+	require.NoError(t, verifyShardResultsAreCorrect(
+		values, blockSize, streamed, opts))
+	require.Equal(t, 0, len(res.ShardResults()))
+	require.True(t, res.Unfulfilled().IsEmpty())
+
+	// The summary still accounts for the streamed shards.
+	summary, ok := src.LastBootstrapSummary(testNamespaceID)
+	require.True(t, ok)
+	require.Equal(t, int64(3), summary.NumSeries)
+}
+
+func TestReadResumesFromCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bootstrap-checkpoint")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var (
+		opts      = testOptions().SetCheckpointDir(dir)
+		md        = testNsMetadata(t)
+		src       = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		blockSize = md.Options().RetentionOptions().BlockSize()
+		now       = time.Now()
+		start     = now.Truncate(blockSize).Add(-blockSize)
+		end       = now.Truncate(blockSize)
+		ranges    = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		targets   = result.ShardTimeRanges{}
+		values    []testValue
+	)
+
+	for shard := uint32(0); shard < 4; shard++ {
+		targets[shard] = ranges
+		series := commitlog.Series{
+			Namespace: testNamespaceID,
+			Shard:     shard,
+			ID:        ident.StringID(fmt.Sprintf("series-%d", shard)),
+		}
+		values = append(values, testValue{series, start.Add(time.Minute), float64(shard), xtime.Second, nil})
+	}
+
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		return newTestCommitLogIterator(values, nil), nil
+	}
+
+	// Simulate a bootstrap that crashed after the receiver persisted the first
+	// two shards, the checkpoint is only removed once a read returns.
+	checkpoint := src.newShardCheckpoint(md.ID(), targets)
+	require.NoError(t, checkpoint.record(0))
+	require.NoError(t, checkpoint.record(1))
+
+	// Resuming only reads the remaining two shards.
+	var received []uint32
+	shardResults := make(chan ShardReadResult)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for r := range shardResults {
+			if len(received) == 0 {
+				// Another shard is still to be sent so the read is in progress,
+				// the shard is only recorded once the receiver persisted it.
+				completed, err := src.newShardCheckpoint(md.ID(), targets).read()
+				require.NoError(t, err)
+				require.NotContains(t, completed, r.Shard)
+				require.NoError(t, r.MarkPersisted())
+				completed, err = src.newShardCheckpoint(md.ID(), targets).read()
+				require.NoError(t, err)
+				require.Contains(t, completed, r.Shard)
+			}
+			received = append(received, r.Shard)
+		}
+	}()
+	res, err := src.ReadStreaming(md, targets, testDefaultRunOpts, shardResults)
+	<-done
+	require.NoError(t, err)
+
+	sort.Slice(received, func(i, j int) bool { return received[i] < received[j] })
+	require.Equal(t, []uint32{2, 3}, received)
+	require.NoError(t, verifyShardResultsAreCorrect(
+		values[2:], blockSize, res.ShardResults(), opts))
+
+	// The skipped shards aren't reloaded so they are returned as unfulfilled.
+	expectedUnfulfilled := result.ShardTimeRanges{0: ranges, 1: ranges}
+	require.True(t, expectedUnfulfilled.Equal(res.Unfulfilled()),
+		fmt.Sprintf("expected: %s, actual: %s", expectedUnfulfilled, res.Unfulfilled()))
+
+	// The checkpoint is removed once the bootstrap has completed.
+	_, err = os.Stat(checkpoint.path)
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestReadIgnoresCheckpointOfOtherReads(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bootstrap-checkpoint")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var (
+		opts      = testOptions().SetCheckpointDir(dir)
+		md        = testNsMetadata(t)
+		src       = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		blockSize = md.Options().RetentionOptions().BlockSize()
+		now       = time.Now()
+		start     = now.Truncate(blockSize).Add(-blockSize)
+		end       = now.Truncate(blockSize)
+		ranges    = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		targets   = result.ShardTimeRanges{0: ranges, 1: ranges}
+		foo       = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		bar       = commitlog.Series{Namespace: testNamespaceID, Shard: 1, ID: ident.StringID("bar")}
+		values    = []testValue{
+			{foo, start.Add(time.Minute), 1.0, xtime.Second, nil},
+			{bar, start.Add(time.Minute), 2.0, xtime.Second, nil},
+		}
+	)
+
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		return newTestCommitLogIterator(values, nil), nil
+	}
+
+	// ReadData never skips shards, even those of a matching checkpoint.
+	require.NoError(t, src.newShardCheckpoint(md.ID(), targets).record(0))
+	res, err := src.ReadData(md, targets, testDefaultRunOpts)
+	require.NoError(t, err)
+	require.True(t, res.Unfulfilled().IsEmpty())
+	require.NoError(t, verifyShardResultsAreCorrect(
+		values, blockSize, res.ShardResults(), opts))
+
+	// A stale checkpoint of an earlier bootstrap of other time ranges is ignored.
+	earlier := xtime.Ranges{}.AddRange(xtime.Range{Start: start.Add(-blockSize), End: start})
+	require.NoError(t, src.newShardCheckpoint(md.ID(),
+		result.ShardTimeRanges{0: earlier, 1: earlier}).record(0))
+
+	var received []uint32
+	shardResults := make(chan ShardReadResult)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for r := range shardResults {
+			received = append(received, r.Shard)
+		}
+	}()
+	res, err = src.ReadStreaming(md, targets, testDefaultRunOpts, shardResults)
+	<-done
+	require.NoError(t, err)
+
+	sort.Slice(received, func(i, j int) bool { return received[i] < received[j] })
+	require.Equal(t, []uint32{0, 1}, received)
+	require.True(t, res.Unfulfilled().IsEmpty())
+	require.NoError(t, verifyShardResultsAreCorrect(
+		values, blockSize, res.ShardResults(), opts))
+}
+
+func TestReadSparseHighNumberedShard(t *testing.T) {
+	var (
+		opts      = testOptions()
+		md        = testNsMetadata(t)
+		src       = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		blockSize = md.Options().RetentionOptions().BlockSize()
+		now       = time.Now()
+		start     = now.Truncate(blockSize).Add(-blockSize)
+		end       = now.Truncate(blockSize)
+		ranges    = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		shard     = uint32(4095)
+
+		foo    = commitlog.Series{Namespace: testNamespaceID, Shard: shard, ID: ident.StringID("foo")}
+		bar    = commitlog.Series{Namespace: testNamespaceID, Shard: 10, ID: ident.StringID("bar")}
+		values = []testValue{
+			{foo, start, 1.0, xtime.Second, nil},
+			{foo, start.Add(1 * time.Minute), 2.0, xtime.Second, nil},
+			// "bar" is in a shard that isn't being bootstrapped and should not be returned
+			{bar, start.Add(2 * time.Minute), 3.0, xtime.Second, nil},
+		}
+		targetRanges = result.ShardTimeRanges{shard: ranges}
+	)
+
+	// Only the requested shard should have any shard data allocated for it.
+	shardDataByShard := src.newShardDataByShard(targetRanges, nil)
+	require.Equal(t, 1, len(shardDataByShard))
+	require.NotNil(t, shardDataByShard[shard])
+
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		return newTestCommitLogIterator(values, nil), nil
+	}
+
+	res, err := src.ReadData(md, targetRanges, testDefaultRunOpts)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(res.ShardResults()))
+	require.Equal(t, 0, len(res.Unfulfilled()))
+	require.NoError(t, verifyShardResultsAreCorrect(
+		values[:2], blockSize, res.ShardResults(), opts))
+}
+
+func TestReadIgnoresShardWithEmptyRange(t *testing.T) {
+	var (
+		opts      = testOptions()
+		md        = testNsMetadata(t)
+		src       = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		blockSize = md.Options().RetentionOptions().BlockSize()
+		now       = time.Now()
+		start     = now.Truncate(blockSize).Add(-blockSize)
+		end       = now.Truncate(blockSize)
+		ranges    = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+
+		foo    = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		bar    = commitlog.Series{Namespace: testNamespaceID, Shard: 1, ID: ident.StringID("bar")}
+		values = []testValue{
+			{foo, start, 1.0, xtime.Second, nil},
+			// "bar" is in a shard with nothing to bootstrap and should not be returned
+			{bar, start.Add(time.Minute), 2.0, xtime.Second, nil},
+		}
+		targetRanges = result.ShardTimeRanges{0: ranges, 1: xtime.Ranges{}}
+	)
+
+	// No shard data is allocated for the shard with the empty range.
+	shardDataByShard := src.newShardDataByShard(targetRanges, nil)
+	require.Equal(t, 1, len(shardDataByShard))
+	_, ok := shardDataByShard[1]
+	require.False(t, ok)
+
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		return newTestCommitLogIterator(values, nil), nil
+	}
+
+	res, err := src.ReadData(md, targetRanges, testDefaultRunOpts)
+	require.NoError(t, err)
+	require.True(t, res.Unfulfilled().IsEmpty())
+	_, ok = res.ShardResults()[1]
+	require.False(t, ok)
+	require.NoError(t, verifyShardResultsAreCorrect(
+		values[:1], blockSize, res.ShardResults(), opts))
+}
+
+func TestReadUnorderedValues(t *testing.T) {
+	opts := testOptions()
+	md := testNsMetadata(t)
+	src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+
+	blockSize := md.Options().RetentionOptions().BlockSize()
+	now := time.Now()
+	start := now.Truncate(blockSize).Add(-blockSize)
+	end := now.Truncate(blockSize)
+
+	// Request a little after the start of data, because always reading full blocks
+	// it should return the entire block beginning from "start"
+	require.True(t, blockSize >= minCommitLogRetention)
+	ranges := xtime.Ranges{}
+	ranges = ranges.AddRange(xtime.Range{
+		Start: start,
+		End:   end,
+	})
+
+	foo := commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+
+	values := []testValue{
+		{foo, start.Add(10 * time.Minute), 1.0, xtime.Second, nil},
+		{foo, start.Add(1 * time.Minute), 2.0, xtime.Second, nil},
+		{foo, start.Add(2 * time.Minute), 3.0, xtime.Second, nil},
+		{foo, start.Add(3 * time.Minute), 4.0, xtime.Second, nil},
+		{foo, start, 5.0, xtime.Second, nil},
+	}
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		return newTestCommitLogIterator(values, nil), nil
+	}
+
+	targetRanges := result.ShardTimeRanges{0: ranges, 1: ranges}
+	res, err := src.ReadData(md, targetRanges, testDefaultRunOpts)
+	require.NoError(t, err)
+	require.NotNil(t, res)
+	require.Equal(t, 1, len(res.ShardResults()))
+	require.Equal(t, 0, len(res.Unfulfilled()))
+	require.NoError(t, verifyShardResultsAreCorrect(
+		values, blockSize, res.ShardResults(), opts))
+}
+
+// TestReadHandlesDifferentSeriesWithIdenticalUniqueIndex was added as a regression test to make
+// sure that the commit log bootstrapper does not make any assumptions about series having a unique
+// unique index because that only holds for the duration that an M3DB node is on, but commit log
+// files can span multiple M3DB processes which means that unique indexes could be re-used for multiple
+// different series.
+func TestReadHandlesDifferentSeriesWithIdenticalUniqueIndex(t *testing.T) {
+	opts := testOptions()
+	md := testNsMetadata(t)
+	src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+
+	blockSize := md.Options().RetentionOptions().BlockSize()
+	now := time.Now()
+	start := now.Truncate(blockSize).Add(-blockSize)
+	end := now.Truncate(blockSize)
+
+	require.True(t, blockSize >= minCommitLogRetention)
+	ranges := xtime.Ranges{}
+	ranges = ranges.AddRange(xtime.Range{
+		Start: start,
+		End:   end,
+	})
+
+	// All series need to be in the same shard to exercise the regression.
+	foo := commitlog.Series{
+		Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo"), UniqueIndex: 0}
+	bar := commitlog.Series{
+		Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("bar"), UniqueIndex: 0}
+
+	values := []testValue{
+		{foo, start, 1.0, xtime.Second, nil},
+		{bar, start, 2.0, xtime.Second, nil},
+	}
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		return newTestCommitLogIterator(values, nil), nil
+	}
+
+	targetRanges := result.ShardTimeRanges{0: ranges, 1: ranges}
+	res, err := src.ReadData(md, targetRanges, testDefaultRunOpts)
+	require.NoError(t, err)
+	require.NotNil(t, res)
+	require.Equal(t, 1, len(res.ShardResults()))
+	require.Equal(t, 0, len(res.Unfulfilled()))
+	require.NoError(t, verifyShardResultsAreCorrect(
+		values, blockSize, res.ShardResults(), opts))
+}
+
+func TestReadTrimsToRanges(t *testing.T) {
+	opts := testOptions()
+	md := testNsMetadata(t)
+	src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+
+	blockSize := md.Options().RetentionOptions().BlockSize()
+	now := time.Now()
+	start := now.Truncate(blockSize).Add(-blockSize)
+	end := now.Truncate(blockSize)
+
+	// Request a little after the start of data, because always reading full blocks
+	// it should return the entire block beginning from "start"
+	require.True(t, blockSize >= minCommitLogRetention)
+	ranges := xtime.Ranges{}
+	ranges = ranges.AddRange(xtime.Range{
+		Start: start,
+		End:   end,
+	})
+
+	foo := commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+
+	values := []testValue{
+		{foo, start.Add(-1 * time.Minute), 1.0, xtime.Nanosecond, nil},
+		{foo, start, 2.0, xtime.Nanosecond, nil},
+		{foo, start.Add(1 * time.Minute), 3.0, xtime.Nanosecond, nil},
+		{foo, end.Truncate(blockSize).Add(blockSize).Add(time.Nanosecond), 4.0, xtime.Nanosecond, nil},
+	}
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		return newTestCommitLogIterator(values, nil), nil
+	}
+
+	targetRanges := result.ShardTimeRanges{0: ranges, 1: ranges}
+	res, err := src.ReadData(md, targetRanges, testDefaultRunOpts)
+	require.NoError(t, err)
+	require.NotNil(t, res)
+	require.Equal(t, 1, len(res.ShardResults()))
+	require.Equal(t, 0, len(res.Unfulfilled()))
+	require.NoError(t, verifyShardResultsAreCorrect(
+		values[1:3], blockSize, res.ShardResults(), opts))
+}
+
+func TestItMergesSnapshotsAndCommitLogs(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		opts      = testOptions()
+		md        = testNsMetadata(t)
+		src       = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		blockSize = md.Options().RetentionOptions().BlockSize()
+		now       = time.Now()
+		start     = now.Truncate(blockSize).Add(-blockSize)
+		end       = now.Truncate(blockSize)
+		ranges    = xtime.Ranges{}
+
+		foo             = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		commitLogValues = []testValue{
+			{foo, start.Add(2 * time.Minute), 1.0, xtime.Nanosecond, nil},
+			{foo, start.Add(3 * time.Minute), 2.0, xtime.Nanosecond, nil},
+			{foo, start.Add(4 * time.Minute), 3.0, xtime.Nanosecond, nil},
+
+			// Should not be present
+			{foo, end.Truncate(blockSize).Add(blockSize).Add(time.Nanosecond), 4.0, xtime.Nanosecond, nil},
+		}
+	)
+
+	// Request a little after the start of data, because always reading full blocks it
+	// should return the entire block beginning from "start".
+	require.True(t, blockSize >= minCommitLogRetention)
+
+	ranges = ranges.AddRange(xtime.Range{
+		Start: start,
+		End:   end,
+	})
+
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		return newTestCommitLogIterator(commitLogValues, nil), nil
+	}
+	src.snapshotFilesFn = func(filePathPrefix string, namespace ident.ID, shard uint32) (fs.FileSetFilesSlice, error) {
+		return fs.FileSetFilesSlice{
+			fs.FileSetFile{
+				ID: fs.FileSetFileIdentifier{
+					Namespace:   namespace,
+					BlockStart:  start,
+					Shard:       shard,
+					VolumeIndex: 0,
+				},
+				AbsoluteFilepaths:  []string{"checkpoint"},
+				CachedSnapshotTime: start.Add(time.Minute),
+			},
+		}, nil
+	}
+
+	mockReader := fs.NewMockDataFileSetReader(ctrl)
+	mockReader.EXPECT().Close().Return(nil).AnyTimes()
+	mockReader.EXPECT().Open(fs.ReaderOpenOptionsMatcher{
+		ID: fs.FileSetFileIdentifier{
+			Namespace:   testNamespaceID,
+			BlockStart:  start,
+			Shard:       0,
+			VolumeIndex: 0,
+		},
+		FileSetType: persist.FileSetSnapshotType,
+	}).Return(nil).AnyTimes()
+	mockReader.EXPECT().Entries().Return(1).AnyTimes()
+
+	snapshotValues := []testValue{
+		{foo, start.Add(1 * time.Minute), 1.0, xtime.Nanosecond, nil},
+	}
+
+	encoder := m3tsz.NewEncoder(snapshotValues[0].t, nil, true, nil)
+	for _, value := range snapshotValues {
+		dp := ts.Datapoint{
+			Timestamp: value.t,
+			Value:     value.v,
+		}
+		encoder.Encode(dp, value.u, value.a)
+	}
+	reader := encoder.Stream()
+	seg, err := reader.Segment()
+	require.NoError(t, err)
+	bytes := make([]byte, seg.Len())
+	_, err = reader.Read(bytes)
+	require.NoError(t, err)
+	mockReader.EXPECT().Read().Return(
+		foo.ID,
+		ident.EmptyTagIterator,
+		checked.NewBytes(bytes, nil),
+		digest.Checksum(bytes),
+		nil,
+	)
+	mockReader.EXPECT().Read().Return(nil, nil, nil, uint32(0), io.EOF)
+
+	src.newReaderFn = func(bytesPool pool.CheckedBytesPool, opts fs.Options) (fs.DataFileSetReader, error) {
+		return mockReader, nil
+	}
+
+	targetRanges := result.ShardTimeRanges{0: ranges}
+	res, err := src.ReadData(md, targetRanges, testDefaultRunOpts)
+	require.NoError(t, err)
+	require.NotNil(t, res)
+	require.Equal(t, 1, len(res.ShardResults()))
+	require.Equal(t, 0, len(res.Unfulfilled()))
+	expectedValues := append([]testValue{}, snapshotValues...)
+	expectedValues = append(expectedValues, commitLogValues[0:3]...)
+
+	require.NoError(t, verifyShardResultsAreCorrect(
+		expectedValues, blockSize, res.ShardResults(), opts))
+}
+
+func TestReadSnapshotsFromFileSource(t *testing.T) {
+	var (
+		md        = testNsMetadata(t)
+		blockSize = md.Options().RetentionOptions().BlockSize()
+		now       = time.Now()
+		start     = now.Truncate(blockSize).Add(-blockSize)
+		end       = now.Truncate(blockSize)
+		ranges    = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		foo       = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		bar       = commitlog.Series{Namespace: testNamespaceID, Shard: 1, ID: ident.StringID("bar")}
+		source    = &testMemFileSource{
+			t:            t,
+			blockStart:   start,
+			snapshotTime: start.Add(2 * time.Minute),
+			values: map[uint32][]testValue{
+				0: {{foo, start.Add(time.Minute), 1.0, xtime.Second, nil}},
+				1: {{bar, start.Add(time.Minute), 2.0, xtime.Second, nil}},
+			},
+		}
+		commitLogValues = []testValue{
+			{foo, start.Add(3 * time.Minute), 3.0, xtime.Second, nil},
+			{bar, start.Add(3 * time.Minute), 4.0, xtime.Second, nil},
+		}
+		opts = testOptions().SetFileSource(source)
+		src  = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+	)
+
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		return newTestCommitLogIterator(commitLogValues, nil), nil
+	}
+
+	res, err := src.ReadData(md, result.ShardTimeRanges{0: ranges, 1: ranges}, testDefaultRunOpts)
+	require.NoError(t, err)
+	require.True(t, res.Unfulfilled().IsEmpty())
+
+	expectedValues := append([]testValue{}, source.values[0]...)
+	expectedValues = append(expectedValues, source.values[1]...)
+	expectedValues = append(expectedValues, commitLogValues...)
+	require.NoError(t, verifyShardResultsAreCorrect(
+		expectedValues, blockSize, res.ShardResults(), opts))
+}
+
+func TestReadSnapshotsWithSeparateReadBytesPool(t *testing.T) {
+	var (
+		md        = testNsMetadata(t)
+		blockSize = md.Options().RetentionOptions().BlockSize()
+		now       = time.Now()
+		start     = now.Truncate(blockSize).Add(-blockSize)
+		end       = now.Truncate(blockSize)
+		ranges    = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		foo       = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		bar       = commitlog.Series{Namespace: testNamespaceID, Shard: 1, ID: ident.StringID("bar")}
+	)
+
+	for _, separateReadPool := range []bool{false, true} {
+		var (
+			source = &testMemFileSource{
+				t:            t,
+				blockStart:   start,
+				snapshotTime: start.Add(2 * time.Minute),
+				values: map[uint32][]testValue{
+					0: {{foo, start.Add(time.Minute), 1.0, xtime.Second, nil}},
+					1: {{bar, start.Add(time.Minute), 2.0, xtime.Second, nil}},
+				},
+			}
+			blockPool = newTestCountingBytesPool()
+			readPool  = newTestCountingBytesPool()
+			opts      = testOptions().SetFileSource(source)
+		)
+		opts = opts.SetResultOptions(opts.ResultOptions().SetDatabaseBlockOptions(
+			opts.ResultOptions().DatabaseBlockOptions().SetBytesPool(blockPool)))
+		if separateReadPool {
+			opts = opts.SetSnapshotReadBytesPool(readPool)
+		}
+		src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+			return newTestCommitLogIterator(nil, nil), nil
+		}
+
+		res, err := src.ReadData(md, result.ShardTimeRanges{0: ranges, 1: ranges}, testDefaultRunOpts)
+		require.NoError(t, err)
+		require.True(t, res.Unfulfilled().IsEmpty())
+
+		expectedValues := append([]testValue{}, source.values[0]...)
+		expectedValues = append(expectedValues, source.values[1]...)
+		require.NoError(t, verifyShardResultsAreCorrect(
+			expectedValues, blockSize, res.ShardResults(), opts))
+
+		// Either way the retained blocks are backed by the block pool, only the
+		// reads move to the read pool when one is set.
+		var expectedReads int64
+		if separateReadPool {
+			expectedReads = 2
+		}
+		require.Equal(t, expectedReads, atomic.LoadInt64(&readPool.gets))
+		require.Equal(t, int64(2), atomic.LoadInt64(&blockPool.gets))
+	}
+}
+
+// testCountingBytesPool counts the bytes taken from the pool it wraps.
+type testCountingBytesPool struct {
+	pool.CheckedBytesPool
+
+	gets int64
+}
+
+func newTestCountingBytesPool() *testCountingBytesPool {
+	bytesPool := pool.NewCheckedBytesPool([]pool.Bucket{
+		{Capacity: 1024, Count: 10},
+	}, nil, func(s []pool.Bucket) pool.BytesPool {
+		return pool.NewBytesPool(s, nil)
+	})
+	bytesPool.Init()
+	return &testCountingBytesPool{CheckedBytesPool: bytesPool}
+}
+
+func (p *testCountingBytesPool) Get(capacity int) checked.Bytes {
+	atomic.AddInt64(&p.gets, 1)
+	return p.CheckedBytesPool.Get(capacity)
+}
+
+func TestReadRejectsSnapshotsForAnotherBlock(t *testing.T) {
+	var (
+		md        = testNsMetadata(t)
+		blockSize = md.Options().RetentionOptions().BlockSize()
+		now       = time.Now()
+		start     = now.Truncate(blockSize).Add(-blockSize)
+		end       = now.Truncate(blockSize)
+		ranges    = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		foo       = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+	)
+
+	for _, infoStart := range []time.Time{start, start.Add(-blockSize)} {
+		var (
+			source = &testMemFileSource{
+				t:            t,
+				blockStart:   start,
+				snapshotTime: start.Add(2 * time.Minute),
+				values: map[uint32][]testValue{
+					0: {{foo, start.Add(time.Minute), 1.0, xtime.Second, nil}},
+				},
+				infoRange: xtime.Range{Start: infoStart, End: infoStart.Add(blockSize)},
+			}
+			opts = testOptions().SetFileSource(source).SetValidateSnapshotInfo(true)
+			src  = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		)
+		src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+			return newTestCommitLogIterator(nil, nil), nil
+		}
+
+		res, err := src.ReadData(md, result.ShardTimeRanges{0: ranges}, testDefaultRunOpts)
+		require.NoError(t, err)
+
+		if infoStart.Equal(start) {
+			require.True(t, res.Unfulfilled().IsEmpty())
+			require.NoError(t, verifyShardResultsAreCorrect(
+				source.values[0], blockSize, res.ShardResults(), opts))
+			continue
+		}
+
+		// The misplaced snapshot is not read so the block is left for a
+		// subsequent bootstrapper.
+		require.Equal(t, 0, len(res.ShardResults()))
+		expectedUnfulfilled := result.ShardTimeRanges{0: ranges}
+		require.True(t, expectedUnfulfilled.Equal(res.Unfulfilled()),
+			fmt.Sprintf("expected: %s, actual: %s", expectedUnfulfilled, res.Unfulfilled()))
+	}
+}
+
+func TestReadKeepsEntriesOfTruncatedSnapshot(t *testing.T) {
+	var (
+		md        = testNsMetadata(t)
+		blockSize = md.Options().RetentionOptions().BlockSize()
+		now       = time.Now()
+		start     = now.Truncate(blockSize).Add(-blockSize)
+		end       = now.Truncate(blockSize)
+		ranges    = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		foo       = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		source    = &testMemFileSource{
+			t:            t,
+			blockStart:   start,
+			snapshotTime: start.Add(2 * time.Minute),
+			values: map[uint32][]testValue{
+				0: {{foo, start.Add(time.Minute), 1.0, xtime.Second, nil}},
+			},
+			truncated: true,
+		}
+		opts = testOptions().SetFileSource(source)
+		src  = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+	)
+
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		return newTestCommitLogIterator(nil, nil), nil
+	}
+
+	res, err := src.ReadData(md, result.ShardTimeRanges{0: ranges}, testDefaultRunOpts)
+	require.NoError(t, err)
+
+	// The entry read before the truncation is kept but the block is still left
+	// for a subsequent bootstrapper since the rest of the snapshot is missing.
+	require.NoError(t, verifyShardResultsAreCorrect(
+		source.values[0], blockSize, res.ShardResults(), opts))
+	expectedUnfulfilled := result.ShardTimeRanges{0: ranges}
+	require.True(t, expectedUnfulfilled.Equal(res.Unfulfilled()),
+		fmt.Sprintf("expected: %s, actual: %s", expectedUnfulfilled, res.Unfulfilled()))
+}
+
+// testMemFileSource serves a synthetic snapshot fileset per shard from memory.
+type testMemFileSource struct {
+	t            testing.TB
+	blockStart   time.Time
+	snapshotTime time.Time
+	values       map[uint32][]testValue
+	// infoRange is the block range recorded in the synthetic info files.
+	infoRange xtime.Range
+	// truncated makes readers fail with io.ErrUnexpectedEOF after the entry.
+	truncated bool
+}
+
+func (s *testMemFileSource) SnapshotFiles(
+	_ string,
+	namespace ident.ID,
+	shard uint32,
+) (fs.FileSetFilesSlice, error) {
+	if _, ok := s.values[shard]; !ok {
+		return nil, nil
+	}
+	return fs.FileSetFilesSlice{
+		fs.FileSetFile{
+			ID: fs.FileSetFileIdentifier{
+				Namespace:  namespace,
+				BlockStart: s.blockStart,
+				Shard:      shard,
+			},
+			AbsoluteFilepaths:  []string{"checkpoint"},
+			CachedSnapshotTime: s.snapshotTime,
+		},
+	}, nil
+}
+
+func (s *testMemFileSource) NewReader(
+	bytesPool pool.CheckedBytesPool,
+	_ fs.Options,
+) (fs.DataFileSetReader, error) {
+	return &testMemReader{source: s, bytesPool: bytesPool}, nil
+}
+
+// testMemReader reads the values of a single series from a testMemFileSource,
+// methods not used by the bootstrapper are left unimplemented.
+type testMemReader struct {
+	fs.DataFileSetReader
+
+	source    *testMemFileSource
+	bytesPool pool.CheckedBytesPool
+	opts      fs.DataReaderOpenOptions
+	values    []testValue
+	read      bool
+}
+
+func (r *testMemReader) Open(opts fs.DataReaderOpenOptions) error {
+	values, ok := r.source.values[opts.Identifier.Shard]
+	if !ok {
+		return fmt.Errorf("no snapshot for shard: %d", opts.Identifier.Shard)
+	}
+	r.opts, r.values, r.read = opts, values, false
+	return nil
+}
+
+func (r *testMemReader) Status() fs.DataFileSetReaderStatus {
+	return fs.DataFileSetReaderStatus{
+		Namespace:  r.opts.Identifier.Namespace,
+		BlockStart: r.source.infoRange.Start,
+		Shard:      r.opts.Identifier.Shard,
+		Open:       true,
+	}
+}
+
+func (r *testMemReader) Range() xtime.Range {
+	return r.source.infoRange
+}
+
+func (r *testMemReader) Entries() int {
+	return 1
+}
+
+func (r *testMemReader) Read() (ident.ID, ident.TagIterator, checked.Bytes, uint32, error) {
+	if r.read {
+		if r.source.truncated {
+			return nil, nil, nil, 0, io.ErrUnexpectedEOF
+		}
+		return nil, nil, nil, 0, io.EOF
+	}
+	r.read = true
+	bytes := testEncodeValues(r.source.t, r.values)
+	data := checked.NewBytes(bytes, nil)
+	if r.bytesPool != nil {
+		data = r.bytesPool.Get(len(bytes))
+		data.IncRef()
+		data.AppendAll(bytes)
+		data.DecRef()
+	}
+	return r.values[0].s.ID, ident.EmptyTagIterator, data, digest.Checksum(bytes), nil
+}
+
+func (r *testMemReader) Close() error {
+	return nil
+}
+
+func TestReadDeduplicatesIdenticalSnapshotBlocks(t *testing.T) {
+	var (
+		md        = testNsMetadata(t)
+		blockSize = md.Options().RetentionOptions().BlockSize()
+		now       = time.Now()
+		start     = now.Truncate(blockSize).Add(-blockSize)
+		end       = now.Truncate(blockSize)
+		ranges    = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		foo       = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		bar       = commitlog.Series{Namespace: testNamespaceID, Shard: 1, ID: ident.StringID("bar")}
+	)
+
+	for _, dedup := range []bool{false, true} {
+		var (
+			// Both series hold the same datapoints so their blocks encode identically.
+			source = &testMemFileSource{
+				t:            t,
+				blockStart:   start,
+				snapshotTime: start.Add(2 * time.Minute),
+				values: map[uint32][]testValue{
+					0: {{foo, start.Add(time.Minute), 1.0, xtime.Second, nil}},
+					1: {{bar, start.Add(time.Minute), 1.0, xtime.Second, nil}},
+				},
+			}
+			scope = tally.NewTestScope("", nil)
+			opts  = testOptions().
+				SetFileSource(source).
+				SetDeduplicateSnapshotBlocks(dedup)
+		)
+		opts = opts.SetResultOptions(opts.ResultOptions().SetInstrumentOptions(
+			opts.ResultOptions().InstrumentOptions().SetMetricsScope(scope)))
+		src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+			return newTestCommitLogIterator(nil, nil), nil
+		}
+
+		res, err := src.ReadData(md, result.ShardTimeRanges{0: ranges, 1: ranges}, testDefaultRunOpts)
+		require.NoError(t, err)
+		require.True(t, res.Unfulfilled().IsEmpty())
+
+		expectedValues := append([]testValue{}, source.values[0]...)
+		expectedValues = append(expectedValues, source.values[1]...)
+		require.NoError(t, verifyShardResultsAreCorrect(
+			expectedValues, blockSize, res.ShardResults(), opts))
+
+		// Only the block read second is shared rather than held as a copy.
+		var expectedDeduplicated int64
+		if dedup {
+			expectedDeduplicated = 1
+		}
+		counter, ok := scope.Snapshot().Counters()["bootstrap.commitlog.snapshot-blocks-deduplicated+"]
+		require.True(t, ok)
+		require.Equal(t, expectedDeduplicated, counter.Value())
+	}
+}
+
+func TestReadFinalizesDeduplicatedSnapshotBlockData(t *testing.T) {
+	var (
+		md        = testNsMetadata(t)
+		blockSize = md.Options().RetentionOptions().BlockSize()
+		now       = time.Now()
+		start     = now.Truncate(blockSize).Add(-blockSize)
+		end       = now.Truncate(blockSize)
+		ranges    = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		foo       = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		bar       = commitlog.Series{Namespace: testNamespaceID, Shard: 1, ID: ident.StringID("bar")}
+		baz       = commitlog.Series{Namespace: testNamespaceID, Shard: 2, ID: ident.StringID("baz")}
+		detector  = newTestBytesLeakDetector()
+		source    = &testMemFileSource{
+			t:            t,
+			blockStart:   start,
+			snapshotTime: start.Add(2 * time.Minute),
+			values: map[uint32][]testValue{
+				// The blocks of foo and bar are identical, the block of baz isn't.
+				0: {{foo, start.Add(time.Minute), 1.0, xtime.Second, nil}},
+				1: {{bar, start.Add(time.Minute), 1.0, xtime.Second, nil}},
+				2: {{baz, start.Add(time.Minute), 2.0, xtime.Second, nil}},
+			},
+		}
+		opts = testOptions().
+			SetFileSource(source).
+			SetDeduplicateSnapshotBlocks(true)
+		blOpts = opts.ResultOptions().DatabaseBlockOptions()
+	)
+	opts = opts.SetResultOptions(opts.ResultOptions().SetDatabaseBlockOptions(
+		blOpts.SetBytesPool(testLeakCheckingBytesPool{
+			CheckedBytesPool: blOpts.BytesPool(),
+			detector:         detector,
+		})))
+	src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		return newTestCommitLogIterator(nil, nil), nil
+	}
+
+	res, err := src.ReadData(md, result.ShardTimeRanges{0: ranges, 1: ranges, 2: ranges}, testDefaultRunOpts)
+	require.NoError(t, err)
+	require.True(t, res.Unfulfilled().IsEmpty())
+
+	// The data of the duplicate block is finalized straight away, the data
+	// shared by foo and bar and the data of baz are held by their blocks.
+	require.Equal(t, 2, detector.numOutstanding())
+
+	// The shared data is only finalized once both blocks sharing it are closed.
+	res.ShardResults()[0].Close()
+	require.Equal(t, 2, detector.numOutstanding())
+	res.ShardResults()[1].Close()
+	require.Equal(t, 1, detector.numOutstanding())
+	res.ShardResults()[2].Close()
+	require.Equal(t, 0, detector.numOutstanding())
+}
+
+// TestItMergesDuplicateTimestampsDeterministically makes sure that when the
+// snapshot and the commit log contain datapoints with identical timestamps
+// the last commit log write always wins.
+func TestItMergesDuplicateTimestampsDeterministically(t *testing.T) {
+	var (
+		md        = testNsMetadata(t)
+		blockSize = md.Options().RetentionOptions().BlockSize()
+		now       = time.Now()
+		start     = now.Truncate(blockSize).Add(-blockSize)
+		end       = now.Truncate(blockSize)
+		ranges    = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+
+		foo            = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		snapshotValues = []testValue{
+			{foo, start.Add(1 * time.Minute), 1.0, xtime.Nanosecond, nil},
+			{foo, start.Add(2 * time.Minute), 2.0, xtime.Nanosecond, nil},
+		}
+		commitLogValues = []testValue{
+			{foo, start.Add(2 * time.Minute), 20.0, xtime.Nanosecond, nil},
+			{foo, start.Add(3 * time.Minute), 3.0, xtime.Nanosecond, nil},
+			{foo, start.Add(2 * time.Minute), 200.0, xtime.Nanosecond, nil},
+		}
+		expectedValues = []testValue{
+			snapshotValues[0],
+			commitLogValues[2],
+			commitLogValues[1],
+		}
+	)
+
+	// Run a few times since a nondeterministic merge would only fail some of the time.
+	for i := 0; i < 10; i++ {
+		ctrl := gomock.NewController(t)
+
+		opts := testOptions()
+		src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+			return newTestCommitLogIterator(commitLogValues, nil), nil
+		}
+		src.snapshotFilesFn = func(filePathPrefix string, namespace ident.ID, shard uint32) (fs.FileSetFilesSlice, error) {
+			return fs.FileSetFilesSlice{
+				fs.FileSetFile{
+					ID: fs.FileSetFileIdentifier{
+						Namespace:   namespace,
+						BlockStart:  start,
+						Shard:       shard,
+						VolumeIndex: 0,
+					},
+					AbsoluteFilepaths:  []string{"checkpoint"},
+					CachedSnapshotTime: start.Add(time.Minute),
+				},
+			}, nil
+		}
+
+		snapshotBytes := testEncodeValues(t, snapshotValues)
+		mockReader := fs.NewMockDataFileSetReader(ctrl)
+		mockReader.EXPECT().Close().Return(nil).AnyTimes()
+		mockReader.EXPECT().Open(gomock.Any()).Return(nil)
+		mockReader.EXPECT().Entries().Return(1).AnyTimes()
+		mockReader.EXPECT().Read().Return(
+			foo.ID,
+			ident.EmptyTagIterator,
+			checked.NewBytes(snapshotBytes, nil),
+			digest.Checksum(snapshotBytes),
+			nil,
+		)
+		mockReader.EXPECT().Read().Return(nil, nil, nil, uint32(0), io.EOF)
+		src.newReaderFn = func(bytesPool pool.CheckedBytesPool, opts fs.Options) (fs.DataFileSetReader, error) {
+			return mockReader, nil
+		}
+
+		res, err := src.ReadData(md, result.ShardTimeRanges{0: ranges}, testDefaultRunOpts)
+		require.NoError(t, err)
+		require.Equal(t, 0, len(res.Unfulfilled()))
+		require.NoError(t, verifyShardResultsAreCorrect(
+			expectedValues, blockSize, res.ShardResults(), opts))
+
+		ctrl.Finish()
+	}
+}
+
+// TestReadResolvesSnapshotsOnce makes sure that the snapshot files that are
+// resolved during the planning phase are re-used by the merge phase instead
+// of being listed and opened a second time.
+func TestReadResolvesSnapshotsOnce(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		opts      = testOptions()
+		md        = testNsMetadata(t)
+		src       = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		blockSize = md.Options().RetentionOptions().BlockSize()
+		now       = time.Now()
+		start     = now.Truncate(blockSize).Add(-blockSize)
+		end       = now.Truncate(blockSize)
+		ranges    = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+
+		foo    = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		values = []testValue{
+			{foo, start.Add(2 * time.Minute), 1.0, xtime.Nanosecond, nil},
+		}
+
+		numSnapshotFilesFnCalls int
+		numNewReaderFnCalls     int
+	)
+
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		return newTestCommitLogIterator(values, nil), nil
+	}
+	src.snapshotFilesFn = func(filePathPrefix string, namespace ident.ID, shard uint32) (fs.FileSetFilesSlice, error) {
+		numSnapshotFilesFnCalls++
+		return fs.FileSetFilesSlice{
+			fs.FileSetFile{
+				ID: fs.FileSetFileIdentifier{
+					Namespace:   namespace,
+					BlockStart:  start,
+					Shard:       shard,
+					VolumeIndex: 0,
+				},
+				AbsoluteFilepaths:  []string{"checkpoint"},
+				CachedSnapshotTime: start.Add(time.Minute),
+			},
+		}, nil
+	}
+
+	mockReader := fs.NewMockDataFileSetReader(ctrl)
+	mockReader.EXPECT().Close().Return(nil).AnyTimes()
+	mockReader.EXPECT().Open(gomock.Any()).Return(nil).AnyTimes()
+	mockReader.EXPECT().Entries().Return(0).AnyTimes()
+	mockReader.EXPECT().Read().Return(nil, nil, nil, uint32(0), io.EOF).AnyTimes()
+	src.newReaderFn = func(bytesPool pool.CheckedBytesPool, opts fs.Options) (fs.DataFileSetReader, error) {
+		numNewReaderFnCalls++
+		return mockReader, nil
+	}
+
+	res, err := src.ReadData(md, result.ShardTimeRanges{0: ranges}, testDefaultRunOpts)
+	require.NoError(t, err)
+	require.NoError(t, verifyShardResultsAreCorrect(
+		values, blockSize, res.ShardResults(), opts))
+
+	// One listing per shard and one reader per shard/block combination.
+	require.Equal(t, 1, numSnapshotFilesFnCalls)
+	require.Equal(t, 1, numNewReaderFnCalls)
+}
+
+func TestReadReportsDroppedDatapoints(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	encodeErr := fmt.Errorf("an error")
+	enc := encoding.NewMockEncoder(ctrl)
+	enc.EXPECT().Reset(gomock.Any(), gomock.Any()).AnyTimes()
+	enc.EXPECT().Encode(gomock.Any(), gomock.Any(), gomock.Any()).Return(encodeErr).AnyTimes()
+	enc.EXPECT().Close().AnyTimes()
+	encoderPool := encoding.NewEncoderPool(nil)
+	encoderPool.Init(func() encoding.Encoder { return enc })
+
+	reporter := &testDroppedDatapointsReporter{}
+	opts := testOptions().SetDroppedDatapointsReporter(reporter)
+	ropts := opts.ResultOptions()
+	opts = opts.SetResultOptions(ropts.SetDatabaseBlockOptions(
+		ropts.DatabaseBlockOptions().SetEncoderPool(encoderPool)))
+
+	md := testNsMetadata(t)
+	src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+
+	blockSize := md.Options().RetentionOptions().BlockSize()
+	now := time.Now()
+	start := now.Truncate(blockSize).Add(-blockSize)
+	end := now.Truncate(blockSize)
+	ranges := xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+
+	foo := commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+	values := []testValue{
+		{foo, start.Add(2 * time.Minute), 1.0, xtime.Second, nil},
+		{foo, start.Add(1 * time.Minute), 2.0, xtime.Second, nil},
+	}
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		return newTestCommitLogIterator(values, nil), nil
+	}
+
+	_, err := src.ReadData(md, result.ShardTimeRanges{0: ranges}, testDefaultRunOpts)
+	require.NoError(t, err)
+
+	require.True(t, testNamespaceID.Equal(reporter.namespace))
+	require.Equal(t, len(values), len(reporter.dropped))
+	for i, dropped := range reporter.dropped {
+		require.Equal(t, uint32(0), dropped.Shard)
+		require.True(t, foo.ID.Equal(dropped.ID))
+		require.True(t, values[i].t.Equal(dropped.Timestamp))
+		require.Equal(t, encodeErr, dropped.Err)
+	}
+}
+
+func TestReadReportsOutOfOrderDatapoints(t *testing.T) {
+	var (
+		dropped  = &testDroppedDatapointsReporter{}
+		reporter = &testOutOfOrderDatapointsReporter{}
+		opts     = testOptions().
+				SetDroppedDatapointsReporter(dropped).
+				SetOutOfOrderDatapointsReporter(reporter)
+	)
+	md := testNsMetadata(t)
+	src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+
+	blockSize := md.Options().RetentionOptions().BlockSize()
+	now := time.Now()
+	start := now.Truncate(blockSize).Add(-blockSize)
+	end := now.Truncate(blockSize)
+	ranges := xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+
+	foo := commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+	values := []testValue{
+		{foo, start.Add(2 * time.Minute), 1.0, xtime.Second, nil},
+		{foo, start.Add(3 * time.Minute), 2.0, xtime.Second, nil},
+		// Both are before the last write so need encoders of their own.
+		{foo, start.Add(1 * time.Minute), 3.0, xtime.Second, nil},
+		{foo, start.Add(30 * time.Second), 4.0, xtime.Second, nil},
+		{foo, start.Add(4 * time.Minute), 5.0, xtime.Second, nil},
+	}
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		return newTestCommitLogIterator(values, nil), nil
+	}
+
+	res, err := src.ReadData(md, result.ShardTimeRanges{0: ranges}, testDefaultRunOpts)
+	require.NoError(t, err)
+	require.NoError(t, verifyShardResultsAreCorrect(values, blockSize, res.ShardResults(), opts))
+
+	// Out of order datapoints that were encoded aren't dropped.
+	require.Equal(t, 0, len(dropped.dropped))
+
+	require.True(t, testNamespaceID.Equal(reporter.namespace))
+	require.Equal(t, 2, reporter.numOutOfOrder)
+	require.Equal(t, 2, len(reporter.samples))
+	for i, sample := range reporter.samples {
+		require.Equal(t, uint32(0), sample.Shard)
+		require.True(t, foo.ID.Equal(sample.ID))
+		require.True(t, values[i+2].t.Equal(sample.Timestamp))
+	}
+}
+
+func TestReadCapsOutOfOrderDatapointSamples(t *testing.T) {
+	reporter := &testOutOfOrderDatapointsReporter{}
+	opts := testOptions().
+		SetEncodingConcurrency(1).
+		SetOutOfOrderDatapointsReporter(reporter)
+	md := testNsMetadata(t)
+	src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+
+	blockSize := md.Options().RetentionOptions().BlockSize()
+	now := time.Now()
+	start := now.Truncate(blockSize).Add(-blockSize)
+	end := now.Truncate(blockSize)
+	ranges := xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+
+	// Descending timestamps so every datapoint after the first is out of order.
+	var (
+		foo           = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		numOutOfOrder = 2 * maxOutOfOrderDatapointSamples
+		values        []testValue
+	)
+	for i := numOutOfOrder; i >= 0; i-- {
+		values = append(values, testValue{foo, start.Add(time.Duration(i+1) * time.Second), float64(i), xtime.Second, nil})
+	}
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		return newTestCommitLogIterator(values, nil), nil
+	}
+
+	_, err := src.ReadData(md, result.ShardTimeRanges{0: ranges}, testDefaultRunOpts)
+	require.NoError(t, err)
+
+	require.Equal(t, numOutOfOrder, reporter.numOutOfOrder)
+	require.Equal(t, maxOutOfOrderDatapointSamples, len(reporter.samples))
+}
+
+func TestReadReportsUnmergedStatsToDebugSink(t *testing.T) {
+	sink := &testUnmergedDebugSink{}
+	opts := testOptions().SetUnmergedDebugSink(sink)
+	md := testNsMetadata(t)
+	src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+
+	blockSize := md.Options().RetentionOptions().BlockSize()
+	now := time.Now()
+	start := now.Truncate(blockSize).Add(-blockSize)
+	prev := start.Add(-blockSize)
+	end := now.Truncate(blockSize)
+	ranges := xtime.Ranges{}.AddRange(xtime.Range{Start: prev, End: end})
+
+	foo := commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+	bar := commitlog.Series{Namespace: testNamespaceID, Shard: 1, ID: ident.StringID("bar")}
+	values := []testValue{
+		{foo, prev.Add(1 * time.Minute), 1.0, xtime.Second, nil},
+		{foo, start.Add(1 * time.Minute), 2.0, xtime.Second, nil},
+		{foo, start.Add(2 * time.Minute), 3.0, xtime.Second, nil},
+		// Out of order so requires a second encoder for the block.
+		{foo, start.Add(30 * time.Second), 4.0, xtime.Second, nil},
+		{bar, start.Add(1 * time.Minute), 1.0, xtime.Second, nil},
+	}
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		return newTestCommitLogIterator(values, nil), nil
+	}
+
+	res, err := src.ReadData(md, result.ShardTimeRanges{0: ranges, 1: ranges}, testDefaultRunOpts)
+	require.NoError(t, err)
+	require.NoError(t, verifyShardResultsAreCorrect(
+		values, blockSize, res.ShardResults(), opts))
+
+	prevBlock := xtime.ToUnixNano(prev).ToTime()
+	startBlock := xtime.ToUnixNano(start).ToTime()
+	require.Equal(t, 1, sink.calls)
+	require.True(t, testNamespaceID.Equal(sink.namespace))
+	require.Equal(t, []UnmergedShardStats{
+		{
+			Shard:     0,
+			NumSeries: 1,
+			Blocks: []UnmergedBlockStats{
+				{BlockStart: prevBlock, NumSeries: 1, NumEncoders: 1},
+				{BlockStart: startBlock, NumSeries: 1, NumEncoders: 2},
+			},
+		},
+		{
+			Shard:     1,
+			NumSeries: 1,
+			Blocks: []UnmergedBlockStats{
+				{BlockStart: startBlock, NumSeries: 1, NumEncoders: 1},
+			},
+		},
+	}, sink.stats)
+}
+
+func TestReadUnmergedReturnsRawEncoders(t *testing.T) {
+	opts := testOptions()
+	md := testNsMetadata(t)
+	src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+
+	blockSize := md.Options().RetentionOptions().BlockSize()
+	now := time.Now()
+	start := now.Truncate(blockSize).Add(-blockSize)
+	end := now.Truncate(blockSize)
+	ranges := xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+
+	foo := commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+	bar := commitlog.Series{Namespace: testNamespaceID, Shard: 1, ID: ident.StringID("bar")}
+	values := []testValue{
+		{foo, start.Add(1 * time.Minute), 1.0, xtime.Second, nil},
+		{foo, start.Add(2 * time.Minute), 2.0, xtime.Second, nil},
+		// Out of order so requires a second encoder for the block.
+		{foo, start.Add(30 * time.Second), 3.0, xtime.Second, nil},
+		{bar, start.Add(1 * time.Minute), 4.0, xtime.Second, nil},
+	}
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		return newTestCommitLogIterator(values, nil), nil
+	}
+
+	res, err := src.ReadUnmerged(md, result.ShardTimeRanges{0: ranges, 1: ranges}, testDefaultRunOpts)
+	require.NoError(t, err)
+	defer res.Close()
+
+	require.Equal(t, []uint32{0, 1}, res.Shards())
+	require.True(t, res.Unfulfilled().IsEmpty())
+
+	readerIteratorPool := opts.ResultOptions().DatabaseBlockOptions().ReaderIteratorPool()
+	for _, shard := range res.Shards() {
+		series := res.Series(shard)
+		require.Equal(t, 1, len(series))
+		require.Equal(t, 1, len(series[0].Encoders))
+
+		encoders := series[0].Encoders[xtime.ToUnixNano(start)]
+		var (
+			expected []testValue
+			actual   []testValue
+		)
+		for _, v := range values {
+			if v.s.Shard == shard {
+				expected = append(expected, v)
+			}
+		}
+		for _, enc := range encoders {
+			iter := readerIteratorPool.Get()
+			iter.Reset(enc.Stream())
+			for iter.Next() {
+				dp, unit, _ := iter.Current()
+				actual = append(actual, testValue{t: dp.Timestamp, v: dp.Value, u: unit})
+			}
+			require.NoError(t, iter.Err())
+			iter.Close()
+		}
+		sort.Stable(testValuesByTime(expected))
+		sort.Stable(testValuesByTime(actual))
+		require.Equal(t, len(expected), len(actual))
+		for i := range expected {
+			require.True(t, expected[i].s.ID.Equal(series[0].ID))
+			require.True(t, expected[i].t.Equal(actual[i].t))
+			require.Equal(t, expected[i].v, actual[i].v)
+		}
+	}
+	require.Equal(t, 2, len(res.Series(0)[0].Encoders[xtime.ToUnixNano(start)]))
+
+	// Reading only snapshots leaves no commit log data to return.
+	src.opts = opts.SetSnapshotsOnly(true)
+	_, err = src.ReadUnmerged(md, result.ShardTimeRanges{0: ranges}, testDefaultRunOpts)
+	require.Equal(t, errUnmergedSnapshotsOnly, err)
+}
+
+func TestReadNamespacesReadsConcurrentlyWithinBound(t *testing.T) {
+	const (
+		numNamespaces = 3
+		concurrency   = 2
+	)
+	var (
+		opts = testOptions().SetNamespaceReadConcurrency(concurrency)
+		src  = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+
+		requests []NamespaceReadRequest
+		values   []testValue
+	)
+	for i := 0; i < numNamespaces; i++ {
+		md, err := namespace.NewMetadata(
+			ident.StringID(fmt.Sprintf("testns%d", i)), namespace.NewOptions())
+		require.NoError(t, err)
+
+		blockSize := md.Options().RetentionOptions().BlockSize()
+		start := time.Now().Truncate(blockSize).Add(-blockSize)
+		ranges := xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: start.Add(blockSize)})
+		requests = append(requests, NamespaceReadRequest{
+			Namespace:        md,
+			ShardsTimeRanges: result.ShardTimeRanges{0: ranges},
+		})
+
+		series := commitlog.Series{Namespace: md.ID(), Shard: 0, ID: ident.StringID("foo")}
+		values = append(values,
+			testValue{series, start.Add(time.Minute), float64(i), xtime.Second, nil},
+			testValue{series, start.Add(2 * time.Minute), float64(i + 1), xtime.Second, nil})
+	}
+
+	var (
+		started     int32
+		inFlight    int32
+		maxInFlight int32
+	)
+	src.newIteratorFn = func(iterOpts commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		n := atomic.AddInt32(&inFlight, 1)
+		defer atomic.AddInt32(&inFlight, -1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+
+		// Hold the first namespaces until as many as allowed are being read at
+		// once so the bound is reached, the last one is read on its own.
+		if atomic.AddInt32(&started, 1) <= concurrency {
+			deadline := time.Now().Add(5 * time.Second)
+			for atomic.LoadInt32(&started) < concurrency && time.Now().Before(deadline) {
+				time.Sleep(time.Millisecond)
+			}
+		}
+
+		var nsValues []testValue
+		for _, v := range values {
+			if iterOpts.SeriesFilterPredicate(v.s.ID, v.s.Namespace) {
+				nsValues = append(nsValues, v)
+			}
+		}
+		return newTestCommitLogIterator(nsValues, nil), nil
+	}
+
+	results := src.ReadNamespaces(requests, testDefaultRunOpts)
+	require.Equal(t, numNamespaces, len(results))
+	require.Equal(t, int32(concurrency), atomic.LoadInt32(&maxInFlight))
+
+	for i, res := range results {
+		require.NoError(t, res.Err)
+		require.True(t, requests[i].Namespace.ID().Equal(res.Namespace))
+		require.True(t, res.Result.Unfulfilled().IsEmpty())
+
+		blockSize := requests[i].Namespace.Options().RetentionOptions().BlockSize()
+		require.NoError(t, verifyShardResultsAreCorrect(
+			values[2*i:2*i+2], blockSize, res.Result.ShardResults(), opts))
+	}
+}
+
+func TestReadMarksUnreadableSnapshotBlocksUnfulfilled(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		opts       = testOptions()
+		md         = testNsMetadata(t)
+		src        = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		blockSize  = md.Options().RetentionOptions().BlockSize()
+		now        = time.Now()
+		start      = now.Truncate(blockSize).Add(-2 * blockSize)
+		badBlock   = start.Add(blockSize)
+		end        = now.Truncate(blockSize)
+		ranges     = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		badRange   = xtime.Range{Start: badBlock, End: badBlock.Add(blockSize)}
+		foo        = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		values     = []testValue{{foo, start.Add(2 * time.Minute), 1.0, xtime.Nanosecond, nil}}
+		snapshotID = func(blockStart time.Time) fs.FileSetFileIdentifier {
+			return fs.FileSetFileIdentifier{
+				Namespace:   testNamespaceID,
+				BlockStart:  blockStart,
+				Shard:       0,
+				VolumeIndex: 0,
+			}
+		}
+	)
+
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		return newTestCommitLogIterator(values, nil), nil
+	}
+	src.snapshotFilesFn = func(filePathPrefix string, namespace ident.ID, shard uint32) (fs.FileSetFilesSlice, error) {
+		var files fs.FileSetFilesSlice
+		for _, blockStart := range []time.Time{start, badBlock} {
+			files = append(files, fs.FileSetFile{
+				ID:                 snapshotID(blockStart),
+				AbsoluteFilepaths:  []string{"checkpoint"},
+				CachedSnapshotTime: blockStart.Add(time.Minute),
+			})
+		}
+		return files, nil
+	}
+
+	mockReader := fs.NewMockDataFileSetReader(ctrl)
+	mockReader.EXPECT().Close().Return(nil).AnyTimes()
+	mockReader.EXPECT().Open(fs.ReaderOpenOptionsMatcher{
+		ID:          snapshotID(start),
+		FileSetType: persist.FileSetSnapshotType,
+	}).Return(nil)
+	mockReader.EXPECT().Open(fs.ReaderOpenOptionsMatcher{
+		ID:          snapshotID(badBlock),
+		FileSetType: persist.FileSetSnapshotType,
+	}).Return(fmt.Errorf("an error"))
+	mockReader.EXPECT().Read().Return(nil, nil, nil, uint32(0), io.EOF)
+	src.newReaderFn = func(bytesPool pool.CheckedBytesPool, opts fs.Options) (fs.DataFileSetReader, error) {
+		return mockReader, nil
+	}
+
+	res, err := src.ReadData(md, result.ShardTimeRanges{0: ranges}, testDefaultRunOpts)
+	require.NoError(t, err)
+	require.NoError(t, verifyShardResultsAreCorrect(
+		values, blockSize, res.ShardResults(), opts))
+
+	expectedUnfulfilled := result.ShardTimeRanges{0: xtime.Ranges{}.AddRange(badRange)}
+	require.True(t, expectedUnfulfilled.Equal(res.Unfulfilled()),
+		fmt.Sprintf("expected: %s, actual: %s", expectedUnfulfilled, res.Unfulfilled()))
+}
+
+func TestReadClosesMergedShardResultsWhenAShardFails(t *testing.T) {
+	var (
+		opts      = testOptions()
+		md        = testNsMetadata(t)
+		src       = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		blockSize = md.Options().RetentionOptions().BlockSize()
+		now       = time.Now()
+		start     = now.Truncate(blockSize).Add(-blockSize)
+		end       = now.Truncate(blockSize)
+		ranges    = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		// A range that isn't a multiple of the block size fails to be read.
+		badRanges = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end.Add(-time.Minute)})
+		foo       = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		values    = []testValue{{foo, start.Add(time.Minute), 1.0, xtime.Second, nil}}
+	)
+
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		return newTestCommitLogIterator(values, nil), nil
+	}
+	src.snapshotFilesFn = func(_ string, _ ident.ID, _ uint32) (fs.FileSetFilesSlice, error) {
+		return nil, nil
+	}
+
+	var streamed []ShardReadResult
+	shardResults := make(chan ShardReadResult)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for r := range shardResults {
+			streamed = append(streamed, r)
+		}
+	}()
+	_, err := src.ReadStreaming(md, result.ShardTimeRanges{0: ranges, 1: badRanges},
+		testDefaultRunOpts, shardResults)
+	<-done
+	require.Error(t, err)
+
+	// The merged shard is never returned so its blocks are closed.
+	var numSeries int
+	for _, r := range streamed {
+		if r.Result == nil {
+			continue
+		}
+		for _, entry := range r.Result.AllSeries().Iter() {
+			numSeries++
+			require.Equal(t, 0, entry.Value().Blocks.Len())
+		}
+	}
+	require.Equal(t, 1, numSeries)
+}
+
+func TestReadFallsBackToEarlierSnapshotWhenLatestIsCorrupt(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		opts       = testOptions()
+		md         = testNsMetadata(t)
+		src        = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		blockSize  = md.Options().RetentionOptions().BlockSize()
+		now        = time.Now()
+		start      = now.Truncate(blockSize).Add(-blockSize)
+		end        = now.Truncate(blockSize)
+		ranges     = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		foo        = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		snapshotID = func(volume int) fs.FileSetFileIdentifier {
+			return fs.FileSetFileIdentifier{
+				Namespace:   testNamespaceID,
+				BlockStart:  start,
+				Shard:       0,
+				VolumeIndex: volume,
+			}
+		}
+
+		snapshotValues  = []testValue{{foo, start.Add(time.Minute), 1.0, xtime.Nanosecond, nil}}
+		commitLogValues = []testValue{{foo, start.Add(time.Hour), 2.0, xtime.Nanosecond, nil}}
+	)
+
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		return newTestCommitLogIterator(commitLogValues, nil), nil
+	}
+	src.snapshotFilesFn = func(filePathPrefix string, namespace ident.ID, shard uint32) (fs.FileSetFilesSlice, error) {
+		return fs.FileSetFilesSlice{
+			fs.FileSetFile{
+				ID:                 snapshotID(0),
+				AbsoluteFilepaths:  []string{"checkpoint"},
+				CachedSnapshotTime: start.Add(2 * time.Minute),
+			},
+			fs.FileSetFile{
+				ID:                 snapshotID(1),
+				AbsoluteFilepaths:  []string{"checkpoint"},
+				CachedSnapshotTime: start.Add(3 * time.Minute),
+			},
+		}, nil
+	}
+
+	bytes := testEncodeValues(t, snapshotValues)
+	mockReader := fs.NewMockDataFileSetReader(ctrl)
+	mockReader.EXPECT().Close().Return(nil).AnyTimes()
+	mockReader.EXPECT().Open(fs.ReaderOpenOptionsMatcher{
+		ID:          snapshotID(1),
+		FileSetType: persist.FileSetSnapshotType,
+	}).Return(fmt.Errorf("corrupt snapshot"))
+	mockReader.EXPECT().Open(fs.ReaderOpenOptionsMatcher{
+		ID:          snapshotID(0),
+		FileSetType: persist.FileSetSnapshotType,
+	}).Return(nil)
+	mockReader.EXPECT().Entries().Return(1).AnyTimes()
+	mockReader.EXPECT().Read().Return(
+		foo.ID,
+		ident.EmptyTagIterator,
+		checked.NewBytes(bytes, nil),
+		digest.Checksum(bytes),
+		nil,
+	)
+	mockReader.EXPECT().Read().Return(nil, nil, nil, uint32(0), io.EOF)
+	src.newReaderFn = func(bytesPool pool.CheckedBytesPool, opts fs.Options) (fs.DataFileSetReader, error) {
+		return mockReader, nil
+	}
+
+	res, err := src.ReadData(md, result.ShardTimeRanges{0: ranges}, testDefaultRunOpts)
+	require.NoError(t, err)
+
+	// The writes between the two snapshots weren't replayed from the commit log
+	// so the block is left for a subsequent bootstrapper.
+	expectedUnfulfilled := result.ShardTimeRanges{0: ranges}
+	require.True(t, expectedUnfulfilled.Equal(res.Unfulfilled()),
+		fmt.Sprintf("expected: %s, actual: %s", expectedUnfulfilled, res.Unfulfilled()))
+
+	expectedValues := append([]testValue{}, snapshotValues...)
+	expectedValues = append(expectedValues, commitLogValues...)
+	require.NoError(t, verifyShardResultsAreCorrect(
+		expectedValues, blockSize, res.ShardResults(), opts))
+}
+
+func TestReadHandlesPlannedSnapshotRemovedBeforeRead(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		opts       = testOptions()
+		md         = testNsMetadata(t)
+		src        = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		blockSize  = md.Options().RetentionOptions().BlockSize()
+		now        = time.Now()
+		start      = now.Truncate(blockSize).Add(-blockSize)
+		end        = now.Truncate(blockSize)
+		ranges     = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		foo        = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		bar        = commitlog.Series{Namespace: testNamespaceID, Shard: 1, ID: ident.StringID("bar")}
+		snapshotID = func(shard uint32, volume int) fs.FileSetFileIdentifier {
+			return fs.FileSetFileIdentifier{
+				Namespace:   testNamespaceID,
+				BlockStart:  start,
+				Shard:       shard,
+				VolumeIndex: volume,
+			}
+		}
+		snapshotFile = func(shard uint32, volume int) fs.FileSetFile {
+			return fs.FileSetFile{
+				ID:                 snapshotID(shard, volume),
+				AbsoluteFilepaths:  []string{"checkpoint"},
+				CachedSnapshotTime: start.Add(time.Duration(volume+2) * time.Minute),
+			}
+		}
+
+		snapshotValues  = []testValue{{foo, start.Add(time.Minute), 1.0, xtime.Nanosecond, nil}}
+		commitLogValues = []testValue{
+			{foo, start.Add(time.Hour), 2.0, xtime.Nanosecond, nil},
+			{bar, start.Add(time.Hour), 3.0, xtime.Nanosecond, nil},
+		}
+		targetRanges = result.ShardTimeRanges{0: ranges, 1: ranges}
+	)
+
+	src.snapshotFilesFn = func(filePathPrefix string, namespace ident.ID, shard uint32) (fs.FileSetFilesSlice, error) {
+		return fs.FileSetFilesSlice{snapshotFile(shard, 0), snapshotFile(shard, 1)}, nil
+	}
+
+	plan, err := src.Plan(md, targetRanges)
+	require.NoError(t, err)
+	require.Equal(t, 1, plan.MostRecentSnapshotByBlockShard[xtime.ToUnixNano(start)][0].ID.VolumeIndex)
+
+	// The planned volume of shard 0 is rotated away leaving an earlier volume to
+	// fall back to while every snapshot of shard 1 is removed.
+	plan.SnapshotFilesByShard[0] = fs.FileSetFilesSlice{snapshotFile(0, 0)}
+	plan.SnapshotFilesByShard[1] = nil
+
+	// Only the remaining volume is opened.
+	bytes := testEncodeValues(t, snapshotValues)
+	mockReader := fs.NewMockDataFileSetReader(ctrl)
+	mockReader.EXPECT().Close().Return(nil).AnyTimes()
+	mockReader.EXPECT().Open(fs.ReaderOpenOptionsMatcher{
+		ID:          snapshotID(0, 0),
+		FileSetType: persist.FileSetSnapshotType,
+	}).Return(nil)
+	mockReader.EXPECT().Entries().Return(1).AnyTimes()
+	mockReader.EXPECT().Read().Return(
+		foo.ID,
+		ident.EmptyTagIterator,
+		checked.NewBytes(bytes, nil),
+		digest.Checksum(bytes),
+		nil,
+	)
+	mockReader.EXPECT().Read().Return(nil, nil, nil, uint32(0), io.EOF)
+	src.newReaderFn = func(bytesPool pool.CheckedBytesPool, opts fs.Options) (fs.DataFileSetReader, error) {
+		return mockReader, nil
+	}
+
+	iter := newTestCommitLogIterator(commitLogValues, nil)
+	res, err := src.ReadFromIterator(md, targetRanges, testDefaultRunOpts, iter, plan)
+	require.NoError(t, err)
+
+	// Shard 0 fell back to an earlier volume so is unfulfilled too.
+	expectedUnfulfilled := result.ShardTimeRanges{0: ranges, 1: ranges}
+	require.True(t, expectedUnfulfilled.Equal(res.Unfulfilled()),
+		fmt.Sprintf("expected: %s, actual: %s", expectedUnfulfilled, res.Unfulfilled()))
+
+	expectedValues := append([]testValue{}, snapshotValues...)
+	expectedValues = append(expectedValues, commitLogValues...)
+	require.NoError(t, verifyShardResultsAreCorrect(
+		expectedValues, blockSize, res.ShardResults(), opts))
+}
+
+func TestReadIncompleteSnapshotOnlyWhenAllowed(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		md         = testNsMetadata(t)
+		blockSize  = md.Options().RetentionOptions().BlockSize()
+		now        = time.Now()
+		start      = now.Truncate(blockSize).Add(-blockSize)
+		end        = now.Truncate(blockSize)
+		ranges     = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		foo        = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		snapshotID = fs.FileSetFileIdentifier{
+			Namespace:   testNamespaceID,
+			BlockStart:  start,
+			Shard:       0,
+			VolumeIndex: 0,
+		}
+
+		snapshotValues  = []testValue{{foo, start.Add(time.Minute), 1.0, xtime.Nanosecond, nil}}
+		commitLogValues = []testValue{{foo, start.Add(time.Hour), 2.0, xtime.Nanosecond, nil}}
+	)
+
+	newSource := func(opts Options) *commitLogSource {
+		src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+			return newTestCommitLogIterator(commitLogValues, nil), nil
+		}
+		src.snapshotFilesFn = func(filePathPrefix string, namespace ident.ID, shard uint32) (fs.FileSetFilesSlice, error) {
+			// The snapshot is missing its checkpoint file.
+			return fs.FileSetFilesSlice{
+				fs.FileSetFile{
+					ID:                snapshotID,
+					AbsoluteFilepaths: []string{"info", "data"},
+				},
+			}, nil
+		}
+		return src
+	}
+
+	// By default the incomplete snapshot is ignored.
+	opts := testOptions()
+	src := newSource(opts)
+	src.newReaderFn = func(bytesPool pool.CheckedBytesPool, opts fs.Options) (fs.DataFileSetReader, error) {
+		require.FailNow(t, "unexpected snapshot read")
+		return nil, nil
+	}
+
+	res, err := src.ReadData(md, result.ShardTimeRanges{0: ranges}, testDefaultRunOpts)
+	require.NoError(t, err)
+	require.True(t, res.Unfulfilled().IsEmpty())
+	require.NoError(t, verifyShardResultsAreCorrect(
+		commitLogValues, blockSize, res.ShardResults(), opts))
+
+	// When allowed the incomplete snapshot is read alongside the commit log.
+	opts = testOptions().SetAllowIncompleteSnapshots(true)
+	src = newSource(opts)
+
+	bytes := testEncodeValues(t, snapshotValues)
+	mockReader := fs.NewMockDataFileSetReader(ctrl)
+	mockReader.EXPECT().Close().Return(nil).AnyTimes()
+	mockReader.EXPECT().Open(fs.ReaderOpenOptionsMatcher{
+		ID:                     snapshotID,
+		FileSetType:            persist.FileSetSnapshotType,
+		AllowMissingCheckpoint: true,
+	}).Return(nil)
+	mockReader.EXPECT().Entries().Return(1).AnyTimes()
+	mockReader.EXPECT().Read().Return(
+		foo.ID,
+		ident.EmptyTagIterator,
+		checked.NewBytes(bytes, nil),
+		digest.Checksum(bytes),
+		nil,
+	)
+	mockReader.EXPECT().Read().Return(nil, nil, nil, uint32(0), io.EOF)
+	src.newReaderFn = func(bytesPool pool.CheckedBytesPool, opts fs.Options) (fs.DataFileSetReader, error) {
+		return mockReader, nil
+	}
+
+	res, err = src.ReadData(md, result.ShardTimeRanges{0: ranges}, testDefaultRunOpts)
+	require.NoError(t, err)
+	require.True(t, res.Unfulfilled().IsEmpty())
+
+	expectedValues := append([]testValue{}, snapshotValues...)
+	expectedValues = append(expectedValues, commitLogValues...)
+	require.NoError(t, verifyShardResultsAreCorrect(
+		expectedValues, blockSize, res.ShardResults(), opts))
+}
+
+func TestReadSnapshotsOnlyNeverReadsCommitLog(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		opts         = testOptions().SetSnapshotsOnly(true)
+		md           = testNsMetadata(t)
+		src          = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		blockSize    = md.Options().RetentionOptions().BlockSize()
+		bufferPast   = md.Options().RetentionOptions().BufferPast()
+		now          = time.Now()
+		start        = now.Truncate(blockSize).Add(-blockSize)
+		end          = now.Truncate(blockSize)
+		snapshotTime = start.Add(time.Hour)
+		ranges       = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		foo          = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		snapshotID   = fs.FileSetFileIdentifier{
+			Namespace:  testNamespaceID,
+			BlockStart: start,
+			Shard:      0,
+		}
+		snapshotValues = []testValue{{foo, start.Add(time.Minute), 1.0, xtime.Nanosecond, nil}}
+	)
+
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		require.FailNow(t, "commit log iterator should not be created")
+		return nil, nil
+	}
+	src.snapshotFilesFn = func(filePathPrefix string, namespace ident.ID, shard uint32) (fs.FileSetFilesSlice, error) {
+		if shard != 0 {
+			return nil, nil
+		}
+		return fs.FileSetFilesSlice{
+			fs.FileSetFile{
+				ID:                 snapshotID,
+				AbsoluteFilepaths:  []string{"checkpoint"},
+				CachedSnapshotTime: snapshotTime,
+			},
+		}, nil
+	}
+
+	bytes := testEncodeValues(t, snapshotValues)
+	mockReader := fs.NewMockDataFileSetReader(ctrl)
+	mockReader.EXPECT().Close().Return(nil).AnyTimes()
+	mockReader.EXPECT().Open(fs.ReaderOpenOptionsMatcher{
+		ID:          snapshotID,
+		FileSetType: persist.FileSetSnapshotType,
+	}).Return(nil)
+	mockReader.EXPECT().Entries().Return(1).AnyTimes()
+	mockReader.EXPECT().Read().Return(
+		foo.ID,
+		ident.EmptyTagIterator,
+		checked.NewBytes(bytes, nil),
+		digest.Checksum(bytes),
+		nil,
+	)
+	mockReader.EXPECT().Read().Return(nil, nil, nil, uint32(0), io.EOF)
+	src.newReaderFn = func(bytesPool pool.CheckedBytesPool, opts fs.Options) (fs.DataFileSetReader, error) {
+		return mockReader, nil
+	}
+
+	res, err := src.ReadData(md, result.ShardTimeRanges{0: ranges, 1: ranges}, testDefaultRunOpts)
+	require.NoError(t, err)
+	require.NoError(t, verifyShardResultsAreCorrect(
+		snapshotValues, blockSize, res.ShardResults(), opts))
+
+	// Shard 0 is missing the data written after its snapshot and shard 1 has
+	// no snapshot at all.
+	expectedUnfulfilled := result.ShardTimeRanges{
+		0: xtime.Ranges{}.AddRange(xtime.Range{Start: snapshotTime.Add(-bufferPast), End: end}),
+		1: ranges,
+	}
+	require.True(t, expectedUnfulfilled.Equal(res.Unfulfilled()),
+		fmt.Sprintf("expected: %s, actual: %s", expectedUnfulfilled, res.Unfulfilled()))
+}
+
+func TestReadEmitsMetrics(t *testing.T) {
+	var (
+		scope     = tally.NewTestScope("", nil)
+		opts      = testOptions()
+		md        = testNsMetadata(t)
+		blockSize = md.Options().RetentionOptions().BlockSize()
+		now       = time.Now()
+		start     = now.Truncate(blockSize).Add(-blockSize)
+		end       = now.Truncate(blockSize)
+		ranges    = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		foo       = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		bar       = commitlog.Series{Namespace: testNamespaceID, Shard: 1, ID: ident.StringID("bar")}
+		baz       = commitlog.Series{Namespace: testNamespaceID, Shard: 2, ID: ident.StringID("baz")}
+		values    = []testValue{
+			{foo, start, 1.0, xtime.Second, nil},
+			{foo, start.Add(time.Minute), 2.0, xtime.Second, nil},
+			{bar, start.Add(2 * time.Minute), 1.0, xtime.Second, nil},
+			// "baz" is in shard 2 and should be skipped
+			{baz, start.Add(3 * time.Minute), 1.0, xtime.Second, nil},
+		}
+	)
+
+	opts = opts.SetResultOptions(opts.ResultOptions().SetInstrumentOptions(
+		opts.ResultOptions().InstrumentOptions().SetMetricsScope(scope)))
+	src := newCommitLogSource(opts, fs.Inspection{
+		SortedCommitLogFiles: []string{"read", "skipped"},
+	}).(*commitLogSource)
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		return newTestCommitLogIterator(values, nil), nil
+	}
+
+	res, err := src.ReadData(md, result.ShardTimeRanges{0: ranges, 1: ranges}, testDefaultRunOpts)
+	require.NoError(t, err)
+	require.NoError(t, verifyShardResultsAreCorrect(
+		values[:3], blockSize, res.ShardResults(), opts))
+
+	// The test iterator doesn't apply the file predicate so exercise it directly.
+	pred := src.newReadCommitLogPred([]xtime.Range{{Start: start, End: end}}, nil)
+	require.True(t, pred(commitlog.File{FilePath: "read", Start: start, Duration: time.Minute}))
+	require.False(t, pred(commitlog.File{FilePath: "skipped", Start: end, Duration: time.Minute}))
+
+	snapshot := scope.Snapshot()
+	counters := snapshot.Counters()
+	require.Equal(t, int64(3), counters["bootstrap.commitlog.datapoints-read+"].Value())
+	require.Equal(t, int64(1), counters["bootstrap.commitlog.datapoints-skipped+"].Value())
+	require.Equal(t, int64(2), counters["bootstrap.commitlog.series-encoded+"].Value())
+	require.Equal(t, int64(0), counters["bootstrap.commitlog.encode-errors+"].Value())
+	require.Equal(t, int64(0), counters["bootstrap.commitlog.merge-errors+"].Value())
+	require.Equal(t, int64(1), counters["bootstrap.commitlog.commitlog-files-read+"].Value())
+	require.Equal(t, int64(1), counters["bootstrap.commitlog.commitlog-files-skipped+"].Value())
+
+	timers := snapshot.Timers()
+	require.Equal(t, 1, len(timers["bootstrap.commitlog.read-duration+"].Values()))
+	require.Equal(t, 1, len(timers["bootstrap.commitlog.merge-duration+"].Values()))
+}
+
+func TestReadSkipsDatapointsOlderThanRetention(t *testing.T) {
+	var (
+		scope     = tally.NewTestScope("", nil)
+		opts      = testOptions()
+		blockSize = 2 * time.Hour
+		ropts     = retention.NewOptions().SetBlockSize(blockSize).SetRetentionPeriod(3 * blockSize)
+		nsOpts    = namespace.NewOptions().SetRetentionOptions(ropts)
+		now       = time.Now()
+		start     = now.Truncate(blockSize).Add(-5 * blockSize)
+		recent    = now.Truncate(blockSize).Add(-blockSize)
+		end       = now.Truncate(blockSize)
+		ranges    = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		foo       = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		values    = []testValue{
+			// Aged out of retention and should be skipped
+			{foo, start.Add(time.Minute), 1.0, xtime.Second, nil},
+			{foo, recent.Add(time.Minute), 2.0, xtime.Second, nil},
+		}
+	)
+
+	md, err := namespace.NewMetadata(testNamespaceID, nsOpts)
+	require.NoError(t, err)
+
+	opts = opts.SetResultOptions(opts.ResultOptions().SetInstrumentOptions(
+		opts.ResultOptions().InstrumentOptions().SetMetricsScope(scope)))
+	src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		return newTestCommitLogIterator(values, nil), nil
+	}
+
+	res, err := src.ReadData(md, result.ShardTimeRanges{0: ranges}, testDefaultRunOpts)
+	require.NoError(t, err)
+	require.NoError(t, verifyShardResultsAreCorrect(
+		values[1:], blockSize, res.ShardResults(), opts))
+
+	counters := scope.Snapshot().Counters()
+	require.Equal(t, int64(1), counters["bootstrap.commitlog.datapoints-skipped+"].Value())
+	require.Equal(t, int64(1), counters["bootstrap.commitlog.datapoints-read+"].Value())
+}
+
+func TestReadAlignsBlocksInUTCAcrossDaylightSavingChange(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone database not available: %v", err)
+	}
+
+	var (
+		blockSize = 2 * time.Hour
+		ropts     = retention.NewOptions().SetBlockSize(blockSize).SetRetentionPeriod(12 * blockSize)
+		nsOpts    = namespace.NewOptions().SetRetentionOptions(ropts)
+		// Clocks in New York went forward from 2am to 3am, that is 07:00 UTC.
+		now    = time.Date(2018, time.March, 11, 12, 0, 0, 0, loc)
+		start  = time.Date(2018, time.March, 11, 0, 0, 0, 0, loc).Truncate(blockSize)
+		end    = now.Truncate(blockSize)
+		ranges = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		foo    = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		values = []testValue{
+			{foo, time.Date(2018, time.March, 11, 1, 30, 0, 0, loc), 1.0, xtime.Second, nil},
+			{foo, time.Date(2018, time.March, 11, 3, 30, 0, 0, loc), 2.0, xtime.Second, nil},
+			{foo, time.Date(2018, time.March, 11, 4, 30, 0, 0, loc), 3.0, xtime.Second, nil},
+		}
+		opts          = testOptions()
+		commitLogOpts = opts.CommitLogOptions()
+	)
+
+	md, err := namespace.NewMetadata(testNamespaceID, nsOpts)
+	require.NoError(t, err)
+
+	opts = opts.SetCommitLogOptions(commitLogOpts.SetClockOptions(
+		commitLogOpts.ClockOptions().SetNowFn(func() time.Time { return now })))
+	src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		return newTestCommitLogIterator(values, nil), nil
+	}
+
+	res, err := src.ReadData(md, result.ShardTimeRanges{0: ranges}, testDefaultRunOpts)
+	require.NoError(t, err)
+	require.NoError(t, verifyShardResultsAreCorrect(
+		values, blockSize, res.ShardResults(), opts))
+
+	// 06:30 and 07:30 UTC share a block despite being two hours apart locally.
+	var blockStarts []time.Time
+	for _, entry := range res.ShardResults()[0].AllSeries().Iter() {
+		for blockStart := range entry.Value().Blocks.AllBlocks() {
+			blockStarts = append(blockStarts, blockStart.ToTime().UTC())
+		}
+	}
+	sort.Slice(blockStarts, func(i, j int) bool {
+		return blockStarts[i].Before(blockStarts[j])
+	})
+	require.Equal(t, []time.Time{
+		time.Date(2018, time.March, 11, 6, 0, 0, 0, time.UTC),
+		time.Date(2018, time.March, 11, 8, 0, 0, 0, time.UTC),
+	}, blockStarts)
+}
+
+func TestReadUsesClockForRetention(t *testing.T) {
+	var (
+		blockSize = 2 * time.Hour
+		ropts     = retention.NewOptions().SetBlockSize(blockSize).SetRetentionPeriod(3 * blockSize)
+		nsOpts    = namespace.NewOptions().SetRetentionOptions(ropts)
+		// Long enough ago that every datapoint is out of retention by the wall clock.
+		now    = time.Date(2018, time.January, 1, 12, 0, 0, 0, time.UTC)
+		start  = now.Truncate(blockSize).Add(-5 * blockSize)
+		recent = now.Truncate(blockSize).Add(-blockSize)
+		end    = now.Truncate(blockSize)
+		ranges = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		foo    = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		values = []testValue{
+			{foo, start.Add(time.Minute), 1.0, xtime.Second, nil},
+			{foo, recent.Add(time.Minute), 2.0, xtime.Second, nil},
+		}
+		opts          = testOptions()
+		commitLogOpts = opts.CommitLogOptions()
+	)
+
+	md, err := namespace.NewMetadata(testNamespaceID, nsOpts)
+	require.NoError(t, err)
+
+	opts = opts.SetCommitLogOptions(commitLogOpts.SetClockOptions(
+		commitLogOpts.ClockOptions().SetNowFn(func() time.Time { return now })))
+	src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		return newTestCommitLogIterator(values, nil), nil
+	}
+
+	// Only the block within retention of the fixed now is read.
+	res, err := src.ReadData(md, result.ShardTimeRanges{0: ranges}, testDefaultRunOpts)
+	require.NoError(t, err)
+	require.NoError(t, verifyShardResultsAreCorrect(
+		values[1:], blockSize, res.ShardResults(), opts))
+}
+
+func TestReadMeasuresTimeBlockedOnEncodingWorkers(t *testing.T) {
+	var (
+		scope     = tally.NewTestScope("", nil)
+		opts      = testOptions().SetEncodingConcurrency(1)
+		md        = testNsMetadata(t)
+		blockSize = md.Options().RetentionOptions().BlockSize()
+		now       = time.Now()
+		start     = now.Truncate(blockSize).Add(-blockSize)
+		end       = now.Truncate(blockSize)
+		ranges    = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		foo       = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		values    []testValue
+	)
+
+	// Enough datapoints to fill the worker's channel while it is stalled.
+	for i := 0; i < 2*encoderChanBufSize; i++ {
+		ts := start.Add(time.Duration(i) * time.Second)
+		values = append(values, testValue{foo, ts, float64(i), xtime.Second, nil})
+	}
+
+	// The worker validates the series before encoding its first datapoint so
+	// a slow validator stalls it.
+	opts = opts.
+		SetEncoderBlockedWarnThreshold(10 * time.Millisecond).
+		SetSeriesValidator(func(_ ident.ID, _ ident.Tags) error {
+			time.Sleep(100 * time.Millisecond)
+			return nil
+		}).
+		SetResultOptions(opts.ResultOptions().SetInstrumentOptions(
+			opts.ResultOptions().InstrumentOptions().SetMetricsScope(scope)))
+	src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		return newTestCommitLogIterator(values, nil), nil
+	}
+
+	res, err := src.ReadData(md, result.ShardTimeRanges{0: ranges}, testDefaultRunOpts)
+	require.NoError(t, err)
+	require.NoError(t, verifyShardResultsAreCorrect(
+		values, blockSize, res.ShardResults(), opts))
+
+	snapshot := scope.Snapshot()
+	require.True(t, len(snapshot.Timers()["bootstrap.commitlog.encoder-blocked-duration+"].Values()) > 0)
+	require.True(t, snapshot.Counters()["bootstrap.commitlog.encoder-blocked-slow+"].Value() > 0)
+}
+
+func TestReadHandlesOversizedAnnotations(t *testing.T) {
+	var (
+		md         = testNsMetadata(t)
+		blockSize  = md.Options().RetentionOptions().BlockSize()
+		now        = time.Now()
+		start      = now.Truncate(blockSize).Add(-blockSize)
+		end        = now.Truncate(blockSize)
+		ranges     = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		foo        = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		maxBytes   = 10
+		annotation = ts.Annotation(bytes.Repeat([]byte("a"), 100))
+		oversized  = testValue{foo, start.Add(time.Minute), 1.0, xtime.Second, annotation}
+		other      = testValue{foo, start.Add(2 * time.Minute), 2.0, xtime.Second, ts.Annotation("b")}
+	)
+
+	tests := []struct {
+		name     string
+		truncate bool
+		expected []testValue
+	}{
+		{
+			name:     "drop",
+			expected: []testValue{other},
+		},
+		{
+			name:     "truncate",
+			truncate: true,
+			expected: []testValue{
+				{oversized.s, oversized.t, oversized.v, oversized.u, annotation[:maxBytes]},
+				other,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			scope := tally.NewTestScope("", nil)
+			opts := testOptions().
+				SetMaxAnnotationBytes(maxBytes).
+				SetTruncateOversizedAnnotations(test.truncate)
+			opts = opts.SetResultOptions(opts.ResultOptions().SetInstrumentOptions(
+				opts.ResultOptions().InstrumentOptions().SetMetricsScope(scope)))
+
+			src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+			src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+				return newTestCommitLogIterator([]testValue{oversized, other}, nil), nil
+			}
+
+			res, err := src.ReadData(md, result.ShardTimeRanges{0: ranges}, testDefaultRunOpts)
+			require.NoError(t, err)
+			require.NoError(t, verifyShardResultsAreCorrect(
+				test.expected, blockSize, res.ShardResults(), opts))
+
+			counters := scope.Snapshot().Counters()
+			require.Equal(t, int64(1), counters["bootstrap.commitlog.oversized-annotations+"].Value())
+		})
+	}
+}
+
+func TestReadKeepsOversizedSnapshotAnnotations(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		scope      = tally.NewTestScope("", nil)
+		opts       = testOptions().SetMaxAnnotationBytes(10)
+		md         = testNsMetadata(t)
+		blockSize  = md.Options().RetentionOptions().BlockSize()
+		now        = time.Now()
+		start      = now.Truncate(blockSize).Add(-blockSize)
+		end        = now.Truncate(blockSize)
+		ranges     = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		foo        = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		annotation = ts.Annotation(bytes.Repeat([]byte("a"), 100))
+		// Only the commit log annotations are checked so the snapshot datapoint
+		// is bootstrapped with its annotation intact.
+		snapshotValues  = []testValue{{foo, start.Add(time.Minute), 1.0, xtime.Second, annotation}}
+		commitLogValues = []testValue{{foo, start.Add(2 * time.Minute), 2.0, xtime.Second, ts.Annotation("b")}}
+	)
+
+	opts = opts.SetResultOptions(opts.ResultOptions().SetInstrumentOptions(
+		opts.ResultOptions().InstrumentOptions().SetMetricsScope(scope)))
+	src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		return newTestCommitLogIterator(commitLogValues, nil), nil
+	}
+	src.snapshotFilesFn = func(filePathPrefix string, namespace ident.ID, shard uint32) (fs.FileSetFilesSlice, error) {
+		return fs.FileSetFilesSlice{
+			fs.FileSetFile{
+				ID: fs.FileSetFileIdentifier{
+					Namespace:   namespace,
+					BlockStart:  start,
+					Shard:       shard,
+					VolumeIndex: 0,
+				},
+				AbsoluteFilepaths:  []string{"checkpoint"},
+				CachedSnapshotTime: start.Add(90 * time.Second),
+			},
+		}, nil
+	}
+
+	snapshotBytes := testEncodeValues(t, snapshotValues)
+	mockReader := fs.NewMockDataFileSetReader(ctrl)
+	mockReader.EXPECT().Close().Return(nil).AnyTimes()
+	mockReader.EXPECT().Open(gomock.Any()).Return(nil)
+	mockReader.EXPECT().Entries().Return(1).AnyTimes()
+	mockReader.EXPECT().Read().Return(
+		foo.ID, ident.EmptyTagIterator, checked.NewBytes(snapshotBytes, nil), digest.Checksum(snapshotBytes), nil)
+	mockReader.EXPECT().Read().Return(nil, nil, nil, uint32(0), io.EOF)
+	src.newReaderFn = func(bytesPool pool.CheckedBytesPool, opts fs.Options) (fs.DataFileSetReader, error) {
+		return mockReader, nil
+	}
+
+	res, err := src.ReadData(md, result.ShardTimeRanges{0: ranges}, testDefaultRunOpts)
+	require.NoError(t, err)
+	require.True(t, res.Unfulfilled().IsEmpty())
+
+	expectedValues := append([]testValue{}, snapshotValues...)
+	expectedValues = append(expectedValues, commitLogValues...)
+	require.NoError(t, verifyShardResultsAreCorrect(
+		expectedValues, blockSize, res.ShardResults(), opts))
+
+	counters := scope.Snapshot().Counters()
+	if counter, ok := counters["bootstrap.commitlog.oversized-annotations+"]; ok {
+		require.Equal(t, int64(0), counter.Value())
+	}
+}
+
+func TestReadCountsOrphanDatapoints(t *testing.T) {
+	var (
+		md        = testNsMetadata(t)
+		blockSize = md.Options().RetentionOptions().BlockSize()
+		now       = time.Now()
+		start     = now.Truncate(blockSize).Add(-blockSize)
+		end       = now.Truncate(blockSize)
+		ranges    = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		foo       = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		orphan    = commitlog.Series{Namespace: testNamespaceID, Shard: 5, ID: ident.StringID("orphan")}
+		values    = []testValue{
+			{foo, start.Add(time.Minute), 1.0, xtime.Second, nil},
+			{orphan, start.Add(time.Minute), 2.0, xtime.Second, nil},
+			{orphan, start.Add(2 * time.Minute), 3.0, xtime.Second, nil},
+		}
+	)
+
+	for _, logOrphans := range []bool{false, true} {
+		var (
+			buf   bytes.Buffer
+			scope = tally.NewTestScope("", nil)
+			opts  = testOptions().SetLogOrphanDatapoints(logOrphans)
+		)
+		opts = opts.SetResultOptions(opts.ResultOptions().SetInstrumentOptions(
+			opts.ResultOptions().InstrumentOptions().SetMetricsScope(scope)))
+		src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		src.log = xlog.NewLogger(&buf)
+		src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+			return newTestCommitLogIterator(values, nil), nil
+		}
+
+		res, err := src.ReadData(md, result.ShardTimeRanges{0: ranges}, testDefaultRunOpts)
+		require.NoError(t, err)
+		require.NoError(t, verifyShardResultsAreCorrect(
+			values[:1], blockSize, res.ShardResults(), opts))
+
+		// The orphan datapoints are always counted but only logged when enabled.
+		counters := scope.Snapshot().Counters()
+		require.Equal(t, int64(2), counters["bootstrap.commitlog.orphan-datapoints+"].Value())
+		require.Equal(t, logOrphans, strings.Contains(buf.String(),
+			"read datapoint from commit log for shard that is not being bootstrapped"))
+	}
+}
+
+func TestReadDoesNotCountSkippedShardsAsOrphans(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bootstrap-checkpoint")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var (
+		buf            bytes.Buffer
+		scope          = tally.NewTestScope("", nil)
+		md             = testNsMetadata(t)
+		blockSize      = md.Options().RetentionOptions().BlockSize()
+		now            = time.Now()
+		start          = now.Truncate(blockSize).Add(-2 * blockSize)
+		selected       = start.Add(blockSize)
+		end            = now.Truncate(blockSize)
+		selectedRanges = xtime.Ranges{}.AddRange(xtime.Range{Start: selected, End: end})
+		excludedRanges = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: selected})
+		foo            = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		checkpointed   = commitlog.Series{Namespace: testNamespaceID, Shard: 1, ID: ident.StringID("checkpointed")}
+		filtered       = commitlog.Series{Namespace: testNamespaceID, Shard: 2, ID: ident.StringID("filtered")}
+		values         = []testValue{
+			{foo, selected.Add(time.Minute), 1.0, xtime.Second, nil},
+			{checkpointed, selected.Add(time.Minute), 2.0, xtime.Second, nil},
+			{filtered, start.Add(time.Minute), 3.0, xtime.Second, nil},
+		}
+	)
+
+	opts := testOptions().
+		SetCheckpointDir(dir).
+		SetLogOrphanDatapoints(true).
+		SetBlockFilter(func(blockStart xtime.UnixNano) bool {
+			return blockStart == xtime.ToUnixNano(selected)
+		})
+	opts = opts.SetResultOptions(opts.ResultOptions().SetInstrumentOptions(
+		opts.ResultOptions().InstrumentOptions().SetMetricsScope(scope)))
+	src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+	src.log = xlog.NewLogger(&buf)
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		return newTestCommitLogIterator(values, nil), nil
+	}
+
+	// Shard 1 was persisted by an earlier bootstrap that was interrupted.
+	targetRanges := result.ShardTimeRanges{0: selectedRanges, 1: selectedRanges, 2: excludedRanges}
+	require.NoError(t, src.newShardCheckpoint(md.ID(), targetRanges).record(1))
+
+	// Shard 1 is skipped by the checkpoint and every block of shard 2 by the
+	// block filter so neither of their datapoints are orphans.
+	shardResults := make(chan ShardReadResult)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range shardResults {
+		}
+	}()
+	res, err := src.ReadStreaming(md, targetRanges, testDefaultRunOpts, shardResults)
+	<-done
+	require.NoError(t, err)
+	require.NoError(t, verifyShardResultsAreCorrect(
+		values[:1], blockSize, res.ShardResults(), opts))
+
+	counters := scope.Snapshot().Counters()
+	if counter, ok := counters["bootstrap.commitlog.orphan-datapoints+"]; ok {
+		require.Equal(t, int64(0), counter.Value())
+	}
+	require.False(t, strings.Contains(buf.String(),
+		"read datapoint from commit log for shard that is not being bootstrapped"))
+}
+
+func TestReadCountsShardsWithoutSnapshots(t *testing.T) {
+	var (
+		scope     = tally.NewTestScope("", nil)
+		opts      = testOptions()
+		md        = testNsMetadata(t)
+		blockSize = md.Options().RetentionOptions().BlockSize()
+		now       = time.Now()
+		start     = now.Truncate(blockSize).Add(-blockSize)
+		end       = now.Truncate(blockSize)
+		ranges    = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		foo       = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		values    = []testValue{{foo, start.Add(time.Minute), 1.0, xtime.Second, nil}}
+	)
+
+	opts = opts.SetResultOptions(opts.ResultOptions().SetInstrumentOptions(
+		opts.ResultOptions().InstrumentOptions().SetMetricsScope(scope)))
+	src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		return newTestCommitLogIterator(values, nil), nil
+	}
+	src.snapshotFilesFn = func(_ string, _ ident.ID, _ uint32) (fs.FileSetFilesSlice, error) {
+		return nil, nil
+	}
+
+	res, err := src.ReadData(md, result.ShardTimeRanges{0: ranges, 1: ranges}, testDefaultRunOpts)
+	require.NoError(t, err)
+	require.True(t, res.Unfulfilled().IsEmpty())
+	require.NoError(t, verifyShardResultsAreCorrect(
+		values, blockSize, res.ShardResults(), opts))
+
+	counters := scope.Snapshot().Counters()
+	require.Equal(t, int64(2), counters["bootstrap.commitlog.shards-no-snapshots+"].Value())
+}
+
+func TestReadRespectsSnapshotReadConcurrency(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		numShards    = 8
+		concurrency  = 2
+		opts         = testOptions().SetSnapshotReadConcurrency(concurrency).SetMergeShardsConcurrency(numShards)
+		md           = testNsMetadata(t)
+		src          = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		blockSize    = md.Options().RetentionOptions().BlockSize()
+		now          = time.Now()
+		start        = now.Truncate(blockSize).Add(-blockSize)
+		end          = now.Truncate(blockSize)
+		ranges       = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		targetRanges = result.ShardTimeRanges{}
+		values       []testValue
+		lock         sync.Mutex
+		reading      int
+		maxReading   int
+	)
+
+	for shard := 0; shard < numShards; shard++ {
+		targetRanges[uint32(shard)] = ranges
+		series := commitlog.Series{
+			Namespace: testNamespaceID,
+			Shard:     uint32(shard),
+			ID:        ident.StringID(fmt.Sprintf("series-%d", shard)),
+		}
+		values = append(values, testValue{series, start.Add(time.Minute), float64(shard), xtime.Second, nil})
+	}
+
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		return newTestCommitLogIterator(nil, nil), nil
+	}
+	src.snapshotFilesFn = func(filePathPrefix string, namespace ident.ID, shard uint32) (fs.FileSetFilesSlice, error) {
+		return fs.FileSetFilesSlice{
+			fs.FileSetFile{
+				ID: fs.FileSetFileIdentifier{
+					Namespace:   namespace,
+					BlockStart:  start,
+					Shard:       shard,
+					VolumeIndex: 0,
+				},
+				AbsoluteFilepaths:  []string{"checkpoint"},
+				CachedSnapshotTime: start.Add(2 * time.Minute),
+			},
+		}, nil
+	}
+
+	readers := make(chan fs.DataFileSetReader, numShards)
+	for _, value := range values {
+		bytes := testEncodeValues(t, []testValue{value})
+		mockReader := fs.NewMockDataFileSetReader(ctrl)
+		mockReader.EXPECT().Close().Return(nil).AnyTimes()
+		mockReader.EXPECT().Open(gomock.Any()).Do(func(_ fs.DataReaderOpenOptions) {
+			lock.Lock()
+			reading++
+			if reading > maxReading {
+				maxReading = reading
+			}
+			lock.Unlock()
+			time.Sleep(10 * time.Millisecond)
+		}).Return(nil)
+		mockReader.EXPECT().Entries().Return(1).AnyTimes()
+		mockReader.EXPECT().Read().Return(
+			value.s.ID,
+			ident.EmptyTagIterator,
+			checked.NewBytes(bytes, nil),
+			digest.Checksum(bytes),
+			nil,
+		)
+		mockReader.EXPECT().Read().Do(func() {
+			lock.Lock()
+			reading--
+			lock.Unlock()
+		}).Return(nil, nil, nil, uint32(0), io.EOF)
+		readers <- mockReader
+	}
+	src.newReaderFn = func(bytesPool pool.CheckedBytesPool, opts fs.Options) (fs.DataFileSetReader, error) {
+		return <-readers, nil
+	}
+
+	res, err := src.ReadData(md, targetRanges, testDefaultRunOpts)
+	require.NoError(t, err)
+	require.True(t, res.Unfulfilled().IsEmpty())
+	require.NoError(t, verifyShardResultsAreCorrect(
+		values, blockSize, res.ShardResults(), opts))
+
+	require.True(t, maxReading <= concurrency,
+		fmt.Sprintf("expected at most %d concurrent reads, got %d", concurrency, maxReading))
+}
+
+func TestReadBoundsSnapshotReadsAcrossSourcesSharingPool(t *testing.T) {
+	var (
+		numShards   = 4
+		concurrency = 2
+		md          = testNsMetadata(t)
+		blockSize   = md.Options().RetentionOptions().BlockSize()
+		now         = time.Now()
+		start       = now.Truncate(blockSize).Add(-blockSize)
+		end         = now.Truncate(blockSize)
+		ranges      = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		memSource   = &testMemFileSource{
+			t:            t,
+			blockStart:   start,
+			snapshotTime: start.Add(2 * time.Minute),
+			values:       map[uint32][]testValue{},
+		}
+		source       = &testInFlightFileSource{testMemFileSource: memSource}
+		targetRanges = result.ShardTimeRanges{}
+		readPool     = xsync.NewWorkerPool(concurrency)
+	)
+	readPool.Init()
+
+	for shard := 0; shard < numShards; shard++ {
+		targetRanges[uint32(shard)] = ranges
+		series := commitlog.Series{
+			Namespace: testNamespaceID,
+			Shard:     uint32(shard),
+			ID:        ident.StringID(fmt.Sprintf("series-%d", shard)),
+		}
+		memSource.values[uint32(shard)] = []testValue{
+			{series, start.Add(time.Minute), float64(shard), xtime.Second, nil}}
+	}
+
+	// Each source on its own would read the snapshots of all of its shards at once.
+	opts := testOptions().
+		SetFileSource(source).
+		SetSnapshotReadConcurrency(numShards).
+		SetMergeShardsConcurrency(numShards).
+		SetSharedSnapshotReadPool(readPool)
+
+	var (
+		wg   sync.WaitGroup
+		ress = make([]result.DataBootstrapResult, 2)
+		errs = make([]error, 2)
+	)
+	for i := range ress {
+		i := i
+		src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+			return newTestCommitLogIterator(nil, nil), nil
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ress[i], errs[i] = src.ReadData(md, targetRanges, testDefaultRunOpts)
+		}()
+	}
+	wg.Wait()
+
+	for i := range ress {
+		require.NoError(t, errs[i])
+		require.True(t, ress[i].Unfulfilled().IsEmpty())
+	}
+
+	source.Lock()
+	defer source.Unlock()
+	require.True(t, source.maxInFlight <= concurrency,
+		fmt.Sprintf("expected at most %d concurrent reads, got %d", concurrency, source.maxInFlight))
+}
+
+// testInFlightFileSource tracks the readers of the file source it wraps that
+// have been created but not read to the end yet.
+type testInFlightFileSource struct {
+	sync.Mutex
+	*testMemFileSource
+
+	inFlight    int
+	maxInFlight int
+}
+
+func (s *testInFlightFileSource) NewReader(
+	bytesPool pool.CheckedBytesPool,
+	fsOpts fs.Options,
+) (fs.DataFileSetReader, error) {
+	reader, err := s.testMemFileSource.NewReader(bytesPool, fsOpts)
+	if err != nil {
+		return nil, err
+	}
+	s.Lock()
+	s.inFlight++
+	if s.inFlight > s.maxInFlight {
+		s.maxInFlight = s.inFlight
+	}
+	s.Unlock()
+	return &testInFlightReader{DataFileSetReader: reader, source: s}, nil
+}
+
+type testInFlightReader struct {
+	fs.DataFileSetReader
+
+	source *testInFlightFileSource
+}
+
+func (r *testInFlightReader) Read() (ident.ID, ident.TagIterator, checked.Bytes, uint32, error) {
+	id, tagsIter, data, checksum, err := r.DataFileSetReader.Read()
+	if err == io.EOF {
+		r.source.Lock()
+		r.source.inFlight--
+		r.source.Unlock()
+	} else {
+		// Give the other reads the chance to overlap with this one.
+		time.Sleep(10 * time.Millisecond)
+	}
+	return id, tagsIter, data, checksum, err
+}
+
+func TestReadAbandonsShardMergesThatTimeOut(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		scope     = tally.NewTestScope("", nil)
+		opts      = testOptions().SetPerShardMergeTimeout(100 * time.Millisecond)
+		blOpts    = opts.ResultOptions().DatabaseBlockOptions()
+		encPool   = &testLeakCheckingEncoderPool{EncoderPool: blOpts.EncoderPool()}
+		md        = testNsMetadata(t)
+		blockSize = md.Options().RetentionOptions().BlockSize()
+		now       = time.Now()
+		start     = now.Truncate(blockSize).Add(-blockSize)
+		end       = now.Truncate(blockSize)
+		ranges    = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		foo       = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		bar       = commitlog.Series{Namespace: testNamespaceID, Shard: 1, ID: ident.StringID("bar")}
+		values    = []testValue{
+			{foo, start.Add(time.Minute), 1.0, xtime.Second, nil},
+			{bar, start.Add(time.Minute), 2.0, xtime.Second, nil},
+		}
+		release = make(chan struct{})
+	)
+
+	opts = opts.SetResultOptions(opts.ResultOptions().
+		SetInstrumentOptions(opts.ResultOptions().InstrumentOptions().SetMetricsScope(scope)).
+		SetDatabaseBlockOptions(blOpts.SetEncoderPool(encPool)))
+	src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		return newTestCommitLogIterator(values, nil), nil
+	}
+	src.snapshotFilesFn = func(filePathPrefix string, namespace ident.ID, shard uint32) (fs.FileSetFilesSlice, error) {
+		if shard != 1 {
+			return nil, nil
+		}
+		return fs.FileSetFilesSlice{
+			fs.FileSetFile{
+				ID: fs.FileSetFileIdentifier{
+					Namespace:   namespace,
+					BlockStart:  start,
+					Shard:       shard,
+					VolumeIndex: 0,
+				},
+				AbsoluteFilepaths:  []string{"checkpoint"},
+				CachedSnapshotTime: start.Add(2 * time.Minute),
+			},
+		}, nil
+	}
+
+	// Reading the snapshot of shard 1 hangs until released.
+	mockReader := fs.NewMockDataFileSetReader(ctrl)
+	mockReader.EXPECT().Close().Return(nil).AnyTimes()
+	mockReader.EXPECT().Open(gomock.Any()).Do(func(_ fs.DataReaderOpenOptions) {
+		<-release
+	}).Return(fmt.Errorf("an error"))
+	src.newReaderFn = func(bytesPool pool.CheckedBytesPool, opts fs.Options) (fs.DataFileSetReader, error) {
+		return mockReader, nil
+	}
+
+	res, err := src.ReadData(md, result.ShardTimeRanges{0: ranges, 1: ranges}, testDefaultRunOpts)
+	require.NoError(t, err)
+	require.NoError(t, verifyShardResultsAreCorrect(
+		values[:1], blockSize, res.ShardResults(), opts))
+
+	expectedUnfulfilled := result.ShardTimeRanges{1: ranges}
+	require.True(t, expectedUnfulfilled.Equal(res.Unfulfilled()),
+		fmt.Sprintf("expected: %s, actual: %s", expectedUnfulfilled, res.Unfulfilled()))
+
+	counters := scope.Snapshot().Counters()
+	require.Equal(t, int64(1), counters["bootstrap.commitlog.merge-timeouts+"].Value())
+
+	// The abandoned read keeps running after the bootstrap has returned and
+	// returns the encoders of the shard to the pool once it completes.
+	encPool.Lock()
+	require.Equal(t, 1, encPool.outstanding)
+	encPool.Unlock()
+	close(release)
+	for deadline := time.Now().Add(time.Minute); ; {
+		encPool.Lock()
+		outstanding := encPool.outstanding
+		encPool.Unlock()
+		if outstanding == 0 {
+			break
+		}
+		require.True(t, time.Now().Before(deadline), "encoders of abandoned shard not closed")
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReadSkipsSeriesRejectedByValidator(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		scope     = tally.NewTestScope("", nil)
+		validator = func(id ident.ID, _ ident.Tags) error {
+			if bytes.IndexByte(id.Bytes(), '!') != -1 {
+				return fmt.Errorf("series id contains forbidden byte")
+			}
+			return nil
+		}
+		opts      = testOptions().SetSeriesValidator(validator)
+		md        = testNsMetadata(t)
+		blockSize = md.Options().RetentionOptions().BlockSize()
+		now       = time.Now()
+		start     = now.Truncate(blockSize).Add(-blockSize)
+		end       = now.Truncate(blockSize)
+		ranges    = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		foo       = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		bad       = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("b!d")}
+		values    = []testValue{
+			{foo, start.Add(time.Minute), 1.0, xtime.Second, nil},
+			{bad, start.Add(time.Minute), 2.0, xtime.Second, nil},
+			{bad, start.Add(2 * time.Minute), 3.0, xtime.Second, nil},
+			{foo, start.Add(2 * time.Minute), 4.0, xtime.Second, nil},
+		}
+		badSnapshotValues = []testValue{{bad, start.Add(10 * time.Second), 5.0, xtime.Second, nil}}
+		detector          = newTestBytesLeakDetector()
+	)
+
+	opts = opts.SetResultOptions(opts.ResultOptions().SetInstrumentOptions(
+		opts.ResultOptions().InstrumentOptions().SetMetricsScope(scope)))
+	src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		return newTestCommitLogIterator(values, nil), nil
+	}
+	src.snapshotFilesFn = func(filePathPrefix string, namespace ident.ID, shard uint32) (fs.FileSetFilesSlice, error) {
+		return fs.FileSetFilesSlice{
+			fs.FileSetFile{
+				ID: fs.FileSetFileIdentifier{
+					Namespace:   namespace,
+					BlockStart:  start,
+					Shard:       shard,
+					VolumeIndex: 0,
+				},
+				AbsoluteFilepaths:  []string{"checkpoint"},
+				CachedSnapshotTime: start.Add(30 * time.Second),
+			},
+		}, nil
+	}
+
+	// The rejected series also has snapshot data.
+	badBytes := testEncodeValues(t, badSnapshotValues)
+	mockReader := fs.NewMockDataFileSetReader(ctrl)
+	mockReader.EXPECT().Close().Return(nil).AnyTimes()
+	mockReader.EXPECT().Open(gomock.Any()).Return(nil)
+	mockReader.EXPECT().Entries().Return(1).AnyTimes()
+	mockReader.EXPECT().Read().Return(
+		bad.ID, ident.EmptyTagIterator, detector.newBytes(badBytes), digest.Checksum(badBytes), nil)
+	mockReader.EXPECT().Read().Return(nil, nil, nil, uint32(0), io.EOF)
+	src.newReaderFn = func(bytesPool pool.CheckedBytesPool, opts fs.Options) (fs.DataFileSetReader, error) {
+		return mockReader, nil
+	}
+
+	res, err := src.ReadData(md, result.ShardTimeRanges{0: ranges}, testDefaultRunOpts)
+	require.NoError(t, err)
+	require.True(t, res.Unfulfilled().IsEmpty())
+	require.False(t, res.ShardResults()[0].AllSeries().Contains(bad.ID))
+	require.NoError(t, verifyShardResultsAreCorrect(
+		[]testValue{values[0], values[3]}, blockSize, res.ShardResults(), opts))
+
+	// The snapshot data of the rejected series was dropped rather than being
+	// handed off to the result.
+	require.Equal(t, 0, detector.numOutstanding())
+
+	counters := scope.Snapshot().Counters()
+	require.Equal(t, int64(1), counters["bootstrap.commitlog.series-quarantined+"].Value())
+	require.Equal(t, int64(1), counters["bootstrap.commitlog.series-encoded+"].Value())
+}
+
+func TestReadExcludesSeriesRejectedBySeriesFilter(t *testing.T) {
+	var (
+		md        = testNsMetadata(t)
+		blockSize = md.Options().RetentionOptions().BlockSize()
+		now       = time.Now()
+		start     = now.Truncate(blockSize).Add(-blockSize)
+		end       = now.Truncate(blockSize)
+		ranges    = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		foo       = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		bar       = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("deprecated.bar")}
+		baz       = commitlog.Series{Namespace: testNamespaceID, Shard: 1, ID: ident.StringID("deprecated.baz")}
+		values    = []testValue{
+			{foo, start, 1.0, xtime.Second, nil},
+			{bar, start.Add(time.Minute), 2.0, xtime.Second, nil},
+			{baz, start.Add(2 * time.Minute), 3.0, xtime.Second, nil},
+		}
+		opts = testOptions().SetSeriesFilter(func(id ident.ID) bool {
+			return !bytes.HasPrefix(id.Bytes(), []byte("deprecated."))
+		})
+		src = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+	)
+
+	// The test iterator doesn't apply the series predicate so apply it up front.
+	src.newIteratorFn = func(iterOpts commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		var filtered []testValue
+		for _, v := range values {
+			if iterOpts.SeriesFilterPredicate(v.s.ID, v.s.Namespace) {
+				filtered = append(filtered, v)
+			}
+		}
+		return newTestCommitLogIterator(filtered, nil), nil
+	}
+
+	res, err := src.ReadData(md, result.ShardTimeRanges{0: ranges, 1: ranges}, testDefaultRunOpts)
+	require.NoError(t, err)
+	require.NoError(t, verifyShardResultsAreCorrect(
+		values[:1], blockSize, res.ShardResults(), opts))
+
+	// The filter is combined with the namespace check.
+	pred := newReadSeriesPredicate(md, opts.SeriesFilter(), src.namespaceMatcher())
+	require.True(t, pred(foo.ID, testNamespaceID))
+	require.False(t, pred(foo.ID, ident.StringID("other")))
+	require.False(t, pred(bar.ID, testNamespaceID))
+}
+
+func TestReadMatchesPaddedNamespaceWithNamespaceMatcher(t *testing.T) {
+	var (
+		md        = testNsMetadata(t)
+		blockSize = md.Options().RetentionOptions().BlockSize()
+		now       = time.Now()
+		start     = now.Truncate(blockSize).Add(-blockSize)
+		end       = now.Truncate(blockSize)
+		ranges    = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		paddedID  = ident.StringID(testNamespaceID.String() + "\x00\x00")
+		foo       = commitlog.Series{Namespace: paddedID, Shard: 0, ID: ident.StringID("foo")}
+		values    = []testValue{
+			{foo, start.Add(time.Minute), 1.0, xtime.Second, nil},
+		}
+	)
+
+	read := func(opts Options) result.DataBootstrapResult {
+		src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		// The test iterator doesn't apply the series predicate so apply it up front.
+		src.newIteratorFn = func(iterOpts commitlog.IteratorOpts) (commitlog.Iterator, error) {
+			var filtered []testValue
+			for _, v := range values {
+				if iterOpts.SeriesFilterPredicate(v.s.ID, v.s.Namespace) {
+					filtered = append(filtered, v)
+				}
+			}
+			return newTestCommitLogIterator(filtered, nil), nil
+		}
+		res, err := src.ReadData(md, result.ShardTimeRanges{0: ranges}, testDefaultRunOpts)
+		require.NoError(t, err)
+		return res
+	}
+
+	// Byte identical namespace IDs are required by default.
+	opts := testOptions()
+	res := read(opts)
+	require.Equal(t, 0, len(res.ShardResults()))
+
+	opts = opts.SetNamespaceMatcher(TrimmedNamespaceMatcher)
+	res = read(opts)
+	require.NoError(t, verifyShardResultsAreCorrect(values, blockSize, res.ShardResults(), opts))
+
+	require.True(t, TrimmedNamespaceMatcher(testNamespaceID, ident.StringID(testNamespaceID.String()+"  ")))
+	require.False(t, TrimmedNamespaceMatcher(testNamespaceID, ident.StringID(" "+testNamespaceID.String())))
+}
+
+func TestReadOnlyIncludesSeriesInSeriesAllowlist(t *testing.T) {
+	var (
+		md        = testNsMetadata(t)
+		blockSize = md.Options().RetentionOptions().BlockSize()
+		now       = time.Now()
+		start     = now.Truncate(blockSize).Add(-blockSize)
+		end       = now.Truncate(blockSize)
+		ranges    = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		values    []testValue
+		expected  []testValue
+	)
+
+	for i := 0; i < 10; i++ {
+		series := commitlog.Series{
+			Namespace: testNamespaceID,
+			Shard:     uint32(i % 2),
+			ID:        ident.StringID(fmt.Sprintf("series-%d", i)),
+		}
+		value := testValue{series, start.Add(time.Duration(i) * time.Minute), float64(i), xtime.Second, nil}
+		values = append(values, value)
+		if i == 3 || i == 4 {
+			expected = append(expected, value)
+		}
+	}
+
+	var (
+		opts = testOptions().SetSeriesAllowlist([]ident.ID{
+			ident.StringID("series-3"),
+			ident.StringID("series-4"),
+		})
+		src = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+	)
+
+	// The test iterator doesn't apply the series predicate so apply it up front.
+	src.newIteratorFn = func(iterOpts commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		var filtered []testValue
+		for _, v := range values {
+			if iterOpts.SeriesFilterPredicate(v.s.ID, v.s.Namespace) {
+				filtered = append(filtered, v)
+			}
+		}
+		return newTestCommitLogIterator(filtered, nil), nil
+	}
+
+	res, err := src.ReadData(md, result.ShardTimeRanges{0: ranges, 1: ranges}, testDefaultRunOpts)
+	require.NoError(t, err)
+	require.NoError(t, verifyShardResultsAreCorrect(
+		expected, blockSize, res.ShardResults(), opts))
+
+	// The allowlist is combined with the series filter.
+	src.opts = opts.SetSeriesFilter(func(id ident.ID) bool {
+		return id.String() != "series-4"
+	})
+	pred := newReadSeriesPredicate(md, src.seriesFilter(), src.namespaceMatcher())
+	require.True(t, pred(ident.StringID("series-3"), testNamespaceID))
+	require.False(t, pred(ident.StringID("series-4"), testNamespaceID))
+	require.False(t, pred(ident.StringID("series-5"), testNamespaceID))
+}
+
+func TestReadOnlyBootstrapsBlocksMatchingBlockFilter(t *testing.T) {
+	var (
+		md        = testNsMetadata(t)
+		blockSize = md.Options().RetentionOptions().BlockSize()
+		now       = time.Now()
+		start     = now.Truncate(blockSize).Add(-3 * blockSize)
+		selected  = start.Add(blockSize)
+		end       = now.Truncate(blockSize)
+		opts      = testOptions().SetBlockFilter(func(blockStart xtime.UnixNano) bool {
+			return blockStart == xtime.ToUnixNano(selected)
+		})
+		src    = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		ranges = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		foo    = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		values = []testValue{
+			{foo, start.Add(time.Minute), 1.0, xtime.Second, nil},
+			{foo, selected.Add(time.Minute), 2.0, xtime.Second, nil},
+			{foo, selected.Add(blockSize).Add(time.Minute), 3.0, xtime.Second, nil},
+		}
+	)
+
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		return newTestCommitLogIterator(values, nil), nil
+	}
+
+	res, err := src.ReadData(md, result.ShardTimeRanges{0: ranges}, testDefaultRunOpts)
+	require.NoError(t, err)
+	require.NoError(t, verifyShardResultsAreCorrect(
+		values[1:2], blockSize, res.ShardResults(), opts))
+
+	expectedUnfulfilled := result.ShardTimeRanges{0: xtime.Ranges{}.
+		AddRange(xtime.Range{Start: start, End: selected}).
+		AddRange(xtime.Range{Start: selected.Add(blockSize), End: end})}
+	require.True(t, expectedUnfulfilled.Equal(res.Unfulfilled()),
+		fmt.Sprintf("expected: %s, actual: %s", expectedUnfulfilled, res.Unfulfilled()))
+}
+
+func TestReadRecordsBootstrapSummary(t *testing.T) {
+	var (
+		md        = testNsMetadata(t)
+		blockSize = md.Options().RetentionOptions().BlockSize()
+		now       = time.Now()
+		start     = now.Truncate(blockSize).Add(-2 * blockSize)
+		selected  = start.Add(blockSize)
+		end       = now.Truncate(blockSize)
+		opts      = testOptions().SetBlockFilter(func(blockStart xtime.UnixNano) bool {
+			return blockStart == xtime.ToUnixNano(selected)
+		})
+		src    = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		ranges = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		foo    = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		bar    = commitlog.Series{Namespace: testNamespaceID, Shard: 1, ID: ident.StringID("bar")}
+		values = []testValue{
+			{foo, selected.Add(time.Minute), 1.0, xtime.Second, nil},
+			{foo, selected.Add(2 * time.Minute), 2.0, xtime.Second, nil},
+			{bar, selected.Add(time.Minute), 3.0, xtime.Second, nil},
+		}
+	)
+
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		return newTestCommitLogIterator(values, nil), nil
+	}
+
+	_, ok := src.LastBootstrapSummary(testNamespaceID)
+	require.False(t, ok)
+
+	targetRanges := result.ShardTimeRanges{0: ranges, 1: ranges}
+	res, err := src.ReadData(md, targetRanges, testDefaultRunOpts)
+	require.NoError(t, err)
+	require.NoError(t, verifyShardResultsAreCorrect(
+		values, blockSize, res.ShardResults(), opts))
+
+	var expectedBytes int64
+	for _, shardResult := range res.ShardResults() {
+		for _, entry := range shardResult.AllSeries().Iter() {
+			for _, dbBlock := range entry.Value().Blocks.AllBlocks() {
+				expectedBytes += int64(dbBlock.Len())
+			}
+		}
+	}
+
+	summary, ok := src.LastBootstrapSummary(testNamespaceID)
+	require.True(t, ok)
+	require.Equal(t, 2, summary.NumShards)
+	require.Equal(t, int64(2), summary.NumSeries)
+	require.Equal(t, int64(2), summary.NumBlocks)
+	require.True(t, expectedBytes > 0)
+	require.Equal(t, expectedBytes, summary.NumBytes)
+
+	selectedRanges := xtime.Ranges{}.AddRange(xtime.Range{Start: selected, End: end})
+	excludedRanges := xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: selected})
+	expectedFulfilled := result.ShardTimeRanges{0: selectedRanges, 1: selectedRanges}
+	expectedUnfulfilled := result.ShardTimeRanges{0: excludedRanges, 1: excludedRanges}
+	require.True(t, expectedFulfilled.Equal(summary.Fulfilled),
+		fmt.Sprintf("expected: %s, actual: %s", expectedFulfilled, summary.Fulfilled))
+	require.True(t, expectedUnfulfilled.Equal(summary.Unfulfilled),
+		fmt.Sprintf("expected: %s, actual: %s", expectedUnfulfilled, summary.Unfulfilled))
+}
+
+func TestReadSummaryCountsDatapointsMerged(t *testing.T) {
+	var (
+		scope     = tally.NewTestScope("", nil)
+		opts      = testOptions()
+		md        = testNsMetadata(t)
+		blockSize = md.Options().RetentionOptions().BlockSize()
+		now       = time.Now()
+		start     = now.Truncate(blockSize).Add(-2 * blockSize)
+		end       = now.Truncate(blockSize)
+		ranges    = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		values    []testValue
+	)
+	opts = opts.SetResultOptions(opts.ResultOptions().SetInstrumentOptions(
+		opts.ResultOptions().InstrumentOptions().SetMetricsScope(scope)))
+	src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+
+	// Spread the datapoints across shards, series and blocks.
+	for shard := uint32(0); shard < 2; shard++ {
+		for i := 0; i < 3; i++ {
+			series := commitlog.Series{
+				Namespace: testNamespaceID,
+				Shard:     shard,
+				ID:        ident.StringID(fmt.Sprintf("series-%d-%d", shard, i)),
+			}
+			for j := 0; j < 4; j++ {
+				at := start.Add(time.Duration(j) * blockSize / 2).Add(time.Minute)
+				values = append(values, testValue{series, at, float64(j), xtime.Second, nil})
+			}
+		}
+	}
+
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		return newTestCommitLogIterator(values, nil), nil
+	}
+
+	res, err := src.ReadData(md, result.ShardTimeRanges{0: ranges, 1: ranges}, testDefaultRunOpts)
+	require.NoError(t, err)
+	require.NoError(t, verifyShardResultsAreCorrect(
+		values, blockSize, res.ShardResults(), opts))
+
+	summary, ok := src.LastBootstrapSummary(testNamespaceID)
+	require.True(t, ok)
+	require.Equal(t, int64(len(values)), summary.NumDatapointsMerged)
+
+	counters := scope.Snapshot().Counters()
+	require.Equal(t, int64(len(values)), counters["bootstrap.commitlog.datapoints-merged+"].Value())
+}
+
+func TestReadSummaryListsSkippedCommitLogFiles(t *testing.T) {
+	var (
+		opts         = testOptions()
+		md           = testNsMetadata(t)
+		rOpts        = md.Options().RetentionOptions()
+		blockSize    = rOpts.BlockSize()
+		bufferPast   = rOpts.BufferPast()
+		bufferFuture = rOpts.BufferFuture()
+		start        = time.Now().Truncate(blockSize).Add(-blockSize)
+		end          = start.Add(blockSize)
+		ranges       = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		logSize      = 10 * time.Minute
+
+		commitLogFiles = []commitlog.File{
+			// Ends before the start of the block less buffer future.
+			{FilePath: "before", Start: start.Add(-bufferFuture - logSize), Duration: logSize},
+			{FilePath: "inside", Start: start, Duration: logSize},
+			// Starts after the end of the block plus buffer past.
+			{FilePath: "after", Start: end.Add(bufferPast), Duration: logSize},
+			// Not present when the node started so not skipped for its range.
+			{FilePath: "active", Start: end.Add(bufferPast), Duration: logSize},
+		}
+		inspection = fs.Inspection{SortedCommitLogFiles: []string{"before", "inside", "after"}}
+		src        = newCommitLogSource(opts, inspection).(*commitLogSource)
+	)
+
+	src.newIteratorFn = func(iterOpts commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		// The test iterator doesn't apply the file predicate so apply it up front.
+		for _, f := range commitLogFiles {
+			iterOpts.FileFilterPredicate(f)
+		}
+		return newTestCommitLogIterator(nil, nil), nil
+	}
+
+	_, err := src.ReadData(md, result.ShardTimeRanges{0: ranges}, testDefaultRunOpts)
+	require.NoError(t, err)
+
+	summary, ok := src.LastBootstrapSummary(testNamespaceID)
+	require.True(t, ok)
+	require.Equal(t, []string{"before", "after"}, summary.SkippedCommitLogFiles)
+}
+
+func TestResetDoesNotBleedStateBetweenReads(t *testing.T) {
+	var (
+		opts      = testOptions()
+		md        = testNsMetadata(t)
+		blockSize = md.Options().RetentionOptions().BlockSize()
+		start     = time.Now().Truncate(blockSize).Add(-blockSize)
+		ranges    = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: start.Add(blockSize)})
+		// Both files are well before the range so each is skipped if it was
+		// present when the node started.
+		commitLogFiles = []commitlog.File{
+			{FilePath: "a", Start: start.Add(-4 * blockSize), Duration: time.Minute},
+			{FilePath: "b", Start: start.Add(-3 * blockSize), Duration: time.Minute},
+		}
+		src = newCommitLogSource(opts, testCommitLogFilesInspection{"a": {}}).(*commitLogSource)
+	)
+
+	src.newIteratorFn = func(iterOpts commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		for _, f := range commitLogFiles {
+			iterOpts.FileFilterPredicate(f)
+		}
+		return newTestCommitLogIterator(nil, nil), nil
+	}
+
+	for _, expectedSkipped := range []string{"a", "b"} {
+		if expectedSkipped == "b" {
+			src.Reset(testCommitLogFilesInspection{"b": {}})
+			_, ok := src.LastBootstrapSummary(testNamespaceID)
+			require.False(t, ok)
+		}
+
+		// The stubbed hooks are kept across the reset.
+		_, err := src.ReadData(md, result.ShardTimeRanges{0: ranges}, testDefaultRunOpts)
+		require.NoError(t, err)
+
+		summary, ok := src.LastBootstrapSummary(testNamespaceID)
+		require.True(t, ok)
+		require.Equal(t, []string{expectedSkipped}, summary.SkippedCommitLogFiles)
+	}
+}
+
+func TestReadMarksBlocksOfUnreadableCommitLogFilesUnfulfilled(t *testing.T) {
+	var (
+		scope     = tally.NewTestScope("", nil)
+		opts      = testOptions()
+		md        = testNsMetadata(t)
+		blockSize = md.Options().RetentionOptions().BlockSize()
+		now       = time.Now()
+		start     = now.Truncate(blockSize).Add(-2 * blockSize)
+		corrupt   = start.Add(blockSize)
+		end       = now.Truncate(blockSize)
+		ranges    = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		foo       = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		bar       = commitlog.Series{Namespace: testNamespaceID, Shard: 1, ID: ident.StringID("bar")}
+		values    = []testValue{
+			{foo, start.Add(time.Minute), 1.0, xtime.Second, nil},
+			{bar, start.Add(2 * time.Minute), 2.0, xtime.Second, nil},
+		}
+		fileErr = commitlog.FileError{
+			File: commitlog.File{
+				FilePath: "corrupt",
+				// Middle of the block so that buffer past and future don't
+				// reach the neighbouring blocks.
+				Start:    corrupt.Add(blockSize / 2),
+				Duration: time.Minute,
+			},
+			Err: fmt.Errorf("corrupt commit log"),
+		}
+	)
+
+	opts = opts.SetResultOptions(opts.ResultOptions().SetInstrumentOptions(
+		opts.ResultOptions().InstrumentOptions().SetMetricsScope(scope)))
+	src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		iter := newTestCommitLogIterator(values, fileErr.Err)
+		iter.fileErrs = []commitlog.FileError{fileErr}
+		return iter, nil
+	}
+
+	res, err := src.ReadData(md, result.ShardTimeRanges{0: ranges, 1: ranges}, testDefaultRunOpts)
+	require.NoError(t, err)
+	require.NoError(t, verifyShardResultsAreCorrect(
+		values, blockSize, res.ShardResults(), opts))
+
+	corruptRanges := xtime.Ranges{}.AddRange(xtime.Range{Start: corrupt, End: corrupt.Add(blockSize)})
+	expectedUnfulfilled := result.ShardTimeRanges{0: corruptRanges, 1: corruptRanges}
+	require.True(t, expectedUnfulfilled.Equal(res.Unfulfilled()),
+		fmt.Sprintf("expected: %s, actual: %s", expectedUnfulfilled, res.Unfulfilled()))
+
+	counters := scope.Snapshot().Counters()
+	require.Equal(t, int64(1), counters["bootstrap.commitlog.commitlog-file-errors+"].Value())
+}
+
+func TestReadWarnsWhenCommitLogBlockSizeExceedsNamespace(t *testing.T) {
+	var (
+		md        = testNsMetadata(t)
+		blockSize = md.Options().RetentionOptions().BlockSize()
+		start     = time.Now().Truncate(blockSize).Add(-blockSize)
+		ranges    = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: start.Add(blockSize)})
+	)
+
+	for _, commitLogBlockSize := range []time.Duration{blockSize / 2, blockSize, 2 * blockSize} {
+		var (
+			opts = testOptions()
+			buf  bytes.Buffer
+		)
+		opts = opts.SetCommitLogOptions(opts.CommitLogOptions().SetBlockSize(commitLogBlockSize))
+		src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		src.log = xlog.NewLogger(&buf)
+		src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+			return newTestCommitLogIterator(nil, nil), nil
+		}
+
+		_, err := src.ReadData(md, result.ShardTimeRanges{0: ranges}, testDefaultRunOpts)
+		require.NoError(t, err)
+
+		const warning = "commit log block size exceeds namespace block size"
+		if commitLogBlockSize > blockSize {
+			require.Contains(t, buf.String(), warning)
+		} else {
+			require.NotContains(t, buf.String(), warning)
+		}
+	}
+}
+
+func TestReadFailsOnCommitLogReadErrorWhenEnabled(t *testing.T) {
+	var (
+		md        = testNsMetadata(t)
+		blockSize = md.Options().RetentionOptions().BlockSize()
+		now       = time.Now()
+		start     = now.Truncate(blockSize).Add(-blockSize)
+		end       = now.Truncate(blockSize)
+		ranges    = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		foo       = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		values    = []testValue{
+			{foo, start.Add(time.Minute), 1.0, xtime.Second, nil},
+			{foo, start.Add(2 * time.Minute), 2.0, xtime.Second, nil},
+		}
+		fileErr = commitlog.FileError{
+			File: commitlog.File{
+				FilePath: "corrupt",
+				Start:    start.Add(blockSize / 2),
+				Duration: time.Minute,
+			},
+			Err: fmt.Errorf("corrupt commit log"),
+		}
+	)
+
+	for _, failOnReadError := range []bool{false, true} {
+		opts := testOptions().SetFailOnCommitLogReadError(failOnReadError)
+		src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+			// The iterator skips the corrupt file partway through and carries on
+			// with the values of the files after it.
+			iter := newTestCommitLogIterator(values, fileErr.Err)
+			iter.fileErrs = []commitlog.FileError{fileErr}
+			return iter, nil
+		}
+
+		res, err := src.ReadData(md, result.ShardTimeRanges{0: ranges}, testDefaultRunOpts)
+		if failOnReadError {
+			require.Equal(t, fileErr.Err, err)
+			require.Nil(t, res)
+			continue
+		}
+
+		require.NoError(t, err)
+		require.NoError(t, verifyShardResultsAreCorrect(
+			values, blockSize, res.ShardResults(), opts))
+		expectedUnfulfilled := result.ShardTimeRanges{0: ranges}
+		require.True(t, expectedUnfulfilled.Equal(res.Unfulfilled()),
+			fmt.Sprintf("expected: %s, actual: %s", expectedUnfulfilled, res.Unfulfilled()))
+	}
+}
+
+func TestReadRandomizesShardMergeOrder(t *testing.T) {
+	var (
+		reporter  = &testProgressReporter{}
+		opts      = testOptions().SetProgressReporter(reporter).SetRandomizeShardMergeOrder(true)
+		md        = testNsMetadata(t)
+		src       = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		blockSize = md.Options().RetentionOptions().BlockSize()
+		now       = time.Now()
+		start     = now.Truncate(blockSize).Add(-blockSize)
+		end       = now.Truncate(blockSize)
+		ranges    = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		targets   = result.ShardTimeRanges{}
+		unmerged  = map[uint32]*shardData{}
+		sequence  []uint32
+		values    []testValue
+	)
+	src.shardOrderSeedFn = func() int64 { return 42 }
+
+	for shard := uint32(0); shard < 8; shard++ {
+		targets[shard] = ranges
+		unmerged[shard] = &shardData{}
+		sequence = append(sequence, shard)
+		series := commitlog.Series{
+			Namespace: testNamespaceID,
+			Shard:     shard,
+			ID:        ident.StringID(fmt.Sprintf("series-%d", shard)),
+		}
+		values = append(values, testValue{series, start.Add(time.Minute), float64(shard), xtime.Second, nil})
+	}
+
+	// The same seed always gives the same order, which isn't sequential.
+	order := src.shardMergeOrder(unmerged)
+	require.Equal(t, order, src.shardMergeOrder(unmerged))
+	require.NotEqual(t, sequence, order)
+	sorted := append([]uint32(nil), order...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+	require.Equal(t, sequence, sorted)
+
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		return newTestCommitLogIterator(values, nil), nil
+	}
+	res, err := src.ReadData(md, targets, testDefaultRunOpts)
+	require.NoError(t, err)
+	require.NoError(t, verifyShardResultsAreCorrect(
+		values, blockSize, res.ShardResults(), opts))
+	sort.Slice(reporter.shardsMerged, func(i, j int) bool {
+		return reporter.shardsMerged[i] < reporter.shardsMerged[j]
+	})
+	require.Equal(t, sequence, reporter.shardsMerged)
+}
+
+func TestReadNotifiesProgressReporter(t *testing.T) {
+	var (
+		reporter  = &testProgressReporter{}
+		opts      = testOptions().SetProgressReporter(reporter)
+		md        = testNsMetadata(t)
+		src       = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		blockSize = md.Options().RetentionOptions().BlockSize()
+		now       = time.Now()
+		start     = now.Truncate(blockSize).Add(-blockSize)
+		end       = now.Truncate(blockSize)
+		ranges    = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+
+		foo    = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		bar    = commitlog.Series{Namespace: testNamespaceID, Shard: 1, ID: ident.StringID("bar")}
+		values = []testValue{
+			{foo, start, 1.0, xtime.Second, nil},
+			{foo, start.Add(1 * time.Minute), 2.0, xtime.Second, nil},
+			{bar, start.Add(2 * time.Minute), 1.0, xtime.Second, nil},
+		}
+	)
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		return newTestCommitLogIterator(values, nil), nil
+	}
+
+	targetRanges := result.ShardTimeRanges{0: ranges, 1: ranges, 2: ranges}
+	_, err := src.ReadData(md, targetRanges, testDefaultRunOpts)
+	require.NoError(t, err)
+
+	sort.Slice(reporter.shardsMerged, func(i, j int) bool {
+		return reporter.shardsMerged[i] < reporter.shardsMerged[j]
+	})
+	require.Equal(t, []uint32{0, 1, 2}, reporter.shardsMerged)
+	require.Equal(t, int64(len(values)), reporter.datapointsRead)
+}
+
+func TestReadCompactsUnmergedEncodersWhenOverMemoryLimit(t *testing.T) {
+	md := testNsMetadata(t)
+	blockSize := md.Options().RetentionOptions().BlockSize()
+	now := time.Now()
+	start := now.Truncate(blockSize).Add(-blockSize)
+	end := now.Truncate(blockSize)
+	ranges := xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+
+	foo := commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+	bar := commitlog.Series{Namespace: testNamespaceID, Shard: 1, ID: ident.StringID("bar")}
+
+	// Descending timestamps require a new encoder for every datapoint.
+	var values []testValue
+	for i := 10; i > 0; i-- {
+		values = append(values,
+			testValue{foo, start.Add(time.Duration(i) * time.Minute), float64(i), xtime.Second, nil},
+			testValue{bar, start.Add(time.Duration(i) * time.Minute), float64(i), xtime.Second, nil})
+	}
+
+	// Allow twice the bytes of the series once compacted so compacting is
+	// always enough to stay within the limit.
+	var (
+		sink         = &testUnmergedDebugSink{}
+		opts         = testOptions()
+		encoderPool  = opts.ResultOptions().DatabaseBlockOptions().EncoderPool()
+		compactedLen int64
+	)
+	for _, series := range []commitlog.Series{foo, bar} {
+		enc := encoderPool.Get()
+		enc.Reset(start, 0)
+		for i := len(values) - 1; i >= 0; i-- {
+			if values[i].s.ID.Equal(series.ID) {
+				require.NoError(t, enc.Encode(
+					ts.Datapoint{Timestamp: values[i].t, Value: values[i].v}, values[i].u, nil))
+			}
+		}
+		compactedLen += int64(enc.Len())
+		enc.Close()
+	}
+	opts = opts.
+		SetEncodingConcurrency(1).
+		SetMaxUnmergedMemoryBytes(2 * compactedLen).
+		SetUnmergedDebugSink(sink)
+	src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		return newTestCommitLogIterator(values, nil), nil
+	}
+
+	res, err := src.ReadData(md, result.ShardTimeRanges{0: ranges, 1: ranges}, testDefaultRunOpts)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(res.Unfulfilled()))
+	require.NoError(t, verifyShardResultsAreCorrect(
+		values, blockSize, res.ShardResults(), opts))
+
+	// Without compacting every datapoint would have an encoder of its own.
+	require.Equal(t, 2, len(sink.stats))
+	for _, shardStats := range sink.stats {
+		require.Equal(t, 1, len(shardStats.Blocks))
+		require.True(t, shardStats.Blocks[0].NumEncoders < len(values)/2)
+	}
+}
+
+func TestReadDropsOldestBlocksWhenOverMemoryLimit(t *testing.T) {
+	opts := testOptions().
+		SetEncodingConcurrency(1).
+		SetMaxUnmergedMemoryBytes(1)
+	md := testNsMetadata(t)
+	src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+
+	blockSize := md.Options().RetentionOptions().BlockSize()
+	now := time.Now()
+	start := now.Truncate(blockSize).Add(-blockSize)
+	end := now.Truncate(blockSize)
+	ranges := xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+
+	foo := commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+	values := []testValue{
+		{foo, start.Add(1 * time.Minute), 1.0, xtime.Second, nil},
+		{foo, start.Add(2 * time.Minute), 2.0, xtime.Second, nil},
+	}
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		return newTestCommitLogIterator(values, nil), nil
+	}
+
+	// Not even a single datapoint fits within the limit so the block is dropped
+	// rather than held regardless of the limit.
+	res, err := src.ReadData(md, result.ShardTimeRanges{0: ranges}, testDefaultRunOpts)
+	require.NoError(t, err)
+	require.True(t, result.ShardTimeRanges{0: ranges}.Equal(res.Unfulfilled()))
+	for _, shardResult := range res.ShardResults() {
+		require.Equal(t, int64(0), shardResult.NumSeries())
+	}
+}
+
+func TestDropOldestUnmergedBlocks(t *testing.T) {
+	var (
+		opts        = testOptions()
+		blopts      = opts.ResultOptions().DatabaseBlockOptions()
+		blockSize   = 2 * time.Hour
+		newestStart = time.Now().Truncate(blockSize)
+		oldestStart = newestStart.Add(-blockSize)
+		id          = ident.StringID("foo")
+		unmerged    = map[uint32]*shardData{0: {series: NewMap(MapOptions{})}}
+		encoders    = make(map[xtime.UnixNano][]encoder)
+		totalBytes  int64
+	)
+	for _, blockStart := range []time.Time{oldestStart, newestStart} {
+		enc := blopts.EncoderPool().Get()
+		enc.Reset(blockStart, 0)
+		require.NoError(t, enc.Encode(
+			ts.Datapoint{Timestamp: blockStart.Add(time.Minute), Value: 1}, xtime.Second, nil))
+		totalBytes += int64(enc.Len())
+		encoders[xtime.ToUnixNano(blockStart)] = []encoder{{lastWriteAt: blockStart.Add(time.Minute), enc: enc}}
+	}
+	unmerged[0].series.Set(id, metadataAndEncodersByTime{id: id, encoders: encoders})
+
+	// Dropping the oldest block is enough to get within the target.
+	remaining, numDropped := dropOldestUnmergedBlocks(
+		map[uint32]struct{}{0: {}}, unmerged, totalBytes, totalBytes-1)
+	require.Equal(t, 1, numDropped)
+	require.Equal(t, int64(encoders[xtime.ToUnixNano(newestStart)][0].enc.Len()), remaining)
+	require.Equal(t, map[xtime.UnixNano]struct{}{xtime.ToUnixNano(oldestStart): {}}, unmerged[0].droppedBlocks)
+
+	series, ok := unmerged[0].series.Get(id)
+	require.True(t, ok)
+	require.Equal(t, 1, len(series.encoders))
+	_, ok = series.encoders[xtime.ToUnixNano(newestStart)]
+	require.True(t, ok)
+
+	unfulfilled := droppedBlocksUnfulfilled(result.ShardTimeRanges{
+		0: xtime.Ranges{}.AddRange(xtime.Range{Start: oldestStart, End: newestStart.Add(blockSize)}),
+	}, unmerged, blockSize)
+	require.True(t, result.ShardTimeRanges{
+		0: xtime.Ranges{}.AddRange(xtime.Range{Start: oldestStart, End: newestStart}),
+	}.Equal(unfulfilled))
+}
+
+func TestCompactUnmergedShards(t *testing.T) {
+	var (
+		opts       = testOptions()
+		src        = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		blopts     = opts.ResultOptions().DatabaseBlockOptions()
+		blockSize  = 2 * time.Hour
+		blockStart = time.Now().Truncate(blockSize)
+		id         = ident.StringID("foo")
+		unmerged   = map[uint32]*shardData{0: {series: NewMap(MapOptions{})}}
+		encoders   []encoder
+	)
+
+	for i := 3; i > 0; i-- {
+		enc := blopts.EncoderPool().Get()
+		enc.Reset(blockStart, 0)
+		writeAt := blockStart.Add(time.Duration(i) * time.Minute)
+		require.NoError(t, enc.Encode(ts.Datapoint{Timestamp: writeAt, Value: float64(i)}, xtime.Second, nil))
+		encoders = append(encoders, encoder{lastWriteAt: writeAt, enc: enc})
+	}
+	unmerged[0].series.Set(id, metadataAndEncodersByTime{
+		id:       id,
+		encoders: map[xtime.UnixNano][]encoder{xtime.ToUnixNano(blockStart): encoders},
+	})
+
+	unmergedBytes, numErrs := src.compactUnmergedShards(
+		map[uint32]struct{}{0: struct{}{}}, unmerged, blopts.EncoderPool(), blopts)
+	require.Equal(t, 0, numErrs)
+
+	series, ok := unmerged[0].series.Get(id)
+	require.True(t, ok)
+	compacted := series.encoders[xtime.ToUnixNano(blockStart)]
+	require.Equal(t, 1, len(compacted))
+	require.Equal(t, int64(compacted[0].enc.Len()), unmergedBytes)
+	require.True(t, blockStart.Add(3*time.Minute).Equal(compacted[0].lastWriteAt))
+}
+
+func TestCompactUnmergedShardsPrefersLastEncoderForDuplicateTimestamps(t *testing.T) {
+	var (
+		opts       = testOptions()
+		src        = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		blopts     = opts.ResultOptions().DatabaseBlockOptions()
+		blockSize  = 2 * time.Hour
+		blockStart = time.Now().Truncate(blockSize).Add(-blockSize)
+		foo        = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		unmerged   = map[uint32]*shardData{0: {series: NewMap(MapOptions{})}}
+		encoders   []encoder
+	)
+
+	// The second encoder holds a later write for a timestamp of the first.
+	for _, values := range [][]testValue{
+		{
+			{foo, blockStart.Add(time.Minute), 1.0, xtime.Second, nil},
+			{foo, blockStart.Add(2 * time.Minute), 2.0, xtime.Second, nil},
+		},
+		{
+			{foo, blockStart.Add(time.Minute), 3.0, xtime.Second, nil},
+		},
+	} {
+		enc := blopts.EncoderPool().Get()
+		enc.Reset(blockStart, 0)
+		for _, v := range values {
+			require.NoError(t, enc.Encode(ts.Datapoint{Timestamp: v.t, Value: v.v}, v.u, v.a))
+		}
+		encoders = append(encoders, encoder{lastWriteAt: values[len(values)-1].t, enc: enc})
+	}
+	unmerged[0].series.Set(foo.ID, metadataAndEncodersByTime{
+		id:       foo.ID,
+		encoders: map[xtime.UnixNano][]encoder{xtime.ToUnixNano(blockStart): encoders},
+	})
+
+	_, numErrs := src.compactUnmergedShards(
+		map[uint32]struct{}{0: struct{}{}}, unmerged, blopts.EncoderPool(), blopts)
+	require.Equal(t, 0, numErrs)
+
+	snapshotData := result.NewShardResult(0, opts.ResultOptions())
+	shardResult, _, _ := src.mergeShardCommitLogEncodersAndSnapshots(
+		0, snapshotData, *unmerged[0], blockSize, nil)
+	require.NoError(t, verifyShardResultsAreCorrect([]testValue{
+		{foo, blockStart.Add(time.Minute), 3.0, xtime.Second, nil},
+		{foo, blockStart.Add(2 * time.Minute), 2.0, xtime.Second, nil},
+	}, blockSize, result.ShardResults{0: shardResult}, opts))
+}
+
+func TestCompactUnmergedShardsMarksBlockIncompleteOnError(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		opts       = testOptions()
+		src        = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		blopts     = opts.ResultOptions().DatabaseBlockOptions()
+		blockSize  = 2 * time.Hour
+		blockStart = time.Now().Truncate(blockSize).Add(-blockSize)
+		id         = ident.StringID("foo")
+		unmerged   = map[uint32]*shardData{0: {series: NewMap(MapOptions{})}}
+		encoders   []encoder
+	)
+
+	for i := 2; i > 0; i-- {
+		enc := blopts.EncoderPool().Get()
+		enc.Reset(blockStart, 0)
+		writeAt := blockStart.Add(time.Duration(i) * time.Minute)
+		require.NoError(t, enc.Encode(ts.Datapoint{Timestamp: writeAt, Value: float64(i)}, xtime.Second, nil))
+		encoders = append(encoders, encoder{lastWriteAt: writeAt, enc: enc})
+	}
+	unmerged[0].series.Set(id, metadataAndEncodersByTime{
+		id:       id,
+		encoders: map[xtime.UnixNano][]encoder{xtime.ToUnixNano(blockStart): encoders},
+	})
+
+	// The compacted encoder can't be written to.
+	failing := encoding.NewMockEncoder(ctrl)
+	failing.EXPECT().Reset(gomock.Any(), gomock.Any()).AnyTimes()
+	failing.EXPECT().Encode(gomock.Any(), gomock.Any(), gomock.Any()).Return(fmt.Errorf("an error"))
+	failing.EXPECT().Close()
+	failingPool := encoding.NewEncoderPool(nil)
+	failingPool.Init(func() encoding.Encoder { return failing })
+
+	unmergedBytes, numErrs := src.compactUnmergedShards(
+		map[uint32]struct{}{0: struct{}{}}, unmerged, failingPool, blopts)
+	require.Equal(t, 1, numErrs)
+	require.Equal(t, int64(0), unmergedBytes)
+
+	// The series lost its commit log data for the block so the block can't be
+	// fulfilled.
+	ranges := xtime.Ranges{}.AddRange(xtime.Range{Start: blockStart, End: blockStart.Add(blockSize)})
+	unfulfilled := droppedBlocksUnfulfilled(result.ShardTimeRanges{0: ranges}, unmerged, blockSize)
+	require.True(t, result.ShardTimeRanges{0: ranges}.Equal(unfulfilled),
+		fmt.Sprintf("expected: %s, actual: %s", ranges, unfulfilled))
+}
+
+func TestEncodingWorkerBoundsEncodersPerSeries(t *testing.T) {
+	var (
+		opts       = testOptions().SetMaxEncodersPerSeries(4)
+		src        = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		md         = testNsMetadata(t)
+		blopts     = opts.ResultOptions().DatabaseBlockOptions()
+		blockSize  = md.Options().RetentionOptions().BlockSize()
+		blockStart = time.Now().Truncate(blockSize).Add(-blockSize)
+		foo        = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		unmerged   = map[uint32]*shardData{0: {series: NewMap(MapOptions{})}}
+		workerErrs = make([]int, 1)
+		ec         = make(chan encoderArg, 20)
+		wg         sync.WaitGroup
+		values     []testValue
+	)
+
+	// Every datapoint is older than the last so none can be appended to an
+	// existing encoder.
+	for i := 20; i > 0; i-- {
+		v := testValue{foo, blockStart.Add(time.Duration(i) * time.Minute), float64(i), xtime.Second, nil}
+		values = append(values, v)
+		ec <- encoderArg{series: v.s, dp: ts.Datapoint{Timestamp: v.t, Value: v.v}, unit: v.u, blockStart: blockStart}
+	}
+	close(ec)
+
+	wg.Add(1)
+	src.startEncodingWorker(md, testDefaultRunOpts, 0, ec, unmerged, blopts.EncoderPool(),
+		workerErrs, make([][]encodeError, 1), nil, false,
+		make([]outOfOrderDatapoints, 1), 0, blopts, &wg)
+	require.Equal(t, 0, workerErrs[0])
+
+	series, ok := unmerged[0].series.Get(foo.ID)
+	require.True(t, ok)
+	encoders := series.encoders[xtime.ToUnixNano(blockStart)]
+	require.True(t, len(encoders) <= 4, fmt.Sprintf("expected at most 4 encoders, got %d", len(encoders)))
+
+	snapshotData := result.NewShardResult(0, opts.ResultOptions())
+	shardResult, stats, _ := src.mergeShardCommitLogEncodersAndSnapshots(
+		0, snapshotData, *unmerged[0], blockSize, nil)
+	require.Equal(t, 0, stats.numEmptyErrs)
+	require.Equal(t, 0, stats.numErrs)
+	require.NoError(t, verifyShardResultsAreCorrect(
+		values, blockSize, result.ShardResults{0: shardResult}, opts))
+}
+
+func TestEncodingWorkerBoundsUnmergedBytesPerSeries(t *testing.T) {
+	const (
+		numValues      = 1000
+		maxSeriesBytes = 64
+	)
+	var (
+		opts       = testOptions().SetMaxUnmergedBytesPerSeries(maxSeriesBytes)
+		src        = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		md         = testNsMetadata(t)
+		blopts     = opts.ResultOptions().DatabaseBlockOptions()
+		blockSize  = md.Options().RetentionOptions().BlockSize()
+		blockStart = time.Now().Truncate(blockSize).Add(-blockSize)
+		foo        = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		unmerged   = map[uint32]*shardData{0: {series: NewMap(MapOptions{})}}
+		workerErrs = make([]int, 1)
+		ec         = make(chan encoderArg, numValues)
+		wg         sync.WaitGroup
+		values     []testValue
+	)
+
+	// Every datapoint is older than the last so each needs a new encoder.
+	for i := numValues; i > 0; i-- {
+		v := testValue{foo, blockStart.Add(time.Duration(i) * time.Second), float64(i), xtime.Second, nil}
+		values = append(values, v)
+		ec <- encoderArg{series: v.s, dp: ts.Datapoint{Timestamp: v.t, Value: v.v}, unit: v.u, blockStart: blockStart}
+	}
+	close(ec)
+
+	wg.Add(1)
+	src.startEncodingWorker(md, testDefaultRunOpts, 0, ec, unmerged, blopts.EncoderPool(),
+		workerErrs, make([][]encodeError, 1), nil, false,
+		make([]outOfOrderDatapoints, 1), 0, blopts, &wg)
+	require.Equal(t, 0, workerErrs[0])
+
+	// Every encoder holds at least a byte so the cap also bounds their number.
+	series, ok := unmerged[0].series.Get(foo.ID)
+	require.True(t, ok)
+	encoders := series.encoders[xtime.ToUnixNano(blockStart)]
+	require.True(t, extraEncodersLen(encoders) <= maxSeriesBytes)
+	require.True(t, len(encoders) <= maxSeriesBytes+1,
+		fmt.Sprintf("expected at most %d encoders, got %d", maxSeriesBytes+1, len(encoders)))
+
+	snapshotData := result.NewShardResult(0, opts.ResultOptions())
+	shardResult, stats, _ := src.mergeShardCommitLogEncodersAndSnapshots(
+		0, snapshotData, *unmerged[0], blockSize, nil)
+	require.Equal(t, 0, stats.numEmptyErrs)
+	require.Equal(t, 0, stats.numErrs)
+	require.NoError(t, verifyShardResultsAreCorrect(
+		values, blockSize, result.ShardResults{0: shardResult}, opts))
+}
+
+func TestEncodingWorkerMarksBlockIncompleteWhenSeriesCompactionFails(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		opts       = testOptions().SetMaxUnmergedBytesPerSeries(1)
+		src        = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		md         = testNsMetadata(t)
+		blopts     = opts.ResultOptions().DatabaseBlockOptions()
+		blockSize  = md.Options().RetentionOptions().BlockSize()
+		blockStart = time.Now().Truncate(blockSize).Add(-blockSize)
+		foo        = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		unmerged   = map[uint32]*shardData{0: {series: NewMap(MapOptions{})}}
+		workerErrs = make([]int, 1)
+		ec         = make(chan encoderArg, 2)
+		wg         sync.WaitGroup
+	)
+
+	// The encoders of both datapoints are written to but the one they're
+	// compacted into can't be.
+	failing := encoding.NewMockEncoder(ctrl)
+	failing.EXPECT().Reset(gomock.Any(), gomock.Any()).AnyTimes()
+	failing.EXPECT().Encode(gomock.Any(), gomock.Any(), gomock.Any()).Return(fmt.Errorf("an error"))
+	failing.EXPECT().Close()
+	encoderPool := &testFailingAfterEncoderPool{
+		EncoderPool: blopts.EncoderPool(),
+		remaining:   2,
+		failing:     failing,
+	}
+
+	// The second datapoint is older than the first so it needs an encoder of its
+	// own, which takes the series over its cap.
+	for i := 2; i > 0; i-- {
+		ec <- encoderArg{
+			series:     foo,
+			dp:         ts.Datapoint{Timestamp: blockStart.Add(time.Duration(i) * time.Minute), Value: float64(i)},
+			unit:       xtime.Second,
+			blockStart: blockStart,
+		}
+	}
+	close(ec)
+
+	wg.Add(1)
+	src.startEncodingWorker(md, testDefaultRunOpts, 0, ec, unmerged, encoderPool,
+		workerErrs, make([][]encodeError, 1), nil, false,
+		make([]outOfOrderDatapoints, 1), 0, blopts, &wg)
+	require.Equal(t, 1, workerErrs[0])
+
+	ranges := xtime.Ranges{}.AddRange(xtime.Range{Start: blockStart, End: blockStart.Add(blockSize)})
+	unfulfilled := droppedBlocksUnfulfilled(result.ShardTimeRanges{0: ranges}, unmerged, blockSize)
+	require.True(t, result.ShardTimeRanges{0: ranges}.Equal(unfulfilled),
+		fmt.Sprintf("expected: %s, actual: %s", ranges, unfulfilled))
+}
+
+// testFailingAfterEncoderPool returns encoders from the pool it wraps until
+// remaining reaches zero, after which it returns the failing encoder.
+type testFailingAfterEncoderPool struct {
+	encoding.EncoderPool
+
+	remaining int
+	failing   encoding.Encoder
+}
+
+func (p *testFailingAfterEncoderPool) Get() encoding.Encoder {
+	if p.remaining == 0 {
+		return p.failing
+	}
+	p.remaining--
+	return p.EncoderPool.Get()
+}
+
+func TestLogEncodingOutcomeLogsSampleOfEncodeErrors(t *testing.T) {
+	const numValues = 3 * maxEncodeErrorSamples
+	var (
+		opts       = testOptions().SetMaxAnnotationBytes(1)
+		src        = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		md         = testNsMetadata(t)
+		blopts     = opts.ResultOptions().DatabaseBlockOptions()
+		blockSize  = md.Options().RetentionOptions().BlockSize()
+		blockStart = time.Now().Truncate(blockSize).Add(-blockSize)
+		foo        = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		unmerged   = map[uint32]*shardData{0: {series: NewMap(MapOptions{})}}
+		workerErrs = make([]int, 1)
+		errSamples = make([][]encodeError, 1)
+		ec         = make(chan encoderArg, numValues)
+		wg         sync.WaitGroup
+	)
+
+	// Every annotation is too large so every datapoint fails to encode.
+	for i := 0; i < numValues; i++ {
+		ec <- encoderArg{
+			series:     foo,
+			dp:         ts.Datapoint{Timestamp: blockStart.Add(time.Duration(i) * time.Second), Value: float64(i)},
+			unit:       xtime.Second,
+			annotation: ts.Annotation("too large"),
+			blockStart: blockStart,
+		}
+	}
+	close(ec)
+
+	wg.Add(1)
+	src.startEncodingWorker(md, testDefaultRunOpts, 0, ec, unmerged, blopts.EncoderPool(),
+		workerErrs, errSamples, nil, false,
+		make([]outOfOrderDatapoints, 1), 0, blopts, &wg)
+	require.Equal(t, numValues, workerErrs[0])
+	require.Equal(t, maxEncodeErrorSamples, len(errSamples[0]))
+
+	var buf bytes.Buffer
+	src.log = xlog.NewLogger(&buf)
+	src.logEncodingOutcome(workerErrs, errSamples, newTestCommitLogIterator(nil, nil))
+	require.Contains(t, buf.String(), fmt.Sprintf("%d block encode errors", numValues))
+	require.Equal(t, maxEncodeErrorSamples,
+		strings.Count(buf.String(), "error encoding datapoint from commit log"))
+	require.Contains(t, buf.String(), "foo")
+	require.Contains(t, buf.String(), errAnnotationTooLarge.Error())
+}
+
+func TestReadSkipsCommitLogDatapointsCapturedBySnapshot(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		opts         = testOptions()
+		md           = testNsMetadata(t)
+		src          = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		blockSize    = md.Options().RetentionOptions().BlockSize()
+		bufferPast   = md.Options().RetentionOptions().BufferPast()
+		now          = time.Now()
+		start        = now.Truncate(blockSize).Add(-blockSize)
+		end          = now.Truncate(blockSize)
+		ranges       = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		snapshotTime = start.Add(30 * time.Minute)
+
+		foo            = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		snapshotValues = []testValue{
+			{foo, start.Add(1 * time.Minute), 1.0, xtime.Nanosecond, nil},
+		}
+		commitLogValues = []testValue{
+			// Must have been written before the snapshot was taken so should be skipped,
+			// it's intentionally missing from the snapshot to prove that it was skipped.
+			{foo, snapshotTime.Add(-bufferPast).Add(-time.Minute), 2.0, xtime.Nanosecond, nil},
+			// May have been written after the snapshot was taken so must be read.
+			{foo, snapshotTime.Add(-bufferPast).Add(time.Minute), 3.0, xtime.Nanosecond, nil},
+			{foo, snapshotTime.Add(time.Minute), 4.0, xtime.Nanosecond, nil},
+		}
+	)
+
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		return newTestCommitLogIterator(commitLogValues, nil), nil
+	}
+	src.snapshotFilesFn = func(filePathPrefix string, namespace ident.ID, shard uint32) (fs.FileSetFilesSlice, error) {
+		return fs.FileSetFilesSlice{
+			fs.FileSetFile{
+				ID: fs.FileSetFileIdentifier{
+					Namespace:   namespace,
+					BlockStart:  start,
+					Shard:       shard,
+					VolumeIndex: 0,
+				},
+				AbsoluteFilepaths:  []string{"checkpoint"},
+				CachedSnapshotTime: snapshotTime,
+			},
+		}, nil
+	}
+
+	snapshotBytes := testEncodeValues(t, snapshotValues)
+	mockReader := fs.NewMockDataFileSetReader(ctrl)
+	mockReader.EXPECT().Close().Return(nil).AnyTimes()
+	mockReader.EXPECT().Open(gomock.Any()).Return(nil)
+	mockReader.EXPECT().Entries().Return(1).AnyTimes()
+	mockReader.EXPECT().Read().Return(
+		foo.ID,
+		ident.EmptyTagIterator,
+		checked.NewBytes(snapshotBytes, nil),
+		digest.Checksum(snapshotBytes),
+		nil,
+	)
+	mockReader.EXPECT().Read().Return(nil, nil, nil, uint32(0), io.EOF)
+	src.newReaderFn = func(bytesPool pool.CheckedBytesPool, opts fs.Options) (fs.DataFileSetReader, error) {
+		return mockReader, nil
+	}
+
+	res, err := src.ReadData(md, result.ShardTimeRanges{0: ranges}, testDefaultRunOpts)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(res.Unfulfilled()))
+
+	expectedValues := append([]testValue{}, snapshotValues...)
+	expectedValues = append(expectedValues, commitLogValues[1:]...)
+	require.NoError(t, verifyShardResultsAreCorrect(
+		expectedValues, blockSize, res.ShardResults(), opts))
+}
+
+// testEncodeValues encodes the values into a single M3TSZ stream which
+// can be returned from a snapshot file reader.
+func testEncodeValues(t testing.TB, values []testValue) []byte {
+	encoder := m3tsz.NewEncoder(values[0].t, nil, true, nil)
+	for _, value := range values {
+		dp := ts.Datapoint{
+			Timestamp: value.t,
+			Value:     value.v,
+		}
+		require.NoError(t, encoder.Encode(dp, value.u, value.a))
+	}
+
+	reader := encoder.Stream()
+	seg, err := reader.Segment()
+	require.NoError(t, err)
+	bytes := make([]byte, seg.Len())
+	_, err = reader.Read(bytes)
+	require.NoError(t, err)
+	return bytes
+}
+
+func TestMergeEncodersAndSnapshot(t *testing.T) {
+	var (
+		opts       = testOptions()
+		blopts     = opts.ResultOptions().DatabaseBlockOptions()
+		blockSize  = 2 * time.Hour
+		blockStart = time.Now().Truncate(blockSize).Add(-blockSize)
+		foo        = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+
+		snapshotValues = []testValue{
+			{foo, blockStart.Add(time.Minute), 1.0, xtime.Second, nil},
+			{foo, blockStart.Add(2 * time.Minute), 2.0, xtime.Second, nil},
+		}
+		firstEncoderValues = []testValue{
+			{foo, blockStart.Add(2 * time.Minute), 3.0, xtime.Second, nil},
+			{foo, blockStart.Add(3 * time.Minute), 4.0, xtime.Second, nil},
+		}
+		secondEncoderValues = []testValue{
+			{foo, blockStart.Add(3 * time.Minute), 5.0, xtime.Second, nil},
+		}
+	)
+
+	tests := []struct {
+		name           string
+		snapshotValues []testValue
+		encoderValues  [][]testValue
+		expected       []testValue
+	}{
+		{
+			name: "empty encoders and snapshot",
+		},
+		{
+			name:           "empty encoders",
+			snapshotValues: snapshotValues,
+			expected:       snapshotValues,
+		},
+		{
+			name:          "empty snapshot",
+			encoderValues: [][]testValue{firstEncoderValues, secondEncoderValues},
+			// The later encoder wins ties.
+			expected: []testValue{firstEncoderValues[0], secondEncoderValues[0]},
+		},
+		{
+			name:           "encoders and snapshot",
+			snapshotValues: snapshotValues,
+			encoderValues:  [][]testValue{firstEncoderValues, secondEncoderValues},
+			// Encoders win ties with the snapshot.
+			expected: []testValue{snapshotValues[0], firstEncoderValues[0], secondEncoderValues[0]},
+		},
+	}
+
+	for _, test := range tests {
+		var snapshot ts.Segment
+		if len(test.snapshotValues) > 0 {
+			bytes := testEncodeValues(t, test.snapshotValues)
+			snapshot = ts.NewSegment(checked.NewBytes(bytes, nil), nil, ts.FinalizeHead)
+		}
+
+		var encoders []encoding.Encoder
+		for _, values := range test.encoderValues {
+			enc := blopts.EncoderPool().Get()
+			enc.Reset(blockStart, 0)
+			for _, v := range values {
+				require.NoError(t, enc.Encode(ts.Datapoint{Timestamp: v.t, Value: v.v}, v.u, v.a))
+			}
+			encoders = append(encoders, enc)
+		}
+
+		merged, stats, err := MergeEncodersAndSnapshot(
+			blockStart, blockSize, encoders, snapshot, blopts)
+		require.NoError(t, err, test.name)
+		require.Equal(t, len(test.expected), stats.NumDatapoints, test.name)
+		if len(test.expected) == 0 {
+			require.Nil(t, merged, test.name)
+			continue
+		}
+
+		require.NotNil(t, merged, test.name)
+		shardResult := result.NewShardResult(0, opts.ResultOptions())
+		shardResult.AddBlock(foo.ID, ident.Tags{}, merged)
+		require.NoError(t, verifyShardResultsAreCorrect(
+			test.expected, blockSize, result.ShardResults{0: shardResult}, opts), test.name)
+	}
+}
+
+func TestMergeShardReleasesMergedSnapshotSeries(t *testing.T) {
+	var (
+		opts       = testOptions()
+		src        = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		blockSize  = 2 * time.Hour
+		blockStart = time.Now().Truncate(blockSize).Add(-blockSize)
+		foo        = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		bar        = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("bar")}
+		baz        = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("baz")}
+
+		snapshotValues = []testValue{
+			{foo, blockStart.Add(time.Minute), 1.0, xtime.Second, nil},
+			{bar, blockStart.Add(time.Minute), 2.0, xtime.Second, nil},
+		}
+		commitLogValues = []testValue{
+			{foo, blockStart.Add(2 * time.Minute), 3.0, xtime.Second, nil},
+			{baz, blockStart.Add(2 * time.Minute), 4.0, xtime.Second, nil},
+		}
+	)
+
+	snapshotData, unmergedShard := testMergeShardInputs(
+		t, opts, blockSize, snapshotValues, commitLogValues)
+	shardResult, stats, _ := src.mergeShardCommitLogEncodersAndSnapshots(
+		0, snapshotData, unmergedShard, blockSize, nil)
+	require.Equal(t, 0, stats.numEmptyErrs)
+	require.Equal(t, 0, stats.numErrs)
+
+	// Only the snapshot series without commit log data should remain.
+	remaining := snapshotData.AllSeries()
+	require.Equal(t, 1, remaining.Len())
+	require.True(t, remaining.Contains(bar.ID))
+
+	expectedValues := append([]testValue{}, snapshotValues...)
+	expectedValues = append(expectedValues, commitLogValues...)
+	require.NoError(t, verifyShardResultsAreCorrect(
+		expectedValues, blockSize, result.ShardResults{0: shardResult}, opts))
+}
+
+func TestMergeShardSkipsSeriesOnceCancelled(t *testing.T) {
+	var (
+		opts         = testOptions()
+		src          = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		blockSize    = 2 * time.Hour
+		blockStart   = time.Now().Truncate(blockSize).Add(-blockSize)
+		foo          = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		bar          = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("bar")}
+		cancellation = &mergeCancellation{}
+
+		snapshotValues  = []testValue{{foo, blockStart.Add(time.Minute), 1.0, xtime.Second, nil}}
+		commitLogValues = []testValue{
+			{foo, blockStart.Add(2 * time.Minute), 2.0, xtime.Second, nil},
+			{bar, blockStart.Add(2 * time.Minute), 3.0, xtime.Second, nil},
+		}
+	)
+
+	blOpts := opts.ResultOptions().DatabaseBlockOptions()
+	encPool := &testLeakCheckingEncoderPool{EncoderPool: blOpts.EncoderPool()}
+	opts = opts.SetResultOptions(opts.ResultOptions().SetDatabaseBlockOptions(
+		blOpts.SetEncoderPool(encPool)))
+
+	snapshotData, unmergedShard := testMergeShardInputs(
+		t, opts, blockSize, snapshotValues, commitLogValues)
+	cancellation.cancel()
+	shardResult, stats, _ := src.mergeShardCommitLogEncodersAndSnapshots(
+		0, snapshotData, unmergedShard, blockSize, cancellation)
+	require.Equal(t, 0, stats.numDatapoints)
+
+	// The encoders of the skipped series are returned to the pool.
+	encPool.Lock()
+	require.Equal(t, 2, encPool.gets)
+	require.Equal(t, 0, encPool.outstanding)
+	encPool.Unlock()
+
+	// None of the commit log data was merged, the snapshot data is left in the
+	// result so that it's closed along with it.
+	require.False(t, shardResult.AllSeries().Contains(bar.ID))
+	require.NoError(t, verifyShardResultsAreCorrect(
+		snapshotValues, blockSize, result.ShardResults{0: shardResult}, opts))
+	shardResult.Close()
+}
+
+func TestReadReturnsSnapshotBytesAfterMerge(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		opts       = testOptions()
+		md         = testNsMetadata(t)
+		src        = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		blockSize  = md.Options().RetentionOptions().BlockSize()
+		now        = time.Now()
+		start      = now.Truncate(blockSize).Add(-blockSize)
+		end        = now.Truncate(blockSize)
+		ranges     = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		foo        = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		bar        = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("bar")}
+		detector   = newTestBytesLeakDetector()
+		snapshotID = func(volume int) fs.FileSetFileIdentifier {
+			return fs.FileSetFileIdentifier{
+				Namespace:   testNamespaceID,
+				BlockStart:  start,
+				Shard:       0,
+				VolumeIndex: volume,
+			}
+		}
+
+		fooSnapshotValues = []testValue{{foo, start.Add(time.Minute), 1.0, xtime.Nanosecond, nil}}
+		barSnapshotValues = []testValue{{bar, start.Add(time.Minute), 2.0, xtime.Nanosecond, nil}}
+		commitLogValues   = []testValue{{foo, start.Add(time.Hour), 3.0, xtime.Nanosecond, nil}}
+	)
+
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		return newTestCommitLogIterator(commitLogValues, nil), nil
+	}
+	src.snapshotFilesFn = func(filePathPrefix string, namespace ident.ID, shard uint32) (fs.FileSetFilesSlice, error) {
+		return fs.FileSetFilesSlice{
+			fs.FileSetFile{
+				ID:                 snapshotID(0),
+				AbsoluteFilepaths:  []string{"checkpoint"},
+				CachedSnapshotTime: start.Add(2 * time.Minute),
+			},
+			fs.FileSetFile{
+				ID:                 snapshotID(1),
+				AbsoluteFilepaths:  []string{"checkpoint"},
+				CachedSnapshotTime: start.Add(3 * time.Minute),
+			},
+		}, nil
+	}
+
+	var (
+		fooBytes   = testEncodeValues(t, fooSnapshotValues)
+		barBytes   = testEncodeValues(t, barSnapshotValues)
+		mockReader = fs.NewMockDataFileSetReader(ctrl)
+	)
+	mockReader.EXPECT().Entries().Return(2).AnyTimes()
+	// Both snapshots are closed once read, including the corrupt one.
+	mockReader.EXPECT().Close().Return(nil).Times(2)
+
+	// The latest snapshot fails part way through being read.
+	mockReader.EXPECT().Open(fs.ReaderOpenOptionsMatcher{
+		ID:          snapshotID(1),
+		FileSetType: persist.FileSetSnapshotType,
+	}).Return(nil)
+	mockReader.EXPECT().Read().Return(
+		ident.StringID("foo"), ident.EmptyTagIterator, detector.newBytes(fooBytes), digest.Checksum(fooBytes), nil)
+	mockReader.EXPECT().Read().Return(nil, nil, nil, uint32(0), fmt.Errorf("corrupt snapshot"))
+
+	mockReader.EXPECT().Open(fs.ReaderOpenOptionsMatcher{
+		ID:          snapshotID(0),
+		FileSetType: persist.FileSetSnapshotType,
+	}).Return(nil)
+	mockReader.EXPECT().Read().Return(
+		ident.StringID("foo"), ident.EmptyTagIterator, detector.newBytes(fooBytes), digest.Checksum(fooBytes), nil)
+	mockReader.EXPECT().Read().Return(
+		ident.StringID("bar"), ident.EmptyTagIterator, detector.newBytes(barBytes), digest.Checksum(barBytes), nil)
+	mockReader.EXPECT().Read().Return(nil, nil, nil, uint32(0), io.EOF)
+	src.newReaderFn = func(bytesPool pool.CheckedBytesPool, opts fs.Options) (fs.DataFileSetReader, error) {
+		return mockReader, nil
+	}
+
+	res, err := src.ReadData(md, result.ShardTimeRanges{0: ranges}, testDefaultRunOpts)
+	require.NoError(t, err)
+	require.True(t, res.Unfulfilled().IsEmpty())
+
+	var expectedValues []testValue
+	expectedValues = append(expectedValues, fooSnapshotValues...)
+	expectedValues = append(expectedValues, barSnapshotValues...)
+	expectedValues = append(expectedValues, commitLogValues...)
+	require.NoError(t, verifyShardResultsAreCorrect(
+		expectedValues, blockSize, res.ShardResults(), opts))
+
+	// Only the snapshot data for the series without commit log data was
+	// handed off to the result.
+	require.Equal(t, 1, detector.numOutstanding())
+
+	res.ShardResults()[0].Close()
+	require.Equal(t, 0, detector.numOutstanding())
+}
+
+func BenchmarkMergeShardCommitLogEncodersAndSnapshots(b *testing.B) {
+	var (
+		opts            = testOptions()
+		src             = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		blockSize       = 2 * time.Hour
+		blockStart      = time.Now().Truncate(blockSize).Add(-blockSize)
+		snapshotValues  []testValue
+		commitLogValues []testValue
+	)
+
+	for i := 0; i < 1000; i++ {
+		series := commitlog.Series{
+			Namespace: testNamespaceID,
+			Shard:     0,
+			ID:        ident.StringID(fmt.Sprintf("series-%d", i)),
+		}
+		for j := 0; j < 10; j++ {
+			snapshotValues = append(snapshotValues, testValue{
+				series, blockStart.Add(time.Duration(j) * time.Second), float64(j), xtime.Second, nil})
+			commitLogValues = append(commitLogValues, testValue{
+				series, blockStart.Add(time.Duration(j) * time.Minute), float64(j), xtime.Second, nil})
+		}
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		snapshotData, unmergedShard := testMergeShardInputs(
+			b, opts, blockSize, snapshotValues, commitLogValues)
+		b.StartTimer()
+
+		src.mergeShardCommitLogEncodersAndSnapshots(0, snapshotData, unmergedShard, blockSize, nil)
+	}
+}
+
+// BenchmarkMergeShardPooledSnapshotSeries takes the IDs and tags of the
+// snapshot series from the identifier pool, as reading a snapshot file does,
+// so the allocations per op include those of the snapshot series that aren't
+// returned to the pool once they've been merged.
+func BenchmarkMergeShardPooledSnapshotSeries(b *testing.B) {
+	var (
+		opts            = testOptions()
+		src             = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		blopts          = opts.ResultOptions().DatabaseBlockOptions()
+		idPool          = opts.CommitLogOptions().IdentifierPool()
+		blockSize       = 2 * time.Hour
+		blockStart      = time.Now().Truncate(blockSize).Add(-blockSize)
+		seriesNames     []string
+		snapshotBytes   [][]byte
+		commitLogValues []testValue
+	)
+
+	for i := 0; i < 1000; i++ {
+		var (
+			name   = fmt.Sprintf("series-%d", i)
+			series = commitlog.Series{
+				Namespace: testNamespaceID,
+				Shard:     0,
+				ID:        ident.StringID(name),
+			}
+			snapshotValues []testValue
+		)
+		for j := 0; j < 10; j++ {
+			snapshotValues = append(snapshotValues, testValue{
+				series, blockStart.Add(time.Duration(j) * time.Second), float64(j), xtime.Second, nil})
+			commitLogValues = append(commitLogValues, testValue{
+				series, blockStart.Add(time.Duration(j) * time.Minute), float64(j), xtime.Second, nil})
+		}
+		seriesNames = append(seriesNames, name)
+		snapshotBytes = append(snapshotBytes, testEncodeValues(b, snapshotValues))
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		snapshotData, unmergedShard := testMergeShardInputs(
+			b, opts, blockSize, nil, commitLogValues)
+		b.StartTimer()
+
+		for j, name := range seriesNames {
+			tags := idPool.Tags()
+			tags.Append(idPool.StringTag("name", name))
+			snapshotData.AddBlock(idPool.StringID(name), tags, block.NewDatabaseBlock(
+				blockStart, blockSize, ts.NewSegment(checked.NewBytes(snapshotBytes[j], nil), nil, ts.FinalizeHead), blopts))
+		}
+		shardResult, _, _ := src.mergeShardCommitLogEncodersAndSnapshots(
+			0, snapshotData, unmergedShard, blockSize, nil)
+
+		b.StopTimer()
+		shardResult.Close()
+		b.StartTimer()
+	}
+}
+
+func BenchmarkMergeShardDisjointCommitLogAndSnapshotSeries(b *testing.B) {
+	var (
+		opts            = testOptions()
+		src             = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		blockSize       = 2 * time.Hour
+		blockStart      = time.Now().Truncate(blockSize).Add(-blockSize)
+		snapshotValues  []testValue
+		commitLogValues []testValue
+	)
+
+	// Series only present in one of the snapshot or the commit log are the ones
+	// that would grow the shard result if it were sized from either alone.
+	for i := 0; i < 1000; i++ {
+		snapshotValues = append(snapshotValues, testValue{
+			commitlog.Series{Namespace: testNamespaceID, ID: ident.StringID(fmt.Sprintf("snapshot-%d", i))},
+			blockStart, float64(i), xtime.Second, nil})
+		commitLogValues = append(commitLogValues, testValue{
+			commitlog.Series{Namespace: testNamespaceID, ID: ident.StringID(fmt.Sprintf("commitlog-%d", i))},
+			blockStart, float64(i), xtime.Second, nil})
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		snapshotData, unmergedShard := testMergeShardInputs(
+			b, opts, blockSize, snapshotValues, commitLogValues)
+		b.StartTimer()
+
+		src.mergeShardCommitLogEncodersAndSnapshots(0, snapshotData, unmergedShard, blockSize, nil)
+	}
+}
+
+func testMergeShardMultiBlockValues(
+	numSeries int,
+	numBlocks int,
+	blockSize time.Duration,
+	blockStart time.Time,
+) ([]testValue, []testValue) {
+	var snapshotValues, commitLogValues []testValue
+	for i := 0; i < numSeries; i++ {
+		series := commitlog.Series{
+			Namespace: testNamespaceID,
+			Shard:     0,
+			ID:        ident.StringID(fmt.Sprintf("series-%d", i)),
+		}
+		for j := 0; j < numBlocks; j++ {
+			start := blockStart.Add(time.Duration(j) * blockSize)
+			for k := 0; k < 10; k++ {
+				snapshotValues = append(snapshotValues, testValue{
+					series, start.Add(time.Duration(k) * time.Second), float64(k), xtime.Second, nil})
+				commitLogValues = append(commitLogValues, testValue{
+					series, start.Add(time.Duration(k) * time.Minute), float64(k), xtime.Second, nil})
+			}
+		}
+	}
+	return snapshotValues, commitLogValues
+}
+
+func TestMergeShardConcurrentlyMatchesSerial(t *testing.T) {
+	var (
+		blockSize  = 2 * time.Hour
+		blockStart = time.Now().Truncate(blockSize).Add(-4 * blockSize)
+
+		snapshotValues, commitLogValues = testMergeShardMultiBlockValues(
+			50, 4, blockSize, blockStart)
+	)
+
+	var expectedValues []testValue
+	expectedValues = append(expectedValues, snapshotValues...)
+	expectedValues = append(expectedValues, commitLogValues...)
+
+	for _, concurrency := range []int{1, 8} {
+		var (
+			opts = testOptions().SetMergeSeriesConcurrency(concurrency)
+			src  = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+
+			snapshotData, unmergedShard = testMergeShardInputs(
+				t, opts, blockSize, snapshotValues, commitLogValues)
+		)
+
+		shardResult, stats, mergeErrs := src.mergeShardCommitLogEncodersAndSnapshots(
+			0, snapshotData, unmergedShard, blockSize, nil)
+		require.Empty(t, mergeErrs)
+		require.Equal(t, 0, stats.numErrs)
+		require.Equal(t, len(expectedValues), stats.numDatapoints)
+		require.NoError(t, verifyShardResultsAreCorrect(
+			expectedValues, blockSize, result.ShardResults{0: shardResult}, opts))
+	}
+}
+
+func BenchmarkMergeShardSeriesConcurrency(b *testing.B) {
+	var (
+		blockSize  = 2 * time.Hour
+		blockStart = time.Now().Truncate(blockSize).Add(-4 * blockSize)
+
+		snapshotValues, commitLogValues = testMergeShardMultiBlockValues(
+			1000, 4, blockSize, blockStart)
+	)
+
+	for _, concurrency := range []int{1, 4, 8} {
+		b.Run(fmt.Sprintf("concurrency-%d", concurrency), func(b *testing.B) {
+			var (
+				opts = testOptions().SetMergeSeriesConcurrency(concurrency)
+				src  = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+			)
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				snapshotData, unmergedShard := testMergeShardInputs(
+					b, opts, blockSize, snapshotValues, commitLogValues)
+				b.StartTimer()
+
+				src.mergeShardCommitLogEncodersAndSnapshots(0, snapshotData, unmergedShard, blockSize, nil)
+			}
+		})
+	}
+}
+
+func testMergeAllShardsInputs(
+	t testing.TB,
+	opts Options,
+	blockSize time.Duration,
+	blockStart time.Time,
+	numShards int,
+	numSeries int,
+) (result.ShardTimeRanges, map[uint32]*shardData, []testValue) {
+	var (
+		ranges           = xtime.Ranges{}.AddRange(xtime.Range{Start: blockStart, End: blockStart.Add(blockSize)})
+		shardsTimeRanges = result.ShardTimeRanges{}
+		unmerged         = make(map[uint32]*shardData, numShards)
+		values           []testValue
+	)
+	for shard := uint32(0); shard < uint32(numShards); shard++ {
+		var shardValues []testValue
+		for i := 0; i < numSeries; i++ {
+			series := commitlog.Series{
+				Namespace: testNamespaceID,
+				Shard:     shard,
+				ID:        ident.StringID(fmt.Sprintf("series-%d-%d", shard, i)),
+			}
+			shardValues = append(shardValues, testValue{
+				series, blockStart.Add(time.Duration(i) * time.Second), float64(i), xtime.Second, nil})
+		}
+		_, unmergedShard := testMergeShardInputs(t, opts, blockSize, nil, shardValues)
+		shardsTimeRanges[shard] = ranges
+		unmerged[shard] = &unmergedShard
+		values = append(values, shardValues...)
+	}
+	return shardsTimeRanges, unmerged, values
+}
+
+func TestMergeAllShardsAssemblesResultOfEveryShard(t *testing.T) {
+	var (
+		md         = testNsMetadata(t)
+		blockSize  = md.Options().RetentionOptions().BlockSize()
+		blockStart = time.Now().Truncate(blockSize).Add(-blockSize)
+	)
+
+	for _, concurrency := range []int{1, 8} {
+		var (
+			opts = testOptions().SetMergeShardsConcurrency(concurrency)
+			src  = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+
+			shardsTimeRanges, unmerged, values = testMergeAllShardsInputs(
+				t, opts, blockSize, blockStart, 32, 4)
+		)
+
+		res, err := src.mergeAllShardsCommitLogEncodersAndSnapshots(
+			md, shardsTimeRanges, nil, nil, blockSize, unmerged, nil)
+		require.NoError(t, err)
+		require.True(t, res.Unfulfilled().IsEmpty())
+		require.NoError(t, verifyShardResultsAreCorrect(
+			values, blockSize, res.ShardResults(), opts))
+	}
+}
+
+func BenchmarkMergeAllShardsCommitLogEncodersAndSnapshots(b *testing.B) {
+	var (
+		opts = testOptions().SetMergeShardsConcurrency(8)
+		src  = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+	)
+
+	md, err := namespace.NewMetadata(testNamespaceID, namespace.NewOptions())
+	require.NoError(b, err)
+
+	var (
+		blockSize  = md.Options().RetentionOptions().BlockSize()
+		blockStart = time.Now().Truncate(blockSize).Add(-blockSize)
+	)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		shardsTimeRanges, unmerged, _ := testMergeAllShardsInputs(
+			b, opts, blockSize, blockStart, 1024, 2)
+		b.StartTimer()
+
+		src.mergeAllShardsCommitLogEncodersAndSnapshots(
+			md, shardsTimeRanges, nil, nil, blockSize, unmerged, nil)
+	}
+}
+
+func TestMergedSeriesCapacity(t *testing.T) {
+	var (
+		opts       = testOptions()
+		src        = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		blockSize  = 2 * time.Hour
+		blockStart = time.Now().Truncate(blockSize).Add(-blockSize)
+		foo        = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		bar        = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("bar")}
+		baz        = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("baz")}
+		qux        = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("qux")}
+
+		snapshotValues = []testValue{
+			{foo, blockStart.Add(time.Minute), 1.0, xtime.Second, nil},
+			{bar, blockStart.Add(time.Minute), 2.0, xtime.Second, nil},
+		}
+		commitLogValues = []testValue{
+			{foo, blockStart.Add(2 * time.Minute), 3.0, xtime.Second, nil},
+			{baz, blockStart.Add(2 * time.Minute), 4.0, xtime.Second, nil},
+			{qux, blockStart.Add(2 * time.Minute), 5.0, xtime.Second, nil},
+		}
+	)
+
+	snapshotData, unmergedShard := testMergeShardInputs(
+		t, opts, blockSize, snapshotValues, commitLogValues)
+	require.Equal(t, 4, mergedSeriesCapacity(snapshotData, unmergedShard))
+	require.Equal(t, 2, mergedSeriesCapacity(snapshotData, shardData{}))
+
+	shardResult, stats, _ := src.mergeShardCommitLogEncodersAndSnapshots(
+		0, snapshotData, unmergedShard, blockSize, nil)
+	require.Equal(t, 0, stats.numEmptyErrs)
+	require.Equal(t, 0, stats.numErrs)
+	require.Equal(t, int64(4), shardResult.NumSeries())
+
+	expectedValues := append([]testValue{}, snapshotValues...)
+	expectedValues = append(expectedValues, commitLogValues...)
+	require.NoError(t, verifyShardResultsAreCorrect(
+		expectedValues, blockSize, result.ShardResults{0: shardResult}, opts))
+}
+
+func TestLogMergeShardsOutcomeSamplesFailedSeries(t *testing.T) {
+	var (
+		opts       = testOptions()
+		src        = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		blopts     = opts.ResultOptions().DatabaseBlockOptions()
+		blockSize  = 2 * time.Hour
+		blockStart = time.Now().Truncate(blockSize).Add(-blockSize)
+		foo        = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		bar        = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("bar")}
+
+		commitLogValues = []testValue{
+			{foo, blockStart.Add(time.Minute), 1.0, xtime.Second, nil},
+			{bar, blockStart.Add(time.Minute), 2.0, xtime.Second, nil},
+		}
+	)
+
+	snapshotData, unmergedShard := testMergeShardInputs(
+		t, opts, blockSize, nil, commitLogValues)
+	// A truncated snapshot block fails to decode when merged with the commit log.
+	snapshotData.AddBlock(foo.ID, ident.Tags{}, block.NewDatabaseBlock(
+		blockStart, blockSize, ts.NewSegment(checked.NewBytes([]byte{0x1}, nil), nil, ts.FinalizeNone), blopts))
+
+	_, stats, mergeErrs := src.mergeShardCommitLogEncodersAndSnapshots(
+		0, snapshotData, unmergedShard, blockSize, nil)
+	require.Equal(t, 0, stats.numEmptyErrs)
+	require.Equal(t, 1, stats.numErrs)
+	require.Equal(t, 1, len(mergeErrs))
+	require.Equal(t, uint32(0), mergeErrs[0].shard)
+	require.True(t, foo.ID.Equal(mergeErrs[0].id))
+	require.Error(t, mergeErrs[0].err)
+
+	var buf bytes.Buffer
+	src.log = xlog.NewLogger(&buf)
+	src.logMergeShardsOutcome([]mergeStats{stats}, [][]mergeSeriesError{mergeErrs})
+	require.Contains(t, buf.String(), "error merging series from commit log")
+	require.Contains(t, buf.String(), "foo")
+	require.NotContains(t, buf.String(), "bar")
+}
+
+func TestEmptyMergedBlocksAreOnlyErrorsWhenEnabled(t *testing.T) {
+	var (
+		blockSize  = 2 * time.Hour
+		blockStart = time.Now().Truncate(blockSize).Add(-blockSize)
+		foo        = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+		bar        = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("bar")}
+
+		commitLogValues = []testValue{{foo, blockStart.Add(time.Minute), 1.0, xtime.Second, nil}}
+	)
+
+	for _, emptyAreErrors := range []bool{false, true} {
+		var (
+			scope = tally.NewTestScope("", nil)
+			opts  = testOptions().SetEmptyMergedBlocksAreErrors(emptyAreErrors)
+			buf   bytes.Buffer
+		)
+		opts = opts.SetResultOptions(opts.ResultOptions().SetInstrumentOptions(
+			opts.ResultOptions().InstrumentOptions().SetMetricsScope(scope)))
+		src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		src.log = xlog.NewLogger(&buf)
+
+		snapshotData, unmergedShard := testMergeShardInputs(
+			t, opts, blockSize, nil, commitLogValues)
+		// A block without any encoders merges into a block without any datapoints.
+		unmergedShard.series.Set(bar.ID, metadataAndEncodersByTime{
+			id: bar.ID,
+			encoders: map[xtime.UnixNano][]encoder{
+				xtime.ToUnixNano(blockStart): nil,
+			},
+		})
+
+		shardResult, stats, _ := src.mergeShardCommitLogEncodersAndSnapshots(
+			0, snapshotData, unmergedShard, blockSize, nil)
+		require.Equal(t, 1, stats.numEmptyErrs)
+		require.Equal(t, 0, stats.numErrs)
+		require.NoError(t, verifyShardResultsAreCorrect(
+			commitLogValues, blockSize, result.ShardResults{0: shardResult}, opts))
+
+		src.logMergeShardsOutcome([]mergeStats{stats}, nil)
+		counters := scope.Snapshot().Counters()
+		if emptyAreErrors {
+			require.Contains(t, buf.String(), "empty unmerged blocks errors")
+			require.Equal(t, int64(1), counters["bootstrap.commitlog.merge-errors+"].Value())
+		} else {
+			require.NotContains(t, buf.String(), "error")
+			require.Equal(t, int64(1), counters["bootstrap.commitlog.empty-merged-blocks+"].Value())
+		}
+	}
+}
+
+// testMergeShardInputs builds the snapshot data and unmerged commit log encoders
+// for a single shard, values for each series must be in order.
+func testMergeShardInputs(
+	t testing.TB,
+	opts Options,
+	blockSize time.Duration,
+	snapshotValues []testValue,
+	commitLogValues []testValue,
+) (result.ShardResult, shardData) {
+	var (
+		blopts        = opts.ResultOptions().DatabaseBlockOptions()
+		snapshotData  = result.NewShardResult(0, opts.ResultOptions())
+		unmergedShard = shardData{series: NewMap(MapOptions{})}
+		valuesByKey   = make(map[string][]testValue)
+		order         []string
+	)
+
+	// Snapshot values are encoded into one block per series and block start.
+	for _, v := range snapshotValues {
+		key := fmt.Sprintf("%s-%d", v.s.ID.String(), v.t.Truncate(blockSize).UnixNano())
+		if _, ok := valuesByKey[key]; !ok {
+			order = append(order, key)
+		}
+		valuesByKey[key] = append(valuesByKey[key], v)
+	}
+	for _, key := range order {
+		values := valuesByKey[key]
+		blockStart := values[0].t.Truncate(blockSize)
+		bytes := testEncodeValues(t, values)
+		snapshotData.AddBlock(values[0].s.ID, ident.Tags{}, block.NewDatabaseBlock(
+			blockStart, blockSize, ts.NewSegment(checked.NewBytes(bytes, nil), nil, ts.FinalizeHead), blopts))
+	}
+
+	for _, v := range commitLogValues {
+		blockStart := xtime.ToUnixNano(v.t.Truncate(blockSize))
+		series, ok := unmergedShard.series.Get(v.s.ID)
+		if !ok {
+			series = metadataAndEncodersByTime{
+				id:       v.s.ID,
+				encoders: make(map[xtime.UnixNano][]encoder),
+			}
+			unmergedShard.series.Set(v.s.ID, series)
+		}
+
+		encoders := series.encoders[blockStart]
+		if len(encoders) == 0 {
+			enc := blopts.EncoderPool().Get()
+			enc.Reset(blockStart.ToTime(), 0)
+			encoders = append(encoders, encoder{enc: enc})
+		}
+		require.NoError(t, encoders[0].enc.Encode(ts.Datapoint{Timestamp: v.t, Value: v.v}, v.u, v.a))
+		encoders[0].lastWriteAt = v.t
+		series.encoders[blockStart] = encoders
+	}
+
+	return snapshotData, unmergedShard
+}
+
+func TestPlanListsOverlappingCommitLogFiles(t *testing.T) {
+	var (
+		opts         = testOptions()
+		md           = testNsMetadata(t)
+		rOpts        = md.Options().RetentionOptions()
+		blockSize    = rOpts.BlockSize()
+		bufferPast   = rOpts.BufferPast()
+		bufferFuture = rOpts.BufferFuture()
+		start        = time.Now().Truncate(blockSize).Add(-blockSize)
+		end          = start.Add(blockSize)
+		logSize      = 10 * time.Minute
+	)
+
+	commitLogFiles := []commitlog.File{
+		// Ends before the start of the block less buffer future.
+		{FilePath: "before", Start: start.Add(-bufferFuture - logSize), Duration: logSize},
+		{FilePath: "block-start", Start: start.Add(-bufferFuture), Duration: logSize},
+		{FilePath: "block-end", Start: end, Duration: logSize},
+		// Starts after the end of the block plus buffer past.
+		{FilePath: "after", Start: end.Add(bufferPast), Duration: logSize},
+		// Overlaps but was not present when the node started.
+		{FilePath: "active", Start: start, Duration: logSize},
+	}
+
+	var present []string
+	for _, f := range commitLogFiles {
+		if f.FilePath != "active" {
+			present = append(present, f.FilePath)
+		}
+	}
+
+	src, err := NewCommitLogSource(opts, fs.Inspection{SortedCommitLogFiles: present})
+	require.NoError(t, err)
+
+	s := src.(*commitLogSource)
+	s.snapshotFilesFn = func(_ string, _ ident.ID, _ uint32) (fs.FileSetFilesSlice, error) {
+		return nil, nil
+	}
+	s.commitLogFilesFn = func(_ commitlog.Options) ([]commitlog.File, error) {
+		return commitLogFiles, nil
+	}
+
+	ranges := xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+	plan, err := src.Plan(md, result.ShardTimeRanges{0: ranges, 1: ranges})
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"block-start", "block-end"}, plan.CommitLogFiles)
+	require.Equal(t, []xtime.Range{{
+		Start: start.Add(-bufferFuture),
+		End:   end.Add(bufferPast),
+	}}, plan.RangesToCheck)
+
+	byShard, ok := plan.MostRecentSnapshotByBlockShard[xtime.ToUnixNano(start)]
+	require.True(t, ok)
+	require.Equal(t, 2, len(byShard))
+	for _, snapshot := range byShard {
+		require.True(t, snapshot.CachedSnapshotTime.Equal(start))
+	}
+}
+
+func TestReadErrorsWhenTooManyCommitLogFilesSelected(t *testing.T) {
+	var (
+		md        = testNsMetadata(t)
+		blockSize = md.Options().RetentionOptions().BlockSize()
+		start     = time.Now().Truncate(blockSize).Add(-blockSize)
+		ranges    = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: start.Add(blockSize)})
+		logSize   = 10 * time.Minute
+
+		commitLogFiles = []commitlog.File{
+			{FilePath: "first", Start: start, Duration: logSize},
+			{FilePath: "second", Start: start.Add(logSize), Duration: logSize},
+			{FilePath: "third", Start: start.Add(2 * logSize), Duration: logSize},
+		}
+		inspection = fs.Inspection{SortedCommitLogFiles: []string{"first", "second", "third"}}
+	)
+
+	newSource := func(maxFiles int) *commitLogSource {
+		opts := testOptions().SetMaxCommitLogFilesToRead(maxFiles)
+		src := newCommitLogSource(opts, inspection).(*commitLogSource)
+		src.snapshotFilesFn = func(_ string, _ ident.ID, _ uint32) (fs.FileSetFilesSlice, error) {
+			return nil, nil
+		}
+		src.commitLogFilesFn = func(_ commitlog.Options) ([]commitlog.File, error) {
+			return commitLogFiles, nil
+		}
+		src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+			return newTestCommitLogIterator(nil, nil), nil
+		}
+		return src
+	}
+
+	// Every file overlaps the block so the read fails without reading any of them.
+	src := newSource(2)
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		require.FailNow(t, "no commit log files should be read")
+		return nil, nil
+	}
+	_, err := src.ReadData(md, result.ShardTimeRanges{0: ranges}, testDefaultRunOpts)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "3 commit log files")
+	require.Contains(t, err.Error(), "MaxCommitLogFilesToRead of 2")
+
+	res, err := newSource(3).ReadData(md, result.ShardTimeRanges{0: ranges}, testDefaultRunOpts)
+	require.NoError(t, err)
+	require.True(t, res.Unfulfilled().IsEmpty())
+}
+
+func TestReadReportsOverlappingCommitLogFiles(t *testing.T) {
+	var (
+		scope     = tally.NewTestScope("", nil)
+		md        = testNsMetadata(t)
+		blockSize = md.Options().RetentionOptions().BlockSize()
+		start     = time.Now().Truncate(blockSize).Add(-blockSize)
+		ranges    = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: start.Add(blockSize)})
+		logSize   = 10 * time.Minute
+
+		commitLogFiles = []commitlog.File{
+			{FilePath: "first", Start: start, Duration: logSize, Index: 0},
+			// Written again after a restart, covering part of the first file.
+			{FilePath: "restarted", Start: start.Add(logSize / 2), Duration: logSize, Index: 1},
+			{FilePath: "second", Start: start.Add(2 * logSize), Duration: logSize, Index: 2},
+		}
+		inspection = fs.Inspection{SortedCommitLogFiles: []string{"first", "restarted", "second"}}
+		opts       = testOptions().SetDetectOverlappingCommitLogFiles(true)
+		buf        bytes.Buffer
+	)
+
+	opts = opts.SetResultOptions(opts.ResultOptions().SetInstrumentOptions(
+		opts.ResultOptions().InstrumentOptions().SetMetricsScope(scope)))
+	src := newCommitLogSource(opts, inspection).(*commitLogSource)
+	src.log = xlog.NewLogger(&buf)
+	src.snapshotFilesFn = func(_ string, _ ident.ID, _ uint32) (fs.FileSetFilesSlice, error) {
+		return nil, nil
+	}
+	src.commitLogFilesFn = func(_ commitlog.Options) ([]commitlog.File, error) {
+		return commitLogFiles, nil
+	}
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		return newTestCommitLogIterator(nil, nil), nil
+	}
+
+	_, err := src.ReadData(md, result.ShardTimeRanges{0: ranges}, testDefaultRunOpts)
+	require.NoError(t, err)
+
+	counters := scope.Snapshot().Counters()
+	require.Equal(t, int64(1), counters["bootstrap.commitlog.commitlog-files-overlapping+"].Value())
+	require.Equal(t, 1, strings.Count(buf.String(), "commit log file overlaps with another selected file"))
+	require.Contains(t, buf.String(), "restarted")
+
+	require.Equal(t, []commitLogFileOverlap{
+		{first: commitLogFiles[0], second: commitLogFiles[1]},
+	}, overlappingCommitLogFiles(commitLogFiles))
+	require.Equal(t, 0, len(overlappingCommitLogFiles([]commitlog.File{
+		commitLogFiles[0], commitLogFiles[2],
+	})))
+}
+
+func TestEstimateCostMatchesFileSizes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "estimate-cost")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var (
+		opts      = testOptions()
+		md        = testNsMetadata(t)
+		blockSize = md.Options().RetentionOptions().BlockSize()
+		start     = time.Now().Truncate(blockSize).Add(-blockSize)
+		end       = start.Add(blockSize)
+		ranges    = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+	)
+
+	writeFile := func(name string, size int) string {
+		filePath := filepath.Join(dir, name)
+		require.NoError(t, ioutil.WriteFile(filePath, make([]byte, size), 0644))
+		return filePath
+	}
+
+	var (
+		readLog    = writeFile("commitlog-read", 1000)
+		skippedLog = writeFile("commitlog-skipped", 2000)
+		dataFile   = writeFile("snapshot-data", 300)
+		checkpoint = writeFile("snapshot-checkpoint", 20)
+	)
+
+	src, err := NewCommitLogSource(opts, fs.Inspection{
+		SortedCommitLogFiles: []string{readLog, skippedLog},
+	})
+	require.NoError(t, err)
+
+	s := src.(*commitLogSource)
+	s.commitLogFilesFn = func(_ commitlog.Options) ([]commitlog.File, error) {
+		return []commitlog.File{
+			{FilePath: readLog, Start: start, Duration: time.Minute},
+			// Starts well after the end of the block so it isn't read.
+			{FilePath: skippedLog, Start: end.Add(blockSize), Duration: time.Minute},
+		}, nil
+	}
+	// Only shard 1 has a snapshot.
+	s.snapshotFilesFn = func(_ string, namespace ident.ID, shard uint32) (fs.FileSetFilesSlice, error) {
+		if shard != 1 {
+			return nil, nil
+		}
+		return fs.FileSetFilesSlice{
+			fs.FileSetFile{
+				ID: fs.FileSetFileIdentifier{
+					Namespace:  namespace,
+					BlockStart: start,
+					Shard:      shard,
+				},
+				AbsoluteFilepaths:  []string{dataFile, checkpoint},
+				CachedSnapshotTime: start.Add(time.Minute),
+			},
+		}, nil
+	}
+
+	estimate, err := src.EstimateCost(md, result.ShardTimeRanges{0: ranges, 1: ranges})
+	require.NoError(t, err)
+	require.Equal(t, BootstrapCostEstimate{
+		NumCommitLogFiles:   1,
+		CommitLogBytes:      1000,
+		NumSnapshotFileSets: 1,
+		SnapshotBytes:       320,
+	}, estimate)
+	require.Equal(t, int64(1320), estimate.BytesToRead())
+}
+
+func TestReadCommitLogPredUsesInspection(t *testing.T) {
+	var (
+		opts      = testOptions()
+		blockSize = 2 * time.Hour
+		start     = time.Now().Truncate(blockSize).Add(-blockSize)
+		ranges    = []xtime.Range{{Start: start, End: start.Add(blockSize)}}
+		present   = commitlog.File{FilePath: "present", Start: start, Duration: time.Minute}
+		absent    = commitlog.File{FilePath: "absent", Start: start, Duration: time.Minute}
+	)
+
+	_, err := NewCommitLogSource(opts, nil)
+	require.Equal(t, errInspectionNotSet, err)
+
+	for _, inspection := range []fs.CommitLogFilesInspection{
+		testCommitLogFilesInspection{"present": struct{}{}},
+		fs.NewInspection([]string{"present"}),
+	} {
+		src, err := NewCommitLogSource(opts, inspection)
+		require.NoError(t, err)
+
+		pred := src.(*commitLogSource).newReadCommitLogPred(ranges, nil)
+		require.True(t, pred(present))
+		// Files created after the node started are never read.
+		require.False(t, pred(absent))
+	}
+}
+
+func TestShouldReadCommitLogFile(t *testing.T) {
+	var (
+		rOpts      = testNsMetadata(t).Options().RetentionOptions()
+		blockSize  = rOpts.BlockSize()
+		blockStart = time.Now().Truncate(blockSize).Add(-blockSize)
+		known      = map[string]struct{}{"known": struct{}{}}
+		// The range for a block with a snapshot at its start extends back by
+		// buffer future and forward to the end of the block plus buffer past.
+		bufferedRange = commitLogRangeToCheck(rOpts, blockStart, blockStart)
+		blockRange    = xtime.Range{Start: blockStart, End: blockStart.Add(blockSize)}
+	)
+
+	tests := []struct {
+		name     string
+		path     string
+		start    time.Time
+		duration time.Duration
+		ranges   []xtime.Range
+		expected bool
+	}{
+		{
+			name:     "inside range",
+			path:     "known",
+			start:    blockStart.Add(time.Minute),
+			duration: time.Minute,
+			ranges:   []xtime.Range{blockRange},
+			expected: true,
+		},
+		{
+			name:     "overlaps range start",
+			path:     "known",
+			start:    blockStart.Add(-time.Minute),
+			duration: 2 * time.Minute,
+			ranges:   []xtime.Range{blockRange},
+			expected: true,
+		},
+		{
+			name:     "ends at range start",
+			path:     "known",
+			start:    blockStart.Add(-time.Minute),
+			duration: time.Minute,
+			ranges:   []xtime.Range{blockRange},
+			expected: false,
+		},
+		{
+			name:     "starts at range end",
+			path:     "known",
+			start:    blockRange.End,
+			duration: time.Minute,
+			ranges:   []xtime.Range{blockRange},
+			expected: false,
+		},
+		{
+			name:     "overlaps second range",
+			path:     "known",
+			start:    blockRange.End.Add(time.Hour),
+			duration: time.Minute,
+			ranges: []xtime.Range{
+				blockRange,
+				{Start: blockRange.End.Add(time.Hour), End: blockRange.End.Add(2 * time.Hour)},
+			},
+			expected: true,
+		},
+		{
+			name:     "unknown file",
+			path:     "unknown",
+			start:    blockStart.Add(time.Minute),
+			duration: time.Minute,
+			ranges:   []xtime.Range{blockRange},
+			expected: false,
+		},
+		{
+			name:     "no ranges",
+			path:     "known",
+			start:    blockStart.Add(time.Minute),
+			duration: time.Minute,
+			expected: false,
+		},
+		{
+			name:     "within buffer future",
+			path:     "known",
+			start:    blockStart.Add(-rOpts.BufferFuture()),
+			duration: time.Minute,
+			ranges:   []xtime.Range{bufferedRange},
+			expected: true,
+		},
+		{
+			name:     "ends at buffer future",
+			path:     "known",
+			start:    blockStart.Add(-rOpts.BufferFuture()).Add(-time.Minute),
+			duration: time.Minute,
+			ranges:   []xtime.Range{bufferedRange},
+			expected: false,
+		},
+		{
+			name:     "within buffer past",
+			path:     "known",
+			start:    blockRange.End.Add(rOpts.BufferPast()).Add(-time.Minute),
+			duration: time.Minute,
+			ranges:   []xtime.Range{bufferedRange},
+			expected: true,
+		},
+		{
+			name:     "starts at buffer past",
+			path:     "known",
+			start:    blockRange.End.Add(rOpts.BufferPast()),
+			duration: time.Minute,
+			ranges:   []xtime.Range{bufferedRange},
+			expected: false,
+		},
+	}
+
+	for _, test := range tests {
+		f := commitlog.File{FilePath: test.path, Start: test.start, Duration: test.duration}
+		require.Equal(t, test.expected, ShouldReadCommitLogFile(f, test.ranges, known), test.name)
+	}
+}
+
+func TestMinimumMostRecentSnapshotTimeByBlockWithMixedShards(t *testing.T) {
+	var (
+		opts      = testOptions()
+		src       = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		blockSize = 2 * time.Hour
+		start     = time.Now().Truncate(blockSize).Add(-blockSize)
+		end       = start.Add(blockSize)
+		ranges    = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		snapshot  = func(snapshotTime time.Time) fs.FileSetFile {
+			return fs.FileSetFile{
+				AbsoluteFilepaths:  []string{"checkpoint"},
+				CachedSnapshotTime: snapshotTime,
+			}
+		}
+	)
+
+	tests := []struct {
+		name     string
+		byShard  map[uint32]fs.FileSetFile
+		expected time.Time
+	}{
+		{
+			name: "snapshot at block end and no snapshot",
+			byShard: map[uint32]fs.FileSetFile{
+				0: snapshot(end),
+				1: {CachedSnapshotTime: start},
+			},
+			expected: start,
+		},
+		{
+			name: "recent snapshots and no snapshot",
+			byShard: map[uint32]fs.FileSetFile{
+				0: snapshot(start.Add(time.Hour)),
+				1: {CachedSnapshotTime: start},
+				2: snapshot(end.Add(time.Minute)),
+			},
+			expected: start,
+		},
+		{
+			name: "recent snapshots only",
+			byShard: map[uint32]fs.FileSetFile{
+				0: snapshot(start.Add(time.Hour)),
+				1: snapshot(end),
+			},
+			expected: start.Add(time.Hour),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			shardsTimeRanges := result.ShardTimeRanges{}
+			for shard := range test.byShard {
+				shardsTimeRanges[shard] = ranges
+			}
+
+			minByBlock := src.minimumMostRecentSnapshotTimeByBlock(
+				shardsTimeRanges, blockSize, map[xtime.UnixNano]map[uint32]fs.FileSetFile{
+					xtime.ToUnixNano(start): test.byShard,
+				})
+			require.Equal(t, 1, len(minByBlock))
+			require.True(t, test.expected.Equal(minByBlock[xtime.ToUnixNano(start)]))
+		})
+	}
+}
+
+func TestPlanReadsFullBlockWhenAnyShardHasNoSnapshot(t *testing.T) {
+	var (
+		opts         = testOptions()
+		md           = testNsMetadata(t)
+		rOpts        = md.Options().RetentionOptions()
+		blockSize    = rOpts.BlockSize()
+		bufferPast   = rOpts.BufferPast()
+		bufferFuture = rOpts.BufferFuture()
+		start        = time.Now().Truncate(blockSize).Add(-blockSize)
+		end          = start.Add(blockSize)
+		ranges       = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+	)
+
+	src, err := NewCommitLogSource(opts, fs.Inspection{})
+	require.NoError(t, err)
+
+	s := src.(*commitLogSource)
+	s.snapshotFilesFn = func(_ string, _ ident.ID, shard uint32) (fs.FileSetFilesSlice, error) {
+		if shard != 0 {
+			return nil, nil
+		}
+		// Only shard 0 has a snapshot which was taken at the end of the block.
+		return fs.FileSetFilesSlice{
+			fs.FileSetFile{
+				ID: fs.FileSetFileIdentifier{
+					Namespace:  testNamespaceID,
+					BlockStart: start,
+					Shard:      0,
+				},
+				AbsoluteFilepaths:  []string{"checkpoint"},
+				CachedSnapshotTime: end,
+			},
+		}, nil
+	}
+	s.commitLogFilesFn = func(_ commitlog.Options) ([]commitlog.File, error) {
+		return nil, nil
 	}
 
-	targetRanges := result.ShardTimeRanges{0: ranges, 1: ranges}
-	res, err := src.ReadData(md, targetRanges, testDefaultRunOpts)
+	plan, err := src.Plan(md, result.ShardTimeRanges{0: ranges, 1: ranges})
 	require.NoError(t, err)
-	require.NotNil(t, res)
-	require.Equal(t, 1, len(res.ShardResults()))
-	require.Equal(t, 0, len(res.Unfulfilled()))
-	require.NoError(t, verifyShardResultsAreCorrect(
-		values, blockSize, res.ShardResults(), opts))
-}
 
-// TestReadHandlesDifferentSeriesWithIdenticalUniqueIndex was added as a regression test to make
-// sure that the commit log bootstrapper does not make any assumptions about series having a unique
-// unique index because that only holds for the duration that an M3DB node is on, but commit log
-// files can span multiple M3DB processes which means that unique indexes could be re-used for multiple
-// different series.
-func TestReadHandlesDifferentSeriesWithIdenticalUniqueIndex(t *testing.T) {
-	opts := testOptions()
-	md := testNsMetadata(t)
-	src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+	byShard := plan.MostRecentSnapshotByBlockShard[xtime.ToUnixNano(start)]
+	require.True(t, byShard[0].CachedSnapshotTime.Equal(end))
+	require.True(t, byShard[1].CachedSnapshotTime.Equal(start))
 
-	blockSize := md.Options().RetentionOptions().BlockSize()
-	now := time.Now()
-	start := now.Truncate(blockSize).Add(-blockSize)
-	end := now.Truncate(blockSize)
+	// The shard without a snapshot requires the entire block to be read.
+	require.Equal(t, []xtime.Range{{
+		Start: start.Add(-bufferFuture),
+		End:   end.Add(bufferPast),
+	}}, plan.RangesToCheck)
+}
 
-	require.True(t, blockSize >= minCommitLogRetention)
-	ranges := xtime.Ranges{}
-	ranges = ranges.AddRange(xtime.Range{
-		Start: start,
-		End:   end,
-	})
+func TestPlanSnapshotTimesReportFallbacks(t *testing.T) {
+	var (
+		opts      = testOptions()
+		md        = testNsMetadata(t)
+		blockSize = md.Options().RetentionOptions().BlockSize()
+		start     = time.Now().Truncate(blockSize).Add(-blockSize)
+		end       = start.Add(blockSize)
+		ranges    = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		snapTime  = start.Add(time.Minute)
+	)
 
-	// All series need to be in the same shard to exercise the regression.
-	foo := commitlog.Series{
-		Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo"), UniqueIndex: 0}
-	bar := commitlog.Series{
-		Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("bar"), UniqueIndex: 0}
+	src, err := NewCommitLogSource(opts, fs.Inspection{})
+	require.NoError(t, err)
 
-	values := []testValue{
-		{foo, start, 1.0, xtime.Second, nil},
-		{bar, start, 2.0, xtime.Second, nil},
+	s := src.(*commitLogSource)
+	s.snapshotFilesFn = func(_ string, _ ident.ID, shard uint32) (fs.FileSetFilesSlice, error) {
+		if shard == 1 {
+			return nil, nil
+		}
+		// Shard 0 has a readable snapshot, shard 2 an unreadable one.
+		return fs.FileSetFilesSlice{
+			fs.FileSetFile{
+				ID: fs.FileSetFileIdentifier{
+					Namespace:  testNamespaceID,
+					BlockStart: start,
+					Shard:      shard,
+				},
+				AbsoluteFilepaths: []string{"checkpoint"},
+			},
+		}, nil
 	}
-	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
-		return newTestCommitLogIterator(values, nil), nil
+	s.snapshotTimeFn = func(f fs.FileSetFile, _ int) (time.Time, error) {
+		if f.ID.Shard == 2 {
+			return time.Time{}, fmt.Errorf("unreadable snapshot")
+		}
+		return snapTime, nil
+	}
+	s.commitLogFilesFn = func(_ commitlog.Options) ([]commitlog.File, error) {
+		return nil, nil
 	}
 
-	targetRanges := result.ShardTimeRanges{0: ranges, 1: ranges}
-	res, err := src.ReadData(md, targetRanges, testDefaultRunOpts)
+	plan, err := src.Plan(md, result.ShardTimeRanges{0: ranges, 1: ranges, 2: ranges})
 	require.NoError(t, err)
-	require.NotNil(t, res)
-	require.Equal(t, 1, len(res.ShardResults()))
-	require.Equal(t, 0, len(res.Unfulfilled()))
-	require.NoError(t, verifyShardResultsAreCorrect(
-		values, blockSize, res.ShardResults(), opts))
+
+	snapshotTimes := plan.SnapshotTimes()
+	require.Equal(t, 1, len(snapshotTimes))
+	byShard := snapshotTimes[xtime.ToUnixNano(start)]
+	require.Equal(t, 3, len(byShard))
+
+	require.True(t, byShard[0].SnapshotTime.Equal(snapTime))
+	require.False(t, byShard[0].FellBackToBlockStart)
+	for _, shard := range []uint32{1, 2} {
+		require.True(t, byShard[shard].SnapshotTime.Equal(start))
+		require.True(t, byShard[shard].FellBackToBlockStart)
+	}
 }
 
-func TestReadTrimsToRanges(t *testing.T) {
-	opts := testOptions()
-	md := testNsMetadata(t)
-	src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+func TestPlanReportsSnapshotIndexGaps(t *testing.T) {
+	var (
+		buf       bytes.Buffer
+		opts      = testOptions()
+		md        = testNsMetadata(t)
+		blockSize = md.Options().RetentionOptions().BlockSize()
+		start     = time.Now().Truncate(blockSize).Add(-blockSize)
+		ranges    = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: start.Add(blockSize)})
+		src       = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+	)
 
-	blockSize := md.Options().RetentionOptions().BlockSize()
-	now := time.Now()
-	start := now.Truncate(blockSize).Add(-blockSize)
-	end := now.Truncate(blockSize)
+	src.log = xlog.NewLogger(&buf)
+	src.snapshotFilesFn = func(_ string, namespace ident.ID, shard uint32) (fs.FileSetFilesSlice, error) {
+		// Shard 0 is missing index 1 and indices 3 and 4, shard 1 isn't missing any.
+		indices := []int{0, 2, 5}
+		if shard == 1 {
+			indices = []int{0, 1}
+		}
+		var files fs.FileSetFilesSlice
+		for _, index := range indices {
+			files = append(files, fs.FileSetFile{
+				ID: fs.FileSetFileIdentifier{
+					Namespace:   namespace,
+					BlockStart:  start,
+					Shard:       shard,
+					VolumeIndex: index,
+				},
+				AbsoluteFilepaths:  []string{"checkpoint"},
+				CachedSnapshotTime: start.Add(time.Duration(index+1) * time.Minute),
+			})
+		}
+		return files, nil
+	}
+	src.commitLogFilesFn = func(_ commitlog.Options) ([]commitlog.File, error) {
+		return nil, nil
+	}
 
-	// Request a little after the start of data, because always reading full blocks
-	// it should return the entire block beginning from "start"
-	require.True(t, blockSize >= minCommitLogRetention)
-	ranges := xtime.Ranges{}
-	ranges = ranges.AddRange(xtime.Range{
-		Start: start,
-		End:   end,
-	})
+	plan, err := src.Plan(md, result.ShardTimeRanges{0: ranges, 1: ranges})
+	require.NoError(t, err)
 
-	foo := commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
+	blockStart := xtime.ToUnixNano(start).ToTime()
+	require.Equal(t, []SnapshotIndexGap{
+		{Shard: 0, BlockStart: blockStart, FirstMissing: 1, LastMissing: 1},
+		{Shard: 0, BlockStart: blockStart, FirstMissing: 3, LastMissing: 4},
+	}, plan.SnapshotIndexGaps())
+	require.Equal(t, 2, strings.Count(buf.String(),
+		"snapshot volume indices for block are not contiguous"))
 
-	values := []testValue{
-		{foo, start.Add(-1 * time.Minute), 1.0, xtime.Nanosecond, nil},
-		{foo, start, 2.0, xtime.Nanosecond, nil},
-		{foo, start.Add(1 * time.Minute), 3.0, xtime.Nanosecond, nil},
-		{foo, end.Truncate(blockSize).Add(blockSize).Add(time.Nanosecond), 4.0, xtime.Nanosecond, nil},
+	// The gaps don't stop the most recent snapshot being used.
+	snapshotTimes := plan.SnapshotTimes()[xtime.ToUnixNano(start)]
+	require.True(t, snapshotTimes[0].SnapshotTime.Equal(start.Add(6*time.Minute)))
+}
+
+func TestReadUsesWorkDistributor(t *testing.T) {
+	var (
+		distributor = &testWorkDistributor{workersByShard: map[uint32]map[int]struct{}{}}
+		opts        = testOptions().SetEncodingConcurrency(3).SetWorkDistributor(distributor)
+		md          = testNsMetadata(t)
+		src         = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		blockSize   = md.Options().RetentionOptions().BlockSize()
+		now         = time.Now()
+		start       = now.Truncate(blockSize).Add(-blockSize)
+		end         = now.Truncate(blockSize)
+		ranges      = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		values      []testValue
+	)
+
+	targetRanges := result.ShardTimeRanges{}
+	for shard := uint32(0); shard < 6; shard++ {
+		targetRanges[shard] = ranges
+		for i := 0; i < 3; i++ {
+			series := commitlog.Series{
+				Namespace: testNamespaceID,
+				Shard:     shard,
+				ID:        ident.StringID(fmt.Sprintf("series-%d-%d", shard, i)),
+			}
+			values = append(values,
+				testValue{series, start.Add(time.Minute), float64(i), xtime.Second, nil},
+				testValue{series, start.Add(2 * time.Minute), float64(i), xtime.Second, nil})
+		}
 	}
+
 	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
 		return newTestCommitLogIterator(values, nil), nil
 	}
 
-	targetRanges := result.ShardTimeRanges{0: ranges, 1: ranges}
 	res, err := src.ReadData(md, targetRanges, testDefaultRunOpts)
 	require.NoError(t, err)
-	require.NotNil(t, res)
-	require.Equal(t, 1, len(res.ShardResults()))
-	require.Equal(t, 0, len(res.Unfulfilled()))
 	require.NoError(t, verifyShardResultsAreCorrect(
-		values[1:3], blockSize, res.ShardResults(), opts))
-}
+		values, blockSize, res.ShardResults(), opts))
 
-func TestItMergesSnapshotsAndCommitLogs(t *testing.T) {
-	ctrl := gomock.NewController(t)
-	defer ctrl.Finish()
+	require.Equal(t, 6, len(distributor.workersByShard))
+	for shard, workers := range distributor.workersByShard {
+		require.Equal(t, 1, len(workers), fmt.Sprintf("shard %d routed to %v", shard, workers))
+	}
+}
 
+func TestReadErrorsWhenWorkDistributorSplitsShard(t *testing.T) {
 	var (
-		opts      = testOptions()
+		opts      = testOptions().SetEncodingConcurrency(2).SetWorkDistributor(seriesWorkDistributor{})
 		md        = testNsMetadata(t)
 		src       = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
 		blockSize = md.Options().RetentionOptions().BlockSize()
 		now       = time.Now()
 		start     = now.Truncate(blockSize).Add(-blockSize)
 		end       = now.Truncate(blockSize)
-		ranges    = xtime.Ranges{}
-
-		foo             = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo")}
-		commitLogValues = []testValue{
-			{foo, start.Add(2 * time.Minute), 1.0, xtime.Nanosecond, nil},
-			{foo, start.Add(3 * time.Minute), 2.0, xtime.Nanosecond, nil},
-			{foo, start.Add(4 * time.Minute), 3.0, xtime.Nanosecond, nil},
-
-			// Should not be present
-			{foo, end.Truncate(blockSize).Add(blockSize).Add(time.Nanosecond), 4.0, xtime.Nanosecond, nil},
+		ranges    = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+		foo       = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("a")}
+		bar       = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("bb")}
+		values    = []testValue{
+			{foo, start.Add(time.Minute), 1.0, xtime.Second, nil},
+			{bar, start.Add(time.Minute), 1.0, xtime.Second, nil},
 		}
 	)
 
-	// Request a little after the start of data, because always reading full blocks it
-	// should return the entire block beginning from "start".
-	require.True(t, blockSize >= minCommitLogRetention)
-
-	ranges = ranges.AddRange(xtime.Range{
-		Start: start,
-		End:   end,
-	})
-
 	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
-		return newTestCommitLogIterator(commitLogValues, nil), nil
-	}
-	src.snapshotFilesFn = func(filePathPrefix string, namespace ident.ID, shard uint32) (fs.FileSetFilesSlice, error) {
-		return fs.FileSetFilesSlice{
-			fs.FileSetFile{
-				ID: fs.FileSetFileIdentifier{
-					Namespace:   namespace,
-					BlockStart:  start,
-					Shard:       shard,
-					VolumeIndex: 0,
-				},
-				AbsoluteFilepaths:  []string{"checkpoint"},
-				CachedSnapshotTime: start.Add(time.Minute),
-			},
-		}, nil
+		return newTestCommitLogIterator(values, nil), nil
 	}
 
-	mockReader := fs.NewMockDataFileSetReader(ctrl)
-	mockReader.EXPECT().Open(fs.ReaderOpenOptionsMatcher{
-		ID: fs.FileSetFileIdentifier{
-			Namespace:   testNamespaceID,
-			BlockStart:  start,
-			Shard:       0,
-			VolumeIndex: 0,
-		},
-		FileSetType: persist.FileSetSnapshotType,
-	}).Return(nil).AnyTimes()
-	mockReader.EXPECT().Entries().Return(1).AnyTimes()
+	// Bootstrap a second shard so that more than one worker is started.
+	_, err := src.ReadData(md, result.ShardTimeRanges{0: ranges, 1: ranges}, testDefaultRunOpts)
+	require.Error(t, err)
+}
 
-	snapshotValues := []testValue{
-		{foo, start.Add(1 * time.Minute), 1.0, xtime.Nanosecond, nil},
-	}
+// testWorkDistributor routes shards to workers in the reverse order of the
+// default distributor and records every routing decision.
+type testWorkDistributor struct {
+	workersByShard map[uint32]map[int]struct{}
+}
 
-	encoder := m3tsz.NewEncoder(snapshotValues[0].t, nil, true, nil)
-	for _, value := range snapshotValues {
-		dp := ts.Datapoint{
-			Timestamp: value.t,
-			Value:     value.v,
-		}
-		encoder.Encode(dp, value.u, value.a)
+func (d *testWorkDistributor) WorkerIndex(series commitlog.Series, numWorkers int) int {
+	worker := numWorkers - 1 - int(series.Shard%uint32(numWorkers))
+	workers, ok := d.workersByShard[series.Shard]
+	if !ok {
+		workers = map[int]struct{}{}
+		d.workersByShard[series.Shard] = workers
 	}
-	reader := encoder.Stream()
-	seg, err := reader.Segment()
-	require.NoError(t, err)
-	bytes := make([]byte, seg.Len())
-	_, err = reader.Read(bytes)
-	require.NoError(t, err)
-	mockReader.EXPECT().Read().Return(
-		foo.ID,
-		ident.EmptyTagIterator,
-		checked.NewBytes(bytes, nil),
-		digest.Checksum(bytes),
-		nil,
-	)
-	mockReader.EXPECT().Read().Return(nil, nil, nil, uint32(0), io.EOF)
+	workers[worker] = struct{}{}
+	return worker
+}
 
-	src.newReaderFn = func(bytesPool pool.CheckedBytesPool, opts fs.Options) (fs.DataFileSetReader, error) {
-		return mockReader, nil
-	}
+// seriesWorkDistributor routes series by the length of their ID which splits
+// shards across workers.
+type seriesWorkDistributor struct{}
 
-	targetRanges := result.ShardTimeRanges{0: ranges}
-	res, err := src.ReadData(md, targetRanges, testDefaultRunOpts)
-	require.NoError(t, err)
-	require.NotNil(t, res)
-	require.Equal(t, 1, len(res.ShardResults()))
-	require.Equal(t, 0, len(res.Unfulfilled()))
-	expectedValues := append([]testValue{}, snapshotValues...)
-	expectedValues = append(expectedValues, commitLogValues[0:3]...)
+func (seriesWorkDistributor) WorkerIndex(series commitlog.Series, numWorkers int) int {
+	return len(series.ID.String()) % numWorkers
+}
 
-	require.NoError(t, verifyShardResultsAreCorrect(
-		expectedValues, blockSize, res.ShardResults(), opts))
+type testProgressReporter struct {
+	sync.Mutex
+	filesSelected  []string
+	datapointsRead int64
+	shardsMerged   []uint32
+}
+
+func (r *testProgressReporter) OnCommitLogFileSelected(file string) {
+	r.Lock()
+	r.filesSelected = append(r.filesSelected, file)
+	r.Unlock()
+}
+
+func (r *testProgressReporter) OnDatapointsRead(n int64) {
+	r.Lock()
+	r.datapointsRead += n
+	r.Unlock()
+}
+
+func (r *testProgressReporter) OnShardMergeComplete(shard uint32) {
+	r.Lock()
+	r.shardsMerged = append(r.shardsMerged, shard)
+	r.Unlock()
+}
+
+type testDroppedDatapointsReporter struct {
+	namespace ident.ID
+	dropped   []DroppedDatapoint
+}
+
+func (r *testDroppedDatapointsReporter) ReportDroppedDatapoints(
+	namespace ident.ID,
+	dropped []DroppedDatapoint,
+) {
+	r.namespace = namespace
+	r.dropped = append(r.dropped, dropped...)
+}
+
+type testOutOfOrderDatapointsReporter struct {
+	namespace     ident.ID
+	numOutOfOrder int
+	samples       []OutOfOrderDatapoint
+}
+
+func (r *testOutOfOrderDatapointsReporter) ReportOutOfOrderDatapoints(
+	namespace ident.ID,
+	numOutOfOrder int,
+	samples []OutOfOrderDatapoint,
+) {
+	r.namespace = namespace
+	r.numOutOfOrder += numOutOfOrder
+	r.samples = append(r.samples, samples...)
+}
+
+type testUnmergedDebugSink struct {
+	calls     int
+	namespace ident.ID
+	stats     []UnmergedShardStats
+}
+
+func (s *testUnmergedDebugSink) OnUnmerged(namespace ident.ID, stats []UnmergedShardStats) {
+	s.calls++
+	s.namespace = namespace
+	s.stats = stats
 }
 
 type testValue struct {
@@ -667,11 +6384,60 @@ func verifyBlocksAreEqual(opts Options, expectedAllBlocks, actualAllBlocks map[x
 	return nil
 }
 
+// testLeakCheckingBytesPool returns bytes tracked by a testBytesLeakDetector.
+type testLeakCheckingBytesPool struct {
+	pool.CheckedBytesPool
+
+	detector *testBytesLeakDetector
+}
+
+func (p testLeakCheckingBytesPool) Get(capacity int) checked.Bytes {
+	return p.detector.newBytes(make([]byte, 0, capacity))
+}
+
+type testCommitLogFilesInspection map[string]struct{}
+
+func (i testCommitLogFilesInspection) CommitLogFilesSet() map[string]struct{} {
+	return i
+}
+
+// testBytesLeakDetector tracks the bytes it creates that have not yet been
+// finalized.
+type testBytesLeakDetector struct {
+	sync.Mutex
+	outstanding map[checked.Bytes]struct{}
+}
+
+func newTestBytesLeakDetector() *testBytesLeakDetector {
+	return &testBytesLeakDetector{outstanding: make(map[checked.Bytes]struct{})}
+}
+
+func (d *testBytesLeakDetector) newBytes(data []byte) checked.Bytes {
+	b := checked.NewBytes(data, checked.NewBytesOptions().SetFinalizer(d))
+	d.Lock()
+	d.outstanding[b] = struct{}{}
+	d.Unlock()
+	return b
+}
+
+func (d *testBytesLeakDetector) FinalizeBytes(b checked.Bytes) {
+	d.Lock()
+	delete(d.outstanding, b)
+	d.Unlock()
+}
+
+func (d *testBytesLeakDetector) numOutstanding() int {
+	d.Lock()
+	defer d.Unlock()
+	return len(d.outstanding)
+}
+
 type testCommitLogIterator struct {
-	values []testValue
-	idx    int
-	err    error
-	closed bool
+	values   []testValue
+	idx      int
+	err      error
+	fileErrs []commitlog.FileError
+	closed   bool
 }
 
 type testValuesByTime []testValue
@@ -704,6 +6470,10 @@ func (i *testCommitLogIterator) Err() error {
 	return i.err
 }
 
+func (i *testCommitLogIterator) FileErrors() []commitlog.FileError {
+	return i.fileErrs
+}
+
 func (i *testCommitLogIterator) Close() {
 	i.closed = true
 }
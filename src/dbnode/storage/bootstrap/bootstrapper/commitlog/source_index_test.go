@@ -22,16 +22,20 @@ package commitlog
 
 import (
 	"fmt"
+	"io"
 	"testing"
 	"time"
 
+	"github.com/m3db/m3/src/dbnode/persist"
 	"github.com/m3db/m3/src/dbnode/persist/fs"
 	"github.com/m3db/m3/src/dbnode/persist/fs/commitlog"
 	"github.com/m3db/m3/src/dbnode/storage/bootstrap/result"
 	"github.com/m3db/m3/src/dbnode/storage/namespace"
 	"github.com/m3db/m3x/ident"
+	"github.com/m3db/m3x/pool"
 	xtime "github.com/m3db/m3x/time"
 
+	"github.com/golang/mock/gomock"
 	"github.com/stretchr/testify/require"
 )
 
@@ -267,6 +271,295 @@ func TestBootstrapIndexEmptyShardTimeRanges(t *testing.T) {
 	require.Equal(t, 0, len(res.Unfulfilled()))
 }
 
+func TestBootstrapIndexSnapshotsOnlyNeverReadsCommitLog(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		opts             = testOptions().SetSnapshotsOnly(true).SetReportAccurateAvailability(true)
+		src              = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		dataBlockSize    = 2 * time.Hour
+		indexBlockSize   = 4 * time.Hour
+		namespaceOptions = namespace.NewOptions().
+					SetRetentionOptions(
+				namespace.NewOptions().
+					RetentionOptions().
+					SetBlockSize(dataBlockSize),
+			).
+			SetIndexOptions(
+				namespace.NewOptions().
+					IndexOptions().
+					SetBlockSize(indexBlockSize).
+					SetEnabled(true),
+			)
+	)
+	md, err := namespace.NewMetadata(testNamespaceID, namespaceOptions)
+	require.NoError(t, err)
+
+	var (
+		bufferPast   = namespaceOptions.RetentionOptions().BufferPast()
+		start        = time.Now().Truncate(indexBlockSize).Add(-indexBlockSize)
+		snapshotTime = start.Add(time.Hour)
+		ranges       = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: start.Add(dataBlockSize)})
+		fooTags      = ident.NewTags(ident.StringTag("city", "ny"))
+		foo          = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo"), Tags: fooTags}
+		snapshotID   = fs.FileSetFileIdentifier{
+			Namespace:  testNamespaceID,
+			BlockStart: start,
+			Shard:      0,
+		}
+	)
+
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		require.FailNow(t, "commit log iterator should not be created")
+		return nil, nil
+	}
+	src.snapshotFilesFn = func(filePathPrefix string, namespace ident.ID, shard uint32) (fs.FileSetFilesSlice, error) {
+		return fs.FileSetFilesSlice{
+			fs.FileSetFile{
+				ID:                 snapshotID,
+				AbsoluteFilepaths:  []string{"checkpoint"},
+				CachedSnapshotTime: snapshotTime,
+			},
+		}, nil
+	}
+
+	mockReader := fs.NewMockDataFileSetReader(ctrl)
+	mockReader.EXPECT().Close().Return(nil).AnyTimes()
+	mockReader.EXPECT().Open(fs.ReaderOpenOptionsMatcher{
+		ID:          snapshotID,
+		FileSetType: persist.FileSetSnapshotType,
+	}).Return(nil)
+	mockReader.EXPECT().Entries().Return(1).AnyTimes()
+	mockReader.EXPECT().ReadMetadata().Return(
+		foo.ID, ident.NewTagsIterator(fooTags), 0, uint32(0), nil)
+	mockReader.EXPECT().ReadMetadata().Return(nil, nil, 0, uint32(0), io.EOF)
+	src.newReaderFn = func(bytesPool pool.CheckedBytesPool, opts fs.Options) (fs.DataFileSetReader, error) {
+		return mockReader, nil
+	}
+
+	res, err := src.ReadIndex(md, result.ShardTimeRanges{0: ranges}, testDefaultRunOpts)
+	require.NoError(t, err)
+
+	indexResults := res.IndexResults()
+	require.Equal(t, 1, len(indexResults))
+	err = verifyIndexResultsAreCorrect(
+		[]testValue{{foo, start, 1.0, xtime.Second, nil}}, nil, indexResults, indexBlockSize)
+	require.NoError(t, err)
+
+	// Same as the available ranges, the writes that may have been received
+	// after the snapshot was taken aren't fulfilled.
+	expectedFulfilled := result.ShardTimeRanges{
+		0: xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: snapshotTime.Add(-bufferPast)}),
+	}
+	require.True(t, expectedFulfilled.Equal(src.AvailableIndex(md, result.ShardTimeRanges{0: ranges})))
+	fulfilled := indexResults[xtime.ToUnixNano(start)].Fulfilled()
+	require.True(t, expectedFulfilled.Equal(fulfilled),
+		fmt.Sprintf("expected: %s, actual: %s", expectedFulfilled, fulfilled))
+}
+
+func TestBootstrapIndexMarksUnreadableSnapshotBlocksUnfulfilled(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	var (
+		opts             = testOptions()
+		src              = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		dataBlockSize    = 2 * time.Hour
+		indexBlockSize   = 4 * time.Hour
+		namespaceOptions = namespace.NewOptions().
+					SetRetentionOptions(
+				namespace.NewOptions().
+					RetentionOptions().
+					SetBlockSize(dataBlockSize),
+			).
+			SetIndexOptions(
+				namespace.NewOptions().
+					IndexOptions().
+					SetBlockSize(indexBlockSize).
+					SetEnabled(true),
+			)
+	)
+	md, err := namespace.NewMetadata(testNamespaceID, namespaceOptions)
+	require.NoError(t, err)
+
+	var (
+		start      = time.Now().Truncate(indexBlockSize).Add(-indexBlockSize)
+		badBlock   = start.Add(dataBlockSize)
+		ranges     = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: start.Add(indexBlockSize)})
+		fooTags    = ident.NewTags(ident.StringTag("city", "ny"))
+		foo        = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo"), Tags: fooTags}
+		values     = []testValue{{foo, start.Add(2 * time.Minute), 1.0, xtime.Second, nil}}
+		snapshotID = func(blockStart time.Time) fs.FileSetFileIdentifier {
+			return fs.FileSetFileIdentifier{
+				Namespace:  testNamespaceID,
+				BlockStart: blockStart,
+				Shard:      0,
+			}
+		}
+	)
+
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		return newTestCommitLogIterator(values, nil), nil
+	}
+	src.snapshotFilesFn = func(filePathPrefix string, namespace ident.ID, shard uint32) (fs.FileSetFilesSlice, error) {
+		var files fs.FileSetFilesSlice
+		for _, blockStart := range []time.Time{start, badBlock} {
+			files = append(files, fs.FileSetFile{
+				ID:                 snapshotID(blockStart),
+				AbsoluteFilepaths:  []string{"checkpoint"},
+				CachedSnapshotTime: blockStart.Add(time.Minute),
+			})
+		}
+		return files, nil
+	}
+
+	mockReader := fs.NewMockDataFileSetReader(ctrl)
+	mockReader.EXPECT().Close().Return(nil).AnyTimes()
+	mockReader.EXPECT().Open(fs.ReaderOpenOptionsMatcher{
+		ID:          snapshotID(start),
+		FileSetType: persist.FileSetSnapshotType,
+	}).Return(nil)
+	mockReader.EXPECT().Open(fs.ReaderOpenOptionsMatcher{
+		ID:          snapshotID(badBlock),
+		FileSetType: persist.FileSetSnapshotType,
+	}).Return(fmt.Errorf("an error"))
+	mockReader.EXPECT().Entries().Return(0).AnyTimes()
+	mockReader.EXPECT().ReadMetadata().Return(nil, nil, 0, uint32(0), io.EOF)
+	src.newReaderFn = func(bytesPool pool.CheckedBytesPool, opts fs.Options) (fs.DataFileSetReader, error) {
+		return mockReader, nil
+	}
+
+	// The index is still bootstrapped, the block whose snapshot couldn't be read
+	// is left for a subsequent bootstrapper same as when reading data.
+	res, err := src.ReadIndex(md, result.ShardTimeRanges{0: ranges}, testDefaultRunOpts)
+	require.NoError(t, err)
+
+	indexResults := res.IndexResults()
+	require.Equal(t, 1, len(indexResults))
+	require.NoError(t, verifyIndexResultsAreCorrect(values, nil, indexResults, indexBlockSize))
+
+	expectedFulfilled := result.ShardTimeRanges{
+		0: xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: badBlock}),
+	}
+	fulfilled := indexResults[xtime.ToUnixNano(start)].Fulfilled()
+	require.True(t, expectedFulfilled.Equal(fulfilled),
+		fmt.Sprintf("expected: %s, actual: %s", expectedFulfilled, fulfilled))
+}
+
+func TestBootstrapIndexMarksBlocksOfUnreadableCommitLogFilesUnfulfilled(t *testing.T) {
+	var (
+		opts             = testOptions()
+		src              = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		dataBlockSize    = 2 * time.Hour
+		indexBlockSize   = 4 * time.Hour
+		namespaceOptions = namespace.NewOptions().
+					SetRetentionOptions(
+				namespace.NewOptions().
+					RetentionOptions().
+					SetBlockSize(dataBlockSize),
+			).
+			SetIndexOptions(
+				namespace.NewOptions().
+					IndexOptions().
+					SetBlockSize(indexBlockSize).
+					SetEnabled(true),
+			)
+	)
+	md, err := namespace.NewMetadata(testNamespaceID, namespaceOptions)
+	require.NoError(t, err)
+
+	var (
+		start   = time.Now().Truncate(indexBlockSize).Add(-indexBlockSize)
+		corrupt = start.Add(dataBlockSize)
+		ranges  = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: start.Add(indexBlockSize)})
+		fooTags = ident.NewTags(ident.StringTag("city", "ny"))
+		foo     = commitlog.Series{Namespace: testNamespaceID, Shard: 0, ID: ident.StringID("foo"), Tags: fooTags}
+		values  = []testValue{{foo, start.Add(time.Minute), 1.0, xtime.Second, nil}}
+		fileErr = commitlog.FileError{
+			File: commitlog.File{
+				FilePath: "corrupt",
+				// Middle of the block so that buffer past and future don't
+				// reach the neighbouring blocks.
+				Start:    corrupt.Add(dataBlockSize / 2),
+				Duration: time.Minute,
+			},
+			Err: fmt.Errorf("corrupt commit log"),
+		}
+	)
+
+	src.newIteratorFn = func(_ commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		iter := newTestCommitLogIterator(values, fileErr.Err)
+		iter.fileErrs = []commitlog.FileError{fileErr}
+		return iter, nil
+	}
+
+	res, err := src.ReadIndex(md, result.ShardTimeRanges{0: ranges}, testDefaultRunOpts)
+	require.NoError(t, err)
+
+	indexResults := res.IndexResults()
+	require.Equal(t, 1, len(indexResults))
+	require.NoError(t, verifyIndexResultsAreCorrect(values, nil, indexResults, indexBlockSize))
+
+	// Series written only to the unreadable file would be missing from the index
+	// so its block is left for a subsequent bootstrapper.
+	expectedFulfilled := result.ShardTimeRanges{
+		0: xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: corrupt}),
+	}
+	fulfilled := indexResults[xtime.ToUnixNano(start)].Fulfilled()
+	require.True(t, expectedFulfilled.Equal(fulfilled),
+		fmt.Sprintf("expected: %s, actual: %s", expectedFulfilled, fulfilled))
+}
+
+func TestBootstrapIndexKeepsSkippedCommitLogFilesOfDataSummary(t *testing.T) {
+	var (
+		opts             = testOptions()
+		blockSize        = 2 * time.Hour
+		namespaceOptions = namespace.NewOptions().
+					SetRetentionOptions(
+				namespace.NewOptions().
+					RetentionOptions().
+					SetBlockSize(blockSize),
+			).
+			SetIndexOptions(
+				namespace.NewOptions().
+					IndexOptions().
+					SetBlockSize(blockSize).
+					SetEnabled(true),
+			)
+	)
+	md, err := namespace.NewMetadata(testNamespaceID, namespaceOptions)
+	require.NoError(t, err)
+
+	var (
+		start  = time.Now().Truncate(blockSize).Add(-blockSize)
+		ranges = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: start.Add(blockSize)})
+		// Well before the range so skipped by both the data and index reads.
+		commitLogFiles = []commitlog.File{
+			{FilePath: "before", Start: start.Add(-4 * blockSize), Duration: time.Minute},
+		}
+		src = newCommitLogSource(opts, testCommitLogFilesInspection{"before": {}}).(*commitLogSource)
+	)
+
+	src.newIteratorFn = func(iterOpts commitlog.IteratorOpts) (commitlog.Iterator, error) {
+		for _, f := range commitLogFiles {
+			iterOpts.FileFilterPredicate(f)
+		}
+		return newTestCommitLogIterator(nil, nil), nil
+	}
+
+	_, err = src.ReadData(md, result.ShardTimeRanges{0: ranges}, testDefaultRunOpts)
+	require.NoError(t, err)
+
+	// An index bootstrap of the same namespace must not reset the list.
+	_, err = src.ReadIndex(md, result.ShardTimeRanges{0: ranges}, testDefaultRunOpts)
+	require.NoError(t, err)
+
+	summary, ok := src.LastBootstrapSummary(testNamespaceID)
+	require.True(t, ok)
+	require.Equal(t, []string{"before"}, summary.SkippedCommitLogFiles)
+}
+
 func TestBootstrapIndexNamespaceIndexNotEnabled(t *testing.T) {
 	var (
 		opts             = testOptions()
@@ -0,0 +1,654 @@
+// Copyright (c) 2018 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package commitlog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/m3db/m3/src/dbnode/digest"
+	"github.com/m3db/m3/src/dbnode/persist"
+	"github.com/m3db/m3/src/dbnode/persist/fs"
+	"github.com/m3db/m3/src/dbnode/persist/fs/commitlog"
+	"github.com/m3db/m3/src/dbnode/storage/bootstrap/result"
+	"github.com/m3db/m3x/checked"
+	"github.com/m3db/m3x/ident"
+	xlog "github.com/m3db/m3x/log"
+	xtime "github.com/m3db/m3x/time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMostRecentCompleteSnapshotByBlockShardConcurrencyDoesNotChangeResult(t *testing.T) {
+	var (
+		blockSize            = 2 * time.Hour
+		numShards            = 32
+		numBlocks            = 4
+		end                  = time.Now().Truncate(blockSize)
+		start                = end.Add(-time.Duration(numBlocks) * blockSize)
+		shardsTimeRanges     = testShardTimeRanges(start, end, numShards)
+		snapshotFilesByShard = testSnapshotFilesByShard(start, blockSize, numBlocks, numShards)
+	)
+
+	resolve := func(concurrency int) map[xtime.UnixNano]map[uint32]fs.FileSetFile {
+		opts := testOptions().SetSnapshotResolutionConcurrency(concurrency)
+		src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		src.snapshotTimeFn = func(f fs.FileSetFile, _ int) (time.Time, error) {
+			if f.ID.Shard%5 == 0 {
+				return time.Time{}, fmt.Errorf("an error")
+			}
+			return f.CachedSnapshotTime, nil
+		}
+		mostRecent, err := src.mostRecentCompleteSnapshotByBlockShard(
+			shardsTimeRanges, blockSize, snapshotFilesByShard, opts.CommitLogOptions().FilesystemOptions())
+		require.NoError(t, err)
+		return mostRecent
+	}
+
+	serial := resolve(1)
+	require.Equal(t, numBlocks, len(serial))
+	for blockStart, byShard := range serial {
+		require.Equal(t, numShards, len(byShard))
+		for shard, snapshot := range byShard {
+			if shard%5 == 0 || shard%2 == 0 {
+				// Unresolvable or missing snapshots fall back to the block start.
+				require.True(t, snapshot.CachedSnapshotTime.Equal(blockStart.ToTime()))
+			} else {
+				require.True(t, snapshot.CachedSnapshotTime.After(blockStart.ToTime()))
+			}
+		}
+	}
+
+	require.Equal(t, serial, resolve(8))
+}
+
+func TestMostRecentCompleteSnapshotByBlockShardMaxResolutionErrors(t *testing.T) {
+	var (
+		blockSize            = 2 * time.Hour
+		numShards            = 8
+		numBlocks            = 2
+		end                  = time.Now().Truncate(blockSize)
+		start                = end.Add(-time.Duration(numBlocks) * blockSize)
+		shardsTimeRanges     = testShardTimeRanges(start, end, numShards)
+		snapshotFilesByShard = testSnapshotFilesByShard(start, blockSize, numBlocks, numShards)
+		// Only odd shards have snapshots and none of them can be resolved.
+		numResolutionErrs = numBlocks * numShards / 2
+	)
+
+	resolve := func(opts Options) error {
+		src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		src.snapshotTimeFn = func(f fs.FileSetFile, _ int) (time.Time, error) {
+			return time.Time{}, fmt.Errorf("an error")
+		}
+		_, err := src.mostRecentCompleteSnapshotByBlockShard(
+			shardsTimeRanges, blockSize, snapshotFilesByShard, opts.CommitLogOptions().FilesystemOptions())
+		return err
+	}
+
+	// Unlimited by default.
+	require.NoError(t, resolve(testOptions()))
+	require.NoError(t, resolve(testOptions().SetMaxSnapshotTimeResolutionErrors(numResolutionErrs)))
+	require.Error(t, resolve(testOptions().SetMaxSnapshotTimeResolutionErrors(numResolutionErrs-1)))
+	require.Error(t, resolve(testOptions().SetMaxSnapshotTimeResolutionErrors(0)))
+}
+
+func TestMostRecentCompleteSnapshotByBlockShardZeroSnapshotTime(t *testing.T) {
+	var (
+		blockSize            = 2 * time.Hour
+		numShards            = 2
+		numBlocks            = 1
+		end                  = time.Now().Truncate(blockSize)
+		start                = end.Add(-time.Duration(numBlocks) * blockSize)
+		shardsTimeRanges     = testShardTimeRanges(start, end, numShards)
+		snapshotFilesByShard = testSnapshotFilesByShard(start, blockSize, numBlocks, numShards)
+		opts                 = testOptions()
+		src                  = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+	)
+
+	// The snapshot of shard 1 resolves without an error but to the zero time.
+	src.snapshotTimeFn = func(f fs.FileSetFile, _ int) (time.Time, error) {
+		return time.Time{}, nil
+	}
+
+	mostRecent, err := src.mostRecentCompleteSnapshotByBlockShard(
+		shardsTimeRanges, blockSize, snapshotFilesByShard, opts.CommitLogOptions().FilesystemOptions())
+	require.NoError(t, err)
+
+	byShard, ok := mostRecent[xtime.ToUnixNano(start)]
+	require.True(t, ok)
+	require.Equal(t, numShards, len(byShard))
+	for _, snapshot := range byShard {
+		// Treated the same as a missing snapshot rather than one taken at the epoch.
+		require.True(t, snapshot.IsZero())
+		require.True(t, snapshot.CachedSnapshotTime.Equal(start))
+	}
+
+	minimums := src.minimumMostRecentSnapshotTimeByBlock(shardsTimeRanges, blockSize, mostRecent)
+	require.True(t, minimums[xtime.ToUnixNano(start)].Equal(start))
+
+	// A zero snapshot time counts towards the resolution errors.
+	opts = opts.SetMaxSnapshotTimeResolutionErrors(0)
+	src = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+	src.snapshotTimeFn = func(f fs.FileSetFile, _ int) (time.Time, error) {
+		return time.Time{}, nil
+	}
+	_, err = src.mostRecentCompleteSnapshotByBlockShard(
+		shardsTimeRanges, blockSize, snapshotFilesByShard, opts.CommitLogOptions().FilesystemOptions())
+	require.Error(t, err)
+}
+
+func TestMostRecentCompleteSnapshotByBlockShardInfoReaderBufferSize(t *testing.T) {
+	var (
+		blockSize            = 2 * time.Hour
+		numShards            = 2
+		numBlocks            = 1
+		end                  = time.Now().Truncate(blockSize)
+		start                = end.Add(-time.Duration(numBlocks) * blockSize)
+		shardsTimeRanges     = testShardTimeRanges(start, end, numShards)
+		snapshotFilesByShard = testSnapshotFilesByShard(start, blockSize, numBlocks, numShards)
+		fsOpts               = testOptions().CommitLogOptions().FilesystemOptions().
+					SetInfoReaderBufferSize(128)
+	)
+
+	resolveBufferSizes := func(opts Options) []int {
+		var (
+			lock  sync.Mutex
+			sizes []int
+			src   = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		)
+		src.snapshotTimeFn = func(f fs.FileSetFile, readerBufferSize int) (time.Time, error) {
+			lock.Lock()
+			sizes = append(sizes, readerBufferSize)
+			lock.Unlock()
+			return start.Add(time.Minute), nil
+		}
+		_, err := src.mostRecentCompleteSnapshotByBlockShard(
+			shardsTimeRanges, blockSize, snapshotFilesByShard, fsOpts)
+		require.NoError(t, err)
+		return sizes
+	}
+
+	// Falls back to the filesystem options by default.
+	require.Equal(t, []int{128, 128}, resolveBufferSizes(testOptions()))
+	require.Equal(t, []int{4096, 4096},
+		resolveBufferSizes(testOptions().SetSnapshotInfoReaderBufferSize(4096)))
+}
+
+func TestMostRecentCompleteSnapshotByBlockShardOnlyResolvesRequestedBlocks(t *testing.T) {
+	var (
+		blockSize            = 2 * time.Hour
+		start                = time.Now().Truncate(blockSize).Add(-2 * blockSize)
+		next                 = start.Add(blockSize)
+		end                  = next.Add(blockSize)
+		snapshotFilesByShard = testSnapshotFilesByShard(start, blockSize, 2, 2)
+		// Shard 1, the only one with snapshots, starts in the middle of the
+		// second block so the first block is only bootstrapped for shard 0.
+		shardsTimeRanges = result.ShardTimeRanges{
+			0: xtime.Ranges{}.AddRange(xtime.Range{Start: start.Add(time.Hour), End: end}),
+			1: xtime.Ranges{}.AddRange(xtime.Range{Start: next.Add(30 * time.Minute), End: end}),
+		}
+		opts     = testOptions()
+		src      = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+		lock     sync.Mutex
+		resolved []fs.FileSetFileIdentifier
+	)
+
+	src.snapshotTimeFn = func(f fs.FileSetFile, _ int) (time.Time, error) {
+		lock.Lock()
+		resolved = append(resolved, f.ID)
+		lock.Unlock()
+		return f.CachedSnapshotTime, nil
+	}
+
+	mostRecent, err := src.mostRecentCompleteSnapshotByBlockShard(
+		shardsTimeRanges, blockSize, snapshotFilesByShard, opts.CommitLogOptions().FilesystemOptions())
+	require.NoError(t, err)
+
+	require.Equal(t, 1, len(resolved))
+	require.Equal(t, uint32(1), resolved[0].Shard)
+	require.True(t, resolved[0].BlockStart.Equal(next))
+
+	// The first block still has an entry for shard 1, without a snapshot.
+	skipped, ok := mostRecent[xtime.ToUnixNano(start)][1]
+	require.True(t, ok)
+	require.True(t, skipped.IsZero())
+	require.True(t, skipped.CachedSnapshotTime.Equal(start))
+
+	read, ok := mostRecent[xtime.ToUnixNano(next)][1]
+	require.True(t, ok)
+	require.False(t, read.IsZero())
+	require.True(t, read.CachedSnapshotTime.Equal(next.Add(time.Minute)))
+}
+
+func TestMostRecentCompleteSnapshotByBlockShardDuplicateIndex(t *testing.T) {
+	var (
+		blockSize        = 2 * time.Hour
+		start            = time.Now().Truncate(blockSize).Add(-blockSize)
+		shardsTimeRanges = testShardTimeRanges(start, start.Add(blockSize), 1)
+		snapshotFile     = func(index int, dir string, snapshotTime time.Time) fs.FileSetFile {
+			return fs.FileSetFile{
+				ID: fs.FileSetFileIdentifier{
+					Namespace:   testNamespaceID,
+					BlockStart:  start,
+					Shard:       0,
+					VolumeIndex: index,
+				},
+				AbsoluteFilepaths:  []string{dir + "/data", dir + "/checkpoint"},
+				CachedSnapshotTime: snapshotTime,
+			}
+		}
+		older  = snapshotFile(0, "a", start.Add(time.Minute))
+		first  = snapshotFile(1, "b", start.Add(2*time.Minute))
+		second = snapshotFile(1, "c", start.Add(3*time.Minute))
+	)
+
+	// Whatever the order of the files the one whose file paths sort first is
+	// chosen among the complete snapshots with the highest index.
+	for _, files := range []fs.FileSetFilesSlice{
+		{older, first, second},
+		{second, first, older},
+	} {
+		var (
+			opts = testOptions()
+			src  = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+			buf  bytes.Buffer
+		)
+		src.log = xlog.NewLogger(&buf)
+		src.snapshotTimeFn = func(f fs.FileSetFile, _ int) (time.Time, error) {
+			return f.CachedSnapshotTime, nil
+		}
+
+		mostRecent, err := src.mostRecentCompleteSnapshotByBlockShard(
+			shardsTimeRanges, blockSize, map[uint32]fs.FileSetFilesSlice{0: files},
+			opts.CommitLogOptions().FilesystemOptions())
+		require.NoError(t, err)
+
+		snapshot := mostRecent[xtime.ToUnixNano(start)][0]
+		require.Equal(t, first.AbsoluteFilepaths, snapshot.AbsoluteFilepaths)
+		require.True(t, snapshot.CachedSnapshotTime.Equal(first.CachedSnapshotTime))
+		require.Contains(t, buf.String(), "found complete snapshot files with duplicate index")
+	}
+}
+
+func BenchmarkMostRecentCompleteSnapshotByBlockShard(b *testing.B) {
+	var (
+		blockSize            = 2 * time.Hour
+		numShards            = 256
+		numBlocks            = 4
+		end                  = time.Now().Truncate(blockSize)
+		start                = end.Add(-time.Duration(numBlocks) * blockSize)
+		shardsTimeRanges     = testShardTimeRanges(start, end, numShards)
+		snapshotFilesByShard = testSnapshotFilesByShard(start, blockSize, numBlocks, numShards)
+	)
+
+	for _, concurrency := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("concurrency-%d", concurrency), func(b *testing.B) {
+			opts := testOptions().SetSnapshotResolutionConcurrency(concurrency)
+			src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+			src.snapshotTimeFn = func(f fs.FileSetFile, _ int) (time.Time, error) {
+				// Simulate the latency of reading the snapshot info file from disk.
+				time.Sleep(10 * time.Microsecond)
+				return f.CachedSnapshotTime, nil
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				src.mostRecentCompleteSnapshotByBlockShard(
+					shardsTimeRanges, blockSize, snapshotFilesByShard, opts.CommitLogOptions().FilesystemOptions())
+			}
+		})
+	}
+}
+
+func testShardTimeRanges(start, end time.Time, numShards int) result.ShardTimeRanges {
+	shardsTimeRanges := result.ShardTimeRanges{}
+	for shard := 0; shard < numShards; shard++ {
+		shardsTimeRanges[uint32(shard)] = xtime.Ranges{}.AddRange(xtime.Range{Start: start, End: end})
+	}
+	return shardsTimeRanges
+}
+
+// testSnapshotFilesByShard generates a complete snapshot for every block of
+// every odd numbered shard.
+func testSnapshotFilesByShard(
+	start time.Time,
+	blockSize time.Duration,
+	numBlocks int,
+	numShards int,
+) map[uint32]fs.FileSetFilesSlice {
+	snapshotFilesByShard := map[uint32]fs.FileSetFilesSlice{}
+	for shard := 0; shard < numShards; shard++ {
+		if shard%2 == 0 {
+			continue
+		}
+
+		var snapshotFiles fs.FileSetFilesSlice
+		for i := 0; i < numBlocks; i++ {
+			blockStart := start.Add(time.Duration(i) * blockSize)
+			snapshotFiles = append(snapshotFiles, fs.FileSetFile{
+				ID: fs.FileSetFileIdentifier{
+					Namespace:   testNamespaceID,
+					BlockStart:  blockStart,
+					Shard:       uint32(shard),
+					VolumeIndex: 0,
+				},
+				AbsoluteFilepaths:  []string{"checkpoint"},
+				CachedSnapshotTime: blockStart.Add(time.Minute),
+			})
+		}
+		snapshotFilesByShard[uint32(shard)] = snapshotFiles
+	}
+	return snapshotFilesByShard
+}
+
+func TestReadsEncryptedSnapshotWithDecryptor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "commitlog-encrypted-snapshot")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var (
+		key          = []byte("0123456789abcdef")
+		blockSize    = 2 * time.Hour
+		blockStart   = time.Now().Truncate(blockSize)
+		snapshotTime = blockStart.Add(time.Minute)
+		nsID         = ident.StringID("testns")
+		shard        = uint32(0)
+		seriesID     = ident.StringID("series")
+		data         = []byte("snapshot data")
+		fsOpts       = fs.NewOptions().SetFilePathPrefix(dir)
+	)
+
+	writer, err := fs.NewWriter(fsOpts)
+	require.NoError(t, err)
+	require.NoError(t, writer.Open(fs.DataWriterOpenOptions{
+		Identifier: fs.FileSetFileIdentifier{
+			Namespace:  nsID,
+			BlockStart: blockStart,
+			Shard:      shard,
+		},
+		BlockSize:   blockSize,
+		FileSetType: persist.FileSetSnapshotType,
+		Snapshot: fs.DataWriterSnapshotOptions{
+			SnapshotTime: snapshotTime,
+		},
+	}))
+	checkedData := checked.NewBytes(data, nil)
+	checkedData.IncRef()
+	require.NoError(t, writer.Write(seriesID, ident.Tags{}, checkedData, digest.Checksum(data)))
+	require.NoError(t, writer.Close())
+
+	files, err := fs.SnapshotFiles(dir, nsID, shard)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(files))
+	for _, path := range files[0].AbsoluteFilepaths {
+		testEncryptFile(t, key, path)
+	}
+
+	// Without the decryptor the digests don't match the encrypted contents.
+	opts := testOptions().SetCommitLogOptions(
+		testOptions().CommitLogOptions().SetFilesystemOptions(fsOpts))
+	src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+	_, err = src.snapshotTimeFn(files[0], 65536)
+	require.Error(t, err)
+
+	src = newCommitLogSource(opts.SetDecryptor(testDecryptor(key)), fs.Inspection{}).(*commitLogSource)
+	files, err = src.snapshotFilesFn(dir, nsID, shard)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(files))
+
+	resolved, err := src.snapshotTimeFn(files[0], 65536)
+	require.NoError(t, err)
+	require.True(t, snapshotTime.Equal(resolved))
+
+	reader, err := src.newReaderFn(nil, fsOpts)
+	require.NoError(t, err)
+	require.NoError(t, reader.Open(fs.DataReaderOpenOptions{
+		Identifier:  files[0].ID,
+		FileSetType: persist.FileSetSnapshotType,
+	}))
+	require.NoError(t, reader.Validate())
+
+	id, _, readData, checksum, err := reader.Read()
+	require.NoError(t, err)
+	require.True(t, seriesID.Equal(id))
+	readData.IncRef()
+	require.Equal(t, data, readData.Bytes())
+	require.Equal(t, digest.Checksum(data), checksum)
+	readData.DecRef()
+	require.NoError(t, reader.Close())
+}
+
+func TestReadsCompressedSnapshotWithReadDecompressor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "commitlog-compressed-snapshot")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var (
+		blockSize    = 2 * time.Hour
+		blockStart   = time.Now().Truncate(blockSize)
+		snapshotTime = blockStart.Add(time.Minute)
+		nsID         = ident.StringID("testns")
+		shard        = uint32(0)
+		seriesID     = ident.StringID("series")
+		data         = []byte("snapshot data")
+		fsOpts       = fs.NewOptions().SetFilePathPrefix(dir)
+	)
+
+	writer, err := fs.NewWriter(fsOpts)
+	require.NoError(t, err)
+	require.NoError(t, writer.Open(fs.DataWriterOpenOptions{
+		Identifier: fs.FileSetFileIdentifier{
+			Namespace:  nsID,
+			BlockStart: blockStart,
+			Shard:      shard,
+		},
+		BlockSize:   blockSize,
+		FileSetType: persist.FileSetSnapshotType,
+		Snapshot: fs.DataWriterSnapshotOptions{
+			SnapshotTime: snapshotTime,
+		},
+	}))
+	checkedData := checked.NewBytes(data, nil)
+	checkedData.IncRef()
+	require.NoError(t, writer.Write(seriesID, ident.Tags{}, checkedData, digest.Checksum(data)))
+	require.NoError(t, writer.Close())
+
+	files, err := fs.SnapshotFiles(dir, nsID, shard)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(files))
+	for _, path := range files[0].AbsoluteFilepaths {
+		testCompressFile(t, path)
+	}
+
+	// Without the decompressor the digests don't match the compressed contents.
+	opts := testOptions().SetCommitLogOptions(
+		testOptions().CommitLogOptions().SetFilesystemOptions(fsOpts))
+	src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+	_, err = src.snapshotTimeFn(files[0], 65536)
+	require.Error(t, err)
+
+	opts = opts.SetCommitLogOptions(
+		opts.CommitLogOptions().SetReadDecompressor(commitlog.GzipDecompressor))
+	src = newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+	resolved, err := src.snapshotTimeFn(files[0], 65536)
+	require.NoError(t, err)
+	require.True(t, snapshotTime.Equal(resolved))
+
+	reader, err := src.newReaderFn(nil, fsOpts)
+	require.NoError(t, err)
+	require.NoError(t, reader.Open(fs.DataReaderOpenOptions{
+		Identifier:  files[0].ID,
+		FileSetType: persist.FileSetSnapshotType,
+	}))
+	require.NoError(t, reader.Validate())
+
+	id, _, readData, checksum, err := reader.Read()
+	require.NoError(t, err)
+	require.True(t, seriesID.Equal(id))
+	readData.IncRef()
+	require.Equal(t, data, readData.Bytes())
+	require.Equal(t, digest.Checksum(data), checksum)
+	readData.DecRef()
+	require.NoError(t, reader.Close())
+}
+
+func TestDecryptingReaderRemovesDecryptedFilesWhenOpenFails(t *testing.T) {
+	dir, err := ioutil.TempDir("", "commitlog-encrypted-snapshot")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	var (
+		blockSize     = 2 * time.Hour
+		blockStart    = time.Now().Truncate(blockSize)
+		nsID          = ident.StringID("testns")
+		shard         = uint32(0)
+		data          = []byte("snapshot data")
+		decryptionDir = filepath.Join(dir, "decrypted")
+		fsOpts        = fs.NewOptions().SetFilePathPrefix(filepath.Join(dir, "data"))
+	)
+
+	writer, err := fs.NewWriter(fsOpts)
+	require.NoError(t, err)
+	require.NoError(t, writer.Open(fs.DataWriterOpenOptions{
+		Identifier: fs.FileSetFileIdentifier{
+			Namespace:  nsID,
+			BlockStart: blockStart,
+			Shard:      shard,
+		},
+		BlockSize:   blockSize,
+		FileSetType: persist.FileSetSnapshotType,
+		Snapshot: fs.DataWriterSnapshotOptions{
+			SnapshotTime: blockStart.Add(time.Minute),
+		},
+	}))
+	checkedData := checked.NewBytes(data, nil)
+	checkedData.IncRef()
+	require.NoError(t, writer.Write(ident.StringID("series"), ident.Tags{}, checkedData, digest.Checksum(data)))
+	require.NoError(t, writer.Close())
+
+	files, err := fs.SnapshotFiles(fsOpts.FilePathPrefix(), nsID, shard)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(files))
+	for _, path := range files[0].AbsoluteFilepaths {
+		testEncryptFile(t, []byte("0123456789abcdef"), path)
+	}
+
+	// A volume left decrypted by a bootstrap that crashed is removed.
+	leftover := filepath.Join(decryptionDir, decodedDirPrefix+"crashed")
+	require.NoError(t, os.MkdirAll(leftover, 0700))
+
+	// Decrypting with the wrong key produces files the reader can't open.
+	opts := testOptions().
+		SetCommitLogOptions(testOptions().CommitLogOptions().SetFilesystemOptions(fsOpts)).
+		SetDecryptor(testDecryptor([]byte("fedcba9876543210"))).
+		SetDecryptionDir(decryptionDir)
+	src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+	_, err = os.Stat(leftover)
+	require.True(t, os.IsNotExist(err))
+
+	reader, err := src.newReaderFn(nil, fsOpts)
+	require.NoError(t, err)
+	require.Error(t, reader.Open(fs.DataReaderOpenOptions{
+		Identifier:  files[0].ID,
+		FileSetType: persist.FileSetSnapshotType,
+	}))
+
+	decrypted, err := ioutil.ReadDir(decryptionDir)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(decrypted))
+}
+
+func TestCommitLogReadOptionsDecryptBeforeDecompressing(t *testing.T) {
+	var (
+		key       = []byte("0123456789abcdef")
+		plaintext = []byte("commit log chunk")
+		encrypted bytes.Buffer
+	)
+
+	gzipWriter := gzip.NewWriter(testEncryptingWriter(t, key, &encrypted))
+	_, err := gzipWriter.Write(plaintext)
+	require.NoError(t, err)
+	require.NoError(t, gzipWriter.Close())
+
+	opts := testOptions().
+		SetCommitLogOptions(testOptions().CommitLogOptions().
+			SetReadDecompressor(commitlog.GzipDecompressor)).
+		SetDecryptor(testDecryptor(key))
+	src := newCommitLogSource(opts, fs.Inspection{}).(*commitLogSource)
+
+	r, err := src.commitLogReadOptions().ReadDecompressor()(&encrypted)
+	require.NoError(t, err)
+	decrypted, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	require.Equal(t, plaintext, decrypted)
+}
+
+// testDecryptor decrypts contents encrypted with AES in CTR mode, the IV is
+// left zero since each test key is only used for a handful of files.
+func testDecryptor(key []byte) Decryptor {
+	return func(r io.Reader) (io.Reader, error) {
+		block, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, err
+		}
+		iv := make([]byte, aes.BlockSize)
+		return &cipher.StreamReader{S: cipher.NewCTR(block, iv), R: r}, nil
+	}
+}
+
+func testEncryptingWriter(t *testing.T, key []byte, w io.Writer) io.Writer {
+	block, err := aes.NewCipher(key)
+	require.NoError(t, err)
+	iv := make([]byte, aes.BlockSize)
+	return &cipher.StreamWriter{S: cipher.NewCTR(block, iv), W: w}
+}
+
+func testEncryptFile(t *testing.T, key []byte, path string) {
+	contents, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+
+	var encrypted bytes.Buffer
+	_, err = testEncryptingWriter(t, key, &encrypted).Write(contents)
+	require.NoError(t, err)
+	require.NoError(t, ioutil.WriteFile(path, encrypted.Bytes(), 0644))
+}
+
+func testCompressFile(t *testing.T, path string) {
+	contents, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+
+	var compressed bytes.Buffer
+	gzipWriter := gzip.NewWriter(&compressed)
+	_, err = gzipWriter.Write(contents)
+	require.NoError(t, err)
+	require.NoError(t, gzipWriter.Close())
+	require.NoError(t, ioutil.WriteFile(path, compressed.Bytes(), 0644))
+}
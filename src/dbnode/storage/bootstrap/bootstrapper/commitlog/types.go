@@ -21,19 +21,285 @@
 package commitlog
 
 import (
+	"io"
+	"time"
+
+	"github.com/m3db/m3/src/dbnode/encoding"
+	"github.com/m3db/m3/src/dbnode/persist/fs"
 	"github.com/m3db/m3/src/dbnode/persist/fs/commitlog"
+	"github.com/m3db/m3/src/dbnode/storage/bootstrap"
 	"github.com/m3db/m3/src/dbnode/storage/bootstrap/result"
+	"github.com/m3db/m3/src/dbnode/storage/namespace"
+	"github.com/m3db/m3x/ident"
+	"github.com/m3db/m3x/pool"
+	xsync "github.com/m3db/m3x/sync"
+	xtime "github.com/m3db/m3x/time"
 )
 
+// Source is a bootstrap source that reads snapshot and commit log files.
+// Block starts are computed from the absolute time of datapoints, so they
+// are aligned in UTC regardless of the location of the times provided and
+// are unaffected by daylight saving or other clock changes of that location.
+type Source interface {
+	bootstrap.Source
+
+	// Plan returns the snapshot and commit log files that would be read to
+	// bootstrap the provided shards and time ranges without reading them.
+	Plan(ns namespace.Metadata, shardsTimeRanges result.ShardTimeRanges) (ReadPlan, error)
+
+	// ReadFromIterator bootstraps the provided shards and time ranges from the
+	// datapoints of an already open commit log iterator merged with the
+	// snapshots in the plan, the iterator is not closed.
+	ReadFromIterator(
+		ns namespace.Metadata,
+		shardsTimeRanges result.ShardTimeRanges,
+		runOpts bootstrap.RunOptions,
+		iter commitlog.Iterator,
+		plan ReadPlan,
+	) (result.DataBootstrapResult, error)
+
+	// ReadStreaming bootstraps the provided shards and time ranges the same
+	// way as ReadData but also sends the result of each shard on the channel
+	// as soon as the shard has been read, the channel is closed before
+	// returning the result of all the shards. When checkpointing, the receiver
+	// marks each result as persisted once it has persisted it. When streamed
+	// shard results are omitted they are left out of the returned result and
+	// owned by the receiver, which may release them, for instance once
+	// persisted, rather than the whole result being held until the bootstrap
+	// completes. Otherwise they must not be closed by the receiver.
+	ReadStreaming(
+		ns namespace.Metadata,
+		shardsTimeRanges result.ShardTimeRanges,
+		runOpts bootstrap.RunOptions,
+		shardResults chan<- ShardReadResult,
+	) (result.DataBootstrapResult, error)
+
+	// ReadUnmerged reads the commit log datapoints of the provided shards and
+	// time ranges the same way as ReadData but returns their encoders rather
+	// than merging them with the snapshots, which are not read. The caller
+	// owns the encoders and must close the result once done with them.
+	ReadUnmerged(
+		ns namespace.Metadata,
+		shardsTimeRanges result.ShardTimeRanges,
+		runOpts bootstrap.RunOptions,
+	) (UnmergedResult, error)
+
+	// ReadNamespaces bootstraps the provided shards and time ranges of each of
+	// the namespaces the same way as ReadData, reading up to the namespace read
+	// concurrency of them in parallel. The results are in the order of the
+	// requests and a namespace failing to read doesn't stop the others.
+	ReadNamespaces(
+		requests []NamespaceReadRequest,
+		runOpts bootstrap.RunOptions,
+	) []NamespaceReadResult
+
+	// LastBootstrapSummary returns the summary of the most recent data
+	// bootstrap of the namespace, if any.
+	LastBootstrapSummary(namespace ident.ID) (BootstrapSummary, bool)
+
+	// EstimateCost estimates the cost of bootstrapping the provided shards and
+	// time ranges from the sizes of the files that would be read without
+	// reading them.
+	EstimateCost(
+		ns namespace.Metadata,
+		shardsTimeRanges result.ShardTimeRanges,
+	) (BootstrapCostEstimate, error)
+
+	// Reset prepares the source for another bootstrap with the commit log files
+	// present when the node started, the summaries of previous bootstraps are
+	// cleared while the options and pools are kept. It must not be called while
+	// the source is reading.
+	Reset(inspection fs.CommitLogFilesInspection)
+}
+
+// BootstrapCostEstimate is an estimate of the files a bootstrap will read.
+type BootstrapCostEstimate struct {
+	// NumCommitLogFiles is the number of commit log files that would be read.
+	NumCommitLogFiles int
+
+	// CommitLogBytes is the total size of the commit log files on disk.
+	CommitLogBytes int64
+
+	// NumSnapshotFileSets is the number of snapshot filesets that would be read.
+	NumSnapshotFileSets int
+
+	// SnapshotBytes is the total size of the snapshot filesets on disk.
+	SnapshotBytes int64
+}
+
+// BytesToRead returns the total number of bytes that would be read.
+func (e BootstrapCostEstimate) BytesToRead() int64 {
+	return e.CommitLogBytes + e.SnapshotBytes
+}
+
+// UnmergedResult is the commit log data read by ReadUnmerged.
+type UnmergedResult interface {
+	// Shards returns the shards that were read in ascending order.
+	Shards() []uint32
+
+	// Series returns the series of the shard that were read from the commit log.
+	Series(shard uint32) []UnmergedSeries
+
+	// Unfulfilled returns the ranges that could not be read, for instance
+	// because a commit log file covering them was unreadable.
+	Unfulfilled() result.ShardTimeRanges
+
+	// Close closes every encoder of the result, returning them to the pool.
+	Close()
+}
+
+// UnmergedSeries is a series read from the commit log with its encoders.
+type UnmergedSeries struct {
+	ID   ident.ID
+	Tags ident.Tags
+
+	// Encoders are the encoders of each block start, each is in time order but
+	// datapoints that arrived out of order are spread across several of them.
+	Encoders map[xtime.UnixNano][]encoding.Encoder
+}
+
+// NamespaceReadRequest is a namespace to bootstrap with ReadNamespaces.
+type NamespaceReadRequest struct {
+	// Namespace is the namespace to bootstrap.
+	Namespace namespace.Metadata
+
+	// ShardsTimeRanges are the shards and time ranges of the namespace to
+	// bootstrap.
+	ShardsTimeRanges result.ShardTimeRanges
+}
+
+// NamespaceReadResult is the result of bootstrapping a namespace with
+// ReadNamespaces.
+type NamespaceReadResult struct {
+	// Namespace is the ID of the namespace that was bootstrapped.
+	Namespace ident.ID
+
+	// Result is the data bootstrapped for the namespace, it is nil if the
+	// namespace could not be read.
+	Result result.DataBootstrapResult
+
+	// Err is the error reading the namespace, if any.
+	Err error
+}
+
+// ShardReadResult is the result of bootstrapping a single shard.
+type ShardReadResult struct {
+	// Shard is the shard that was bootstrapped.
+	Shard uint32
+
+	// Result is the data bootstrapped for the shard, it is nil if the shard
+	// could not be read.
+	Result result.ShardResult
+
+	// Unfulfilled are the ranges of the shard that were not bootstrapped.
+	Unfulfilled xtime.Ranges
+
+	// NumDatapointsMerged is the number of datapoints written to the blocks of
+	// the shard that were merged with commit log data.
+	NumDatapointsMerged int64
+
+	// persisted records the shard in the checkpoint, it is nil unless
+	// checkpointing is enabled and the shard has no unfulfilled ranges.
+	persisted func() error
+}
+
+// MarkPersisted records that the receiver of the result has persisted it so that
+// the same bootstrap resumed after a crash skips the shard, which it returns as
+// unfulfilled, rather than reading it again. It does nothing unless a checkpoint
+// directory is set and the shard has no unfulfilled ranges. The shards are
+// recorded in a file in the checkpoint directory which is removed once the
+// bootstrap succeeds.
+func (r ShardReadResult) MarkPersisted() error {
+	if r.persisted == nil {
+		return nil
+	}
+	return r.persisted()
+}
+
+// BootstrapSummary summarizes the data bootstrapped for a namespace across
+// all of the requested shards.
+type BootstrapSummary struct {
+	// NumShards is the number of shards that were requested.
+	NumShards int
+
+	// NumSeries is the number of series bootstrapped.
+	NumSeries int64
+
+	// NumBlocks is the number of blocks bootstrapped.
+	NumBlocks int64
+
+	// NumBytes is the total size of the blocks bootstrapped.
+	NumBytes int64
+
+	// NumDatapointsMerged is the number of datapoints written to the blocks
+	// merged with commit log data, blocks only read from snapshots are not
+	// decoded so their datapoints are not counted.
+	NumDatapointsMerged int64
+
+	// SkippedCommitLogFiles are the paths of the commit log files that were
+	// skipped since they don't overlap with the ranges being bootstrapped.
+	SkippedCommitLogFiles []string
+
+	// Fulfilled are the requested ranges that were bootstrapped.
+	Fulfilled result.ShardTimeRanges
+
+	// Unfulfilled are the requested ranges that were not bootstrapped.
+	Unfulfilled result.ShardTimeRanges
+}
+
+// ReadPlan describes the files that a commit log bootstrap would read.
+type ReadPlan struct {
+	// MostRecentSnapshotByBlockShard is the snapshot chosen for each block and
+	// shard, blocks without a complete snapshot use the block start as their
+	// snapshot time.
+	MostRecentSnapshotByBlockShard map[xtime.UnixNano]map[uint32]fs.FileSetFile
+
+	// SnapshotFilesByShard are the snapshot files available for each shard.
+	SnapshotFilesByShard map[uint32]fs.FileSetFilesSlice
+
+	// RangesToCheck are the system time ranges that a commit log file must
+	// overlap with to be read.
+	RangesToCheck []xtime.Range
+
+	// CommitLogFiles are the paths of the commit log files that would be read.
+	CommitLogFiles []string
+}
+
+// BlockSnapshotTime is the snapshot time resolved for a block and shard.
+type BlockSnapshotTime struct {
+	// SnapshotTime is the time at which the snapshot was taken, only commit log
+	// data written after it needs to be read.
+	SnapshotTime time.Time
+
+	// FellBackToBlockStart is whether the block and shard had no complete
+	// snapshot, or its snapshot time could not be resolved, so the block start
+	// is used instead and the entire block is read from the commit log.
+	FellBackToBlockStart bool
+}
+
+// SnapshotIndexGap is a range of snapshot volume indices of a block and shard
+// that are missing between indices that are present, which may mean that
+// taking a snapshot failed.
+type SnapshotIndexGap struct {
+	Shard      uint32
+	BlockStart time.Time
+	// FirstMissing and LastMissing are the first and last volume indices
+	// missing, inclusive.
+	FirstMissing int
+	LastMissing  int
+}
+
 // Options represents the options for bootstrapping from commit logs
 type Options interface {
 	// Validate validates the options
 	Validate() error
 
-	// SetResultOptions sets the instrumentation options
+	// SetResultOptions sets the result options, the encoder pool of their block
+	// options determines the encoding of the data read from the commit log
 	SetResultOptions(value result.Options) Options
 
-	// ResultOptions returns the instrumentation options
+	// ResultOptions returns the result options, the encoder pool of their block
+	// options determines the encoding of the data read from the commit log
 	ResultOptions() result.Options
 
 	// SetCommitLogOptions sets the commit log options
@@ -42,15 +308,536 @@ type Options interface {
 	// CommitLogOptions returns the commit log options
 	CommitLogOptions() commitlog.Options
 
-	// SetEncodingConcurrency sets the concurrency for encoding
+	// SetEncodingConcurrency sets the concurrency for encoding, no more workers
+	// than there are shards being bootstrapped are started
 	SetEncodingConcurrency(value int) Options
 
-	// EncodingConcurrency returns the concurrency for encoding
+	// EncodingConcurrency returns the concurrency for encoding, no more workers
+	// than there are shards being bootstrapped are started
 	EncodingConcurrency() int
 
+	// With adaptive encoding concurrency the number of encoding workers shards
+	// are assigned to grows while handing datapoints to the workers blocks and
+	// shrinks while it doesn't, shards stay with the worker they were first
+	// assigned to. Zero for both uses the fixed encoding concurrency instead.
+
+	// SetAdaptiveEncodingConcurrency sets the min and max number of encoding workers
+	SetAdaptiveEncodingConcurrency(min, max int) Options
+
+	// AdaptiveEncodingConcurrency returns the min and max number of encoding workers
+	AdaptiveEncodingConcurrency() (int, int)
+
 	// SetMergeShardConcurrency sets the concurrency for merging shards
 	SetMergeShardsConcurrency(value int) Options
 
 	// MergeShardConcurrency returns the concurrency for merging shards
 	MergeShardsConcurrency() int
+
+	// SetRandomizeShardMergeOrder sets whether the order in which shards are
+	// merged is shuffled to spread shards that are expensive to merge across
+	// the merge, rather than merging them in the order of the shards map
+	SetRandomizeShardMergeOrder(value bool) Options
+
+	// RandomizeShardMergeOrder returns whether the order in which shards are
+	// merged is shuffled to spread shards that are expensive to merge across
+	// the merge, rather than merging them in the order of the shards map
+	RandomizeShardMergeOrder() bool
+
+	// SetMergeSeriesConcurrency sets the concurrency for merging the series
+	// of a single shard, one merges them serially
+	SetMergeSeriesConcurrency(value int) Options
+
+	// MergeSeriesConcurrency returns the concurrency for merging the series
+	// of a single shard, one merges them serially
+	MergeSeriesConcurrency() int
+
+	// SetSnapshotResolutionConcurrency sets the concurrency for resolving
+	// the snapshot time of snapshot files
+	SetSnapshotResolutionConcurrency(value int) Options
+
+	// SnapshotResolutionConcurrency returns the concurrency for resolving
+	// the snapshot time of snapshot files
+	SnapshotResolutionConcurrency() int
+
+	// SetMaxSnapshotTimeResolutionErrors sets the number of snapshot files whose
+	// snapshot time can fail to resolve before the bootstrap fails, negative
+	// means unlimited
+	SetMaxSnapshotTimeResolutionErrors(value int) Options
+
+	// MaxSnapshotTimeResolutionErrors returns the number of snapshot files whose
+	// snapshot time can fail to resolve before the bootstrap fails, negative
+	// means unlimited
+	MaxSnapshotTimeResolutionErrors() int
+
+	// SetSnapshotReadConcurrency sets the number of shards whose snapshot
+	// files are read in parallel
+	SetSnapshotReadConcurrency(value int) Options
+
+	// SnapshotReadConcurrency returns the number of shards whose snapshot
+	// files are read in parallel
+	SnapshotReadConcurrency() int
+
+	// SetNamespaceReadConcurrency sets the number of namespaces read in
+	// parallel by ReadNamespaces
+	SetNamespaceReadConcurrency(value int) Options
+
+	// NamespaceReadConcurrency returns the number of namespaces read in
+	// parallel by ReadNamespaces
+	NamespaceReadConcurrency() int
+
+	// The shared snapshot read pool is an initialized worker pool that bounds
+	// the shards whose snapshot files are read in parallel across every source
+	// it is shared with, such as those of different namespaces. When set it is
+	// used instead of the snapshot read concurrency.
+
+	// SetSharedSnapshotReadPool sets the shared snapshot read pool
+	SetSharedSnapshotReadPool(value xsync.WorkerPool) Options
+
+	// SharedSnapshotReadPool returns the shared snapshot read pool
+	SharedSnapshotReadPool() xsync.WorkerPool
+
+	// The max unmerged memory bytes is a soft limit on the bytes held by commit
+	// log encoders before they are merged. Once compacting them isn't enough the
+	// oldest blocks are dropped and marked as unfulfilled, zero means unlimited.
+
+	// SetMaxUnmergedMemoryBytes sets the max unmerged memory bytes
+	SetMaxUnmergedMemoryBytes(value int64) Options
+
+	// MaxUnmergedMemoryBytes returns the max unmerged memory bytes
+	MaxUnmergedMemoryBytes() int64
+
+	// SetMaxCommitLogFilesToRead sets the maximum number of commit log files a
+	// single read may select before failing, zero means unlimited
+	SetMaxCommitLogFilesToRead(value int) Options
+
+	// MaxCommitLogFilesToRead returns the maximum number of commit log files a
+	// single read may select before failing, zero means unlimited
+	MaxCommitLogFilesToRead() int
+
+	// SetDetectOverlappingCommitLogFiles sets whether the commit log files
+	// selected to be read are checked for overlapping time ranges, which are
+	// logged and counted since their writes may be replayed twice
+	SetDetectOverlappingCommitLogFiles(value bool) Options
+
+	// DetectOverlappingCommitLogFiles returns whether the commit log files
+	// selected to be read are checked for overlapping time ranges, which are
+	// logged and counted since their writes may be replayed twice
+	DetectOverlappingCommitLogFiles() bool
+
+	// Out of order datapoints each need a new encoder, so once a block of a
+	// series holds more than the max encoders per series while reading the
+	// commit log they are compacted into one. Zero means unlimited.
+
+	// SetMaxEncodersPerSeries sets the max encoders per series
+	SetMaxEncodersPerSeries(value int) Options
+
+	// MaxEncodersPerSeries returns the max encoders per series
+	MaxEncodersPerSeries() int
+
+	// SetMaxUnmergedBytesPerSeries sets the maximum number of bytes held by the
+	// encoders of a block of a series, other than its first, while reading the
+	// commit log, once exceeded the encoders are compacted into one so out of
+	// order datapoints can't grow a series without bound, zero means unlimited
+	SetMaxUnmergedBytesPerSeries(value int64) Options
+
+	// MaxUnmergedBytesPerSeries returns the maximum number of bytes held by the
+	// encoders of a block of a series, other than its first, while reading the
+	// commit log, once exceeded the encoders are compacted into one so out of
+	// order datapoints can't grow a series without bound, zero means unlimited
+	MaxUnmergedBytesPerSeries() int64
+
+	// SetMaxAnnotationBytes sets the max size of the annotation of a datapoint
+	// read from the commit log, zero means unlimited
+	SetMaxAnnotationBytes(value int) Options
+
+	// MaxAnnotationBytes returns the max size of the annotation of a datapoint
+	// read from the commit log, zero means unlimited
+	MaxAnnotationBytes() int
+
+	// SetTruncateOversizedAnnotations sets whether annotations larger than the
+	// max size are truncated rather than their datapoints dropped
+	SetTruncateOversizedAnnotations(value bool) Options
+
+	// TruncateOversizedAnnotations returns whether annotations larger than the
+	// max size are truncated rather than their datapoints dropped
+	TruncateOversizedAnnotations() bool
+
+	// SetLogOrphanDatapoints sets whether to log a sample of the datapoints read
+	// from the commit log whose shard is not one of the shards being bootstrapped,
+	// they are always counted, which helps detect misrouted data when migrating
+	// the number of shards
+	SetLogOrphanDatapoints(value bool) Options
+
+	// LogOrphanDatapoints returns whether to log a sample of the datapoints read
+	// from the commit log whose shard is not one of the shards being bootstrapped,
+	// they are always counted, which helps detect misrouted data when migrating
+	// the number of shards
+	LogOrphanDatapoints() bool
+
+	// SetProgressReporter sets the reporter that is notified of the
+	// progress of the bootstrap
+	SetProgressReporter(value ProgressReporter) Options
+
+	// ProgressReporter returns the reporter that is notified of the
+	// progress of the bootstrap
+	ProgressReporter() ProgressReporter
+
+	// SetUnmergedDebugSink sets the sink that receives the statistics of the
+	// commit log data read before it is merged, when nil the statistics are not
+	// computed
+	SetUnmergedDebugSink(value UnmergedDebugSink) Options
+
+	// UnmergedDebugSink returns the sink that receives the statistics of the
+	// commit log data read before it is merged, when nil the statistics are not
+	// computed
+	UnmergedDebugSink() UnmergedDebugSink
+
+	// SetDroppedDatapointsReporter sets the reporter that is notified of
+	// commit log datapoints that could not be bootstrapped
+	SetDroppedDatapointsReporter(value DroppedDatapointsReporter) Options
+
+	// DroppedDatapointsReporter returns the reporter that is notified of
+	// commit log datapoints that could not be bootstrapped
+	DroppedDatapointsReporter() DroppedDatapointsReporter
+
+	// SetOutOfOrderDatapointsReporter sets the reporter that is notified of
+	// commit log datapoints that arrived out of order
+	SetOutOfOrderDatapointsReporter(value OutOfOrderDatapointsReporter) Options
+
+	// OutOfOrderDatapointsReporter returns the reporter that is notified of
+	// commit log datapoints that arrived out of order
+	OutOfOrderDatapointsReporter() OutOfOrderDatapointsReporter
+
+	// SetWorkDistributor sets the distributor that assigns commit log
+	// series to encoding workers
+	SetWorkDistributor(value WorkDistributor) Options
+
+	// WorkDistributor returns the distributor that assigns commit log
+	// series to encoding workers
+	WorkDistributor() WorkDistributor
+
+	// SetFileSource sets the source of the snapshot files read by a bootstrap,
+	// defaults to the local filesystem
+	SetFileSource(value FileSource) Options
+
+	// FileSource returns the source of the snapshot files read by a bootstrap,
+	// defaults to the local filesystem
+	FileSource() FileSource
+
+	// SetDecryptor sets the decryptor applied to snapshot and commit log files
+	// as they are read, nil means the files are not encrypted
+	SetDecryptor(value Decryptor) Options
+
+	// Decryptor returns the decryptor applied to snapshot and commit log files
+	// as they are read, nil means the files are not encrypted
+	Decryptor() Decryptor
+
+	// SetDecryptionDir sets the directory encrypted or compressed snapshot
+	// volumes are decoded into while read, empty uses the system temporary
+	// directory
+	SetDecryptionDir(value string) Options
+
+	// DecryptionDir returns the directory encrypted or compressed snapshot
+	// volumes are decoded into while read, empty uses the system temporary
+	// directory
+	DecryptionDir() string
+
+	// SetSeriesValidator sets the validator for series read from the
+	// commit log, nil accepts every series
+	SetSeriesValidator(value SeriesValidator) Options
+
+	// SeriesValidator returns the validator for series read from the
+	// commit log, nil accepts every series
+	SeriesValidator() SeriesValidator
+
+	// SetSeriesFilter sets the filter that selects which series are read from
+	// the commit log, nil reads every series of the namespace
+	SetSeriesFilter(value SeriesFilter) Options
+
+	// SeriesFilter returns the filter that selects which series are read from
+	// the commit log, nil reads every series of the namespace
+	SeriesFilter() SeriesFilter
+
+	// SetNamespaceMatcher sets the matcher that decides whether the namespace
+	// a commit log series was written with is the namespace being bootstrapped,
+	// nil requires the namespace IDs to be byte identical
+	SetNamespaceMatcher(value NamespaceMatcher) Options
+
+	// NamespaceMatcher returns the matcher that decides whether the namespace
+	// a commit log series was written with is the namespace being bootstrapped,
+	// nil requires the namespace IDs to be byte identical
+	NamespaceMatcher() NamespaceMatcher
+
+	// SetSeriesAllowlist sets the IDs of the only series that are read from the
+	// commit log, for instance to recover specific series, empty reads every
+	// series of the namespace. It is combined with the series filter
+	SetSeriesAllowlist(value []ident.ID) Options
+
+	// SeriesAllowlist returns the IDs of the only series that are read from the
+	// commit log, for instance to recover specific series, empty reads every
+	// series of the namespace. It is combined with the series filter
+	SeriesAllowlist() []ident.ID
+
+	// SetBlockFilter sets the filter that selects which data blocks are
+	// bootstrapped, nil bootstraps every block
+	SetBlockFilter(value BlockFilter) Options
+
+	// BlockFilter returns the filter that selects which data blocks are
+	// bootstrapped, nil bootstraps every block
+	BlockFilter() BlockFilter
+
+	// SetAllowIncompleteSnapshots sets whether to fall back to reading the
+	// latest snapshot without a checkpoint file for blocks that have no
+	// complete snapshot, this is unsafe and only intended for disaster recovery
+	SetAllowIncompleteSnapshots(value bool) Options
+
+	// AllowIncompleteSnapshots returns whether to fall back to reading the
+	// latest snapshot without a checkpoint file for blocks that have no
+	// complete snapshot, this is unsafe and only intended for disaster recovery
+	AllowIncompleteSnapshots() bool
+
+	// SetValidateSnapshotInfo sets whether to check that the namespace, shard and
+	// block of each snapshot file that is opened are the ones requested, a file
+	// that doesn't match is treated the same as an unreadable one
+	SetValidateSnapshotInfo(value bool) Options
+
+	// ValidateSnapshotInfo returns whether to check that the namespace, shard and
+	// block of each snapshot file that is opened are the ones requested, a file
+	// that doesn't match is treated the same as an unreadable one
+	ValidateSnapshotInfo() bool
+
+	// SetSnapshotInfoReaderBufferSize sets the buffer size used to read snapshot
+	// info files when resolving snapshot times, when zero the info reader buffer
+	// size of the filesystem options is used
+	SetSnapshotInfoReaderBufferSize(value int) Options
+
+	// SnapshotInfoReaderBufferSize returns the buffer size used to read snapshot
+	// info files when resolving snapshot times, when zero the info reader buffer
+	// size of the filesystem options is used
+	SnapshotInfoReaderBufferSize() int
+
+	// SetSnapshotReadBytesPool sets the bytes pool used by snapshot readers, when
+	// set the data read is copied into bytes from the block options bytes pool
+	// so short lived read buffers don't churn the pool backing retained blocks,
+	// when nil the block options bytes pool is used for both
+	SetSnapshotReadBytesPool(value pool.CheckedBytesPool) Options
+
+	// SnapshotReadBytesPool returns the bytes pool used by snapshot readers, when
+	// set the data read is copied into bytes from the block options bytes pool
+	// so short lived read buffers don't churn the pool backing retained blocks,
+	// when nil the block options bytes pool is used for both
+	SnapshotReadBytesPool() pool.CheckedBytesPool
+
+	// SetDeduplicateSnapshotBlocks sets whether identical snapshot blocks read
+	// by a bootstrap share their data rather than each holding a copy, this
+	// costs comparing every block read with those already read
+	SetDeduplicateSnapshotBlocks(value bool) Options
+
+	// DeduplicateSnapshotBlocks returns whether identical snapshot blocks read
+	// by a bootstrap share their data rather than each holding a copy, this
+	// costs comparing every block read with those already read
+	DeduplicateSnapshotBlocks() bool
+
+	// SetEmptyMergedBlocksAreErrors sets whether blocks left without any
+	// datapoints after merging are treated as errors, which marks their shard
+	// as unfulfilled, rather than being dropped and only counted
+	SetEmptyMergedBlocksAreErrors(value bool) Options
+
+	// EmptyMergedBlocksAreErrors returns whether blocks left without any
+	// datapoints after merging are treated as errors, which marks their shard
+	// as unfulfilled, rather than being dropped and only counted
+	EmptyMergedBlocksAreErrors() bool
+
+	// SetCheckpointDir sets the checkpoint dir, see ShardReadResult.MarkPersisted
+	SetCheckpointDir(value string) Options
+
+	// CheckpointDir returns the checkpoint dir, see ShardReadResult.MarkPersisted
+	CheckpointDir() string
+
+	// SetOmitStreamedShardResults sets whether ReadStreaming omits streamed shard results
+	SetOmitStreamedShardResults(value bool) Options
+
+	// OmitStreamedShardResults returns whether ReadStreaming omits streamed shard results
+	OmitStreamedShardResults() bool
+
+	// SetFailOnCommitLogReadError sets whether the bootstrap fails on any error
+	// reading the commit log rather than skipping the commit log files that
+	// couldn't be read and marking the ranges they cover as unfulfilled
+	SetFailOnCommitLogReadError(value bool) Options
+
+	// FailOnCommitLogReadError returns whether the bootstrap fails on any error
+	// reading the commit log rather than skipping the commit log files that
+	// couldn't be read and marking the ranges they cover as unfulfilled
+	FailOnCommitLogReadError() bool
+
+	// SetSnapshotsOnly sets whether to bootstrap only from snapshot files without
+	// reading the commit log, data written after each snapshot is left unfulfilled
+	SetSnapshotsOnly(value bool) Options
+
+	// SnapshotsOnly returns whether to bootstrap only from snapshot files without
+	// reading the commit log, data written after each snapshot is left unfulfilled
+	SnapshotsOnly() bool
+
+	// SetReportAccurateAvailability sets whether to only report the ranges covered
+	// by the commit log and snapshot files on disk as available rather than every
+	// requested range
+	SetReportAccurateAvailability(value bool) Options
+
+	// ReportAccurateAvailability returns whether to only report the ranges covered
+	// by the commit log and snapshot files on disk as available rather than every
+	// requested range
+	ReportAccurateAvailability() bool
+
+	// SetPerShardMergeTimeout sets the time budget for reading and merging the
+	// data of a single shard, shards that exceed it are abandoned and have their
+	// ranges marked as unfulfilled, zero means no timeout
+	SetPerShardMergeTimeout(value time.Duration) Options
+
+	// PerShardMergeTimeout returns the time budget for reading and merging the
+	// data of a single shard, shards that exceed it are abandoned and have their
+	// ranges marked as unfulfilled, zero means no timeout
+	PerShardMergeTimeout() time.Duration
+
+	// SetEncoderBlockedWarnThreshold sets the threshold after which the time
+	// spent blocked handing datapoints to a busy encoding worker is logged and
+	// counted, zero disables measuring the time spent blocked
+	SetEncoderBlockedWarnThreshold(value time.Duration) Options
+
+	// EncoderBlockedWarnThreshold returns the threshold after which the time
+	// spent blocked handing datapoints to a busy encoding worker is logged and
+	// counted, zero disables measuring the time spent blocked
+	EncoderBlockedWarnThreshold() time.Duration
+}
+
+// BlockFilter returns whether the data block starting at blockStart should be
+// bootstrapped, blocks that are filtered out are returned as unfulfilled.
+type BlockFilter func(blockStart xtime.UnixNano) bool
+
+// SeriesValidator validates a series the first time it is read from the commit
+// log, neither the commit log datapoints nor the snapshot data of any series it
+// returns an error for are bootstrapped. It is called concurrently from multiple
+// encoding workers.
+type SeriesValidator func(id ident.ID, tags ident.Tags) error
+
+// SeriesFilter returns whether the datapoints of a series should be read from
+// the commit log. The commit log reader only has the ID of a series when it
+// decides whether to read it so filters operate on the ID, use a SeriesValidator
+// to match on tags instead at the cost of decoding the datapoints. Series in
+// snapshot files are not filtered.
+type SeriesFilter func(id ident.ID) bool
+
+// NamespaceMatcher returns whether the namespace a commit log series was
+// written with is the namespace being bootstrapped. It allows equivalent but
+// not byte identical namespace IDs, such as those written padded by older
+// versions, to match.
+type NamespaceMatcher func(bootstrapping ident.ID, written ident.ID) bool
+
+// Decryptor wraps the contents of a snapshot or commit log file as it is read
+// so that files that were encrypted at rest can be bootstrapped. The key
+// material is up to the implementation, checksums and digests are verified
+// against the decrypted contents.
+type Decryptor func(r io.Reader) (io.Reader, error)
+
+// WorkDistributor assigns the series read from the commit log to encoding
+// workers. The default distributes shards across workers by modulo.
+//
+// Encoding workers own the state of every shard they are assigned without
+// any synchronization so implementations must route all series belonging
+// to the same shard to the same worker, a bootstrap fails if they don't.
+type WorkDistributor interface {
+	// WorkerIndex returns the index of the worker in [0, numWorkers) that
+	// should encode the series.
+	WorkerIndex(series commitlog.Series, numWorkers int) int
+}
+
+// FileSource provides the snapshot files read by a bootstrap which allows them
+// to be served from an object store rather than the local filesystem. Since
+// snapshot times are otherwise read from the local filesystem implementations
+// must cache them on the files they return.
+type FileSource interface {
+	// SnapshotFiles returns the snapshot filesets of the shard of the namespace.
+	SnapshotFiles(
+		filePathPrefix string,
+		namespace ident.ID,
+		shard uint32,
+	) (fs.FileSetFilesSlice, error)
+
+	// NewReader returns a reader for the snapshot filesets returned by
+	// SnapshotFiles.
+	NewReader(bytesPool pool.CheckedBytesPool, opts fs.Options) (fs.DataFileSetReader, error)
+}
+
+// ProgressReporter is notified of the progress of a commit log bootstrap.
+// Implementations must be safe for concurrent use since shards are merged
+// in parallel.
+type ProgressReporter interface {
+	// OnCommitLogFileSelected is called for every commit log file that
+	// will be read.
+	OnCommitLogFileSelected(file string)
+
+	// OnDatapointsRead is called periodically with the number of datapoints
+	// that have been read from the commit log since the previous call.
+	OnDatapointsRead(n int64)
+
+	// OnShardMergeComplete is called once the data for a shard has been merged.
+	OnShardMergeComplete(shard uint32)
+}
+
+// UnmergedShardStats describes the commit log data read for a shard before
+// it is merged with the snapshots.
+type UnmergedShardStats struct {
+	Shard     uint32
+	NumSeries int
+	// Blocks are ordered by block start.
+	Blocks []UnmergedBlockStats
+}
+
+// UnmergedBlockStats describes the commit log data read for a block of a
+// shard before it is merged with the snapshots.
+type UnmergedBlockStats struct {
+	BlockStart  time.Time
+	NumSeries   int
+	NumEncoders int
+}
+
+// UnmergedDebugSink receives the statistics of the commit log data read for
+// a namespace once reading is done and before it is merged, shards are ordered
+// by shard. It is meant for debugging bootstraps that produce unexpected
+// results.
+type UnmergedDebugSink interface {
+	OnUnmerged(namespace ident.ID, stats []UnmergedShardStats)
+}
+
+// DroppedDatapoint describes a commit log datapoint that could not be
+// bootstrapped because it failed to encode.
+type DroppedDatapoint struct {
+	Shard     uint32
+	ID        ident.ID
+	Timestamp time.Time
+	Err       error
+}
+
+// DroppedDatapointsReporter receives the datapoints that were dropped while
+// reading the commit log for a namespace. The IDs are only valid for the
+// duration of the call and must be copied if they need to be retained.
+type DroppedDatapointsReporter interface {
+	ReportDroppedDatapoints(namespace ident.ID, dropped []DroppedDatapoint)
+}
+
+// OutOfOrderDatapoint describes a commit log datapoint that wasn't after the
+// last write of any encoder of its series and block so was encoded with an
+// encoder of its own.
+type OutOfOrderDatapoint struct {
+	Shard     uint32
+	ID        ident.ID
+	Timestamp time.Time
+}
+
+// OutOfOrderDatapointsReporter receives the number of datapoints that arrived
+// out of order while reading the commit log for a namespace, along with a
+// bounded sample of them. Out of order datapoints that failed to encode are
+// reported as dropped instead. The IDs are only valid for the duration of the
+// call and must be copied if they need to be retained.
+type OutOfOrderDatapointsReporter interface {
+	ReportOutOfOrderDatapoints(namespace ident.ID, numOutOfOrder int, samples []OutOfOrderDatapoint)
 }
@@ -228,6 +228,82 @@ func TestResultAddResult(t *testing.T) {
 	assert.True(t, r.Unfulfilled().Equal(expected.unfulfilled))
 }
 
+func TestMergedDataBootstrapResultDisjointShards(t *testing.T) {
+	opts := testResultOptions()
+	blopts := opts.DatabaseBlockOptions()
+
+	start := time.Now().Truncate(testBlockSize)
+
+	srs := []ShardResult{
+		NewShardResult(0, opts),
+		NewShardResult(0, opts),
+	}
+	srs[0].AddBlock(ident.StringID("foo"), ident.Tags{},
+		block.NewDatabaseBlock(start, testBlockSize, ts.Segment{}, blopts))
+	srs[1].AddBlock(ident.StringID("bar"), ident.Tags{},
+		block.NewDatabaseBlock(start, testBlockSize, ts.Segment{}, blopts))
+
+	rs := []DataBootstrapResult{
+		NewDataBootstrapResult(),
+		NewDataBootstrapResult(),
+	}
+	rs[0].Add(0, srs[0], xtime.Ranges{})
+	rs[1].Add(1, srs[1], xtime.NewRanges(xtime.Range{
+		Start: start.Add(testBlockSize),
+		End:   start.Add(2 * testBlockSize),
+	}))
+
+	r := MergedDataBootstrapResult(rs[0], rs[1])
+
+	require.Equal(t, 2, len(r.ShardResults()))
+	_, ok := r.ShardResults()[0].BlockAt(ident.StringID("foo"), start)
+	require.True(t, ok)
+	_, ok = r.ShardResults()[1].BlockAt(ident.StringID("bar"), start)
+	require.True(t, ok)
+	assert.True(t, r.Unfulfilled().Equal(ShardTimeRanges{
+		1: xtime.NewRanges(xtime.Range{
+			Start: start.Add(testBlockSize),
+			End:   start.Add(2 * testBlockSize),
+		}),
+	}))
+}
+
+func TestMergedDataBootstrapResultOverlappingUnfulfilled(t *testing.T) {
+	start := time.Now().Truncate(testBlockSize)
+
+	rs := []DataBootstrapResult{
+		NewDataBootstrapResult(),
+		NewDataBootstrapResult(),
+	}
+	rs[0].Add(0, nil, xtime.NewRanges(xtime.Range{
+		Start: start,
+		End:   start.Add(4 * testBlockSize),
+	}))
+	rs[1].Add(0, nil, xtime.NewRanges(xtime.Range{
+		Start: start.Add(2 * testBlockSize),
+		End:   start.Add(6 * testBlockSize),
+	}))
+	rs[1].Add(1, nil, xtime.NewRanges(xtime.Range{
+		Start: start,
+		End:   start.Add(testBlockSize),
+	}))
+
+	r := MergedDataBootstrapResult(rs[0], rs[1])
+
+	// A range unfulfilled by either result remains unfulfilled.
+	require.Equal(t, 0, len(r.ShardResults()))
+	assert.True(t, r.Unfulfilled().Equal(ShardTimeRanges{
+		0: xtime.NewRanges(xtime.Range{
+			Start: start,
+			End:   start.Add(6 * testBlockSize),
+		}),
+		1: xtime.NewRanges(xtime.Range{
+			Start: start,
+			End:   start.Add(testBlockSize),
+		}),
+	}))
+}
+
 func TestShardResultIsEmpty(t *testing.T) {
 	opts := testResultOptions()
 	sr := NewShardResult(0, opts)